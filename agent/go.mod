@@ -6,6 +6,7 @@ require (
 	github.com/StackExchange/wmi v1.2.1
 	github.com/getlantern/systray v1.2.2
 	github.com/google/uuid v1.4.0
+	github.com/gorilla/websocket v1.5.3
 	golang.org/x/sys v0.15.0
 )
 
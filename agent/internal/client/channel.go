@@ -0,0 +1,149 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tracr/agent/internal/logger"
+)
+
+// Message types of the agent channel
+const (
+	// Sent by the API
+	ChannelCommand = "command" // a command leased to the agent
+	ChannelConfig  = "config"  // settings managed from the console
+	ChannelError   = "error"   // a message from the agent was rejected
+
+	// Sent by the agent
+	ChannelHeartbeat = "heartbeat"
+	ChannelProgress  = "progress"
+	ChannelAck       = "ack"
+)
+
+// The API pings the channel every 30 seconds, so a channel that stays silent
+// for longer than channelReadTimeout is gone
+const (
+	channelReadTimeout  = 90 * time.Second
+	channelWriteTimeout = 10 * time.Second
+)
+
+// ChannelMessage is a message of the agent channel, one JSON message per
+// WebSocket frame
+type ChannelMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type CommandProgress struct {
+	CommandID string `json:"command_id"`
+	Message   string `json:"message"`
+	Percent   *int   `json:"percent,omitempty"`
+}
+
+type CommandAck struct {
+	CommandID string `json:"command_id"`
+	CommandResult
+}
+
+// Channel is the WebSocket connection the agent keeps open to the API when
+// the agent channel is enabled. Send may be called concurrently with Receive.
+type Channel struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+// DialChannel opens the agent channel of the device
+func (c *Client) DialChannel(deviceID string) (*Channel, error) {
+	endpoint := c.config.APIEndpoint
+	switch {
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = "wss://" + strings.TrimPrefix(endpoint, "https://")
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint = "ws://" + strings.TrimPrefix(endpoint, "http://")
+	}
+	channelURL := fmt.Sprintf("%s/v1/agents/%s/channel", endpoint, deviceID)
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.config.RequestTimeout,
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.DeviceToken))
+	header.Set("User-Agent", fmt.Sprintf("Tracr-Agent/%s", "1.0.0")) // TODO: Use actual version
+
+	logger.Debug("Opening agent channel", "url", channelURL)
+
+	conn, resp, err := dialer.Dial(channelURL, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("agent channel rejected with HTTP %d: %w", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("failed to open agent channel: %w", err)
+	}
+
+	// Pings from the API keep the channel alive
+	conn.SetReadDeadline(time.Now().Add(channelReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(channelReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(channelWriteTimeout))
+	})
+
+	return &Channel{conn: conn}, nil
+}
+
+// Receive waits for the next message from the API
+func (ch *Channel) Receive() (*ChannelMessage, error) {
+	_, data, err := ch.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	ch.conn.SetReadDeadline(time.Now().Add(channelReadTimeout))
+
+	var message ChannelMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal channel message: %w", err)
+	}
+	return &message, nil
+}
+
+// Send writes a message with data encoded as JSON
+func (ch *Channel) Send(messageType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", messageType, err)
+	}
+	message, err := json.Marshal(ChannelMessage{Type: messageType, Data: payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", messageType, err)
+	}
+
+	ch.writeMu.Lock()
+	defer ch.writeMu.Unlock()
+
+	ch.conn.SetWriteDeadline(time.Now().Add(channelWriteTimeout))
+	return ch.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// Heartbeat sends a heartbeat over the channel, with the same content as
+// Client.Heartbeat
+func (ch *Channel) Heartbeat(errors ...string) error {
+	return ch.Send(ChannelHeartbeat, map[string]interface{}{
+		"timestamp": time.Now(),
+		"errors":    errors,
+	})
+}
+
+// Close closes the connection, which makes a pending Receive fail
+func (ch *Channel) Close() error {
+	return ch.conn.Close()
+}
@@ -37,6 +37,8 @@ type Command struct {
 	CommandType string          `json:"command_type"`
 	Payload     json.RawMessage `json:"payload"`
 	CreatedAt   time.Time       `json:"created_at"`
+	// TimeoutSeconds is how long the command may run, zero for the default
+	TimeoutSeconds int `json:"timeout_seconds"`
 }
 
 type CommandResult struct {
@@ -94,11 +96,28 @@ func (c *Client) SendInventory(deviceID string, inventory interface{}) error {
 	return nil
 }
 
-func (c *Client) Heartbeat(deviceID string) error {
+// SendPerformanceSamples uploads a batch of buffered performance samples. The
+// API skips samples it already recorded, so a batch that failed can be sent
+// again with the samples taken since.
+func (c *Client) SendPerformanceSamples(deviceID string, batch interface{}) error {
+	url := fmt.Sprintf("%s/v1/agents/%s/performance", c.config.APIEndpoint, deviceID)
+	
+	if err := c.doRequest("POST", url, batch, nil, true); err != nil {
+		return fmt.Errorf("send performance samples request failed: %w", err)
+	}
+
+	return nil
+}
+
+// Heartbeat reports the device is alive along with the errors the agent ran
+// into since the previous heartbeat. A heartbeat without errors clears the
+// error status of the device.
+func (c *Client) Heartbeat(deviceID string, errors ...string) error {
 	url := fmt.Sprintf("%s/v1/agents/%s/heartbeat", c.config.APIEndpoint, deviceID)
 	
 	heartbeatData := map[string]interface{}{
 		"timestamp": time.Now(),
+		"errors":    errors,
 	}
 
 	if err := c.doRequest("POST", url, heartbeatData, nil, true); err != nil {
@@ -108,11 +127,24 @@ func (c *Client) Heartbeat(deviceID string) error {
 	return nil
 }
 
-func (c *Client) PollCommands(deviceID string) ([]Command, error) {
-	url := fmt.Sprintf("%s/v1/agents/%s/commands", c.config.APIEndpoint, deviceID)
+// PollCommands fetches the commands queued for the device. With a wait, the
+// API holds the request until a command is queued or the wait is over.
+func (c *Client) PollCommands(deviceID string, wait time.Duration) ([]Command, error) {
+	pollURL := fmt.Sprintf("%s/v1/agents/%s/commands", c.config.APIEndpoint, deviceID)
+
+	httpClient := c.httpClient
+	if wait > 0 {
+		pollURL += "?wait=" + url.QueryEscape(wait.String())
+
+		// Allow for the wait on top of the usual request timeout
+		httpClient = &http.Client{
+			Transport: c.httpClient.Transport,
+			Timeout:   wait + c.config.RequestTimeout,
+		}
+	}
 	
 	var commands []Command
-	if err := c.doRequest("GET", url, nil, &commands, true); err != nil {
+	if err := c.doRequestWithRetry(httpClient, "GET", pollURL, nil, &commands, true, c.config.MaxRetries); err != nil {
 		return nil, fmt.Errorf("poll commands request failed: %w", err)
 	}
 
@@ -130,10 +162,10 @@ func (c *Client) AckCommand(deviceID, commandID string, result CommandResult) er
 }
 
 func (c *Client) doRequest(method, url string, requestBody interface{}, responseBody interface{}, requireAuth bool) error {
-	return c.doRequestWithRetry(method, url, requestBody, responseBody, requireAuth, c.config.MaxRetries)
+	return c.doRequestWithRetry(c.httpClient, method, url, requestBody, responseBody, requireAuth, c.config.MaxRetries)
 }
 
-func (c *Client) doRequestWithRetry(method, url string, requestBody interface{}, responseBody interface{}, requireAuth bool, retriesLeft int) error {
+func (c *Client) doRequestWithRetry(httpClient *http.Client, method, url string, requestBody interface{}, responseBody interface{}, requireAuth bool, retriesLeft int) error {
 	var body io.Reader
 	
 	if requestBody != nil {
@@ -160,7 +192,7 @@ func (c *Client) doRequestWithRetry(method, url string, requestBody interface{},
 
 	logger.Debug("Making HTTP request", "method", method, "url", url, "auth", requireAuth)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		// Retry on network errors
 		if retriesLeft > 0 {
@@ -172,7 +204,7 @@ func (c *Client) doRequestWithRetry(method, url string, requestBody interface{},
 				"backoff", backoffDuration, 
 				"retriesLeft", retriesLeft-1)
 			time.Sleep(backoffDuration)
-			return c.doRequestWithRetry(method, url, requestBody, responseBody, requireAuth, retriesLeft-1)
+			return c.doRequestWithRetry(httpClient, method, url, requestBody, responseBody, requireAuth, retriesLeft-1)
 		}
 		return fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -203,7 +235,7 @@ func (c *Client) doRequestWithRetry(method, url string, requestBody interface{},
 			backoffDuration := c.calculateBackoff(c.config.MaxRetries - retriesLeft)
 			logger.Debug("Server error, retrying", "status", resp.StatusCode, "backoff", backoffDuration, "retriesLeft", retriesLeft-1)
 			time.Sleep(backoffDuration)
-			return c.doRequestWithRetry(method, url, requestBody, responseBody, requireAuth, retriesLeft-1)
+			return c.doRequestWithRetry(httpClient, method, url, requestBody, responseBody, requireAuth, retriesLeft-1)
 		}
 
 		return fmt.Errorf("HTTP error %d: %s", resp.StatusCode, string(respBody))
@@ -180,4 +180,16 @@ func (cm *CollectorManager) CollectOS() (*OS, error) {
 		return &os, nil
 	}
 	return nil, fmt.Errorf("OS collector returned unexpected type")
+}
+
+// CollectPerformance collects only the current CPU and memory readings for sampling
+func (cm *CollectorManager) CollectPerformance() (*Performance, error) {
+	data, err := cm.performanceCollector.Collect()
+	if err != nil {
+		return nil, err
+	}
+	if performance, ok := data.(Performance); ok {
+		return &performance, nil
+	}
+	return nil, fmt.Errorf("performance collector returned unexpected type")
 }
\ No newline at end of file
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/tracr/agent/internal/client"
+	"github.com/tracr/agent/internal/config"
+	"github.com/tracr/agent/internal/logger"
+)
+
+const (
+	// stableChannel is how long a channel must stay up for the reconnect
+	// backoff to start over
+	stableChannel = time.Minute
+
+	// channelQueueSize bounds the commands waiting for the one running.
+	// Commands dropped when it is full are delivered again once their lease
+	// expires.
+	channelQueueSize = 16
+)
+
+// runChannel keeps the agent channel open so that commands and settings
+// arrive as soon as they are sent. While the channel is down, commands are
+// polled over HTTP until the next connection attempt, which backs off
+// exponentially up to the maximum backoff time.
+func (e *Executor) runChannel(ctx context.Context) {
+	failures := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.done:
+			return
+		default:
+		}
+
+		delay := e.channelBackoff(failures)
+		if e.config.DeviceID != "" && e.config.DeviceToken != "" {
+			connectedAt := time.Now()
+			connected, err := e.serveChannel(ctx)
+			if connected && time.Since(connectedAt) >= stableChannel {
+				failures = 0
+			}
+			failures++
+			delay = e.channelBackoff(failures)
+			logger.Warn("Agent channel unavailable, polling for commands",
+				"error", err,
+				"reconnect_in", delay)
+		}
+
+		// Poll over HTTP until it is time to reconnect
+		reconnectAt := time.Now().Add(delay)
+		for time.Now().Before(reconnectAt) {
+			if !e.pollCycle(ctx, reconnectAt) {
+				return
+			}
+		}
+	}
+}
+
+// channelBackoff returns how long to wait before reconnecting after a number
+// of consecutive failures
+func (e *Executor) channelBackoff(failures int) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	backoff := time.Duration(math.Pow(e.config.BackoffMultiplier, float64(failures-1)) * float64(time.Second))
+	if backoff < time.Second {
+		backoff = time.Second
+	}
+	if e.config.MaxBackoffTime > 0 && backoff > e.config.MaxBackoffTime {
+		backoff = e.config.MaxBackoffTime
+	}
+	return backoff
+}
+
+// serveChannel opens the agent channel and handles its messages until it
+// fails or the executor stops. Reports whether the channel was opened.
+func (e *Executor) serveChannel(ctx context.Context) (bool, error) {
+	ch, err := e.client.DialChannel(e.config.DeviceID)
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+
+	logger.Info("Agent channel connected")
+
+	e.setChannel(ch)
+	defer e.setChannel(nil)
+
+	// Close the channel when the executor stops to end the pending Receive
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-e.done:
+		case <-closed:
+			return
+		}
+		ch.Close()
+	}()
+
+	// Commands run one at a time in the order they arrive, as when polling.
+	// Commands queued when the channel drops still run, one at a time with
+	// polled ones, and their results are sent over HTTP.
+	queue := make(chan client.Command, channelQueueSize)
+	defer close(queue)
+	go func() {
+		for command := range queue {
+			e.executeCommand(ctx, command)
+		}
+	}()
+
+	for {
+		message, err := ch.Receive()
+		if err != nil {
+			return true, err
+		}
+
+		switch message.Type {
+		case client.ChannelCommand:
+			var command client.Command
+			if err := json.Unmarshal(message.Data, &command); err != nil {
+				logger.Error("Failed to decode command", "error", err)
+				continue
+			}
+			logger.Info("Received command", "id", command.ID, "type", command.CommandType)
+			select {
+			case queue <- command:
+			default:
+				logger.Warn("Command queue full, command will be delivered again", "id", command.ID)
+			}
+
+		case client.ChannelConfig:
+			var update config.Update
+			if err := json.Unmarshal(message.Data, &update); err != nil {
+				logger.Error("Failed to decode settings", "error", err)
+				continue
+			}
+			e.applyConfig(update)
+
+		case client.ChannelError:
+			logger.Warn("Agent channel message rejected by the API", "error", string(message.Data))
+
+		default:
+			logger.Debug("Ignoring unknown agent channel message", "type", message.Type)
+		}
+	}
+}
+
+// applyConfig applies settings pushed from the console. They are not saved,
+// as the API sends them again whenever the channel opens.
+func (e *Executor) applyConfig(update config.Update) {
+	changed, err := e.config.Apply(update)
+	if err != nil {
+		logger.Error("Failed to apply settings from the API", "error", err)
+		return
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, name := range changed {
+		if name == "log_level" {
+			logger.SetLevel(e.config.LogLevel)
+		}
+	}
+	logger.Info("Applied settings from the API", "changed", changed)
+}
+
+func (e *Executor) setChannel(ch *client.Channel) {
+	e.channelMu.Lock()
+	defer e.channelMu.Unlock()
+	e.channel = ch
+}
+
+func (e *Executor) currentChannel() *client.Channel {
+	e.channelMu.Lock()
+	defer e.channelMu.Unlock()
+	return e.channel
+}
+
+// SendHeartbeat sends a heartbeat over the agent channel and reports whether
+// it was sent. Callers send it over HTTP otherwise.
+func (e *Executor) SendHeartbeat(errors ...string) bool {
+	ch := e.currentChannel()
+	if ch == nil {
+		return false
+	}
+	if err := ch.Heartbeat(errors...); err != nil {
+		logger.Warn("Failed to send heartbeat over the agent channel", "error", err)
+		return false
+	}
+	return true
+}
+
+// acknowledge reports the result of a command over the agent channel when it
+// is open, and over HTTP otherwise
+func (e *Executor) acknowledge(commandID string, result client.CommandResult) error {
+	if ch := e.currentChannel(); ch != nil {
+		err := ch.Send(client.ChannelAck, client.CommandAck{CommandID: commandID, CommandResult: result})
+		if err == nil {
+			return nil
+		}
+		logger.Warn("Failed to acknowledge command over the agent channel, using HTTP", "id", commandID, "error", err)
+	}
+	return e.client.AckCommand(e.config.DeviceID, commandID, result)
+}
+
+// reportProgress streams the progress of a command over the agent channel
+func (e *Executor) reportProgress(commandID, message string, percent int) {
+	ch := e.currentChannel()
+	if ch == nil {
+		return
+	}
+	if err := ch.Send(client.ChannelProgress, client.CommandProgress{
+		CommandID: commandID,
+		Message:   message,
+		Percent:   &percent,
+	}); err != nil {
+		logger.Debug("Failed to report command progress", "id", commandID, "error", err)
+	}
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/tracr/agent/internal/client"
@@ -11,6 +12,9 @@ import (
 	"github.com/tracr/agent/internal/logger"
 )
 
+// defaultTimeout applies to commands sent without a timeout
+const defaultTimeout = 5 * time.Minute
+
 type Executor struct {
 	config           *config.Config
 	client           *client.Client
@@ -18,6 +22,14 @@ type Executor struct {
 	ticker           *time.Ticker
 	done             chan struct{}
 	triggerChan      chan struct{} // For external triggers (e.g., from scheduler)
+
+	// Agent channel, set while connected
+	channelMu sync.Mutex
+	channel   *client.Channel
+
+	// Held while a command runs. Commands still queued from a dropped channel
+	// would otherwise run alongside polled ones.
+	runMu sync.Mutex
 }
 
 func NewExecutor(cfg *config.Config, client *client.Client, collectorManager *collectors.CollectorManager) *Executor {
@@ -31,13 +43,22 @@ func NewExecutor(cfg *config.Config, client *client.Client, collectorManager *co
 }
 
 func (e *Executor) Start(ctx context.Context) error {
-	if e.config.DeviceID == "" || e.config.DeviceToken == "" {
-		logger.Debug("Device not registered, command executor not starting")
+	logger.Info("Command executor starting",
+		"poll_interval", e.config.CommandPollInterval,
+		"wait", e.config.CommandWait,
+		"channel", e.config.ChannelEnabled,
+		"command_types", Types())
+	
+	if e.config.ChannelEnabled {
+		go e.runChannel(ctx)
+		return nil
+	}
+
+	if e.config.CommandWait > 0 {
+		go e.runLongPoll(ctx)
 		return nil
 	}
 
-	logger.Info("Command executor starting", "poll_interval", e.config.CommandPollInterval)
-	
 	e.ticker = time.NewTicker(e.config.CommandPollInterval)
 	
 	go e.run(ctx)
@@ -63,13 +84,55 @@ func (e *Executor) run(ctx context.Context) {
 		case <-e.done:
 			return
 		case <-e.ticker.C:
-			e.pollAndExecuteCommands()
+			e.pollAndExecuteCommands(ctx)
 		case <-e.triggerChan:
-			e.pollAndExecuteCommands()
+			e.pollAndExecuteCommands(ctx)
 		}
 	}
 }
 
+// runLongPoll polls with a wait so that commands start as soon as they are
+// queued
+func (e *Executor) runLongPoll(ctx context.Context) {
+	for e.pollCycle(ctx, time.Time{}) {
+	}
+}
+
+// pollCycle polls once. After a failed poll, or when the API answers at once
+// without commands as APIs without long-polling do, it pauses for the poll
+// interval or until the deadline when one is given. Returns false once the
+// executor is stopped.
+func (e *Executor) pollCycle(ctx context.Context, deadline time.Time) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-e.done:
+		return false
+	default:
+	}
+
+	start := time.Now()
+	received, err := e.pollAndExecuteCommands(ctx)
+	if err == nil && e.config.CommandWait > 0 && (received > 0 || time.Since(start) >= e.config.CommandWait/2) {
+		return true
+	}
+
+	pause := e.config.CommandPollInterval
+	if !deadline.IsZero() && time.Until(deadline) < pause {
+		pause = time.Until(deadline)
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-e.done:
+		return false
+	case <-time.After(pause):
+	case <-e.triggerChan:
+	}
+	return true
+}
+
 func (e *Executor) TriggerPoll() {
 	select {
 	case e.triggerChan <- struct{}{}:
@@ -78,42 +141,44 @@ func (e *Executor) TriggerPoll() {
 	}
 }
 
-func (e *Executor) pollAndExecuteCommands() {
-	logger.Debug("Polling for commands")
+// pollAndExecuteCommands polls for commands and executes them, returning how
+// many were received
+func (e *Executor) pollAndExecuteCommands(ctx context.Context) (int, error) {
+	if e.config.DeviceID == "" || e.config.DeviceToken == "" {
+		logger.Debug("Device not registered, skipping command poll")
+		return 0, nil
+	}
+
+	logger.Debug("Polling for commands", "wait", e.config.CommandWait)
 	
-	commands, err := e.client.PollCommands(e.config.DeviceID)
+	commands, err := e.client.PollCommands(e.config.DeviceID, e.config.CommandWait)
 	if err != nil {
 		logger.Error("Failed to poll commands", "error", err)
-		return
+		return 0, err
 	}
 
 	if len(commands) == 0 {
 		logger.Debug("No pending commands")
-		return
+		return 0, nil
 	}
 
 	logger.Info("Received commands", "count", len(commands))
 
 	for _, command := range commands {
-		e.executeCommand(command)
+		e.executeCommand(ctx, command)
 	}
+
+	return len(commands), nil
 }
 
-func (e *Executor) executeCommand(command client.Command) {
+func (e *Executor) executeCommand(ctx context.Context, command client.Command) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
 	logger.Info("Executing command", "id", command.ID, "type", command.CommandType)
 	
 	start := time.Now()
-	result := client.CommandResult{
-		Success: false,
-	}
-
-	switch command.CommandType {
-	case "refresh_now":
-		result = e.executeRefreshNow()
-	default:
-		result.Error = fmt.Sprintf("unknown command type: %s", command.CommandType)
-		logger.Error("Unknown command type", "type", command.CommandType, "id", command.ID)
-	}
+	result := e.runHandler(ctx, command)
 
 	duration := time.Since(start)
 	logger.Info("Command execution completed", 
@@ -123,36 +188,61 @@ func (e *Executor) executeCommand(command client.Command) {
 		"duration", duration)
 
 	// Send acknowledgment
-	if err := e.client.AckCommand(e.config.DeviceID, command.ID, result); err != nil {
+	if err := e.acknowledge(command.ID, result); err != nil {
 		logger.Error("Failed to acknowledge command", "id", command.ID, "error", err)
 	} else {
 		logger.Debug("Command acknowledged", "id", command.ID)
 	}
 }
 
-func (e *Executor) executeRefreshNow() client.CommandResult {
-	logger.Info("Executing refresh_now command")
-
-	// Collect fresh inventory data
-	snapshot, err := e.collectorManager.CollectAll()
-	if err != nil {
+// runHandler runs the handler registered for the command type, giving up once
+// the command's timeout has passed
+func (e *Executor) runHandler(ctx context.Context, command client.Command) client.CommandResult {
+	handler, ok := Lookup(command.CommandType)
+	if !ok {
+		logger.Error("Unknown command type", "type", command.CommandType, "id", command.ID)
 		return client.CommandResult{
 			Success: false,
-			Error:   fmt.Sprintf("failed to collect inventory: %v", err),
+			Error:   fmt.Sprintf("unknown command type: %s", command.CommandType),
 		}
 	}
 
-	// Send inventory to API
-	if err := e.client.SendInventory(e.config.DeviceID, snapshot); err != nil {
+	timeout := defaultTimeout
+	if command.TimeoutSeconds > 0 {
+		timeout = time.Duration(command.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	env := &Environment{
+		Config:           e.config,
+		Client:           e.client,
+		CollectorManager: e.collectorManager,
+		progress: func(message string, percent int) {
+			e.reportProgress(command.ID, message, percent)
+		},
+	}
+
+	type outcome struct {
+		message string
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		message, err := handler(ctx, env, command)
+		done <- outcome{message, err}
+	}()
+
+	select {
+	case <-ctx.Done():
 		return client.CommandResult{
 			Success: false,
-			Error:   fmt.Sprintf("failed to send inventory: %v", err),
+			Error:   fmt.Sprintf("command timed out after %s", timeout),
 		}
+	case o := <-done:
+		if o.err != nil {
+			return client.CommandResult{Success: false, Error: o.err.Error()}
+		}
+		return client.CommandResult{Success: true, Message: o.message}
 	}
-
-	return client.CommandResult{
-		Success: true,
-		Message: fmt.Sprintf("Inventory refreshed successfully. Collected %d volumes, %d software items", 
-			len(snapshot.Volumes), len(snapshot.Software)),
-	}
-}
\ No newline at end of file
+}
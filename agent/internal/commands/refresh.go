@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tracr/agent/internal/client"
+	"github.com/tracr/agent/internal/logger"
+)
+
+func init() {
+	Register("refresh_now", refreshNow)
+}
+
+// refreshNow collects and submits a fresh inventory
+func refreshNow(ctx context.Context, env *Environment, command client.Command) (string, error) {
+	logger.Info("Executing refresh_now command")
+
+	// Collect fresh inventory data
+	env.ReportProgress("Collecting inventory", 0)
+	snapshot, err := env.CollectorManager.CollectAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to collect inventory: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// Send inventory to API
+	env.ReportProgress("Sending inventory", 80)
+	if err := env.Client.SendInventory(env.Config.DeviceID, snapshot); err != nil {
+		return "", fmt.Errorf("failed to send inventory: %w", err)
+	}
+
+	return fmt.Sprintf("Inventory refreshed successfully. Collected %d volumes, %d software items",
+		len(snapshot.Volumes), len(snapshot.Software)), nil
+}
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tracr/agent/internal/client"
+	"github.com/tracr/agent/internal/collectors"
+	"github.com/tracr/agent/internal/config"
+)
+
+// Environment gives command handlers access to the agent
+type Environment struct {
+	Config           *config.Config
+	Client           *client.Client
+	CollectorManager *collectors.CollectorManager
+
+	// progress sends progress reports of the running command
+	progress func(message string, percent int)
+}
+
+// ReportProgress reports how far the running command got, as a percentage
+// from 0 to 100. Progress is streamed over the agent channel and dropped when
+// the agent is not connected to it.
+func (env *Environment) ReportProgress(message string, percent int) {
+	if env.progress != nil {
+		env.progress(message, percent)
+	}
+}
+
+// Handler executes a command of the type it is registered for and returns a
+// message describing the outcome. Handlers should stop when ctx is done, which
+// happens once the command's timeout has passed.
+type Handler func(ctx context.Context, env *Environment, command client.Command) (string, error)
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+)
+
+// Register adds the handler for a command type, typically from an init
+// function. Registering a type twice panics.
+func Register(commandType string, handler Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	if _, exists := handlers[commandType]; exists {
+		panic(fmt.Sprintf("handler for command type %s registered twice", commandType))
+	}
+	handlers[commandType] = handler
+}
+
+// Lookup returns the handler for a command type
+func Lookup(commandType string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	handler, ok := handlers[commandType]
+	return handler, ok
+}
+
+// Types returns the command types with a registered handler, in order
+func Types() []string {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	types := make([]string, 0, len(handlers))
+	for commandType := range handlers {
+		types = append(types, commandType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -24,6 +24,11 @@ type Config struct {
 	CollectionInterval time.Duration `json:"collection_interval"`
 	JitterPercent      float64       `json:"jitter_percent"`
 
+	// Performance Sampling Settings
+	SampleInterval       time.Duration `json:"sample_interval"`
+	SampleUploadInterval time.Duration `json:"sample_upload_interval"`
+	SampleBufferSize     int           `json:"sample_buffer_size"`
+
 	// Retry Settings
 	MaxRetries       int           `json:"max_retries"`
 	BackoffMultiplier float64       `json:"backoff_multiplier"`
@@ -41,6 +46,8 @@ type Config struct {
 	RequestTimeout    time.Duration `json:"request_timeout"`
 	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
 	CommandPollInterval time.Duration `json:"command_poll_interval"`
+	CommandWait         time.Duration `json:"command_wait"` // long-poll wait for commands, 0 to poll on the interval
+	ChannelEnabled      bool          `json:"channel_enabled"` // keep a WebSocket open to the API for commands and settings
 }
 
 func DefaultConfig() *Config {
@@ -48,6 +55,9 @@ func DefaultConfig() *Config {
 		APIEndpoint:         "https://web-production-c4a4.up.railway.app",
 		CollectionInterval:  15 * time.Minute,
 		JitterPercent:       0.1,
+		SampleInterval:       30 * time.Second,
+		SampleUploadInterval: 5 * time.Minute,
+		SampleBufferSize:     2880,
 		MaxRetries:         5,
 		BackoffMultiplier:  2.0,
 		MaxBackoffTime:     5 * time.Minute,
@@ -58,6 +68,7 @@ func DefaultConfig() *Config {
 		RequestTimeout:     30 * time.Second,
 		HeartbeatInterval:  5 * time.Minute,
 		CommandPollInterval: 60 * time.Second,
+		CommandWait:         30 * time.Second,
 	}
 }
 
@@ -130,6 +141,9 @@ func loadFromFile(cfg *Config, path string) error {
 		DeviceToken        string  `json:"device_token,omitempty"`
 		CollectionInterval string  `json:"collection_interval"`
 		JitterPercent      float64 `json:"jitter_percent"`
+		SampleInterval       string `json:"sample_interval"`
+		SampleUploadInterval string `json:"sample_upload_interval"`
+		SampleBufferSize     int    `json:"sample_buffer_size"`
 		MaxRetries         int     `json:"max_retries"`
 		BackoffMultiplier  float64 `json:"backoff_multiplier"`
 		MaxBackoffTime     string  `json:"max_backoff_time"`
@@ -140,6 +154,8 @@ func loadFromFile(cfg *Config, path string) error {
 		RequestTimeout     string  `json:"request_timeout"`
 		HeartbeatInterval  string  `json:"heartbeat_interval"`
 		CommandPollInterval string `json:"command_poll_interval"`
+		CommandWait         string `json:"command_wait"`
+		ChannelEnabled      bool   `json:"channel_enabled"`
 	}
 
 	if err := json.Unmarshal(data, &temp); err != nil {
@@ -159,6 +175,9 @@ func loadFromFile(cfg *Config, path string) error {
 	if temp.JitterPercent > 0 {
 		cfg.JitterPercent = temp.JitterPercent
 	}
+	if temp.SampleBufferSize > 0 {
+		cfg.SampleBufferSize = temp.SampleBufferSize
+	}
 	if temp.MaxRetries > 0 {
 		cfg.MaxRetries = temp.MaxRetries
 	}
@@ -177,6 +196,9 @@ func loadFromFile(cfg *Config, path string) error {
 	if temp.LogDir != "" {
 		cfg.LogDir = temp.LogDir
 	}
+	if temp.ChannelEnabled {
+		cfg.ChannelEnabled = true
+	}
 
 	// Parse duration fields
 	if temp.CollectionInterval != "" {
@@ -184,6 +206,16 @@ func loadFromFile(cfg *Config, path string) error {
 			cfg.CollectionInterval = d
 		}
 	}
+	if temp.SampleInterval != "" {
+		if d, err := time.ParseDuration(temp.SampleInterval); err == nil {
+			cfg.SampleInterval = d
+		}
+	}
+	if temp.SampleUploadInterval != "" {
+		if d, err := time.ParseDuration(temp.SampleUploadInterval); err == nil {
+			cfg.SampleUploadInterval = d
+		}
+	}
 	if temp.MaxBackoffTime != "" {
 		if d, err := time.ParseDuration(temp.MaxBackoffTime); err == nil {
 			cfg.MaxBackoffTime = d
@@ -204,10 +236,73 @@ func loadFromFile(cfg *Config, path string) error {
 			cfg.CommandPollInterval = d
 		}
 	}
+	if temp.CommandWait != "" {
+		if d, err := time.ParseDuration(temp.CommandWait); err == nil {
+			cfg.CommandWait = d
+		}
+	}
 
 	return nil
 }
 
+// Update holds settings managed from the console and pushed over the agent
+// channel. Durations are written like 15m or 30s. Settings left out keep
+// their current value.
+type Update struct {
+	CollectionInterval   *string `json:"collection_interval,omitempty"`
+	SampleInterval       *string `json:"sample_interval,omitempty"`
+	SampleUploadInterval *string `json:"sample_upload_interval,omitempty"`
+	HeartbeatInterval    *string `json:"heartbeat_interval,omitempty"`
+	CommandPollInterval  *string `json:"command_poll_interval,omitempty"`
+	CommandWait          *string `json:"command_wait,omitempty"`
+	LogLevel             *string `json:"log_level,omitempty"`
+}
+
+// Apply copies the settings of an update to the configuration and returns the
+// names of the settings that changed. Nothing is changed when a duration does
+// not parse. Intervals take effect from their next tick.
+func (c *Config) Apply(update Update) ([]string, error) {
+	durations := []struct {
+		name    string
+		value   *string
+		target  *time.Duration
+		minimum time.Duration
+	}{
+		{"collection_interval", update.CollectionInterval, &c.CollectionInterval, time.Minute},
+		{"sample_interval", update.SampleInterval, &c.SampleInterval, time.Second},
+		{"sample_upload_interval", update.SampleUploadInterval, &c.SampleUploadInterval, time.Second},
+		{"heartbeat_interval", update.HeartbeatInterval, &c.HeartbeatInterval, time.Second},
+		{"command_poll_interval", update.CommandPollInterval, &c.CommandPollInterval, time.Second},
+		{"command_wait", update.CommandWait, &c.CommandWait, 0},
+	}
+
+	parsed := make([]time.Duration, len(durations))
+	for i, d := range durations {
+		if d.value == nil {
+			continue
+		}
+		value, err := time.ParseDuration(*d.value)
+		if err != nil || value < d.minimum {
+			return nil, fmt.Errorf("invalid %s: %q", d.name, *d.value)
+		}
+		parsed[i] = value
+	}
+
+	var changed []string
+	for i, d := range durations {
+		if d.value != nil && *d.target != parsed[i] {
+			*d.target = parsed[i]
+			changed = append(changed, d.name)
+		}
+	}
+	if update.LogLevel != nil && *update.LogLevel != c.LogLevel {
+		c.LogLevel = *update.LogLevel
+		changed = append(changed, "log_level")
+	}
+
+	return changed, nil
+}
+
 func loadFromEnv(cfg *Config) {
 	if endpoint := os.Getenv("TRACR_API_ENDPOINT"); endpoint != "" {
 		cfg.APIEndpoint = endpoint
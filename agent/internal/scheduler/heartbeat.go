@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tracr/agent/internal/logger"
+)
+
+// maxReportedErrors bounds the errors kept for the next heartbeat, matching
+// the limit enforced by the API
+const maxReportedErrors = 20
+
+// runHeartbeat tells the API the device is alive on the heartbeat interval and
+// reports the errors recorded since the previous heartbeat
+func (s *Scheduler) runHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(s.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	logger.Info("Heartbeat started", "interval", s.config.HeartbeatInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sendHeartbeat()
+
+			// Pick up an interval changed from the console
+			ticker.Reset(s.config.HeartbeatInterval)
+		}
+	}
+}
+
+// sendHeartbeat sends a heartbeat with the pending errors, over the agent
+// channel when it is open, and keeps them for the next one on failure
+func (s *Scheduler) sendHeartbeat() {
+	if s.config.DeviceID == "" || s.config.DeviceToken == "" {
+		logger.Debug("Device not registered, skipping heartbeat")
+		return
+	}
+
+	s.errorsMu.Lock()
+	pending := s.errors
+	s.errors = nil
+	s.errorsMu.Unlock()
+
+	if s.executor.SendHeartbeat(pending...) {
+		logger.Debug("Heartbeat sent over the agent channel", "errors", len(pending))
+		return
+	}
+
+	if err := s.client.Heartbeat(s.config.DeviceID, pending...); err != nil {
+		logger.Error("Failed to send heartbeat", "error", err)
+
+		// Keep the errors, placing any recorded during the request after them
+		s.errorsMu.Lock()
+		s.errors = append(pending, s.errors...)
+		if len(s.errors) > maxReportedErrors {
+			s.errors = s.errors[len(s.errors)-maxReportedErrors:]
+		}
+		s.errorsMu.Unlock()
+		return
+	}
+
+	logger.Debug("Heartbeat sent", "errors", len(pending))
+}
+
+// reportError records an error to be reported with the next heartbeat, keeping
+// the most recent ones once the limit is reached
+func (s *Scheduler) reportError(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	s.errorsMu.Lock()
+	defer s.errorsMu.Unlock()
+
+	s.errors = append(s.errors, message)
+	if len(s.errors) > maxReportedErrors {
+		s.errors = s.errors[len(s.errors)-maxReportedErrors:]
+	}
+}
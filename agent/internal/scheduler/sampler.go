@@ -0,0 +1,250 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tracr/agent/internal/logger"
+)
+
+// Metric names used for batch summaries, matching the API metric names
+const (
+	MetricCPUPercent      = "cpu_percent"
+	MetricMemoryUsedBytes = "memory_used_bytes"
+)
+
+// PerformanceSample is a single CPU and memory reading taken between inventory uploads
+type PerformanceSample struct {
+	Timestamp        time.Time `json:"timestamp"`
+	CPUPercent       float64   `json:"cpu_percent"`
+	MemoryUsedBytes  uint64    `json:"memory_used_bytes"`
+	MemoryTotalBytes uint64    `json:"memory_total_bytes"`
+}
+
+// SampleSummary describes the distribution of one metric across a batch
+type SampleSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+	P95   float64 `json:"p95"`
+}
+
+// PerformanceBatch is the payload uploaded to the API with buffered samples
+type PerformanceBatch struct {
+	StartedAt       time.Time                `json:"started_at"`
+	EndedAt         time.Time                `json:"ended_at"`
+	IntervalSeconds int                      `json:"interval_seconds"`
+	Samples         []PerformanceSample      `json:"samples"`
+	Summaries       map[string]SampleSummary `json:"summaries"`
+}
+
+// Summarize computes min, average, max and 95th percentile (nearest rank) of values
+func Summarize(values []float64) SampleSummary {
+	if len(values) == 0 {
+		return SampleSummary{}
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, value := range sorted {
+		sum += value
+	}
+
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return SampleSummary{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Avg:   sum / float64(len(sorted)),
+		Max:   sorted[len(sorted)-1],
+		P95:   sorted[rank],
+	}
+}
+
+// NewPerformanceBatch builds an upload batch with per-metric summaries
+func NewPerformanceBatch(samples []PerformanceSample, interval time.Duration) *PerformanceBatch {
+	batch := &PerformanceBatch{
+		IntervalSeconds: int(interval / time.Second),
+		Samples:         samples,
+		Summaries:       make(map[string]SampleSummary),
+	}
+	if len(samples) == 0 {
+		return batch
+	}
+
+	cpu := make([]float64, 0, len(samples))
+	memory := make([]float64, 0, len(samples))
+	batch.StartedAt = samples[0].Timestamp
+	batch.EndedAt = samples[0].Timestamp
+
+	for _, sample := range samples {
+		cpu = append(cpu, sample.CPUPercent)
+		memory = append(memory, float64(sample.MemoryUsedBytes))
+
+		if sample.Timestamp.Before(batch.StartedAt) {
+			batch.StartedAt = sample.Timestamp
+		}
+		if sample.Timestamp.After(batch.EndedAt) {
+			batch.EndedAt = sample.Timestamp
+		}
+	}
+
+	batch.Summaries[MetricCPUPercent] = Summarize(cpu)
+	batch.Summaries[MetricMemoryUsedBytes] = Summarize(memory)
+
+	return batch
+}
+
+// runSampling takes performance samples on a short interval and uploads them in batches
+func (s *Scheduler) runSampling(ctx context.Context) {
+	s.loadPendingSamples()
+
+	sampleTicker := time.NewTicker(s.config.SampleInterval)
+	defer sampleTicker.Stop()
+
+	uploadTicker := time.NewTicker(s.config.SampleUploadInterval)
+	defer uploadTicker.Stop()
+
+	logger.Info("Performance sampling started",
+		"interval", s.config.SampleInterval,
+		"upload_interval", s.config.SampleUploadInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.savePendingSamples()
+			return
+		case <-s.done:
+			s.savePendingSamples()
+			return
+		case <-sampleTicker.C:
+			s.takeSample()
+			sampleTicker.Reset(s.config.SampleInterval)
+		case <-uploadTicker.C:
+			s.uploadSamples()
+			uploadTicker.Reset(s.config.SampleUploadInterval)
+		}
+	}
+}
+
+// takeSample records the current CPU and memory readings in the local buffer
+func (s *Scheduler) takeSample() {
+	performance, err := s.collectorManager.CollectPerformance()
+	if err != nil {
+		logger.Warn("Failed to collect performance sample", "error", err)
+		s.reportError("performance sampling failed: %v", err)
+		return
+	}
+
+	sample := PerformanceSample{
+		Timestamp:        time.Now().UTC(),
+		CPUPercent:       performance.CPUPercent,
+		MemoryUsedBytes:  performance.MemoryUsedBytes,
+		MemoryTotalBytes: performance.MemoryTotalBytes,
+	}
+
+	s.samplesMu.Lock()
+	defer s.samplesMu.Unlock()
+
+	s.samples = append(s.samples, sample)
+
+	// Drop the oldest samples once the buffer is full
+	if limit := s.config.SampleBufferSize; limit > 0 && len(s.samples) > limit {
+		dropped := len(s.samples) - limit
+		s.samples = s.samples[dropped:]
+		s.samplesDropped += dropped
+	}
+}
+
+// uploadSamples sends the buffered samples to the API and keeps them on failure
+func (s *Scheduler) uploadSamples() {
+	if s.config.DeviceID == "" || s.config.DeviceToken == "" {
+		logger.Debug("Device not registered, keeping performance samples buffered")
+		return
+	}
+
+	s.samplesMu.Lock()
+	pending := make([]PerformanceSample, len(s.samples))
+	copy(pending, s.samples)
+	dropped := s.samplesDropped
+	s.samplesMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	batch := NewPerformanceBatch(pending, s.config.SampleInterval)
+	if err := s.client.SendPerformanceSamples(s.config.DeviceID, batch); err != nil {
+		logger.Error("Failed to upload performance samples, will retry",
+			"error", err,
+			"samples", len(pending))
+		s.savePendingSamples()
+		return
+	}
+
+	// Remove the uploaded samples, keeping any taken during the upload. Samples
+	// dropped from the front of a full buffer meanwhile were among those uploaded.
+	s.samplesMu.Lock()
+	if uploaded := len(pending) - (s.samplesDropped - dropped); uploaded > 0 {
+		s.samples = s.samples[uploaded:]
+	}
+	s.samplesMu.Unlock()
+
+	if err := s.storage.ClearPendingSamples(); err != nil {
+		logger.Error("Failed to clear pending performance samples", "error", err)
+	}
+
+	logger.Debug("Performance samples uploaded",
+		"samples", len(pending),
+		"cpu_p95", batch.Summaries[MetricCPUPercent].P95)
+}
+
+// savePendingSamples persists the buffered samples so they survive restarts
+func (s *Scheduler) savePendingSamples() {
+	s.samplesMu.Lock()
+	pending := make([]PerformanceSample, len(s.samples))
+	copy(pending, s.samples)
+	s.samplesMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := s.storage.SavePendingSamples(pending); err != nil {
+		logger.Error("Failed to save pending performance samples", "error", err)
+	}
+}
+
+// loadPendingSamples restores samples left over from a previous run
+func (s *Scheduler) loadPendingSamples() {
+	data, err := s.storage.LoadPendingSamples()
+	if err != nil {
+		logger.Error("Failed to load pending performance samples", "error", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	var pending []PerformanceSample
+	if err := json.Unmarshal(data, &pending); err != nil {
+		logger.Error("Failed to parse pending performance samples", "error", err)
+		return
+	}
+
+	s.samplesMu.Lock()
+	s.samples = append(pending, s.samples...)
+	s.samplesMu.Unlock()
+
+	logger.Info("Restored pending performance samples", "samples", len(pending))
+}
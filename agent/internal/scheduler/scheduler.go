@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/tracr/agent/internal/collectors"
+	"github.com/tracr/agent/internal/commands"
 	"github.com/tracr/agent/internal/config"
 	"github.com/tracr/agent/internal/client"
 	"github.com/tracr/agent/internal/logger"
@@ -19,8 +21,18 @@ type Scheduler struct {
 	collectorManager *collectors.CollectorManager
 	storage          *storage.Storage
 	client           *client.Client
+	executor         *commands.Executor
 	ticker           *time.Ticker
 	done             chan struct{}
+
+	// Performance samples buffered between uploads
+	samplesMu      sync.Mutex
+	samples        []PerformanceSample
+	samplesDropped int // samples dropped from the front of a full buffer
+
+	// Errors reported with the next heartbeat
+	errorsMu sync.Mutex
+	errors   []string
 }
 
 func New(cfg *config.Config) *Scheduler {
@@ -33,6 +45,7 @@ func New(cfg *config.Config) *Scheduler {
 		collectorManager: collectorManager,
 		storage:          storage,
 		client:           client,
+		executor:         commands.NewExecutor(cfg, client, collectorManager),
 		done:             make(chan struct{}),
 	}
 }
@@ -59,6 +72,29 @@ func (s *Scheduler) Start(ctx context.Context) error {
 
 	go s.run(ctx)
 
+	// Start performance sampling between inventory uploads
+	if s.config.SampleInterval > 0 && s.config.SampleUploadInterval > 0 {
+		go s.runSampling(ctx)
+	} else {
+		logger.Info("Performance sampling disabled")
+	}
+
+	// Start heartbeats, which also report collection and upload errors
+	if s.config.HeartbeatInterval > 0 {
+		go s.runHeartbeat(ctx)
+	} else {
+		logger.Info("Heartbeat disabled")
+	}
+
+	// Start polling for commands sent from the console
+	if s.config.CommandPollInterval > 0 {
+		if err := s.executor.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start command executor: %w", err)
+		}
+	} else {
+		logger.Info("Command polling disabled")
+	}
+
 	return nil
 }
 
@@ -68,6 +104,10 @@ func (s *Scheduler) Stop() {
 	if s.ticker != nil {
 		s.ticker.Stop()
 	}
+
+	if s.config.CommandPollInterval > 0 {
+		s.executor.Stop()
+	}
 	
 	close(s.done)
 }
@@ -110,6 +150,7 @@ func (s *Scheduler) runCollection() {
 	snapshot, err := s.collectorManager.CollectAll()
 	if err != nil {
 		logger.Error("Failed to collect inventory", "error", err)
+		s.reportError("inventory collection failed: %v", err)
 		return
 	}
 
@@ -130,6 +171,7 @@ func (s *Scheduler) runCollection() {
 				"device_id", s.config.DeviceID,
 				"api_endpoint", s.config.APIEndpoint,
 				"hint", "Check if device is registered in database or if credentials are valid")
+			s.reportError("inventory upload failed: %v", err)
 		} else {
 			logger.Info("Inventory sent successfully to API",
 				"device_id", s.config.DeviceID,
@@ -140,6 +140,55 @@ func (s *Storage) LoadLatestSnapshot() ([]byte, error) {
 	return data, nil
 }
 
+// SavePendingSamples persists performance samples that have not been uploaded yet
+func (s *Storage) SavePendingSamples(data interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending samples: %w", err)
+	}
+
+	if err := os.WriteFile(s.pendingSamplesPath(), jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write pending samples file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadPendingSamples returns the persisted performance samples, or nil if none are pending
+func (s *Storage) LoadPendingSamples() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := os.ReadFile(s.pendingSamplesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read pending samples file: %w", err)
+	}
+
+	return data, nil
+}
+
+// ClearPendingSamples removes the persisted performance samples after a successful upload
+func (s *Storage) ClearPendingSamples() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.pendingSamplesPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove pending samples file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) pendingSamplesPath() string {
+	return filepath.Join(s.dataDir, "pending_samples.json")
+}
+
 func (s *Storage) loadDeviceInfo() error {
 	deviceInfoPath := filepath.Join(s.dataDir, "device.json")
 
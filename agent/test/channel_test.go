@@ -0,0 +1,268 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tracr/agent/internal/client"
+	"github.com/tracr/agent/internal/commands"
+	"github.com/tracr/agent/internal/config"
+)
+
+func TestChannel(t *testing.T) {
+	t.Run("SendAndReceive", func(t *testing.T) {
+		received := make(chan client.ChannelMessage, 1)
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/agents/test-device/channel" || r.Header.Get("Authorization") != "Bearer test-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			conn.WriteJSON(client.ChannelMessage{
+				Type: client.ChannelCommand,
+				Data: json.RawMessage(`{"id":"cmd-1","command_type":"refresh_now"}`),
+			})
+
+			var message client.ChannelMessage
+			if err := conn.ReadJSON(&message); err == nil {
+				received <- message
+			}
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:    server.URL,
+			DeviceToken:    "test-token",
+			RequestTimeout: 5 * time.Second,
+		}
+
+		ch, err := client.New(cfg).DialChannel("test-device")
+		if err != nil {
+			t.Fatalf("DialChannel failed: %v", err)
+		}
+		defer ch.Close()
+
+		message, err := ch.Receive()
+		if err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+
+		var command client.Command
+		if message.Type != client.ChannelCommand || json.Unmarshal(message.Data, &command) != nil || command.ID != "cmd-1" {
+			t.Fatalf("Expected command cmd-1, got %s %s", message.Type, message.Data)
+		}
+
+		if err := ch.Heartbeat("disk full"); err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+
+		select {
+		case message := <-received:
+			var heartbeat struct {
+				Errors []string `json:"errors"`
+			}
+			json.Unmarshal(message.Data, &heartbeat)
+			if message.Type != client.ChannelHeartbeat || len(heartbeat.Errors) != 1 || heartbeat.Errors[0] != "disk full" {
+				t.Errorf("Expected heartbeat with the reported error, got %s %s", message.Type, message.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected heartbeat to be received")
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:    server.URL,
+			DeviceToken:    "wrong-token",
+			RequestTimeout: 5 * time.Second,
+		}
+
+		if _, err := client.New(cfg).DialChannel("test-device"); err == nil {
+			t.Fatal("Expected DialChannel to fail")
+		}
+	})
+
+	t.Run("ExecuteOverChannel", func(t *testing.T) {
+		commands.Register("test_progress", func(ctx context.Context, env *commands.Environment, command client.Command) (string, error) {
+			env.ReportProgress("halfway", 50)
+			return "done", nil
+		})
+
+		messages := make(chan client.ChannelMessage, 10)
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/agents/test-device/channel" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			conn.WriteJSON(client.ChannelMessage{
+				Type: client.ChannelConfig,
+				Data: json.RawMessage(`{"heartbeat_interval":"2m"}`),
+			})
+			conn.WriteJSON(client.ChannelMessage{
+				Type: client.ChannelCommand,
+				Data: json.RawMessage(`{"id":"cmd-1","command_type":"test_progress"}`),
+			})
+
+			for {
+				var message client.ChannelMessage
+				if err := conn.ReadJSON(&message); err != nil {
+					return
+				}
+				messages <- message
+			}
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:         server.URL,
+			DeviceID:            "test-device",
+			DeviceToken:         "test-token",
+			RequestTimeout:      5 * time.Second,
+			HeartbeatInterval:   time.Minute,
+			CommandPollInterval: time.Hour,
+			ChannelEnabled:      true,
+		}
+
+		executor := commands.NewExecutor(cfg, client.New(cfg), nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := executor.Start(ctx); err != nil {
+			t.Fatalf("Failed to start executor: %v", err)
+		}
+		defer executor.Stop()
+
+		var progress client.CommandProgress
+		var ack client.CommandAck
+		for ack.CommandID == "" {
+			select {
+			case message := <-messages:
+				switch message.Type {
+				case client.ChannelProgress:
+					json.Unmarshal(message.Data, &progress)
+				case client.ChannelAck:
+					json.Unmarshal(message.Data, &ack)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Expected command to be acknowledged over the channel")
+			}
+		}
+
+		if progress.CommandID != "cmd-1" || progress.Message != "halfway" || progress.Percent == nil || *progress.Percent != 50 {
+			t.Errorf("Expected progress report before the result, got %+v", progress)
+		}
+
+		if ack.CommandID != "cmd-1" || !ack.Success || ack.Message != "done" {
+			t.Errorf("Expected successful result, got %+v", ack)
+		}
+
+		if cfg.HeartbeatInterval != 2*time.Minute {
+			t.Errorf("Expected pushed heartbeat interval to be applied, got %s", cfg.HeartbeatInterval)
+		}
+	})
+
+	t.Run("SerializedAfterDrop", func(t *testing.T) {
+		var running, overlapped, completed int32
+		commands.Register("test_serial", func(ctx context.Context, env *commands.Environment, command client.Command) (string, error) {
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.StoreInt32(&overlapped, 1)
+			}
+			time.Sleep(300 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&completed, 1)
+			return "done", nil
+		})
+
+		var polls int32
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/agents/test-device/channel":
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				// Queue two commands and drop the channel while the first runs
+				conn.WriteJSON(client.ChannelMessage{
+					Type: client.ChannelCommand,
+					Data: json.RawMessage(`{"id":"cmd-1","command_type":"test_serial"}`),
+				})
+				conn.WriteJSON(client.ChannelMessage{
+					Type: client.ChannelCommand,
+					Data: json.RawMessage(`{"id":"cmd-2","command_type":"test_serial"}`),
+				})
+				conn.Close()
+
+			case "/v1/agents/test-device/commands":
+				w.Header().Set("Content-Type", "application/json")
+				if atomic.AddInt32(&polls, 1) == 1 {
+					w.Write([]byte(`[{"id":"cmd-3","command_type":"test_serial"}]`))
+					return
+				}
+				w.Write([]byte(`[]`))
+
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:         server.URL,
+			DeviceID:            "test-device",
+			DeviceToken:         "test-token",
+			RequestTimeout:      5 * time.Second,
+			CommandPollInterval: 50 * time.Millisecond,
+			MaxBackoffTime:      time.Minute,
+			BackoffMultiplier:   2,
+			ChannelEnabled:      true,
+		}
+
+		executor := commands.NewExecutor(cfg, client.New(cfg), nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := executor.Start(ctx); err != nil {
+			t.Fatalf("Failed to start executor: %v", err)
+		}
+		defer executor.Stop()
+
+		deadline := time.Now().Add(10 * time.Second)
+		for atomic.LoadInt32(&completed) < 3 && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		if completed := atomic.LoadInt32(&completed); completed < 3 {
+			t.Fatalf("Expected queued and polled commands to run, %d completed", completed)
+		}
+		if atomic.LoadInt32(&overlapped) != 0 {
+			t.Error("Expected commands queued on a dropped channel to run one at a time with polled commands")
+		}
+	})
+}
@@ -14,7 +14,7 @@ import (
 func TestClient(t *testing.T) {
 	t.Run("Register", func(t *testing.T) {
 		// Mock server
-		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path != "/v1/agents/register" {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
@@ -59,7 +59,7 @@ func TestClient(t *testing.T) {
 	})
 	
 	t.Run("SendInventory", func(t *testing.T) {
-		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path != "/v1/agents/test-device/inventory" {
 				http.Error(w, "not found", http.StatusNotFound)
 				return
@@ -104,7 +104,7 @@ func TestClient(t *testing.T) {
 	t.Run("RetryLogic", func(t *testing.T) {
 		attemptCount := 0
 		
-		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			attemptCount++
 			
 			// Fail first two attempts, succeed on third
@@ -139,8 +139,50 @@ func TestClient(t *testing.T) {
 		}
 	})
 	
+	t.Run("HeartbeatErrors", func(t *testing.T) {
+		var received struct {
+			Timestamp time.Time `json:"timestamp"`
+			Errors    []string  `json:"errors"`
+		}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/agents/test-device/heartbeat" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:    server.URL,
+			DeviceToken:    "test-token",
+			RequestTimeout: 5 * time.Second,
+		}
+
+		c := client.New(cfg)
+
+		if err := c.Heartbeat("test-device", "inventory collection failed"); err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+
+		if len(received.Errors) != 1 || received.Errors[0] != "inventory collection failed" {
+			t.Errorf("Expected reported error to be sent, got %v", received.Errors)
+		}
+
+		if received.Timestamp.IsZero() {
+			t.Error("Expected heartbeat timestamp to be sent")
+		}
+	})
+
 	t.Run("Timeout", func(t *testing.T) {
-		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Simulate slow server
 			time.Sleep(2 * time.Second)
 			w.WriteHeader(http.StatusOK)
@@ -164,7 +206,7 @@ func TestClient(t *testing.T) {
 	})
 	
 	t.Run("PollCommands", func(t *testing.T) {
-		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			commands := []client.Command{
 				{
 					ID:          "cmd-123",
@@ -187,7 +229,7 @@ func TestClient(t *testing.T) {
 		
 		c := client.New(cfg)
 		
-		commands, err := c.PollCommands("test-device")
+		commands, err := c.PollCommands("test-device", 0)
 		if err != nil {
 			t.Fatalf("PollCommands failed: %v", err)
 		}
@@ -204,4 +246,40 @@ func TestClient(t *testing.T) {
 			t.Errorf("Expected command type 'refresh_now', got '%s'", commands[0].CommandType)
 		}
 	})
-}
\ No newline at end of file
+	
+	t.Run("PollCommandsWait", func(t *testing.T) {
+		var wait string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wait = r.URL.Query().Get("wait")
+
+			// Hold the request longer than the request timeout, as the API
+			// does while waiting for a command
+			time.Sleep(700 * time.Millisecond)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]client.Command{})
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:    server.URL,
+			DeviceToken:    "test-token",
+			RequestTimeout: 500 * time.Millisecond,
+		}
+
+		c := client.New(cfg)
+
+		commands, err := c.PollCommands("test-device", time.Second)
+		if err != nil {
+			t.Fatalf("PollCommands with wait failed: %v", err)
+		}
+
+		if wait != "1s" {
+			t.Errorf("Expected wait parameter '1s', got '%s'", wait)
+		}
+
+		if len(commands) != 0 {
+			t.Errorf("Expected no commands, got %d", len(commands))
+		}
+	})
+}
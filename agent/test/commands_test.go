@@ -0,0 +1,108 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracr/agent/internal/client"
+	"github.com/tracr/agent/internal/commands"
+	"github.com/tracr/agent/internal/config"
+)
+
+func TestCommands(t *testing.T) {
+	t.Run("BuiltInHandlers", func(t *testing.T) {
+		if _, ok := commands.Lookup("refresh_now"); !ok {
+			t.Fatal("Expected refresh_now handler to be registered")
+		}
+	})
+
+	t.Run("DuplicateRegistration", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected registering refresh_now twice to panic")
+			}
+		}()
+
+		commands.Register("refresh_now", func(ctx context.Context, env *commands.Environment, command client.Command) (string, error) {
+			return "", nil
+		})
+	})
+
+	t.Run("ExecuteRegisteredHandler", func(t *testing.T) {
+		commands.Register("test_echo", func(ctx context.Context, env *commands.Environment, command client.Command) (string, error) {
+			return "echo " + string(command.Payload), nil
+		})
+		commands.Register("test_slow", func(ctx context.Context, env *commands.Environment, command client.Command) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		})
+
+		results := make(chan client.CommandResult, 3)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/v1/agents/test-device/commands":
+				pending := []client.Command{
+					{ID: "cmd-1", CommandType: "test_echo", Payload: json.RawMessage(`{"value":1}`)},
+					{ID: "cmd-2", CommandType: "test_slow", TimeoutSeconds: 1},
+					{ID: "cmd-3", CommandType: "test_unknown"},
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(pending)
+			case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/ack"):
+				var result client.CommandResult
+				json.NewDecoder(r.Body).Decode(&result)
+				results <- result
+				w.WriteHeader(http.StatusOK)
+			default:
+				http.Error(w, "not found", http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		cfg := &config.Config{
+			APIEndpoint:         server.URL,
+			DeviceID:            "test-device",
+			DeviceToken:         "test-token",
+			RequestTimeout:      5 * time.Second,
+			CommandPollInterval: time.Hour,
+		}
+
+		executor := commands.NewExecutor(cfg, client.New(cfg), nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := executor.Start(ctx); err != nil {
+			t.Fatalf("Failed to start executor: %v", err)
+		}
+		defer executor.Stop()
+
+		executor.TriggerPoll()
+
+		var received []client.CommandResult
+		for len(received) < 3 {
+			select {
+			case result := <-results:
+				received = append(received, result)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("Expected 3 acknowledgements, got %d", len(received))
+			}
+		}
+
+		if !received[0].Success || received[0].Message != `echo {"value":1}` {
+			t.Errorf("Expected echo handler to succeed, got %+v", received[0])
+		}
+
+		if received[1].Success || !strings.Contains(received[1].Error, "timed out") {
+			t.Errorf("Expected slow handler to time out, got %+v", received[1])
+		}
+
+		if received[2].Success || !strings.Contains(received[2].Error, "unknown command type") {
+			t.Errorf("Expected unknown command type to fail, got %+v", received[2])
+		}
+	})
+}
@@ -23,8 +23,7 @@ func (m *MockWMIQuery) SetResponse(query string, response interface{}) {
 
 // Query simulates a WMI query and returns the mock response
 func (m *MockWMIQuery) Query(query string, dst interface{}) error {
-	response, exists := m.responses[query]
-	if !exists {
+	if _, exists := m.responses[query]; !exists {
 		return fmt.Errorf("no mock response for query: %s", query)
 	}
 	
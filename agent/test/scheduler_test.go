@@ -16,7 +16,7 @@ func TestScheduler(t *testing.T) {
 			JitterPercent:     0.1,
 		}
 		
-		s := scheduler.New(cfg)
+		_ = scheduler.New(cfg)
 		
 		// Test multiple jitter calculations to ensure they're within bounds
 		for i := 0; i < 100; i++ {
@@ -107,4 +107,63 @@ func TestSchedulerWithMockTime(t *testing.T) {
 	// 4. Verify exact timing of collections without waiting
 	
 	t.Skip("Mock time implementation needed for deterministic testing")
-}
\ No newline at end of file
+}
+
+func TestPerformanceSummaries(t *testing.T) {
+	t.Run("Summarize", func(t *testing.T) {
+		values := make([]float64, 0, 100)
+		for i := 100; i >= 1; i-- {
+			values = append(values, float64(i))
+		}
+		
+		summary := scheduler.Summarize(values)
+		
+		if summary.Count != 100 {
+			t.Errorf("Expected count 100, got %d", summary.Count)
+		}
+		if summary.Min != 1 || summary.Max != 100 {
+			t.Errorf("Expected min 1 and max 100, got %v and %v", summary.Min, summary.Max)
+		}
+		if summary.Avg != 50.5 {
+			t.Errorf("Expected avg 50.5, got %v", summary.Avg)
+		}
+		if summary.P95 != 95 {
+			t.Errorf("Expected p95 95, got %v", summary.P95)
+		}
+	})
+	
+	t.Run("SummarizeEmpty", func(t *testing.T) {
+		summary := scheduler.Summarize(nil)
+		if summary.Count != 0 {
+			t.Errorf("Expected empty summary, got %+v", summary)
+		}
+	})
+	
+	t.Run("NewPerformanceBatch", func(t *testing.T) {
+		start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		samples := []scheduler.PerformanceSample{
+			{Timestamp: start.Add(time.Minute), CPUPercent: 40, MemoryUsedBytes: 2048},
+			{Timestamp: start, CPUPercent: 10, MemoryUsedBytes: 1024},
+			{Timestamp: start.Add(30 * time.Second), CPUPercent: 70, MemoryUsedBytes: 4096},
+		}
+		
+		batch := scheduler.NewPerformanceBatch(samples, 30*time.Second)
+		
+		if !batch.StartedAt.Equal(start) || !batch.EndedAt.Equal(start.Add(time.Minute)) {
+			t.Errorf("Unexpected batch window %v - %v", batch.StartedAt, batch.EndedAt)
+		}
+		if batch.IntervalSeconds != 30 {
+			t.Errorf("Expected interval 30, got %d", batch.IntervalSeconds)
+		}
+		
+		cpu := batch.Summaries[scheduler.MetricCPUPercent]
+		if cpu.Min != 10 || cpu.Max != 70 || cpu.Avg != 40 || cpu.P95 != 70 {
+			t.Errorf("Unexpected CPU summary %+v", cpu)
+		}
+		
+		memory := batch.Summaries[scheduler.MetricMemoryUsedBytes]
+		if memory.Min != 1024 || memory.Max != 4096 {
+			t.Errorf("Unexpected memory summary %+v", memory)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package alerting
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/versions"
+)
+
+// maxDurationMinutes bounds the window of sustained CPU and memory rules so
+// that it stays within the retention of raw metric points
+const maxDurationMinutes = 24 * 60
+
+// Sample is a metric reading taken at a point in time
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Readings are the latest known values of a device that alert rules are
+// checked against. Samples are ordered oldest first and memory samples hold
+// used bytes.
+type Readings struct {
+	LastSeen         time.Time
+	AgentVersion     string
+	Volumes          []models.Volume
+	MemoryTotalBytes *int64
+	CPUSamples       []Sample
+	MemorySamples    []Sample
+}
+
+// Condition is a breach of an alert rule found on a device. Subject names the
+// volume for disk rules and is empty otherwise.
+type Condition struct {
+	Subject string
+	Value   *float64
+	Message string
+}
+
+// DedupKey identifies the open alert of a rule, device and subject. Volume
+// names are compared ignoring case, as they are by the disk rule.
+func DedupKey(ruleID, deviceID uuid.UUID, subject string) string {
+	key := ruleID.String() + ":" + deviceID.String()
+	if subject != "" {
+		key += ":" + strings.ToUpper(subject)
+	}
+	return key
+}
+
+// Validate checks that params hold what the rule type needs
+func Validate(ruleType models.AlertRuleType, params models.AlertParams) error {
+	switch ruleType {
+	case models.AlertDiskFreeBelow, models.AlertCPUHigh, models.AlertMemoryHigh:
+		if params.ThresholdPercent == nil || *params.ThresholdPercent <= 0 || *params.ThresholdPercent > 100 {
+			return fmt.Errorf("%s requires params.threshold_percent between 0 and 100", ruleType)
+		}
+		if ruleType != models.AlertDiskFreeBelow {
+			if params.DurationMinutes == nil || *params.DurationMinutes < 1 || *params.DurationMinutes > maxDurationMinutes {
+				return fmt.Errorf("%s requires params.duration_minutes between 1 and %d", ruleType, maxDurationMinutes)
+			}
+		}
+	case models.AlertDeviceOffline:
+		if params.OfflineHours == nil || *params.OfflineHours <= 0 {
+			return fmt.Errorf("device_offline requires a positive params.offline_hours")
+		}
+	case models.AlertAgentVersionBelow:
+		if strings.TrimSpace(params.MinVersion) == "" {
+			return fmt.Errorf("agent_version_below requires params.min_version")
+		}
+	default:
+		return fmt.Errorf("rule_type must be one of: disk_free_below, cpu_high, memory_high, device_offline, agent_version_below")
+	}
+	return nil
+}
+
+// Window returns how far back metric samples are needed to evaluate a rule,
+// or zero when the rule does not use samples
+func Window(rule models.AlertRule) time.Duration {
+	if (rule.RuleType == models.AlertCPUHigh || rule.RuleType == models.AlertMemoryHigh) && rule.Params.DurationMinutes != nil {
+		return time.Duration(*rule.Params.DurationMinutes) * time.Minute
+	}
+	return 0
+}
+
+// Evaluate checks the readings of a device against a rule and returns the
+// breaches found, one per volume for disk rules and at most one otherwise.
+// CPU and memory are high when every sample of the last duration_minutes is
+// at or above the threshold and the samples cover at least half of that
+// window, so a single spike or a device that just started reporting does not fire.
+func Evaluate(rule models.AlertRule, r Readings, now time.Time) []Condition {
+	params := rule.Params
+
+	switch rule.RuleType {
+	case models.AlertDiskFreeBelow:
+		var conditions []Condition
+		for _, volume := range r.Volumes {
+			if !matchesVolume(volume, params.Volume) || volume.TotalBytes <= 0 {
+				continue
+			}
+			free := float64(volume.FreeBytes) * 100 / float64(volume.TotalBytes)
+			if free < *params.ThresholdPercent {
+				conditions = append(conditions, Condition{
+					Subject: volume.Name,
+					Value:   round(free),
+					Message: fmt.Sprintf("Volume %s has %.1f%% free, below %g%%", volume.Name, free, *params.ThresholdPercent),
+				})
+			}
+		}
+		return conditions
+
+	case models.AlertCPUHigh:
+		if average, ok := sustained(r.CPUSamples, Window(rule), *params.ThresholdPercent, now, nil); ok {
+			return []Condition{{
+				Value:   round(average),
+				Message: fmt.Sprintf("CPU usage averaged %.1f%% over %d minutes, at or above %g%%", average, *params.DurationMinutes, *params.ThresholdPercent),
+			}}
+		}
+
+	case models.AlertMemoryHigh:
+		if r.MemoryTotalBytes == nil || *r.MemoryTotalBytes <= 0 {
+			return nil
+		}
+		if average, ok := sustained(r.MemorySamples, Window(rule), *params.ThresholdPercent, now, r.MemoryTotalBytes); ok {
+			return []Condition{{
+				Value:   round(average),
+				Message: fmt.Sprintf("Memory usage averaged %.1f%% over %d minutes, at or above %g%%", average, *params.DurationMinutes, *params.ThresholdPercent),
+			}}
+		}
+
+	case models.AlertDeviceOffline:
+		if r.LastSeen.IsZero() {
+			return nil
+		}
+		hours := now.Sub(r.LastSeen).Hours()
+		if hours >= *params.OfflineHours {
+			return []Condition{{
+				Value:   round(hours),
+				Message: fmt.Sprintf("Device has not been seen for %.1f hours, over %g hours", hours, *params.OfflineHours),
+			}}
+		}
+
+	case models.AlertAgentVersionBelow:
+		if r.AgentVersion == "" {
+			return nil
+		}
+		if versions.Compare(r.AgentVersion, params.MinVersion) < 0 {
+			return []Condition{{
+				Message: fmt.Sprintf("Agent version %s is below %s", r.AgentVersion, params.MinVersion),
+			}}
+		}
+	}
+
+	return nil
+}
+
+// sustained reports whether every sample within window before now is at or
+// above threshold, returning their average. With total set, sample values are
+// converted to a percentage of it first.
+func sustained(samples []Sample, window time.Duration, threshold float64, now time.Time, total *int64) (float64, bool) {
+	start := now.Add(-window)
+
+	var sum float64
+	var count int
+	var earliest time.Time
+	for _, sample := range samples {
+		if sample.Time.Before(start) || sample.Time.After(now) {
+			continue
+		}
+		value := sample.Value
+		if total != nil {
+			value = value * 100 / float64(*total)
+		}
+		if value < threshold {
+			return 0, false
+		}
+		if count == 0 || sample.Time.Before(earliest) {
+			earliest = sample.Time
+		}
+		sum += value
+		count++
+	}
+
+	if count == 0 || earliest.After(now.Add(-window/2)) {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+func matchesVolume(volume models.Volume, name string) bool {
+	if name == "" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSuffix(volume.Name, `\`), strings.TrimSuffix(name, `\`))
+}
+
+func round(value float64) *float64 {
+	rounded := math.Round(value*10) / 10
+	return &rounded
+}
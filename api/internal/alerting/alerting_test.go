@@ -0,0 +1,136 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+)
+
+func percent(v float64) *float64 { return &v }
+func minutes(v int) *int         { return &v }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		ruleType models.AlertRuleType
+		params   models.AlertParams
+		valid    bool
+	}{
+		{"disk", models.AlertDiskFreeBelow, models.AlertParams{ThresholdPercent: percent(10)}, true},
+		{"disk without threshold", models.AlertDiskFreeBelow, models.AlertParams{}, false},
+		{"disk threshold above 100", models.AlertDiskFreeBelow, models.AlertParams{ThresholdPercent: percent(150)}, false},
+		{"cpu", models.AlertCPUHigh, models.AlertParams{ThresholdPercent: percent(90), DurationMinutes: minutes(15)}, true},
+		{"cpu without duration", models.AlertCPUHigh, models.AlertParams{ThresholdPercent: percent(90)}, false},
+		{"memory duration beyond raw retention", models.AlertMemoryHigh, models.AlertParams{ThresholdPercent: percent(90), DurationMinutes: minutes(maxDurationMinutes + 1)}, false},
+		{"offline", models.AlertDeviceOffline, models.AlertParams{OfflineHours: percent(24)}, true},
+		{"offline zero hours", models.AlertDeviceOffline, models.AlertParams{OfflineHours: percent(0)}, false},
+		{"agent version", models.AlertAgentVersionBelow, models.AlertParams{MinVersion: "1.4.0"}, true},
+		{"agent version blank", models.AlertAgentVersionBelow, models.AlertParams{MinVersion: " "}, false},
+		{"unknown rule type", "battery_low", models.AlertParams{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.ruleType, tt.params)
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate(%s, %+v) = %v, want valid %v", tt.ruleType, tt.params, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	ruleID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	deviceID := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+
+	if got, want := DedupKey(ruleID, deviceID, ""), ruleID.String()+":"+deviceID.String(); got != want {
+		t.Errorf("DedupKey() = %s, want %s", got, want)
+	}
+	if DedupKey(ruleID, deviceID, `c:\`) != DedupKey(ruleID, deviceID, `C:\`) {
+		t.Error("DedupKey() differs by the case of the volume name")
+	}
+	if DedupKey(ruleID, deviceID, "C:") == DedupKey(ruleID, deviceID, "D:") {
+		t.Error("DedupKey() is the same for different volumes")
+	}
+}
+
+// samples returns one sample per minute over the last count minutes before now
+func samples(now time.Time, values ...float64) []Sample {
+	result := make([]Sample, 0, len(values))
+	for i, value := range values {
+		result = append(result, Sample{Time: now.Add(-time.Duration(len(values)-1-i) * time.Minute), Value: value})
+	}
+	return result
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	memoryTotal := int64(1000)
+
+	disk := models.AlertRule{RuleType: models.AlertDiskFreeBelow, Params: models.AlertParams{ThresholdPercent: percent(10)}}
+	diskC := models.AlertRule{RuleType: models.AlertDiskFreeBelow, Params: models.AlertParams{ThresholdPercent: percent(10), Volume: "c:"}}
+	cpu := models.AlertRule{RuleType: models.AlertCPUHigh, Params: models.AlertParams{ThresholdPercent: percent(90), DurationMinutes: minutes(10)}}
+	memory := models.AlertRule{RuleType: models.AlertMemoryHigh, Params: models.AlertParams{ThresholdPercent: percent(80), DurationMinutes: minutes(4)}}
+	offline := models.AlertRule{RuleType: models.AlertDeviceOffline, Params: models.AlertParams{OfflineHours: percent(24)}}
+	agent := models.AlertRule{RuleType: models.AlertAgentVersionBelow, Params: models.AlertParams{MinVersion: "1.4.0"}}
+
+	volumes := []models.Volume{
+		{Name: `C:\`, TotalBytes: 100, FreeBytes: 5},
+		{Name: `D:\`, TotalBytes: 100, FreeBytes: 50},
+		{Name: `E:\`, TotalBytes: 100, FreeBytes: 2},
+		{Name: `F:\`, TotalBytes: 0, FreeBytes: 0},
+	}
+
+	tests := []struct {
+		name     string
+		rule     models.AlertRule
+		readings Readings
+		subjects []string
+		values   []float64
+	}{
+		// Disk rules fire once per volume below the threshold
+		{"disk per volume", disk, Readings{Volumes: volumes}, []string{`C:\`, `E:\`}, []float64{5, 2}},
+		{"disk named volume", diskC, Readings{Volumes: volumes}, []string{`C:\`}, []float64{5}},
+		{"disk above threshold", disk, Readings{Volumes: volumes[1:2]}, nil, nil},
+
+		// CPU and memory must stay high over the window
+		{"cpu sustained", cpu, Readings{CPUSamples: samples(now, 95, 92, 99, 91, 90, 97)}, []string{""}, []float64{94}},
+		{"cpu single dip", cpu, Readings{CPUSamples: samples(now, 95, 92, 40, 91, 90, 97)}, nil, nil},
+		{"cpu single spike", cpu, Readings{CPUSamples: samples(now, 99, 98)}, nil, nil},
+		{"cpu old samples ignored", cpu, Readings{CPUSamples: append(samples(now.Add(-20*time.Minute), 10), samples(now, 95, 95, 95, 95, 95, 95)...)}, []string{""}, []float64{95}},
+		{"cpu no samples", cpu, Readings{}, nil, nil},
+		{"memory as percent of total", memory, Readings{MemoryTotalBytes: &memoryTotal, MemorySamples: samples(now, 850, 900, 800)}, []string{""}, []float64{85}},
+		{"memory below threshold", memory, Readings{MemoryTotalBytes: &memoryTotal, MemorySamples: samples(now, 850, 700, 800)}, nil, nil},
+		{"memory without total", memory, Readings{MemorySamples: samples(now, 850, 900, 800)}, nil, nil},
+
+		// Offline and agent version
+		{"offline", offline, Readings{LastSeen: now.Add(-30 * time.Hour)}, []string{""}, []float64{30}},
+		{"recently seen", offline, Readings{LastSeen: now.Add(-2 * time.Hour)}, nil, nil},
+		{"never seen", offline, Readings{}, nil, nil},
+		{"agent outdated", agent, Readings{AgentVersion: "1.3.9"}, []string{""}, nil},
+		{"agent numeric order", agent, Readings{AgentVersion: "1.10.0"}, nil, nil},
+		{"agent version unknown", agent, Readings{}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditions := Evaluate(tt.rule, tt.readings, now)
+			if len(conditions) != len(tt.subjects) {
+				t.Fatalf("Evaluate() = %+v, want %d conditions", conditions, len(tt.subjects))
+			}
+			for i, condition := range conditions {
+				if condition.Subject != tt.subjects[i] {
+					t.Errorf("conditions[%d].Subject = %q, want %q", i, condition.Subject, tt.subjects[i])
+				}
+				if condition.Message == "" {
+					t.Errorf("conditions[%d].Message is empty", i)
+				}
+				if tt.values != nil && (condition.Value == nil || *condition.Value != tt.values[i]) {
+					t.Errorf("conditions[%d].Value = %v, want %v", i, condition.Value, tt.values[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package alerting
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/events"
+	"github.com/tracr/api/internal/models"
+)
+
+// evaluationBatch bounds the number of devices loaded at once when evaluating the fleet
+const evaluationBatch = 500
+
+// ListEnabledRules retrieves the alert rules evaluated against devices
+func ListEnabledRules(db *sqlx.DB) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	if err := db.Select(&rules, `SELECT * FROM alert_rules WHERE enabled = 1 ORDER BY name ASC`); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// device is a device's latest readings prepared for evaluation
+type device struct {
+	DeviceID uuid.UUID
+	Readings Readings
+}
+
+// loadDevices loads the latest readings of the given devices, including the
+// CPU and memory samples taken within window before now
+func loadDevices(db *sqlx.DB, deviceIDs []uuid.UUID, window time.Duration, now time.Time) ([]device, error) {
+	var rows []struct {
+		ID               uuid.UUID `db:"id"`
+		LastSeen         string    `db:"last_seen"`
+		AgentVersion     *string   `db:"agent_version"`
+		MemoryTotalBytes *int64    `db:"memory_total_bytes"`
+	}
+	query, args, err := sqlx.In(`
+		SELECT d.id, CAST(d.last_seen AS TEXT) AS last_seen, sn.agent_version, s.memory_total_bytes
+		FROM devices d
+		LEFT JOIN device_current_state s ON s.device_id = d.id
+		LEFT JOIN snapshots sn ON sn.id = s.snapshot_id
+		WHERE d.id IN (?)`, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	query, args, err = sqlx.In(`
+		SELECT device_id, name, filesystem, total_bytes, free_bytes
+		FROM device_current_volumes
+		WHERE device_id IN (?)
+		ORDER BY name ASC`, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	var volumeRows []struct {
+		DeviceID uuid.UUID `db:"device_id"`
+		models.Volume
+	}
+	if err := db.Select(&volumeRows, query, args...); err != nil {
+		return nil, err
+	}
+	volumes := make(map[uuid.UUID][]models.Volume)
+	for _, row := range volumeRows {
+		volumes[row.DeviceID] = append(volumes[row.DeviceID], row.Volume)
+	}
+
+	cpuSamples := make(map[uuid.UUID][]Sample)
+	memorySamples := make(map[uuid.UUID][]Sample)
+	if window > 0 {
+		query, args, err := sqlx.In(`
+			SELECT device_id, metric, bucket_start, value_sum / sample_count AS value
+			FROM metric_points
+			WHERE resolution = 'raw' AND device_id IN (?) AND metric IN (?) AND bucket_start >= ?
+			ORDER BY bucket_start ASC`,
+			deviceIDs, []string{models.MetricCPUPercent, models.MetricMemoryUsedBytes}, now.Add(-window).Unix())
+		if err != nil {
+			return nil, err
+		}
+
+		var points []struct {
+			DeviceID    uuid.UUID `db:"device_id"`
+			Metric      string    `db:"metric"`
+			BucketStart int64     `db:"bucket_start"`
+			Value       float64   `db:"value"`
+		}
+		if err := db.Select(&points, query, args...); err != nil {
+			return nil, err
+		}
+		for _, point := range points {
+			sample := Sample{Time: time.Unix(point.BucketStart, 0).UTC(), Value: point.Value}
+			if point.Metric == models.MetricCPUPercent {
+				cpuSamples[point.DeviceID] = append(cpuSamples[point.DeviceID], sample)
+			} else {
+				memorySamples[point.DeviceID] = append(memorySamples[point.DeviceID], sample)
+			}
+		}
+	}
+
+	devices := make([]device, 0, len(rows))
+	for _, row := range rows {
+		lastSeen, err := parseTimestamp(row.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		readings := Readings{
+			LastSeen:         lastSeen,
+			Volumes:          volumes[row.ID],
+			MemoryTotalBytes: row.MemoryTotalBytes,
+			CPUSamples:       cpuSamples[row.ID],
+			MemorySamples:    memorySamples[row.ID],
+		}
+		if row.AgentVersion != nil {
+			readings.AgentVersion = *row.AgentVersion
+		}
+		devices = append(devices, device{DeviceID: row.ID, Readings: readings})
+	}
+	return devices, nil
+}
+
+// openAlert is an unresolved alert as needed to update or resolve it
+type openAlert struct {
+	ID       uuid.UUID `db:"id"`
+	RuleID   uuid.UUID `db:"rule_id"`
+	DeviceID uuid.UUID `db:"device_id"`
+	Subject  string    `db:"subject"`
+	DedupKey string    `db:"dedup_key"`
+	Message  string    `db:"message"`
+}
+
+const openAlertColumns = `id, rule_id, device_id, subject, dedup_key, message`
+
+// loadOpenAlerts returns the unresolved alerts of the given rules and devices
+// keyed by dedup key
+func loadOpenAlerts(tx *sqlx.Tx, ruleIDs, deviceIDs []uuid.UUID) (map[string]openAlert, error) {
+	query, args, err := sqlx.In(`
+		SELECT `+openAlertColumns+` FROM alerts
+		WHERE state != ? AND rule_id IN (?) AND device_id IN (?)`,
+		models.AlertStateResolved, ruleIDs, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []openAlert
+	if err := tx.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	open := make(map[string]openAlert, len(rows))
+	for _, row := range rows {
+		open[row.DedupKey] = row
+	}
+	return open, nil
+}
+
+// EvaluateRules evaluates alert rules against the latest readings of the
+// given devices, or of every device when deviceIDs is nil. A breach without an
+// open alert fires a new one, a breach with an open alert, firing or
+// acknowledged, updates it, and open alerts whose condition no longer holds
+// are resolved. Open alerts are read in the transaction that changes them,
+// and an alert opened by a concurrent evaluation is updated rather than
+// fired twice.
+func EvaluateRules(db *sqlx.DB, rules []models.AlertRule, deviceIDs []uuid.UUID) (models.AlertEvaluationResult, error) {
+	result := models.AlertEvaluationResult{Rules: len(rules)}
+	if len(rules) == 0 {
+		return result, nil
+	}
+
+	if deviceIDs == nil {
+		if err := db.Select(&deviceIDs, `SELECT id FROM devices ORDER BY id`); err != nil {
+			return result, err
+		}
+	}
+
+	var window time.Duration
+	ruleIDs := make([]uuid.UUID, 0, len(rules))
+	rulesByID := make(map[uuid.UUID]models.AlertRule, len(rules))
+	for _, rule := range rules {
+		ruleIDs = append(ruleIDs, rule.ID)
+		rulesByID[rule.ID] = rule
+		if ruleWindow := Window(rule); ruleWindow > window {
+			window = ruleWindow
+		}
+	}
+
+	for start := 0; start < len(deviceIDs); start += evaluationBatch {
+		end := start + evaluationBatch
+		if end > len(deviceIDs) {
+			end = len(deviceIDs)
+		}
+
+		now := time.Now().UTC()
+		devices, err := loadDevices(db, deviceIDs[start:end], window, now)
+		if err != nil {
+			return result, err
+		}
+
+		batch, err := evaluateBatch(db, rules, rulesByID, ruleIDs, deviceIDs[start:end], devices, now)
+		if err != nil {
+			return result, err
+		}
+		result.Fired += batch.Fired
+		result.Updated += batch.Updated
+		result.Resolved += batch.Resolved
+		result.Devices += len(devices)
+	}
+
+	return result, nil
+}
+
+// evaluateBatch applies the conditions found on a batch of devices to their
+// open alerts in one transaction
+func evaluateBatch(db *sqlx.DB, rules []models.AlertRule, rulesByID map[uuid.UUID]models.AlertRule, ruleIDs, deviceIDs []uuid.UUID, devices []device, now time.Time) (models.AlertEvaluationResult, error) {
+	var result models.AlertEvaluationResult
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	open, err := loadOpenAlerts(tx, ruleIDs, deviceIDs)
+	if err != nil {
+		return result, err
+	}
+
+	seen := make(map[string]bool)
+	for _, device := range devices {
+		for _, rule := range rules {
+			for _, condition := range Evaluate(rule, device.Readings, now) {
+				key := DedupKey(rule.ID, device.DeviceID, condition.Subject)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				if _, ok := open[key]; !ok {
+					fired, err := createAlert(tx, rule, device.DeviceID, key, condition, now)
+					if err != nil {
+						return result, err
+					}
+					if fired {
+						result.Fired++
+						continue
+					}
+					// Opened by a concurrent evaluation since open alerts were loaded
+				}
+
+				delete(open, key)
+				if err := updateFiringAlert(tx, key, rule, condition, now); err != nil {
+					return result, err
+				}
+				result.Updated++
+			}
+		}
+	}
+
+	// Open alerts that were not seen again have cleared
+	for _, alert := range open {
+		resolved, err := resolveAlert(tx, alert, rulesByID[alert.RuleID], now)
+		if err != nil {
+			return result, err
+		}
+		if resolved {
+			result.Resolved++
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// createAlert fires a new alert. Reports false without changes when an open
+// alert with the same dedup key already exists.
+func createAlert(tx *sqlx.Tx, rule models.AlertRule, deviceID uuid.UUID, key string, condition Condition, now time.Time) (bool, error) {
+	alertID := uuid.New()
+	result, err := tx.Exec(`
+		INSERT INTO alerts (
+			id, rule_id, device_id, subject, dedup_key, state, severity, message, value,
+			occurrences, fired_at, last_fired_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT (dedup_key) WHERE state != 'resolved' DO NOTHING`,
+		alertID, rule.ID, deviceID, condition.Subject, key, models.AlertStateFiring,
+		rule.Severity, condition.Message, condition.Value, now, now)
+	if err != nil {
+		return false, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return false, err
+	}
+
+	return true, events.Record(tx, models.EventAlertFired, &deviceID, map[string]interface{}{
+		"alert_id":  alertID,
+		"rule_id":   rule.ID,
+		"rule_name": rule.Name,
+		"rule_type": rule.RuleType,
+		"severity":  rule.Severity,
+		"subject":   condition.Subject,
+		"message":   condition.Message,
+		"value":     condition.Value,
+	})
+}
+
+// resolveAlert resolves an open alert of a rule. Reports false when it was
+// already resolved.
+func resolveAlert(tx *sqlx.Tx, alert openAlert, rule models.AlertRule, now time.Time) (bool, error) {
+	result, err := tx.Exec(`UPDATE alerts SET state = ?, resolved_at = ? WHERE id = ? AND state != ?`,
+		models.AlertStateResolved, now, alert.ID, models.AlertStateResolved)
+	if err != nil {
+		return false, err
+	}
+	if resolved, err := result.RowsAffected(); err != nil || resolved == 0 {
+		return false, err
+	}
+
+	return true, events.Record(tx, models.EventAlertResolved, &alert.DeviceID, map[string]interface{}{
+		"alert_id":  alert.ID,
+		"rule_id":   rule.ID,
+		"rule_name": rule.Name,
+		"rule_type": rule.RuleType,
+		"subject":   alert.Subject,
+		"message":   alert.Message,
+	})
+}
+
+// updateFiringAlert records that the condition of the open alert with a
+// dedup key still holds, keeping its state so acknowledged alerts stay
+// acknowledged
+func updateFiringAlert(tx *sqlx.Tx, key string, rule models.AlertRule, condition Condition, now time.Time) error {
+	_, err := tx.Exec(`
+		UPDATE alerts
+		SET severity = ?, message = ?, value = ?, occurrences = occurrences + 1, last_fired_at = ?
+		WHERE dedup_key = ? AND state != ?`,
+		rule.Severity, condition.Message, condition.Value, now, key, models.AlertStateResolved)
+	return err
+}
+
+// ResolveRuleAlerts resolves the open alerts of a rule, used when it is disabled
+func ResolveRuleAlerts(db *sqlx.DB, rule models.AlertRule) (int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var open []openAlert
+	if err := tx.Select(&open, `SELECT `+openAlertColumns+` FROM alerts WHERE rule_id = ? AND state != ?`,
+		rule.ID, models.AlertStateResolved); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	resolved := 0
+	for _, alert := range open {
+		ok, err := resolveAlert(tx, alert, rule, now)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			resolved++
+		}
+	}
+	return resolved, tx.Commit()
+}
+
+// StartEvaluation periodically evaluates the enabled alert rules against
+// every device. This fires offline alerts for devices that stopped reporting
+// and resolves CPU and memory alerts once their window no longer holds samples.
+func StartEvaluation(db *sqlx.DB, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.AlertEvaluationInterval)
+	go func() {
+		for range ticker.C {
+			rules, err := ListEnabledRules(db)
+			if err != nil {
+				log.Printf("[ERROR] Alert evaluation failed: %v", err)
+				continue
+			}
+			result, err := EvaluateRules(db, rules, nil)
+			if err != nil {
+				log.Printf("[ERROR] Alert evaluation failed: %v", err)
+				continue
+			}
+			if result.Fired > 0 || result.Resolved > 0 {
+				log.Printf("[INFO] Alert evaluation fired %d and resolved %d alerts", result.Fired, result.Resolved)
+			}
+		}
+	}()
+}
+
+// parseTimestamp parses a time stored by SQLite, where an empty value is the zero time
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp format: %q", value)
+}
@@ -0,0 +1,246 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/models"
+)
+
+// schema holds the columns evaluation reads and writes, with the alerts table
+// and its unique index as created by the migrations
+const schema = `
+CREATE TABLE devices (id TEXT PRIMARY KEY, last_seen DATETIME);
+CREATE TABLE snapshots (id TEXT PRIMARY KEY, agent_version TEXT);
+CREATE TABLE device_current_state (device_id TEXT PRIMARY KEY, snapshot_id TEXT, memory_total_bytes INTEGER);
+CREATE TABLE device_current_volumes (
+	device_id TEXT NOT NULL, name TEXT NOT NULL, filesystem TEXT NOT NULL DEFAULT '',
+	total_bytes INTEGER NOT NULL, free_bytes INTEGER NOT NULL
+);
+CREATE TABLE metric_points (
+	device_id TEXT NOT NULL, metric TEXT NOT NULL, resolution TEXT NOT NULL,
+	bucket_start INTEGER NOT NULL, value_sum REAL NOT NULL, sample_count INTEGER NOT NULL
+);
+CREATE TABLE events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT, type TEXT NOT NULL, device_id TEXT,
+	data TEXT NOT NULL DEFAULT '{}', created_at TEXT NOT NULL
+);
+CREATE TABLE alerts (
+	id TEXT PRIMARY KEY,
+	rule_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	dedup_key TEXT NOT NULL,
+	state TEXT NOT NULL DEFAULT 'firing',
+	severity TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	value REAL,
+	occurrences INTEGER NOT NULL DEFAULT 1,
+	fired_at TEXT NOT NULL,
+	last_fired_at TEXT NOT NULL,
+	resolved_at TEXT
+);
+CREATE UNIQUE INDEX idx_alerts_open_dedup_key ON alerts(dedup_key) WHERE state != 'resolved';
+`
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type alertRow struct {
+	State       string `db:"state"`
+	Subject     string `db:"subject"`
+	Occurrences int    `db:"occurrences"`
+}
+
+func listAlerts(t *testing.T, db *sqlx.DB) []alertRow {
+	t.Helper()
+	var rows []alertRow
+	if err := db.Select(&rows, `SELECT state, subject, occurrences FROM alerts ORDER BY subject, rowid`); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func countEvents(t *testing.T, db *sqlx.DB, eventType models.EventType) int {
+	t.Helper()
+	var count int
+	if err := db.Get(&count, `SELECT COUNT(*) FROM events WHERE type = ?`, eventType); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestEvaluateRules(t *testing.T) {
+	db := openTestDB(t)
+
+	deviceID := uuid.New()
+	db.MustExec(`INSERT INTO devices (id, last_seen) VALUES (?, ?)`, deviceID, time.Now().UTC())
+	db.MustExec(`INSERT INTO device_current_volumes (device_id, name, total_bytes, free_bytes)
+		VALUES (?, 'C:\', 100, 5), (?, 'D:\', 100, 3)`, deviceID, deviceID)
+
+	rule := models.AlertRule{
+		ID:       uuid.New(),
+		Name:     "Low disk",
+		RuleType: models.AlertDiskFreeBelow,
+		Params:   models.AlertParams{ThresholdPercent: percent(10)},
+		Severity: models.AlertSeverityWarning,
+	}
+	rules := []models.AlertRule{rule}
+
+	// Both volumes breach the rule and fire
+	result, err := EvaluateRules(db, rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Devices != 1 || result.Fired != 2 || result.Updated != 0 || result.Resolved != 0 {
+		t.Errorf("first evaluation = %+v, want 2 fired", result)
+	}
+
+	// Acknowledged alerts stay acknowledged while the condition holds
+	db.MustExec(`UPDATE alerts SET state = 'acknowledged' WHERE subject = 'C:\'`)
+	result, err = EvaluateRules(db, rules, []uuid.UUID{deviceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fired != 0 || result.Updated != 2 {
+		t.Errorf("second evaluation = %+v, want 2 updated", result)
+	}
+
+	// A volume that recovers resolves its alert
+	db.MustExec(`UPDATE device_current_volumes SET free_bytes = 50 WHERE name = 'D:\'`)
+	result, err = EvaluateRules(db, rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fired != 0 || result.Updated != 1 || result.Resolved != 1 {
+		t.Errorf("third evaluation = %+v, want 1 updated and 1 resolved", result)
+	}
+
+	// A new breach after resolution opens a new alert
+	db.MustExec(`UPDATE device_current_volumes SET free_bytes = 1 WHERE name = 'D:\'`)
+	if result, err = EvaluateRules(db, rules, nil); err != nil {
+		t.Fatal(err)
+	}
+	if result.Fired != 1 {
+		t.Errorf("fourth evaluation = %+v, want 1 fired", result)
+	}
+
+	want := []alertRow{
+		{State: "acknowledged", Subject: `C:\`, Occurrences: 4},
+		{State: "resolved", Subject: `D:\`, Occurrences: 2},
+		{State: "firing", Subject: `D:\`, Occurrences: 1},
+	}
+	got := listAlerts(t, db)
+	if len(got) != len(want) {
+		t.Fatalf("alerts = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("alerts[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if fired := countEvents(t, db, models.EventAlertFired); fired != 3 {
+		t.Errorf("%d fired events, want 3", fired)
+	}
+	if resolved := countEvents(t, db, models.EventAlertResolved); resolved != 1 {
+		t.Errorf("%d resolved events, want 1", resolved)
+	}
+
+	// Disabling the rule resolves what is still open
+	resolved, err := ResolveRuleAlerts(db, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != 2 {
+		t.Errorf("ResolveRuleAlerts() = %d, want 2", resolved)
+	}
+}
+
+func TestEvaluateRulesSustainedMetrics(t *testing.T) {
+	db := openTestDB(t)
+
+	deviceID := uuid.New()
+	now := time.Now().UTC()
+	db.MustExec(`INSERT INTO devices (id, last_seen) VALUES (?, ?)`, deviceID, now)
+	for i := 0; i < 10; i++ {
+		db.MustExec(`INSERT INTO metric_points (device_id, metric, resolution, bucket_start, value_sum, sample_count)
+			VALUES (?, ?, 'raw', ?, 190, 2)`, deviceID, models.MetricCPUPercent, now.Add(-time.Duration(i)*time.Minute).Unix())
+	}
+
+	rule := models.AlertRule{
+		ID:       uuid.New(),
+		Name:     "High CPU",
+		RuleType: models.AlertCPUHigh,
+		Params:   models.AlertParams{ThresholdPercent: percent(90), DurationMinutes: minutes(15)},
+		Severity: models.AlertSeverityCritical,
+	}
+
+	result, err := EvaluateRules(db, []models.AlertRule{rule}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fired != 1 {
+		t.Fatalf("evaluation = %+v, want 1 fired", result)
+	}
+
+	var value float64
+	if err := db.Get(&value, `SELECT value FROM alerts`); err != nil {
+		t.Fatal(err)
+	}
+	if value != 95 {
+		t.Errorf("alert value = %v, want the 95%% average", value)
+	}
+}
+
+func TestCreateAlertConflict(t *testing.T) {
+	db := openTestDB(t)
+
+	rule := models.AlertRule{ID: uuid.New(), Name: "Offline", RuleType: models.AlertDeviceOffline, Severity: models.AlertSeverityWarning}
+	deviceID := uuid.New()
+	key := DedupKey(rule.ID, deviceID, "")
+	condition := Condition{Message: "Device has not been seen for 30.0 hours, over 24 hours"}
+	now := time.Now().UTC()
+
+	// A second insert of the same open alert, as made by an evaluation that
+	// loaded open alerts before a concurrent one fired it, changes nothing
+	for i, want := range []bool{true, false} {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fired, err := createAlert(tx, rule, deviceID, key, condition, now)
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("createAlert() attempt %d failed: %v", i+1, err)
+		}
+		if fired != want {
+			t.Errorf("createAlert() attempt %d = %v, want %v", i+1, fired, want)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if alerts := listAlerts(t, db); len(alerts) != 1 {
+		t.Errorf("alerts = %+v, want 1", alerts)
+	}
+	if fired := countEvents(t, db, models.EventAlertFired); fired != 1 {
+		t.Errorf("%d fired events, want 1", fired)
+	}
+}
@@ -0,0 +1,184 @@
+package availability
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/events"
+	"github.com/tracr/api/internal/models"
+)
+
+// rebootTolerance ignores boot time differences caused by clock adjustments
+const rebootTolerance = time.Minute
+
+// RecordCheckin records a device checking in at a time. A check-in within gap
+// of the device's latest interval extends it, otherwise a new interval starts.
+// The latest interval is extended before anything is read, so the transaction
+// already holds the write lock when it starts a new interval and check-ins
+// arriving at once over HTTP and the agent channel cannot both start one.
+func RecordCheckin(tx *sqlx.Tx, deviceID uuid.UUID, at time.Time, gap time.Duration) error {
+	at = at.UTC()
+
+	result, err := tx.Exec(`
+		UPDATE device_availability
+		SET ended_at = CASE WHEN ended_at < ? THEN ? ELSE ended_at END, checkins = checkins + 1
+		WHERE id = (SELECT MAX(id) FROM device_availability WHERE device_id = ?) AND ended_at >= ?`,
+		at, at, deviceID, at.Add(-gap))
+	if err != nil {
+		return fmt.Errorf("failed to extend availability: %w", err)
+	}
+	if extended, err := result.RowsAffected(); err != nil || extended > 0 {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO device_availability (device_id, started_at, ended_at, checkins) VALUES (?, ?, ?, 1)`,
+		deviceID, at, at); err != nil {
+		return fmt.Errorf("failed to record availability: %w", err)
+	}
+	return nil
+}
+
+// DetectReboot records a reboot, along with a device.rebooted event, when an
+// inventory reports a later boot time than the device's current state. Call
+// it before the current state is updated. Reports whether a reboot was found.
+func DetectReboot(db sqlx.Ext, deviceID uuid.UUID, hostname string, bootTime time.Time) (bool, error) {
+	if bootTime.IsZero() {
+		return false, nil
+	}
+	bootTime = bootTime.UTC()
+
+	var previous *time.Time
+	err := sqlx.Get(db, &previous, `SELECT boot_time FROM device_current_state WHERE device_id = ?`, deviceID)
+	if err == sql.ErrNoRows {
+		// The first inventory of a device is the baseline
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load boot time: %w", err)
+	}
+	if previous == nil || bootTime.Sub(*previous) <= rebootTolerance {
+		return false, nil
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO device_reboots (device_id, boot_time, previous_boot_time, detected_at)
+		VALUES (?, ?, ?, ?)`, deviceID, bootTime, previous, time.Now().UTC()); err != nil {
+		return false, fmt.Errorf("failed to record reboot: %w", err)
+	}
+
+	if err := events.Record(db, models.EventDeviceRebooted, &deviceID, map[string]interface{}{
+		"hostname":           hostname,
+		"boot_time":          bootTime,
+		"previous_boot_time": previous,
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Intervals returns the availability intervals of the given devices that
+// overlap [from, to], oldest first. All devices are included when deviceIDs
+// is nil.
+func Intervals(db *sqlx.DB, deviceIDs []uuid.UUID, from, to time.Time) ([]models.AvailabilityInterval, error) {
+	query := `SELECT * FROM device_availability WHERE ended_at >= ? AND started_at <= ?`
+	args := []interface{}{from.UTC(), to.UTC()}
+	if deviceIDs != nil {
+		if len(deviceIDs) == 0 {
+			return []models.AvailabilityInterval{}, nil
+		}
+		inQuery, inArgs, err := sqlx.In(` AND device_id IN (?)`, deviceIDs)
+		if err != nil {
+			return nil, err
+		}
+		query += inQuery
+		args = append(args, inArgs...)
+	}
+	query += ` ORDER BY started_at ASC`
+
+	intervals := []models.AvailabilityInterval{}
+	err := db.Select(&intervals, query, args...)
+	return intervals, err
+}
+
+// Reboots returns the reboots of the given devices with a boot time within
+// [from, to], oldest first. All devices are included when deviceIDs is nil.
+func Reboots(db *sqlx.DB, deviceIDs []uuid.UUID, from, to time.Time) ([]models.DeviceReboot, error) {
+	query := `SELECT * FROM device_reboots WHERE boot_time >= ? AND boot_time <= ?`
+	args := []interface{}{from.UTC(), to.UTC()}
+	if deviceIDs != nil {
+		if len(deviceIDs) == 0 {
+			return []models.DeviceReboot{}, nil
+		}
+		inQuery, inArgs, err := sqlx.In(` AND device_id IN (?)`, deviceIDs)
+		if err != nil {
+			return nil, err
+		}
+		query += inQuery
+		args = append(args, inArgs...)
+	}
+	query += ` ORDER BY boot_time ASC`
+
+	reboots := []models.DeviceReboot{}
+	err := db.Select(&reboots, query, args...)
+	return reboots, err
+}
+
+// Summarize computes how long a device was online within [from, to] from its
+// intervals. Only the part of the range between firstSeen and now is observed.
+// The interval a device is still checking in for counts as online up to now.
+func Summarize(intervals []models.AvailabilityInterval, from, to, firstSeen, now time.Time, gap time.Duration) (observed, online time.Duration, uptime *float64) {
+	start, end := from, to
+	if firstSeen.After(start) {
+		start = firstSeen
+	}
+	if now.Before(end) {
+		end = now
+	}
+	if !end.After(start) {
+		return 0, 0, nil
+	}
+	observed = end.Sub(start)
+
+	for _, interval := range intervals {
+		intervalEnd := interval.EndedAt
+		if Ongoing(interval, now, gap) {
+			intervalEnd = now
+		}
+
+		overlapStart, overlapEnd := interval.StartedAt, intervalEnd
+		if start.After(overlapStart) {
+			overlapStart = start
+		}
+		if end.Before(overlapEnd) {
+			overlapEnd = end
+		}
+		if overlapEnd.After(overlapStart) {
+			online += overlapEnd.Sub(overlapStart)
+		}
+	}
+	if online > observed {
+		online = observed
+	}
+
+	return observed, online, Percent(online, observed)
+}
+
+// Ongoing reports whether the device of an interval is still checking in for
+// it, having checked in within gap of now
+func Ongoing(interval models.AvailabilityInterval, now time.Time, gap time.Duration) bool {
+	return now.Sub(interval.EndedAt) <= gap
+}
+
+// Percent returns online as a percentage of observed, rounded to two decimals,
+// or nil when nothing was observed
+func Percent(online, observed time.Duration) *float64 {
+	if observed <= 0 {
+		return nil
+	}
+	percent := math.Round(float64(online)*10000/float64(observed)) / 100
+	return &percent
+}
@@ -0,0 +1,153 @@
+package availability
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/models"
+)
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	db.MustExec(`CREATE TABLE device_availability (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		device_id TEXT NOT NULL,
+		started_at DATETIME NOT NULL,
+		ended_at DATETIME NOT NULL,
+		checkins INTEGER NOT NULL DEFAULT 1
+	)`)
+	return db
+}
+
+func checkin(t *testing.T, db *sqlx.DB, deviceID uuid.UUID, at time.Time, gap time.Duration) {
+	t.Helper()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := RecordCheckin(tx, deviceID, at, gap); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordCheckin(t *testing.T) {
+	db := openTestDB(t)
+
+	device := uuid.New()
+	other := uuid.New()
+	start := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	gap := 5 * time.Minute
+
+	checkin(t, db, device, start, gap)
+	checkin(t, db, device, start.Add(3*time.Minute), gap)
+	checkin(t, db, device, start.Add(8*time.Minute), gap)
+	// A late check-in within the gap does not move the end back
+	checkin(t, db, device, start.Add(6*time.Minute), gap)
+	// Another device's check-ins do not extend this device's intervals
+	checkin(t, db, other, start.Add(9*time.Minute), gap)
+	// A check-in after the gap starts a new interval
+	checkin(t, db, device, start.Add(20*time.Minute), gap)
+
+	var intervals []models.AvailabilityInterval
+	if err := db.Select(&intervals, `SELECT * FROM device_availability WHERE device_id = ? ORDER BY id`, device); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		start, end time.Duration
+		checkins   int
+	}{
+		{0, 8 * time.Minute, 4},
+		{20 * time.Minute, 20 * time.Minute, 1},
+	}
+	if len(intervals) != len(want) {
+		t.Fatalf("intervals = %+v, want %d", intervals, len(want))
+	}
+	for i, w := range want {
+		got := intervals[i]
+		if !got.StartedAt.Equal(start.Add(w.start)) || !got.EndedAt.Equal(start.Add(w.end)) || got.Checkins != w.checkins {
+			t.Errorf("intervals[%d] = %s to %s with %d check-ins, want %s to %s with %d",
+				i, got.StartedAt, got.EndedAt, got.Checkins, start.Add(w.start), start.Add(w.end), w.checkins)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	day := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	gap := 10 * time.Minute
+	at := func(hours float64) time.Time { return day.Add(time.Duration(hours * float64(time.Hour))) }
+	interval := func(from, to float64) models.AvailabilityInterval {
+		return models.AvailabilityInterval{StartedAt: at(from), EndedAt: at(to)}
+	}
+
+	tests := []struct {
+		name      string
+		intervals []models.AvailabilityInterval
+		firstSeen time.Time
+		now       time.Time
+		observed  time.Duration
+		online    time.Duration
+		uptime    *float64
+	}{
+		{"half the day", []models.AvailabilityInterval{interval(0, 6), interval(12, 18)}, at(-24), at(48), 24 * time.Hour, 12 * time.Hour, percent(50)},
+		{"clipped to the range", []models.AvailabilityInterval{interval(-2, 6)}, at(-24), at(48), 24 * time.Hour, 6 * time.Hour, percent(25)},
+		{"observed from first seen", []models.AvailabilityInterval{interval(12, 18)}, at(12), at(48), 12 * time.Hour, 6 * time.Hour, percent(50)},
+		{"ongoing until now", []models.AvailabilityInterval{interval(0, 11.9)}, at(-24), at(12), 12 * time.Hour, 12 * time.Hour, percent(100)},
+		{"ended before the gap", []models.AvailabilityInterval{interval(0, 6)}, at(-24), at(12), 12 * time.Hour, 6 * time.Hour, percent(50)},
+		{"not seen yet", nil, at(30), at(48), 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]models.AvailabilityInterval(nil), tt.intervals...)
+			observed, online, uptime := Summarize(input, at(0), at(24), tt.firstSeen, tt.now, gap)
+			if observed != tt.observed || online != tt.online {
+				t.Errorf("Summarize() = %s observed, %s online, want %s and %s", observed, online, tt.observed, tt.online)
+			}
+			if (uptime == nil) != (tt.uptime == nil) || (uptime != nil && *uptime != *tt.uptime) {
+				t.Errorf("Summarize() uptime = %v, want %v", deref(uptime), deref(tt.uptime))
+			}
+
+			// The caller's intervals are left untouched
+			for i := range input {
+				if input[i] != tt.intervals[i] {
+					t.Errorf("intervals[%d] changed to %+v", i, input[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPercent(t *testing.T) {
+	if got := Percent(time.Hour, 0); got != nil {
+		t.Errorf("Percent() with nothing observed = %v, want nil", *got)
+	}
+	if got := Percent(time.Hour, 3*time.Hour); got == nil || *got != 33.33 {
+		t.Errorf("Percent() = %v, want 33.33", deref(got))
+	}
+}
+
+func percent(v float64) *float64 { return &v }
+
+func deref(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
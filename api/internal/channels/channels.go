@@ -0,0 +1,112 @@
+package channels
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+)
+
+// sendBuffer bounds the messages queued for an agent that is slow to read
+const sendBuffer = 32
+
+// Channel is the connection of an agent to the API. Messages sent to it are
+// written to the agent by the connection handler.
+type Channel struct {
+	DeviceID uuid.UUID
+
+	outbound  chan models.ChannelMessage
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	mu       sync.Mutex
+	channels = make(map[uuid.UUID]*Channel)
+)
+
+// Open registers the channel of a device, closing the previous one when the
+// agent reconnects before it noticed its old connection was gone
+func Open(deviceID uuid.UUID) *Channel {
+	ch := &Channel{
+		DeviceID: deviceID,
+		outbound: make(chan models.ChannelMessage, sendBuffer),
+		done:     make(chan struct{}),
+	}
+
+	mu.Lock()
+	previous := channels[deviceID]
+	channels[deviceID] = ch
+	mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return ch
+}
+
+// Close unregisters the channel and signals its handler to stop
+func (ch *Channel) Close() {
+	ch.closeOnce.Do(func() {
+		mu.Lock()
+		if channels[ch.DeviceID] == ch {
+			delete(channels, ch.DeviceID)
+		}
+		mu.Unlock()
+		close(ch.done)
+	})
+}
+
+// Outbound returns the messages to write to the agent
+func (ch *Channel) Outbound() <-chan models.ChannelMessage {
+	return ch.outbound
+}
+
+// Done returns a channel that is closed once the channel is closed
+func (ch *Channel) Done() <-chan struct{} {
+	return ch.done
+}
+
+// Connected reports whether the agent of a device is connected
+func Connected(deviceID uuid.UUID) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := channels[deviceID]
+	return ok
+}
+
+// Send queues a message for the agent of a device and reports whether the
+// agent is connected to receive it
+func Send(deviceID uuid.UUID, messageType models.ChannelMessageType, data interface{}) (bool, error) {
+	message, err := NewMessage(messageType, data)
+	if err != nil {
+		return false, err
+	}
+
+	mu.Lock()
+	ch, ok := channels[deviceID]
+	mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	select {
+	case ch.outbound <- message:
+		return true, nil
+	case <-ch.done:
+		return false, nil
+	default:
+		return false, fmt.Errorf("channel of device %s is full", deviceID)
+	}
+}
+
+// NewMessage builds a channel message with data encoded as JSON
+func NewMessage(messageType models.ChannelMessageType, data interface{}) (models.ChannelMessage, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return models.ChannelMessage{}, fmt.Errorf("failed to marshal %s message: %w", messageType, err)
+	}
+	return models.ChannelMessage{Type: messageType, Data: payload}, nil
+}
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tracr/api/internal/models"
+)
+
+// Definition declares a command type: the payload it takes, how long agents
+// allow it to run unless the command sets its own timeout, and the role
+// needed to send it
+type Definition struct {
+	Type           models.CommandType `json:"type"`
+	Description    string             `json:"description"`
+	PayloadSchema  *Schema            `json:"payload_schema"`
+	DefaultTimeout time.Duration      `json:"-"`
+	RequiredRole   models.UserRole    `json:"required_role"`
+}
+
+// MarshalJSON adds the default timeout in seconds
+func (d Definition) MarshalJSON() ([]byte, error) {
+	type definition Definition
+	return json.Marshal(struct {
+		definition
+		DefaultTimeoutSeconds int `json:"default_timeout_seconds"`
+	}{definition(d), int(d.DefaultTimeout / time.Second)})
+}
+
+// ValidatePayload checks a payload against the schema of the command type and
+// returns it in the form it is stored, with a missing payload as an empty object
+func (d Definition) ValidatePayload(payload json.RawMessage) (json.RawMessage, error) {
+	value, err := decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	if d.PayloadSchema != nil {
+		if err := d.PayloadSchema.Validate(value, "payload"); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(value)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[models.CommandType]Definition)
+)
+
+// Register adds a command type. Registering a type twice is a programming
+// error and panics.
+func Register(definition Definition) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := registry[definition.Type]; exists {
+		panic(fmt.Sprintf("command type %s registered twice", definition.Type))
+	}
+	if definition.RequiredRole == "" {
+		definition.RequiredRole = models.UserRoleAdmin
+	}
+	registry[definition.Type] = definition
+}
+
+// Lookup returns the definition of a command type
+func Lookup(commandType models.CommandType) (Definition, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	definition, ok := registry[commandType]
+	return definition, ok
+}
+
+// Definitions returns every registered command type, ordered by type
+func Definitions() []Definition {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	definitions := make([]Definition, 0, len(registry))
+	for _, definition := range registry {
+		definitions = append(definitions, definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Type < definitions[j].Type
+	})
+	return definitions
+}
+
+// Built-in command types, which agents handle out of the box
+func init() {
+	Register(Definition{
+		Type:        models.CommandTypeRefreshNow,
+		Description: "Collect and submit a fresh inventory immediately",
+		PayloadSchema: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"force": {
+					Type:        "boolean",
+					Description: "Submit the inventory even if it is unchanged",
+					Default:     false,
+				},
+			},
+			AdditionalProperties: boolPtr(false),
+		},
+		DefaultTimeout: 5 * time.Minute,
+		RequiredRole:   models.UserRoleAdmin,
+	})
+}
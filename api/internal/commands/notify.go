@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// waiter is the notification channel of a device with the number of waits
+// holding it
+type waiter struct {
+	ch   chan struct{}
+	refs int
+}
+
+var (
+	waitersMu sync.Mutex
+	waiters   = make(map[uuid.UUID]*waiter)
+)
+
+// Queued returns a channel that is closed when a command is next queued for
+// the device. Take it before looking for commands so that none is missed, and
+// call release once done waiting so that the device is forgotten.
+func Queued(deviceID uuid.UUID) (queued <-chan struct{}, release func()) {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+
+	w, ok := waiters[deviceID]
+	if !ok {
+		w = &waiter{ch: make(chan struct{})}
+		waiters[deviceID] = w
+	}
+	w.refs++
+
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			waitersMu.Lock()
+			defer waitersMu.Unlock()
+
+			w.refs--
+			if w.refs == 0 && waiters[deviceID] == w {
+				delete(waiters, deviceID)
+			}
+		})
+	}
+}
+
+// NotifyQueued wakes the agents waiting for commands of the device. Call it
+// once the transaction queueing the command has committed.
+func NotifyQueued(deviceID uuid.UUID) {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+
+	if w, ok := waiters[deviceID]; ok {
+		close(w.ch)
+		delete(waiters, deviceID)
+	}
+}
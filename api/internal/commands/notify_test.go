@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func waiting(deviceID uuid.UUID) bool {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+	_, ok := waiters[deviceID]
+	return ok
+}
+
+func TestQueued(t *testing.T) {
+	deviceID := uuid.New()
+
+	first, releaseFirst := Queued(deviceID)
+	second, releaseSecond := Queued(deviceID)
+	if first != second {
+		t.Fatal("Queued() returned different channels for waits on the same device")
+	}
+
+	// The device is forgotten once the last wait is released, however often
+	// release is called
+	releaseFirst()
+	releaseFirst()
+	if !waiting(deviceID) {
+		t.Fatal("device forgotten while a wait still holds it")
+	}
+	releaseSecond()
+	if waiting(deviceID) {
+		t.Fatal("device still registered after every wait was released")
+	}
+}
+
+func TestNotifyQueued(t *testing.T) {
+	deviceID := uuid.New()
+
+	queued, release := Queued(deviceID)
+	NotifyQueued(deviceID)
+	select {
+	case <-queued:
+	default:
+		t.Fatal("NotifyQueued() did not wake the wait")
+	}
+
+	// A wait taken after the notification gets a new channel, which the
+	// release of the woken wait does not forget
+	next, releaseNext := Queued(deviceID)
+	release()
+	select {
+	case <-next:
+		t.Fatal("new wait woken by the previous notification")
+	default:
+	}
+	if !waiting(deviceID) {
+		t.Fatal("device forgotten by the release of a notified wait")
+	}
+	releaseNext()
+	if waiting(deviceID) {
+		t.Fatal("device still registered after every wait was released")
+	}
+
+	// Notifying a device nobody waits for does nothing
+	NotifyQueued(uuid.New())
+}
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema used to describe command payloads. It is
+// served as is so that clients can build forms from it.
+type Schema struct {
+	Type                 string             `json:"type"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// Validate checks a decoded JSON value against the schema. Errors name the
+// offending field by its path, such as payload.paths[2].
+func (s *Schema) Validate(value interface{}, path string) error {
+	if !s.matchesType(value) {
+		return fmt.Errorf("%s must be of type %s", path, s.Type)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(s.Enum))
+			for i, allowed := range s.Enum {
+				options[i] = fmt.Sprint(allowed)
+			}
+			return fmt.Errorf("%s must be one of: %s", path, strings.Join(options, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not a known field", path, name)
+				}
+				continue
+			}
+			if err := property.Validate(v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s must contain at most %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.Validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			return fmt.Errorf("%s must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && len(v) > *s.MaxLength {
+			return fmt.Errorf("%s must be at most %d characters", path, *s.MaxLength)
+		}
+
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s must be at least %g", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s must be at most %g", path, *s.Maximum)
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	switch s.Type {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	}
+	return true
+}
+
+// decode parses a payload for validation, treating a missing payload as an
+// empty object
+func decode(payload json.RawMessage) (interface{}, error) {
+	trimmed := strings.TrimSpace(string(payload))
+	if trimmed == "" || trimmed == "null" {
+		return map[string]interface{}{}, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return nil, fmt.Errorf("payload must be valid JSON")
+	}
+	return value, nil
+}
+
+func boolPtr(b bool) *bool { return &b }
@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"path": {"type": "string", "minLength": 3, "maxLength": 10},
+		"mode": {"type": "string", "enum": ["quick", "full"]},
+		"retries": {"type": "integer", "minimum": 0, "maximum": 5},
+		"ratio": {"type": "number", "minimum": 0.5},
+		"force": {"type": "boolean"},
+		"paths": {"type": "array", "maxItems": 3, "items": {"type": "string", "maxLength": 5}},
+		"options": {
+			"type": "object",
+			"properties": {"depth": {"type": "integer"}},
+			"required": ["depth"]
+		}
+	},
+	"required": ["path"],
+	"additionalProperties": false
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(testSchema), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{"valid", `{"path": "C:\\Temp", "mode": "full", "retries": 2, "ratio": 0.75, "force": true, "paths": ["a", "b"], "options": {"depth": 1}}`, ""},
+		{"only required", `{"path": "C:\\"}`, ""},
+		{"not an object", `["C:\\Temp"]`, "payload must be of type object"},
+		{"string for integer", `{"path": "C:\\", "retries": "2"}`, "payload.retries must be of type integer"},
+		{"float for integer", `{"path": "C:\\", "retries": 2.5}`, "payload.retries must be of type integer"},
+		{"whole float for integer", `{"path": "C:\\", "retries": 2.0}`, ""},
+		{"integer for number", `{"path": "C:\\", "ratio": 1}`, ""},
+		{"string for boolean", `{"path": "C:\\", "force": "true"}`, "payload.force must be of type boolean"},
+		{"missing required", `{"mode": "full"}`, "payload.path is required"},
+		{"missing nested required", `{"path": "C:\\", "options": {}}`, "payload.options.depth is required"},
+		{"unknown field", `{"path": "C:\\", "verbose": true}`, "payload.verbose is not a known field"},
+		{"unknown nested field allowed", `{"path": "C:\\", "options": {"depth": 1, "follow": true}}`, ""},
+		{"enum", `{"path": "C:\\", "mode": "deep"}`, "payload.mode must be one of: quick, full"},
+		{"below minimum", `{"path": "C:\\", "retries": -1}`, "payload.retries must be at least 0"},
+		{"above maximum", `{"path": "C:\\", "retries": 6}`, "payload.retries must be at most 5"},
+		{"fractional minimum", `{"path": "C:\\", "ratio": 0.25}`, "payload.ratio must be at least 0.5"},
+		{"too short", `{"path": "C:"}`, "payload.path must be at least 3 characters"},
+		{"too long", `{"path": "C:\\Windows\\Temp"}`, "payload.path must be at most 10 characters"},
+		{"too many items", `{"path": "C:\\", "paths": ["a", "b", "c", "d"]}`, "payload.paths must contain at most 3 items"},
+		{"item type", `{"path": "C:\\", "paths": ["a", 2]}`, "payload.paths[1] must be of type string"},
+		{"item length", `{"path": "C:\\", "paths": ["a", "b", "abcdef"]}`, "payload.paths[2] must be at most 5 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.payload), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			err := schema.Validate(value, "payload")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePayload(t *testing.T) {
+	definition := Definition{
+		PayloadSchema: &Schema{
+			Type:                 "object",
+			Properties:           map[string]*Schema{"force": {Type: "boolean"}},
+			AdditionalProperties: boolPtr(false),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"missing", ``, `{}`, false},
+		{"null", `null`, `{}`, false},
+		{"blank", "  \n", `{}`, false},
+		{"empty object", `{}`, `{}`, false},
+		{"valid", `{"force": true}`, `{"force":true}`, false},
+		{"invalid JSON", `{"force":`, "", true},
+		{"schema violation", `{"force": 1}`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := definition.ValidatePayload(json.RawMessage(tt.payload))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ValidatePayload(%q) = %s, want an error", tt.payload, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidatePayload(%q) = %v", tt.payload, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ValidatePayload(%q) = %s, want %s", tt.payload, got, tt.want)
+			}
+		})
+	}
+
+	// Types without a schema accept any payload
+	got, err := Definition{}.ValidatePayload(json.RawMessage(`{"anything": [1, 2]}`))
+	if err != nil || string(got) != `{"anything":[1,2]}` {
+		t.Errorf("ValidatePayload without a schema = %s, %v", got, err)
+	}
+}
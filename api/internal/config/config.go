@@ -33,6 +33,32 @@ type Config struct {
 
 	// Payload limits
 	MaxPayloadSize int64 `json:"max_payload_size"`
+
+	// Metrics retention per resolution tier
+	MetricsRawRetention time.Duration `json:"metrics_raw_retention"`
+	Metrics5mRetention  time.Duration `json:"metrics_5m_retention"`
+	Metrics1hRetention  time.Duration `json:"metrics_1h_retention"`
+	Metrics1dRetention  time.Duration `json:"metrics_1d_retention"`
+
+	// Alerting
+	AlertEvaluationInterval time.Duration `json:"alert_evaluation_interval"`
+
+	// Device status reconciliation
+	DeviceStatusInterval time.Duration `json:"device_status_interval"`
+	DeviceOfflineAfter   time.Duration `json:"device_offline_after"`  // without check-in before a device is offline
+	DeviceInactiveAfter  time.Duration `json:"device_inactive_after"` // without check-in before a device is inactive
+
+	// Command delivery
+	CommandTTL           time.Duration `json:"command_ttl"`            // default time a command can be delivered
+	CommandMaxAttempts   int           `json:"command_max_attempts"`   // default deliveries before a command fails
+	CommandLeaseGrace    time.Duration `json:"command_lease_grace"`    // added to a command's timeout for its lease
+	CommandSweepInterval time.Duration `json:"command_sweep_interval"`
+
+	// Events and webhooks
+	EventRetention     time.Duration `json:"event_retention"`
+	WebhookMaxAttempts int           `json:"webhook_max_attempts"`
+	WebhookRetryBase   time.Duration `json:"webhook_retry_base"`
+	WebhookTimeout     time.Duration `json:"webhook_timeout"`
 }
 
 func Load() (*Config, error) {
@@ -46,6 +72,22 @@ func Load() (*Config, error) {
 		TokenRotationInterval: 30 * 24 * time.Hour, // 30 days
 		LogLevel:             "INFO",
 		MaxPayloadSize:       10 * 1024 * 1024, // 10MB
+		MetricsRawRetention:  48 * time.Hour,
+		Metrics5mRetention:   14 * 24 * time.Hour,
+		Metrics1hRetention:   90 * 24 * time.Hour,
+		Metrics1dRetention:   2 * 365 * 24 * time.Hour,
+		AlertEvaluationInterval: time.Minute,
+		DeviceStatusInterval:  time.Minute,
+		DeviceOfflineAfter:    15 * time.Minute, // three missed 5 minute heartbeats
+		DeviceInactiveAfter:   30 * 24 * time.Hour,
+		CommandTTL:            24 * time.Hour,
+		CommandMaxAttempts:    3,
+		CommandLeaseGrace:     time.Minute,
+		CommandSweepInterval:  time.Minute,
+		EventRetention:        7 * 24 * time.Hour,
+		WebhookMaxAttempts:    8,
+		WebhookRetryBase:      30 * time.Second,
+		WebhookTimeout:        10 * time.Second,
 	}
 
 	// Load from environment variables
@@ -107,6 +149,102 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if retention := os.Getenv("METRICS_RAW_RETENTION"); retention != "" {
+		if duration, err := time.ParseDuration(retention); err == nil {
+			cfg.MetricsRawRetention = duration
+		}
+	}
+
+	if retention := os.Getenv("METRICS_5M_RETENTION"); retention != "" {
+		if duration, err := time.ParseDuration(retention); err == nil {
+			cfg.Metrics5mRetention = duration
+		}
+	}
+
+	if retention := os.Getenv("METRICS_1H_RETENTION"); retention != "" {
+		if duration, err := time.ParseDuration(retention); err == nil {
+			cfg.Metrics1hRetention = duration
+		}
+	}
+
+	if retention := os.Getenv("METRICS_1D_RETENTION"); retention != "" {
+		if duration, err := time.ParseDuration(retention); err == nil {
+			cfg.Metrics1dRetention = duration
+		}
+	}
+
+	if interval := os.Getenv("ALERT_EVALUATION_INTERVAL"); interval != "" {
+		if duration, err := time.ParseDuration(interval); err == nil {
+			cfg.AlertEvaluationInterval = duration
+		}
+	}
+
+	if interval := os.Getenv("DEVICE_STATUS_INTERVAL"); interval != "" {
+		if duration, err := time.ParseDuration(interval); err == nil {
+			cfg.DeviceStatusInterval = duration
+		}
+	}
+
+	if after := os.Getenv("DEVICE_OFFLINE_AFTER"); after != "" {
+		if duration, err := time.ParseDuration(after); err == nil {
+			cfg.DeviceOfflineAfter = duration
+		}
+	}
+
+	if after := os.Getenv("DEVICE_INACTIVE_AFTER"); after != "" {
+		if duration, err := time.ParseDuration(after); err == nil {
+			cfg.DeviceInactiveAfter = duration
+		}
+	}
+
+	if ttl := os.Getenv("COMMAND_TTL"); ttl != "" {
+		if duration, err := time.ParseDuration(ttl); err == nil {
+			cfg.CommandTTL = duration
+		}
+	}
+
+	if attempts := os.Getenv("COMMAND_MAX_ATTEMPTS"); attempts != "" {
+		if a, err := strconv.Atoi(attempts); err == nil {
+			cfg.CommandMaxAttempts = a
+		}
+	}
+
+	if grace := os.Getenv("COMMAND_LEASE_GRACE"); grace != "" {
+		if duration, err := time.ParseDuration(grace); err == nil {
+			cfg.CommandLeaseGrace = duration
+		}
+	}
+
+	if interval := os.Getenv("COMMAND_SWEEP_INTERVAL"); interval != "" {
+		if duration, err := time.ParseDuration(interval); err == nil {
+			cfg.CommandSweepInterval = duration
+		}
+	}
+
+	if retention := os.Getenv("EVENT_RETENTION"); retention != "" {
+		if duration, err := time.ParseDuration(retention); err == nil {
+			cfg.EventRetention = duration
+		}
+	}
+
+	if attempts := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); attempts != "" {
+		if a, err := strconv.Atoi(attempts); err == nil {
+			cfg.WebhookMaxAttempts = a
+		}
+	}
+
+	if base := os.Getenv("WEBHOOK_RETRY_BASE"); base != "" {
+		if duration, err := time.ParseDuration(base); err == nil {
+			cfg.WebhookRetryBase = duration
+		}
+	}
+
+	if timeout := os.Getenv("WEBHOOK_TIMEOUT"); timeout != "" {
+		if duration, err := time.ParseDuration(timeout); err == nil {
+			cfg.WebhookTimeout = duration
+		}
+	}
+
 	// Validate required fields
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
@@ -140,5 +278,53 @@ func (c *Config) validate() error {
 		return fmt.Errorf("max payload size must be at least 1KB")
 	}
 
+	if c.MetricsRawRetention <= 0 || c.Metrics5mRetention <= 0 || c.Metrics1hRetention <= 0 || c.Metrics1dRetention <= 0 {
+		return fmt.Errorf("metrics retention periods must be positive")
+	}
+
+	if c.AlertEvaluationInterval < time.Second {
+		return fmt.Errorf("alert evaluation interval must be at least 1 second")
+	}
+
+	if c.DeviceStatusInterval < time.Second {
+		return fmt.Errorf("device status interval must be at least 1 second")
+	}
+
+	if c.DeviceOfflineAfter < time.Minute {
+		return fmt.Errorf("device offline threshold must be at least 1 minute")
+	}
+
+	if c.DeviceInactiveAfter <= c.DeviceOfflineAfter {
+		return fmt.Errorf("device inactive threshold must be longer than the offline threshold")
+	}
+
+	if c.CommandTTL < time.Minute {
+		return fmt.Errorf("command TTL must be at least 1 minute")
+	}
+
+	if c.CommandMaxAttempts < 1 {
+		return fmt.Errorf("command max attempts must be at least 1")
+	}
+
+	if c.CommandLeaseGrace < 0 {
+		return fmt.Errorf("command lease grace must not be negative")
+	}
+
+	if c.CommandSweepInterval < time.Second {
+		return fmt.Errorf("command sweep interval must be at least 1 second")
+	}
+
+	if c.EventRetention < time.Hour {
+		return fmt.Errorf("event retention must be at least 1 hour")
+	}
+
+	if c.WebhookMaxAttempts < 1 {
+		return fmt.Errorf("webhook max attempts must be at least 1")
+	}
+
+	if c.WebhookRetryBase < time.Second || c.WebhookTimeout < time.Second {
+		return fmt.Errorf("webhook retry base and timeout must be at least 1 second")
+	}
+
 	return nil
 }
\ No newline at end of file
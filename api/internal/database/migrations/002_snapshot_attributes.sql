@@ -0,0 +1,11 @@
+-- Persist identity, OS and hardware attributes on each snapshot
+-- so that historical snapshots can be compared field by field
+
+ALTER TABLE snapshots ADD COLUMN hostname TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN os_caption TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN os_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN os_build TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN manufacturer TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN model TEXT NOT NULL DEFAULT '';
+ALTER TABLE snapshots ADD COLUMN serial_number TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,21 @@
+-- Lightweight check-in records for inventory submissions whose stable
+-- inventory matches an existing snapshot. Volatile readings (performance,
+-- free space, boot time, interactive user) are stored here instead of
+-- duplicating the full snapshot.
+
+CREATE TABLE snapshot_checkins (
+    id TEXT PRIMARY KEY,
+    snapshot_id TEXT NOT NULL REFERENCES snapshots(id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    collected_at TEXT NOT NULL,
+    cpu_percent REAL,
+    memory_used_bytes INTEGER,
+    memory_total_bytes INTEGER,
+    boot_time TEXT,
+    last_interactive_user TEXT NOT NULL DEFAULT '',
+    volume_free_bytes TEXT, -- JSON object of volume name to free bytes
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    UNIQUE(device_id, collected_at)
+);
+
+CREATE INDEX idx_snapshot_checkins_snapshot_id ON snapshot_checkins(snapshot_id);
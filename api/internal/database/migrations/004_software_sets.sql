@@ -0,0 +1,27 @@
+-- Content-addressed software sets. Identical software lists are stored once,
+-- keyed by a hash of their sorted entries, and referenced by snapshots.
+-- Legacy software_items rows are moved into sets by the startup backfill.
+
+CREATE TABLE software_sets (
+    hash TEXT PRIMARY KEY, -- SHA-256 of the sorted software entries
+    item_count INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE software_set_items (
+    id TEXT PRIMARY KEY,
+    set_hash TEXT NOT NULL REFERENCES software_sets(hash) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    version TEXT,
+    publisher TEXT,
+    install_date TEXT,
+    size_kb INTEGER,
+    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+ALTER TABLE snapshots ADD COLUMN software_set_hash TEXT REFERENCES software_sets(hash);
+
+CREATE INDEX idx_software_set_items_set_hash ON software_set_items(set_hash);
+CREATE INDEX idx_software_set_items_name ON software_set_items(name);
+CREATE INDEX idx_software_set_items_publisher ON software_set_items(publisher);
+CREATE INDEX idx_snapshots_software_set_hash ON snapshots(software_set_hash);
@@ -0,0 +1,18 @@
+-- Time-series performance metrics. Each sample is aggregated into every
+-- resolution tier at ingest (raw, 5 minute, hourly, daily) and a background
+-- job removes points older than the retention configured for their tier.
+
+CREATE TABLE metric_points (
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    metric TEXT NOT NULL, -- e.g. cpu_percent, memory_used_bytes, volume_free_bytes
+    label TEXT NOT NULL DEFAULT '', -- series label such as the volume name
+    resolution TEXT NOT NULL CHECK(resolution IN ('raw', '5m', '1h', '1d')),
+    bucket_start INTEGER NOT NULL, -- Unix seconds (UTC) aligned to the resolution
+    sample_count INTEGER NOT NULL,
+    value_min REAL NOT NULL,
+    value_max REAL NOT NULL,
+    value_sum REAL NOT NULL,
+    PRIMARY KEY (device_id, metric, label, resolution, bucket_start)
+);
+
+CREATE INDEX idx_metric_points_resolution_bucket ON metric_points(resolution, bucket_start);
@@ -0,0 +1,19 @@
+-- Per-batch summaries of performance samples uploaded by agents between
+-- inventory submissions. The individual samples are folded into metric_points,
+-- these rows keep the distribution (including p95) of each upload window.
+
+CREATE TABLE metric_batch_summaries (
+    id TEXT PRIMARY KEY,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    metric TEXT NOT NULL,
+    started_at TEXT NOT NULL,
+    ended_at TEXT NOT NULL,
+    sample_count INTEGER NOT NULL,
+    value_min REAL NOT NULL,
+    value_avg REAL NOT NULL,
+    value_max REAL NOT NULL,
+    value_p95 REAL NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX idx_metric_batch_summaries_device_metric ON metric_batch_summaries(device_id, metric, started_at DESC);
@@ -0,0 +1,21 @@
+-- Software install, uninstall, upgrade and downgrade events, derived at
+-- ingest time by comparing each new snapshot with the device's previous one.
+
+CREATE TABLE software_events (
+    id TEXT PRIMARY KEY,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    snapshot_id TEXT NOT NULL REFERENCES snapshots(id) ON DELETE CASCADE,
+    previous_snapshot_id TEXT REFERENCES snapshots(id) ON DELETE SET NULL,
+    event_type TEXT NOT NULL CHECK (event_type IN ('install', 'uninstall', 'upgrade', 'downgrade')),
+    name TEXT NOT NULL,
+    publisher TEXT NOT NULL DEFAULT '',
+    from_version TEXT,
+    to_version TEXT,
+    interactive_user TEXT NOT NULL DEFAULT '',
+    occurred_at TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX idx_software_events_device_occurred ON software_events(device_id, occurred_at DESC);
+CREATE INDEX idx_software_events_name_occurred ON software_events(name, occurred_at DESC);
+CREATE INDEX idx_software_events_occurred ON software_events(occurred_at DESC);
@@ -0,0 +1,37 @@
+-- Current-state inventory, replaced transactionally on every new snapshot so
+-- the catalog and device views read what is installed now instead of scanning
+-- every historical snapshot. Readings are refreshed by check-ins as well.
+
+CREATE TABLE device_current_state (
+    device_id TEXT PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+    snapshot_id TEXT NOT NULL REFERENCES snapshots(id) ON DELETE CASCADE,
+    collected_at TEXT NOT NULL,
+    cpu_percent REAL,
+    memory_used_bytes INTEGER,
+    memory_total_bytes INTEGER,
+    boot_time TEXT,
+    software_count INTEGER NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE device_current_software (
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    version TEXT NOT NULL DEFAULT '',
+    publisher TEXT NOT NULL DEFAULT '',
+    install_date TEXT,
+    size_kb INTEGER
+);
+
+CREATE TABLE device_current_volumes (
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    filesystem TEXT NOT NULL DEFAULT '',
+    total_bytes INTEGER NOT NULL DEFAULT 0,
+    free_bytes INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (device_id, name)
+);
+
+CREATE INDEX idx_device_current_software_device ON device_current_software(device_id);
+CREATE INDEX idx_device_current_software_title ON device_current_software(name, version, publisher);
+CREATE INDEX idx_device_current_software_publisher ON device_current_software(publisher);
@@ -0,0 +1,31 @@
+-- Admin-managed normalization rules for software names and publishers.
+-- Rules are applied at ingest in priority order. The values reported by the
+-- agent are kept in raw_name and raw_publisher so that the normalized columns
+-- can be recomputed by the backfill job whenever the rules change.
+
+CREATE TABLE software_normalization_rules (
+    id TEXT PRIMARY KEY,
+    field TEXT NOT NULL CHECK (field IN ('name', 'publisher')),
+    match_type TEXT NOT NULL CHECK (match_type IN ('regex', 'alias')),
+    pattern TEXT NOT NULL,
+    replacement TEXT NOT NULL DEFAULT '',
+    priority INTEGER NOT NULL DEFAULT 100,
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX idx_software_normalization_rules_order ON software_normalization_rules(field, priority, created_at);
+
+ALTER TABLE software_set_items ADD COLUMN raw_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE software_set_items ADD COLUMN raw_publisher TEXT NOT NULL DEFAULT '';
+UPDATE software_set_items SET raw_name = name, raw_publisher = COALESCE(publisher, '');
+
+ALTER TABLE device_current_software ADD COLUMN raw_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE device_current_software ADD COLUMN raw_publisher TEXT NOT NULL DEFAULT '';
+UPDATE device_current_software SET raw_name = name, raw_publisher = publisher;
+
+ALTER TABLE software_events ADD COLUMN raw_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE software_events ADD COLUMN raw_publisher TEXT NOT NULL DEFAULT '';
+UPDATE software_events SET raw_name = name, raw_publisher = publisher;
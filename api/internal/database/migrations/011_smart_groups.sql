@@ -0,0 +1,19 @@
+-- Smart groups compute their membership from inventory criteria. Members of
+-- every group are stored in device_group_members so that group filters stay
+-- simple joins, and each change is recorded in the membership history.
+
+ALTER TABLE device_groups ADD COLUMN kind TEXT NOT NULL DEFAULT 'static' CHECK (kind IN ('static', 'smart'));
+ALTER TABLE device_groups ADD COLUMN criteria TEXT;
+ALTER TABLE device_groups ADD COLUMN evaluated_at TEXT;
+
+CREATE TABLE device_group_membership_history (
+    id TEXT PRIMARY KEY,
+    group_id TEXT NOT NULL REFERENCES device_groups(id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    change TEXT NOT NULL CHECK (change IN ('joined', 'left')),
+    source TEXT NOT NULL DEFAULT 'manual', -- manual, criteria or inventory
+    changed_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_group_membership_history_group ON device_group_membership_history(group_id, changed_at DESC);
+CREATE INDEX idx_group_membership_history_device ON device_group_membership_history(device_id, changed_at DESC);
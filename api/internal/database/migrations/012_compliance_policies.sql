@@ -0,0 +1,30 @@
+-- Compliance policies are rules such as "software X must be installed" that
+-- are evaluated against the current inventory of every device after each
+-- inventory submission. The latest outcome per device and policy is kept in
+-- policy_results, with changed_at recording when the outcome last flipped.
+
+CREATE TABLE policies (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    description TEXT NOT NULL DEFAULT '',
+    rule_type TEXT NOT NULL,
+    params TEXT NOT NULL DEFAULT '{}',
+    severity TEXT NOT NULL DEFAULT 'medium' CHECK (severity IN ('low', 'medium', 'high', 'critical')),
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE policy_results (
+    policy_id TEXT NOT NULL REFERENCES policies(id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    snapshot_id TEXT REFERENCES snapshots(id) ON DELETE SET NULL,
+    passed BOOLEAN NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    evaluated_at TEXT NOT NULL,
+    changed_at TEXT NOT NULL,
+    PRIMARY KEY (policy_id, device_id)
+);
+
+CREATE INDEX idx_policy_results_device ON policy_results(device_id);
+CREATE INDEX idx_policy_results_policy_passed ON policy_results(policy_id, passed);
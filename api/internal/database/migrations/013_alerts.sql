@@ -0,0 +1,42 @@
+-- Alert rules are thresholds such as "disk free below 10%" checked against the
+-- latest readings of every device on ingest and on a periodic timer. Each
+-- breach opens an alert that moves from firing to acknowledged to resolved.
+-- dedup_key identifies the rule, device and subject (a volume for disk rules)
+-- and at most one unresolved alert exists per key, so a condition that keeps
+-- holding updates its open alert instead of opening new ones.
+
+CREATE TABLE alert_rules (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    description TEXT NOT NULL DEFAULT '',
+    rule_type TEXT NOT NULL,
+    params TEXT NOT NULL DEFAULT '{}',
+    severity TEXT NOT NULL DEFAULT 'warning' CHECK (severity IN ('info', 'warning', 'critical')),
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE alerts (
+    id TEXT PRIMARY KEY,
+    rule_id TEXT NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    subject TEXT NOT NULL DEFAULT '',
+    dedup_key TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'firing' CHECK (state IN ('firing', 'acknowledged', 'resolved')),
+    severity TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    value REAL,
+    occurrences INTEGER NOT NULL DEFAULT 1,
+    fired_at TEXT NOT NULL,
+    last_fired_at TEXT NOT NULL,
+    acknowledged_at TEXT,
+    acknowledged_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+    acknowledgement_note TEXT NOT NULL DEFAULT '',
+    resolved_at TEXT
+);
+
+CREATE UNIQUE INDEX idx_alerts_open_dedup_key ON alerts(dedup_key) WHERE state != 'resolved';
+CREATE INDEX idx_alerts_state_fired ON alerts(state, fired_at);
+CREATE INDEX idx_alerts_device ON alerts(device_id, fired_at);
+CREATE INDEX idx_alerts_rule ON alerts(rule_id, state);
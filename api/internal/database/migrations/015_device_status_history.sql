@@ -0,0 +1,25 @@
+-- Device status is maintained by a background reconciler that moves devices
+-- between active, offline, inactive and error from the time of their last
+-- check-in and the errors their agent reports with heartbeats. Every
+-- transition is kept in device_status_history for availability reporting.
+
+ALTER TABLE devices ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN status_changed_at TEXT;
+ALTER TABLE devices ADD COLUMN agent_error TEXT NOT NULL DEFAULT '';
+
+UPDATE devices SET status_changed_at = first_seen;
+
+CREATE TABLE device_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    previous_status TEXT,
+    status TEXT NOT NULL CHECK (status IN ('active', 'inactive', 'offline', 'error')),
+    reason TEXT NOT NULL DEFAULT '',
+    changed_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_device_status_history_device_changed ON device_status_history(device_id, changed_at);
+
+-- Existing devices start their history in their current status
+INSERT INTO device_status_history (device_id, previous_status, status, reason, changed_at)
+SELECT id, NULL, status, 'initial status', first_seen FROM devices;
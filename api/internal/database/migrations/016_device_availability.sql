@@ -0,0 +1,29 @@
+-- Check-ins (heartbeats, inventory submissions, performance uploads and
+-- command polls) are compacted into availability intervals. A check-in within
+-- the offline threshold of the previous one extends the device's latest
+-- interval, otherwise it starts a new one. History starts with this migration.
+
+CREATE TABLE device_availability (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    started_at TEXT NOT NULL,
+    ended_at TEXT NOT NULL,
+    checkins INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_device_availability_device_ended ON device_availability(device_id, ended_at);
+CREATE INDEX idx_device_availability_ended ON device_availability(ended_at);
+
+-- Reboots are detected from inventory submissions reporting a later boot_time
+-- than the device's current state
+
+CREATE TABLE device_reboots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    boot_time TEXT NOT NULL,
+    previous_boot_time TEXT,
+    detected_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_device_reboots_device_boot ON device_reboots(device_id, boot_time);
+CREATE INDEX idx_device_reboots_boot ON device_reboots(boot_time);
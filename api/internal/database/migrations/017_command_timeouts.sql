@@ -0,0 +1,4 @@
+-- Commands carry the time agents allow them to run. It defaults to the
+-- timeout declared by the command type when the command is created.
+
+ALTER TABLE commands ADD COLUMN timeout_seconds INTEGER NOT NULL DEFAULT 300;
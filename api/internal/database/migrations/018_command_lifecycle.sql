@@ -0,0 +1,32 @@
+-- Commands are leased to agents when polled. A lease that is not acknowledged
+-- before it expires returns the command to the queue until its attempts run
+-- out. Commands become available at not_before and expire once expires_at
+-- passes without being delivered. Every transition is kept in
+-- command_status_history.
+
+ALTER TABLE commands ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE commands ADD COLUMN max_attempts INTEGER NOT NULL DEFAULT 3;
+ALTER TABLE commands ADD COLUMN not_before TEXT;
+ALTER TABLE commands ADD COLUMN expires_at TEXT;
+ALTER TABLE commands ADD COLUMN lease_expires_at TEXT;
+
+UPDATE commands SET expires_at = datetime(created_at, '+1 day');
+
+CREATE INDEX idx_commands_lease_expires ON commands(status, lease_expires_at);
+CREATE INDEX idx_commands_expires ON commands(status, expires_at);
+
+CREATE TABLE command_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    command_id TEXT NOT NULL REFERENCES commands(id) ON DELETE CASCADE,
+    previous_status TEXT,
+    status TEXT NOT NULL CHECK (status IN ('queued', 'in_progress', 'completed', 'failed', 'expired')),
+    reason TEXT NOT NULL DEFAULT '',
+    attempt INTEGER NOT NULL DEFAULT 0,
+    changed_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_command_status_history_command ON command_status_history(command_id, id);
+
+-- Existing commands start their history in their current status
+INSERT INTO command_status_history (command_id, previous_status, status, reason, attempt, changed_at)
+SELECT id, NULL, status, 'initial status', 0, COALESCE(executed_at, created_at) FROM commands;
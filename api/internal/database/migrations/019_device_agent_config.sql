@@ -0,0 +1,9 @@
+-- Agent settings managed from the console. They are pushed to agents
+-- connected over the agent channel, and again whenever an agent connects.
+
+CREATE TABLE device_agent_config (
+    device_id TEXT PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+    config TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    updated_by TEXT NOT NULL DEFAULT ''
+);
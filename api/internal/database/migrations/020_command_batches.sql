@@ -0,0 +1,18 @@
+-- A command batch sends a command to a set of devices, selected by ID, group
+-- or fleet query, as one command per device. The progress of a batch is
+-- counted from the status of its commands.
+
+CREATE TABLE command_batches (
+    id TEXT PRIMARY KEY,
+    command_type TEXT NOT NULL,
+    payload TEXT,
+    target TEXT NOT NULL,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_command_batches_created ON command_batches(created_at);
+
+ALTER TABLE commands ADD COLUMN batch_id TEXT REFERENCES command_batches(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_commands_batch ON commands(batch_id, status);
@@ -0,0 +1,21 @@
+-- Agents upload buffered performance samples again when an upload fails,
+-- including uploads whose response was lost after the API stored them. The
+-- key of every uploaded sample is kept for as long as raw metric points so
+-- that samples already recorded are skipped, and a retried batch replaces the
+-- summary stored for the window it starts.
+
+CREATE TABLE metric_sample_keys (
+    device_id TEXT NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    metric TEXT NOT NULL,
+    label TEXT NOT NULL DEFAULT '',
+    ts INTEGER NOT NULL, -- Unix seconds (UTC) of the sample
+    PRIMARY KEY (device_id, metric, label, ts)
+);
+
+CREATE INDEX idx_metric_sample_keys_ts ON metric_sample_keys(ts);
+
+DELETE FROM metric_batch_summaries WHERE rowid NOT IN (
+    SELECT MAX(rowid) FROM metric_batch_summaries GROUP BY device_id, metric, started_at
+);
+
+CREATE UNIQUE INDEX idx_metric_batch_summaries_window ON metric_batch_summaries(device_id, metric, started_at);
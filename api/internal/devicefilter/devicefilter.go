@@ -0,0 +1,302 @@
+package devicefilter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tracr/api/internal/models"
+)
+
+// Comparison operators accepted by conditions
+const (
+	OpMatch        = ":"
+	OpEqual        = "="
+	OpNotEqual     = "!="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+	OpContains     = "contains"
+	OpStartsWith   = "starts_with"
+	OpInstalled    = "installed"
+	OpNotInstalled = "not_installed"
+)
+
+// fieldKind describes how a field is compared
+type fieldKind int
+
+const (
+	kindText fieldKind = iota
+	kindInteger
+	kindPercent
+	kindTime
+	kindSoftware
+	kindTag
+	kindGroup
+)
+
+// field maps a filterable device attribute to SQL on the devices table,
+// aliased d. Volatile fields change without an inventory submission.
+type field struct {
+	kind     fieldKind
+	column   string
+	volatile bool
+}
+
+var fields = map[string]field{
+	"hostname":            {kind: kindText, column: "d.hostname"},
+	"domain":              {kind: kindText, column: "d.domain"},
+	"manufacturer":        {kind: kindText, column: "d.manufacturer"},
+	"model":               {kind: kindText, column: "d.model"},
+	"serial_number":       {kind: kindText, column: "d.serial_number"},
+	"os_caption":          {kind: kindText, column: "d.os_caption"},
+	"os_version":          {kind: kindText, column: "d.os_version"},
+	"status":              {kind: kindText, column: "d.status", volatile: true},
+	"os_build":            {kind: kindInteger, column: "CAST(d.os_build AS INTEGER)"},
+	"last_seen":           {kind: kindTime, column: "d.last_seen", volatile: true},
+	"first_seen":          {kind: kindTime, column: "d.first_seen", volatile: true},
+	"software":            {kind: kindSoftware},
+	"tag":                 {kind: kindTag, volatile: true},
+	"group":               {kind: kindGroup, volatile: true},
+	"volume_used_percent": {kind: kindPercent},
+}
+
+var operators = map[fieldKind][]string{
+	kindText:     {OpMatch, OpEqual, OpNotEqual, OpContains, OpStartsWith},
+	kindInteger:  {OpMatch, OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual},
+	kindPercent:  {OpLess, OpLessEqual, OpGreater, OpGreaterEqual},
+	kindTime:     {OpLess, OpLessEqual, OpGreater, OpGreaterEqual},
+	kindSoftware: {OpMatch, OpEqual, OpNotEqual, OpInstalled, OpNotInstalled},
+	kindTag:      {OpMatch, OpEqual, OpNotEqual},
+	kindGroup:    {OpMatch, OpEqual, OpNotEqual},
+}
+
+// Fields returns the names of the filterable device attributes
+func Fields() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FieldName resolves the dotted spelling of a field ("volume.used_percent")
+// to its canonical name
+func FieldName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, ".", "_"))
+}
+
+// ConditionSQL translates a single condition into a parameterized SQL
+// expression over the devices table aliased as d.
+//
+// Text values compared with ":" may use * as a wildcard. Time fields accept an
+// age such as 30m, 12h, 7d or 2w, compared against how long ago the event
+// happened (last_seen<7d means seen within the last seven days), or an RFC3339
+// timestamp or YYYY-MM-DD date compared directly.
+func ConditionSQL(condition models.GroupCondition) (string, []interface{}, error) {
+	return conditionSQL(condition, time.Now().UTC())
+}
+
+func conditionSQL(condition models.GroupCondition, now time.Time) (string, []interface{}, error) {
+	name := FieldName(condition.Field)
+	f, ok := fields[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field %q, use one of: %s", condition.Field, strings.Join(Fields(), ", "))
+	}
+
+	if !supports(f.kind, condition.Op) {
+		return "", nil, fmt.Errorf("operator %q is not supported for %s, use one of: %s",
+			condition.Op, name, strings.Join(operators[f.kind], ", "))
+	}
+
+	value := strings.TrimSpace(condition.Value)
+	if value == "" {
+		return "", nil, fmt.Errorf("%s requires a value", name)
+	}
+
+	switch f.kind {
+	case kindText:
+		switch condition.Op {
+		case OpContains:
+			return f.column + " LIKE '%' || ? || '%' ESCAPE '\\'", []interface{}{escapeLike(value)}, nil
+		case OpStartsWith:
+			return f.column + " LIKE ? || '%' ESCAPE '\\'", []interface{}{escapeLike(value)}, nil
+		case OpMatch:
+			clause, arg := matchSQL(f.column, value)
+			return clause, []interface{}{arg}, nil
+		}
+		return f.column + " " + condition.Op + " ? COLLATE NOCASE", []interface{}{value}, nil
+
+	case kindInteger:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s requires a whole number, got %q", name, value)
+		}
+		op := condition.Op
+		if op == OpMatch {
+			op = OpEqual
+		}
+		return f.column + " " + op + " ?", []interface{}{number}, nil
+
+	case kindPercent:
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return "", nil, fmt.Errorf("%s requires a percentage between 0 and 100, got %q", name, value)
+		}
+		// Matches when any volume of the device satisfies the comparison
+		return `EXISTS (
+			SELECT 1 FROM device_current_volumes v
+			WHERE v.device_id = d.id AND v.total_bytes > 0
+			  AND (v.total_bytes - v.free_bytes) * 100.0 / v.total_bytes ` + condition.Op + ` ?)`,
+			[]interface{}{percent}, nil
+
+	case kindTime:
+		if age, ok := parseAge(value); ok {
+			// An age compares in the opposite direction of the timestamp:
+			// seen less than 7 days ago means seen after now - 7 days
+			return f.column + " " + reverseOp(condition.Op) + " ?", []interface{}{now.Add(-age)}, nil
+		}
+		timestamp, err := parseTimestamp(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s requires an age such as 7d or a date such as 2024-01-31, got %q", name, value)
+		}
+		return f.column + " " + condition.Op + " ?", []interface{}{timestamp}, nil
+
+	case kindSoftware:
+		clause, arg := matchSQL("cs.name", value)
+		exists := `EXISTS (
+			SELECT 1 FROM device_current_software cs
+			WHERE cs.device_id = d.id AND ` + clause + `)`
+		if condition.Op == OpNotInstalled || condition.Op == OpNotEqual {
+			exists = "NOT " + exists
+		}
+		return exists, []interface{}{arg}, nil
+
+	case kindTag:
+		clause, arg := matchSQL("t.tag", value)
+		exists := `EXISTS (SELECT 1 FROM device_tags t WHERE t.device_id = d.id AND ` + clause + `)`
+		if condition.Op == OpNotEqual {
+			exists = "NOT " + exists
+		}
+		return exists, []interface{}{arg}, nil
+
+	case kindGroup:
+		// Groups are matched by name or ID
+		exists := `EXISTS (
+			SELECT 1 FROM device_group_members m
+			JOIN device_groups g ON g.id = m.group_id
+			WHERE m.device_id = d.id AND (g.name = ? COLLATE NOCASE OR g.id = ?))`
+		if condition.Op == OpNotEqual {
+			exists = "NOT " + exists
+		}
+		return exists, []interface{}{value, strings.ToLower(value)}, nil
+	}
+
+	return "", nil, fmt.Errorf("unsupported field %q", name)
+}
+
+// CriteriaSQL translates smart group criteria into a parameterized SQL
+// expression over the devices table aliased as d. Membership is only
+// re-evaluated when inventory changes, so fields that change otherwise are
+// rejected: time fields, status, tags and groups, which could also make a
+// group depend on itself.
+func CriteriaSQL(criteria models.GroupCriteria) (string, []interface{}, error) {
+	if len(criteria.Conditions) == 0 {
+		return "", nil, fmt.Errorf("criteria require at least one condition")
+	}
+
+	joiner := " AND "
+	switch criteria.Match {
+	case "", models.GroupMatchAll:
+	case models.GroupMatchAny:
+		joiner = " OR "
+	default:
+		return "", nil, fmt.Errorf("match must be one of: all, any")
+	}
+
+	clauses := make([]string, 0, len(criteria.Conditions))
+	var args []interface{}
+	for i, condition := range criteria.Conditions {
+		if f, ok := fields[FieldName(condition.Field)]; ok && f.volatile {
+			return "", nil, fmt.Errorf("conditions[%d]: %s cannot be used in smart group criteria", i, condition.Field)
+		}
+		clause, conditionArgs, err := ConditionSQL(condition)
+		if err != nil {
+			return "", nil, fmt.Errorf("conditions[%d]: %w", i, err)
+		}
+		clauses = append(clauses, "("+clause+")")
+		args = append(args, conditionArgs...)
+	}
+
+	return "(" + strings.Join(clauses, joiner) + ")", args, nil
+}
+
+func supports(kind fieldKind, op string) bool {
+	for _, candidate := range operators[kind] {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSQL compares column with value ignoring case, treating * in value as a wildcard
+func matchSQL(column, value string) (string, string) {
+	if !strings.Contains(value, "*") {
+		return column + " = ? COLLATE NOCASE", value
+	}
+	return column + " LIKE ? ESCAPE '\\'", strings.ReplaceAll(escapeLike(value), "*", "%")
+}
+
+// escapeLike escapes the LIKE wildcards of a literal value
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// parseAge parses an age such as 30m, 12h, 7d or 2w
+func parseAge(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	number, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || number < 0 {
+		return 0, false
+	}
+
+	unit := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}[value[len(value)-1]]
+	if unit == 0 {
+		return 0, false
+	}
+	return time.Duration(number) * unit, true
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func reverseOp(op string) string {
+	switch op {
+	case OpLess:
+		return OpGreater
+	case OpLessEqual:
+		return OpGreaterEqual
+	case OpGreater:
+		return OpLess
+	case OpGreaterEqual:
+		return OpLessEqual
+	}
+	return op
+}
@@ -0,0 +1,328 @@
+package devicefilter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tracr/api/internal/models"
+)
+
+// MaxQueryLength bounds the size of a fleet query
+const MaxQueryLength = 2000
+
+// Query is a parsed fleet query compiled to a parameterized SQL expression
+// over the devices table aliased as d
+type Query struct {
+	Text string
+	SQL  string
+	Args []interface{}
+}
+
+// SyntaxError describes a malformed fleet query
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos+1)
+}
+
+// Parse compiles a fleet query such as
+//
+//	os_version:10.0.19* AND software:"Google Chrome" AND volume.used_percent>90 AND last_seen<7d
+//
+// Terms have the form field<op>value where op is one of : = != < <= > >=.
+// Terms are combined with AND (also implied between adjacent terms), OR and
+// NOT (or a leading -), and grouped with parentheses. Values containing
+// spaces are double quoted, with \" and \\ as escapes.
+func Parse(text string) (*Query, error) {
+	return parse(text, time.Now().UTC())
+}
+
+func parse(text string, now time.Time) (*Query, error) {
+	if len(text) > MaxQueryLength {
+		return nil, &SyntaxError{Pos: MaxQueryLength, Msg: fmt.Sprintf("query exceeds %d characters", MaxQueryLength)}
+	}
+
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, &SyntaxError{Pos: 0, Msg: "query is empty"}
+	}
+
+	p := &parser{tokens: tokens, now: now}
+	sql, args, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok.describe())}
+	}
+
+	return &Query{Text: text, SQL: sql, Args: args}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) describe() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+// tokenize splits a query into words, quoted strings, operators and parentheses
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, value: "(", pos: i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, value: ")", pos: i})
+			i++
+
+		case r == '"':
+			start := i
+			var value strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					value.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, &SyntaxError{Pos: start, Msg: "unterminated quoted value"}
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value.String(), pos: start})
+
+		case r == ':' || r == '=' || r == '<' || r == '>' || r == '!':
+			start := i
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' && r != ':' && r != '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &SyntaxError{Pos: start, Msg: `expected "!=" operator`}
+			}
+			tokens = append(tokens, token{kind: tokenOp, value: op, pos: start})
+			i += len(op)
+
+		case r == '-' && (i+1 < len(runes) && (runes[i+1] == '(' || isWordRune(runes[i+1]))) && startsTerm(tokens):
+			tokens = append(tokens, token{kind: tokenNot, value: "-", pos: i})
+			i++
+
+		default:
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			if i == start {
+				return nil, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			word := string(runes[start:i])
+			tokens = append(tokens, token{kind: keywordKind(word), value: word, pos: start})
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// startsTerm reports whether the next token begins a new term rather than a value
+func startsTerm(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	return tokens[len(tokens)-1].kind != tokenOp
+}
+
+func isWordRune(r rune) bool {
+	if unicode.IsSpace(r) {
+		return false
+	}
+	switch r {
+	case '(', ')', '"', ':', '=', '<', '>', '!':
+		return false
+	}
+	return true
+}
+
+func keywordKind(word string) tokenKind {
+	switch word {
+	case "AND":
+		return tokenAnd
+	case "OR":
+		return tokenOr
+	case "NOT":
+		return tokenNot
+	}
+	return tokenWord
+}
+
+// parser is a recursive descent parser over the grammar
+//
+//	or   = and { "OR" and }
+//	and  = not { ["AND"] not }
+//	not  = ("NOT" | "-") not | atom
+//	atom = "(" or ")" | field op value
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (string, []interface{}, error) {
+	sql, args, err := p.parseAnd()
+	if err != nil {
+		return "", nil, err
+	}
+
+	clauses := []string{sql}
+	for p.peek().kind == tokenOr {
+		p.next()
+		sql, more, err := p.parseAnd()
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, sql)
+		args = append(args, more...)
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0], args, nil
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args, nil
+}
+
+func (p *parser) parseAnd() (string, []interface{}, error) {
+	sql, args, err := p.parseNot()
+	if err != nil {
+		return "", nil, err
+	}
+
+	clauses := []string{sql}
+	for {
+		switch p.peek().kind {
+		case tokenAnd:
+			p.next()
+		case tokenWord, tokenNot, tokenLParen:
+			// Adjacent terms are implicitly combined with AND
+		default:
+			if len(clauses) == 1 {
+				return clauses[0], args, nil
+			}
+			return "(" + strings.Join(clauses, " AND ") + ")", args, nil
+		}
+
+		sql, more, err := p.parseNot()
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, sql)
+		args = append(args, more...)
+	}
+}
+
+func (p *parser) parseNot() (string, []interface{}, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		sql, args, err := p.parseNot()
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + sql, args, nil
+	}
+	return p.parseAtom()
+}
+
+func (p *parser) parseAtom() (string, []interface{}, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		sql, args, err := p.parseOr()
+		if err != nil {
+			return "", nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return "", nil, &SyntaxError{Pos: closing.pos, Msg: fmt.Sprintf("expected \")\" but found %s", closing.describe())}
+		}
+		return sql, args, nil
+
+	case tokenWord:
+		op := p.next()
+		if op.kind != tokenOp {
+			return "", nil, &SyntaxError{Pos: op.pos, Msg: fmt.Sprintf("expected an operator after %q but found %s", tok.value, op.describe())}
+		}
+
+		value := p.next()
+		if value.kind != tokenWord && value.kind != tokenString {
+			return "", nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("expected a value after %q but found %s", tok.value+op.value, value.describe())}
+		}
+
+		sql, args, err := conditionSQL(models.GroupCondition{
+			Field: tok.value,
+			Op:    op.value,
+			Value: value.value,
+		}, p.now)
+		if err != nil {
+			return "", nil, &SyntaxError{Pos: tok.pos, Msg: err.Error()}
+		}
+		return "(" + sql + ")", args, nil
+	}
+
+	return "", nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected a term but found %s", tok.describe())}
+}
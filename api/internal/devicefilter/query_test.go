@@ -0,0 +1,126 @@
+package devicefilter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// Text equality compiles to a short clause, which keeps the expected SQL
+	// readable
+	a := "(d.hostname = ? COLLATE NOCASE)"
+	b := "(d.domain = ? COLLATE NOCASE)"
+	c := "(d.model = ? COLLATE NOCASE)"
+
+	tests := []struct {
+		name string
+		text string
+		sql  string
+		args []interface{}
+	}{
+		// Precedence and grouping
+		{"single term", "hostname=a", a, []interface{}{"a"}},
+		{"explicit and", "hostname=a AND domain=b", "(" + a + " AND " + b + ")", []interface{}{"a", "b"}},
+		{"implicit and", "hostname=a domain=b", "(" + a + " AND " + b + ")", []interface{}{"a", "b"}},
+		{"and binds tighter than or", "hostname=a OR domain=b model=c", "(" + a + " OR (" + b + " AND " + c + "))", []interface{}{"a", "b", "c"}},
+		{"and before or", "hostname=a domain=b OR model=c", "((" + a + " AND " + b + ") OR " + c + ")", []interface{}{"a", "b", "c"}},
+		{"parentheses", "hostname=a AND (domain=b OR model=c)", "(" + a + " AND (" + b + " OR " + c + "))", []interface{}{"a", "b", "c"}},
+		{"nested parentheses", "((hostname=a))", a, []interface{}{"a"}},
+		{"not", "NOT hostname=a", "NOT " + a, []interface{}{"a"}},
+		{"dash negation", "-hostname=a domain=b", "(NOT " + a + " AND " + b + ")", []interface{}{"a", "b"}},
+		{"not binds tighter than and", "NOT hostname=a AND domain=b", "(NOT " + a + " AND " + b + ")", []interface{}{"a", "b"}},
+		{"negated group", "-(hostname=a OR domain=b)", "NOT (" + a + " OR " + b + ")", []interface{}{"a", "b"}},
+		{"double negation", "NOT NOT hostname=a", "NOT NOT " + a, []interface{}{"a"}},
+
+		// Quoting
+		{"quoted value", `hostname="web 01"`, a, []interface{}{"web 01"}},
+		{"escaped quote", `hostname="a \"b\""`, a, []interface{}{`a "b"`}},
+		{"escaped backslash", `hostname="a\\b"`, a, []interface{}{`a\b`}},
+		{"quoted keyword", `hostname="OR"`, a, []interface{}{"OR"}},
+		{"dash inside value", "hostname=web-01", a, []interface{}{"web-01"}},
+		{"leading dash value", "hostname=-a", a, []interface{}{"-a"}},
+
+		// Operators
+		{"wildcard match", "hostname:web*", "(d.hostname LIKE ? ESCAPE '\\')", []interface{}{"web%"}},
+		{"not equal", "hostname!=a", "(d.hostname != ? COLLATE NOCASE)", []interface{}{"a"}},
+		{"less or equal", "os_build<=19045", "(CAST(d.os_build AS INTEGER) <= ?)", []interface{}{int64(19045)}},
+		{"greater", "os_build>19045", "(CAST(d.os_build AS INTEGER) > ?)", []interface{}{int64(19045)}},
+		{"dotted field", "volume.used_percent>=90", "", []interface{}{float64(90)}},
+		{"case insensitive field", "HOSTNAME=a", a, []interface{}{"a"}},
+	}
+
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parse(tt.text, now)
+			if err != nil {
+				t.Fatalf("parse(%q) failed: %v", tt.text, err)
+			}
+			if tt.sql != "" && q.SQL != tt.sql {
+				t.Errorf("parse(%q).SQL = %s, want %s", tt.text, q.SQL, tt.sql)
+			}
+			if !reflect.DeepEqual(q.Args, tt.args) {
+				t.Errorf("parse(%q).Args = %#v, want %#v", tt.text, q.Args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	q, err := parse("last_seen<7d", now)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if want := "(d.last_seen > ?)"; q.SQL != want {
+		t.Errorf("SQL = %s, want %s", q.SQL, want)
+	}
+	if want := []interface{}{now.Add(-7 * 24 * time.Hour)}; !reflect.DeepEqual(q.Args, want) {
+		t.Errorf("Args = %v, want %v", q.Args, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		pos  int
+		msg  string
+	}{
+		{"empty", "", 0, "query is empty"},
+		{"blank", "   ", 0, "query is empty"},
+		{"unterminated quote", `hostname="web`, 9, "unterminated quoted value"},
+		{"bare bang", "hostname!a", 8, `expected "!=" operator`},
+		{"missing operator", "hostname", 8, `expected an operator after "hostname"`},
+		{"missing value", "hostname=", 9, `expected a value after "hostname="`},
+		{"unclosed parenthesis", "(hostname=a", 11, `expected ")"`},
+		{"stray parenthesis", "hostname=a)", 10, `unexpected ")"`},
+		{"dangling and", "hostname=a AND", 14, "expected a term but found end of query"},
+		{"leading or", "OR hostname=a", 0, `expected a term but found "OR"`},
+		{"unknown field", "color=red", 0, `unknown field "color"`},
+		{"unsupported operator", "last_seen=7d", 0, `operator "=" is not supported for last_seen`},
+		{"invalid number", "os_build>abc", 0, "os_build requires a whole number"},
+		{"invalid age", "last_seen<soon", 0, "last_seen requires an age"},
+		{"too long", strings.Repeat("a", MaxQueryLength+1), MaxQueryLength, "query exceeds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.text)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) error = %v, want a *SyntaxError", tt.text, err)
+			}
+			if syntaxErr.Pos != tt.pos {
+				t.Errorf("Parse(%q) error position = %d, want %d", tt.text, syntaxErr.Pos, tt.pos)
+			}
+			if !strings.Contains(syntaxErr.Msg, tt.msg) {
+				t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.text, syntaxErr.Msg, tt.msg)
+			}
+		})
+	}
+}
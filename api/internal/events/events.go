@@ -0,0 +1,112 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/models"
+)
+
+var (
+	mu      sync.Mutex
+	changed = make(chan struct{})
+)
+
+// Record appends an event to the event log. Pass the transaction making the
+// change so that the event is only visible once the change commits.
+func Record(db sqlx.Execer, eventType models.EventType, deviceID *uuid.UUID, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+
+	// Data is stored as a blob so that it scans back into json.RawMessage
+	if _, err := db.Exec(`INSERT INTO events (type, device_id, data, created_at) VALUES (?, ?, ?, ?)`,
+		eventType, deviceID, payload, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record %s event: %w", eventType, err)
+	}
+
+	notify()
+	return nil
+}
+
+// Changed returns a channel that is closed when the next event is recorded.
+// Events recorded in a transaction may not be visible yet when it fires, so
+// consumers should also poll.
+func Changed() <-chan struct{} {
+	mu.Lock()
+	defer mu.Unlock()
+	return changed
+}
+
+func notify() {
+	mu.Lock()
+	defer mu.Unlock()
+	close(changed)
+	changed = make(chan struct{})
+}
+
+// After returns up to limit events with an ID greater than afterID, oldest first
+func After(db sqlx.Queryer, afterID int64, limit int) ([]models.Event, error) {
+	var events []models.Event
+	err := sqlx.Select(db, &events, `SELECT * FROM events WHERE id > ? ORDER BY id ASC LIMIT ?`, afterID, limit)
+	return events, err
+}
+
+// LatestID returns the ID of the most recent event, or zero when there is none
+func LatestID(db sqlx.Queryer) (int64, error) {
+	var id int64
+	err := sqlx.Get(db, &id, `SELECT COALESCE(MAX(id), 0) FROM events`)
+	return id, err
+}
+
+// Cursor returns the position stored for a consumer. Consumers without a
+// stored position start after the latest event, so they only see new events.
+func Cursor(db *sqlx.DB, name string) (int64, error) {
+	var id int64
+	err := db.Get(&id, `SELECT event_id FROM event_cursors WHERE name = ?`, name)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if id, err = LatestID(db); err != nil {
+		return 0, err
+	}
+	return id, SaveCursor(db, name, id)
+}
+
+// SaveCursor stores the position of a consumer
+func SaveCursor(db sqlx.Execer, name string, eventID int64) error {
+	_, err := db.Exec(`
+		INSERT INTO event_cursors (name, event_id) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET event_id = excluded.event_id`, name, eventID)
+	return err
+}
+
+// StartRetention periodically removes events older than the configured retention
+func StartRetention(db *sqlx.DB, cfg *config.Config) {
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for range ticker.C {
+			cutoff := time.Now().Add(-cfg.EventRetention).UTC()
+			result, err := db.Exec(`DELETE FROM events WHERE created_at < ?`, cutoff)
+			if err != nil {
+				log.Printf("[ERROR] Event retention failed: %v", err)
+				continue
+			}
+			if deleted, _ := result.RowsAffected(); deleted > 0 {
+				log.Printf("[INFO] Event retention removed %d events", deleted)
+			}
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+)
+
+// Filter selects the events sent to a stream. Empty fields match everything.
+// Audit events are only included for admins.
+type Filter struct {
+	DeviceID      *uuid.UUID
+	Types         map[models.EventType]bool
+	IncludeAudits bool
+}
+
+// Matches reports whether an event passes the filter
+func (f Filter) Matches(event models.Event) bool {
+	if event.Type == models.EventAuditCreated && !f.IncludeAudits {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[event.Type] {
+		return false
+	}
+	if f.DeviceID != nil && (event.DeviceID == nil || *event.DeviceID != *f.DeviceID) {
+		return false
+	}
+	return true
+}
+
+// WriteSSE writes an event as a Server-Sent Events message. The event ID is
+// the message ID, so clients resume from it with the Last-Event-ID header.
+func WriteSSE(w *bufio.Writer, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
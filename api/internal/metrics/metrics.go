@@ -0,0 +1,444 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/models"
+)
+
+// Tier describes a resolution at which metric points are stored
+type Tier struct {
+	Name    string
+	Seconds int64
+}
+
+// Tiers lists the storage resolutions from finest to coarsest. Every sample
+// is aggregated into each tier when recorded.
+var Tiers = []Tier{
+	{Name: "raw", Seconds: 1},
+	{Name: "5m", Seconds: 5 * 60},
+	{Name: "1h", Seconds: 60 * 60},
+	{Name: "1d", Seconds: 24 * 60 * 60},
+}
+
+// maxPoints caps the number of buckets a single query may return per series
+const maxPoints = 10000
+
+// autoPoints is the target number of buckets when no step is requested
+const autoPoints = 500
+
+// ErrTooManyPoints is returned when the requested step would produce too many buckets
+var ErrTooManyPoints = errors.New("step is too small for the requested range")
+
+// ValidMetrics lists the metric names accepted by the history endpoint
+var ValidMetrics = map[string]bool{
+	models.MetricCPUPercent:      true,
+	models.MetricMemoryUsedBytes: true,
+	models.MetricVolumeFreeBytes: true,
+}
+
+// Retention returns how long points of the given tier are kept
+func Retention(cfg *config.Config, tier Tier) time.Duration {
+	switch tier.Name {
+	case "raw":
+		return cfg.MetricsRawRetention
+	case "5m":
+		return cfg.Metrics5mRetention
+	case "1h":
+		return cfg.Metrics1hRetention
+	default:
+		return cfg.Metrics1dRetention
+	}
+}
+
+// InventorySamples extracts the metric samples contained in an inventory submission
+func InventorySamples(inventory *models.InventorySubmission) []models.MetricSample {
+	timestamp := inventory.CollectedAt.UTC()
+
+	samples := []models.MetricSample{
+		{Metric: models.MetricCPUPercent, Timestamp: timestamp, Value: inventory.Performance.CPUPercent},
+		{Metric: models.MetricMemoryUsedBytes, Timestamp: timestamp, Value: float64(inventory.Performance.MemoryUsedBytes)},
+	}
+
+	for _, volume := range inventory.Volumes {
+		samples = append(samples, models.MetricSample{
+			Metric:    models.MetricVolumeFreeBytes,
+			Label:     volume.Name,
+			Timestamp: timestamp,
+			Value:     float64(volume.FreeBytes),
+		})
+	}
+
+	return samples
+}
+
+// Record aggregates samples into every resolution tier for a device
+func Record(tx *sqlx.Tx, deviceID uuid.UUID, samples []models.MetricSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Preparex(`
+		INSERT INTO metric_points (
+			device_id, metric, label, resolution, bucket_start,
+			sample_count, value_min, value_max, value_sum
+		) VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?)
+		ON CONFLICT (device_id, metric, label, resolution, bucket_start) DO UPDATE SET
+			sample_count = sample_count + excluded.sample_count,
+			value_min = MIN(value_min, excluded.value_min),
+			value_max = MAX(value_max, excluded.value_max),
+			value_sum = value_sum + excluded.value_sum`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare metric insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, sample := range samples {
+		unix := sample.Timestamp.Unix()
+		for _, tier := range Tiers {
+			bucket := unix - unix%tier.Seconds
+			if _, err := stmt.Exec(deviceID, sample.Metric, sample.Label, tier.Name, bucket,
+				sample.Value, sample.Value, sample.Value); err != nil {
+				return fmt.Errorf("failed to record %s sample: %w", sample.Metric, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordNew records the samples of a device that were not recorded before and
+// returns how many were. Agents upload samples again when an upload fails,
+// even if the API stored them before the response was lost, so each sample is
+// only counted once. Keys are kept as long as raw points.
+func RecordNew(tx *sqlx.Tx, deviceID uuid.UUID, samples []models.MetricSample) (int, error) {
+	stmt, err := tx.Preparex(`INSERT OR IGNORE INTO metric_sample_keys (device_id, metric, label, ts) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare sample key insert: %w", err)
+	}
+	defer stmt.Close()
+
+	recorded := make([]models.MetricSample, 0, len(samples))
+	for _, sample := range samples {
+		result, err := stmt.Exec(deviceID, sample.Metric, sample.Label, sample.Timestamp.Unix())
+		if err != nil {
+			return 0, fmt.Errorf("failed to store %s sample key: %w", sample.Metric, err)
+		}
+		if inserted, _ := result.RowsAffected(); inserted > 0 {
+			recorded = append(recorded, sample)
+		}
+	}
+
+	return len(recorded), Record(tx, deviceID, recorded)
+}
+
+// SelectTier picks the tier to read for a query. Tiers whose retention no
+// longer covers the start of the range are skipped. With an explicit step the
+// coarsest remaining tier that evenly divides it is used, or else the finest
+// remaining tier with the step rounded up to whole buckets of it. Otherwise
+// the finest remaining tier that yields a bounded number of points wins.
+func SelectTier(cfg *config.Config, from, to time.Time, step time.Duration) (Tier, int64) {
+	if step > 0 {
+		stepSeconds := int64(step / time.Second)
+		for i := len(Tiers) - 1; i >= 0; i-- {
+			tier := Tiers[i]
+			if covers(cfg, tier, from) && tier.Seconds <= stepSeconds && stepSeconds%tier.Seconds == 0 {
+				return tier, stepSeconds
+			}
+		}
+
+		tier := finestCovering(cfg, from)
+		if remainder := stepSeconds % tier.Seconds; remainder != 0 {
+			stepSeconds += tier.Seconds - remainder
+		}
+		return tier, stepSeconds
+	}
+
+	span := int64(to.Sub(from) / time.Second)
+	for _, tier := range Tiers {
+		if !covers(cfg, tier, from) {
+			continue
+		}
+		if span/tier.Seconds <= autoPoints {
+			return tier, tier.Seconds
+		}
+	}
+
+	coarsest := Tiers[len(Tiers)-1]
+	return coarsest, coarsest.Seconds
+}
+
+// covers reports whether points of a tier are still kept at the given time
+func covers(cfg *config.Config, tier Tier, from time.Time) bool {
+	return !from.Before(time.Now().Add(-Retention(cfg, tier)))
+}
+
+// finestCovering returns the finest tier whose points are still kept at the
+// given time, or the coarsest tier when none are
+func finestCovering(cfg *config.Config, from time.Time) Tier {
+	for _, tier := range Tiers {
+		if covers(cfg, tier, from) {
+			return tier
+		}
+	}
+	return Tiers[len(Tiers)-1]
+}
+
+// Query returns the history of a metric for a device, bucketed by step
+func Query(db *sqlx.DB, cfg *config.Config, deviceID uuid.UUID, metric string, from, to time.Time, step time.Duration) (*models.MetricQueryResult, error) {
+	tier, stepSeconds := SelectTier(cfg, from, to, step)
+	if int64(to.Sub(from)/time.Second)/stepSeconds > maxPoints {
+		return nil, ErrTooManyPoints
+	}
+
+	var rows []struct {
+		Label       string  `db:"label"`
+		BucketStart int64   `db:"bucket"`
+		Count       int64   `db:"sample_count"`
+		Min         float64 `db:"value_min"`
+		Max         float64 `db:"value_max"`
+		Sum         float64 `db:"value_sum"`
+	}
+	query := `
+		SELECT
+			label,
+			bucket_start - (bucket_start % ?) AS bucket,
+			SUM(sample_count) AS sample_count,
+			MIN(value_min) AS value_min,
+			MAX(value_max) AS value_max,
+			SUM(value_sum) AS value_sum
+		FROM metric_points
+		WHERE device_id = ? AND metric = ? AND resolution = ?
+		  AND bucket_start >= ? AND bucket_start < ?
+		GROUP BY label, bucket
+		ORDER BY label, bucket`
+
+	if err := db.Select(&rows, query, stepSeconds, deviceID, metric, tier.Name, from.Unix(), to.Unix()); err != nil {
+		return nil, err
+	}
+
+	result := &models.MetricQueryResult{
+		DeviceID:    deviceID,
+		Metric:      metric,
+		From:        from,
+		To:          to,
+		StepSeconds: stepSeconds,
+		Resolution:  tier.Name,
+		Series:      []models.MetricSeries{},
+	}
+
+	for _, row := range rows {
+		if len(result.Series) == 0 || result.Series[len(result.Series)-1].Label != row.Label {
+			result.Series = append(result.Series, models.MetricSeries{Label: row.Label, Points: []models.MetricPoint{}})
+		}
+		series := &result.Series[len(result.Series)-1]
+
+		point := models.MetricPoint{
+			Timestamp: time.Unix(row.BucketStart, 0).UTC(),
+			Count:     row.Count,
+			Min:       row.Min,
+			Max:       row.Max,
+		}
+		if row.Count > 0 {
+			point.Avg = row.Sum / float64(row.Count)
+		}
+		series.Points = append(series.Points, point)
+	}
+
+	return result, nil
+}
+
+// ApplyRetention deletes points older than the retention configured for their tier
+// along with expired batch summaries
+func ApplyRetention(db *sqlx.DB, cfg *config.Config) (int64, error) {
+	var total int64
+	for _, tier := range Tiers {
+		cutoff := time.Now().Add(-Retention(cfg, tier)).Unix()
+		result, err := db.Exec(`DELETE FROM metric_points WHERE resolution = ? AND bucket_start < ?`, tier.Name, cutoff)
+		if err != nil {
+			return total, fmt.Errorf("failed to apply %s retention: %w", tier.Name, err)
+		}
+		deleted, _ := result.RowsAffected()
+		total += deleted
+	}
+
+	// Batch summaries cover upload windows of a few minutes, keep them as long as 5m points
+	cutoff := time.Now().Add(-cfg.Metrics5mRetention).UTC()
+	result, err := db.Exec(`DELETE FROM metric_batch_summaries WHERE started_at < ?`, cutoff)
+	if err != nil {
+		return total, fmt.Errorf("failed to apply batch summary retention: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	total += deleted
+
+	// Sample keys only need to outlive retried uploads, keep them as long as raw points
+	result, err = db.Exec(`DELETE FROM metric_sample_keys WHERE ts < ?`,
+		time.Now().Add(-cfg.MetricsRawRetention).Unix())
+	if err != nil {
+		return total, fmt.Errorf("failed to apply sample key retention: %w", err)
+	}
+	deleted, _ = result.RowsAffected()
+	total += deleted
+
+	return total, nil
+}
+
+// StartRetention periodically removes expired metric points
+func StartRetention(db *sqlx.DB, cfg *config.Config) {
+	ticker := time.NewTicker(10 * time.Minute)
+	go func() {
+		for range ticker.C {
+			deleted, err := ApplyRetention(db, cfg)
+			if err != nil {
+				log.Printf("[ERROR] Metric retention failed: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("[INFO] Metric retention removed %d points", deleted)
+			}
+		}
+	}()
+}
+
+// BatchMetrics lists the metrics summarized for agent performance batches
+var BatchMetrics = []string{models.MetricCPUPercent, models.MetricMemoryUsedBytes}
+
+// BatchSamples extracts the metric samples contained in a performance batch
+func BatchSamples(batch *models.PerformanceBatchSubmission) []models.MetricSample {
+	samples := make([]models.MetricSample, 0, len(batch.Samples)*len(BatchMetrics))
+	for _, sample := range batch.Samples {
+		timestamp := sample.Timestamp.UTC()
+		samples = append(samples,
+			models.MetricSample{Metric: models.MetricCPUPercent, Timestamp: timestamp, Value: sample.CPUPercent},
+			models.MetricSample{Metric: models.MetricMemoryUsedBytes, Timestamp: timestamp, Value: float64(sample.MemoryUsedBytes)},
+		)
+	}
+	return samples
+}
+
+// Summarize computes min, average, max and 95th percentile (nearest rank) of values
+func Summarize(values []float64) models.MetricSummary {
+	if len(values) == 0 {
+		return models.MetricSummary{}
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, value := range sorted {
+		sum += value
+	}
+
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return models.MetricSummary{
+		Count: int64(len(sorted)),
+		Min:   sorted[0],
+		Avg:   sum / float64(len(sorted)),
+		Max:   sorted[len(sorted)-1],
+		P95:   sorted[rank],
+	}
+}
+
+// BatchSummaries builds the stored summaries of a performance batch. Summaries
+// reported by the agent are kept as-is, missing ones are computed from the samples.
+func BatchSummaries(deviceID uuid.UUID, batch *models.PerformanceBatchSubmission) []models.MetricBatchSummary {
+	values := make(map[string][]float64)
+	for _, sample := range batch.Samples {
+		values[models.MetricCPUPercent] = append(values[models.MetricCPUPercent], sample.CPUPercent)
+		values[models.MetricMemoryUsedBytes] = append(values[models.MetricMemoryUsedBytes], float64(sample.MemoryUsedBytes))
+	}
+
+	now := time.Now().UTC()
+	summaries := make([]models.MetricBatchSummary, 0, len(BatchMetrics))
+	for _, metric := range BatchMetrics {
+		summary, ok := batch.Summaries[metric]
+		if !ok || summary.Count == 0 {
+			summary = Summarize(values[metric])
+		}
+
+		summaries = append(summaries, models.MetricBatchSummary{
+			ID:          uuid.New(),
+			DeviceID:    deviceID,
+			Metric:      metric,
+			StartedAt:   batch.StartedAt.UTC(),
+			EndedAt:     batch.EndedAt.UTC(),
+			SampleCount: summary.Count,
+			Min:         summary.Min,
+			Avg:         summary.Avg,
+			Max:         summary.Max,
+			P95:         summary.P95,
+			CreatedAt:   now,
+		})
+	}
+
+	return summaries
+}
+
+// CreateBatchSummaries stores the summaries of an uploaded performance batch.
+// A batch retried by the agent starts with the same sample and replaces the
+// summaries stored for it, whose IDs are kept.
+func CreateBatchSummaries(tx *sqlx.Tx, summaries []models.MetricBatchSummary) error {
+	query := `
+		INSERT INTO metric_batch_summaries (
+			id, device_id, metric, started_at, ended_at, sample_count,
+			value_min, value_avg, value_max, value_p95, created_at
+		) VALUES (
+			:id, :device_id, :metric, :started_at, :ended_at, :sample_count,
+			:value_min, :value_avg, :value_max, :value_p95, :created_at
+		)
+		ON CONFLICT (device_id, metric, started_at) DO UPDATE SET
+			ended_at = excluded.ended_at,
+			sample_count = excluded.sample_count,
+			value_min = excluded.value_min,
+			value_avg = excluded.value_avg,
+			value_max = excluded.value_max,
+			value_p95 = excluded.value_p95`
+
+	for i := range summaries {
+		summary := &summaries[i]
+		if _, err := tx.NamedExec(query, summary); err != nil {
+			return fmt.Errorf("failed to store %s summary: %w", summary.Metric, err)
+		}
+		if err := tx.Get(&summary.ID, `
+			SELECT id FROM metric_batch_summaries
+			WHERE device_id = ? AND metric = ? AND started_at = ?`,
+			summary.DeviceID, summary.Metric, summary.StartedAt); err != nil {
+			return fmt.Errorf("failed to read back %s summary: %w", summary.Metric, err)
+		}
+	}
+	return nil
+}
+
+// ListBatchSummaries returns the batch summaries of a device, newest first
+func ListBatchSummaries(db *sqlx.DB, deviceID uuid.UUID, metric string, offset, limit int) ([]models.MetricBatchSummary, error) {
+	summaries := []models.MetricBatchSummary{}
+	query := `
+		SELECT * FROM metric_batch_summaries
+		WHERE device_id = ? AND (? = '' OR metric = ?)
+		ORDER BY started_at DESC, metric
+		LIMIT ? OFFSET ?`
+	err := db.Select(&summaries, query, deviceID, metric, metric, limit, offset)
+	return summaries, err
+}
+
+// CountBatchSummaries returns the number of batch summaries of a device
+func CountBatchSummaries(db *sqlx.DB, deviceID uuid.UUID, metric string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM metric_batch_summaries WHERE device_id = ? AND (? = '' OR metric = ?)`
+	err := db.Get(&count, query, deviceID, metric, metric)
+	return count, err
+}
@@ -0,0 +1,411 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/models"
+)
+
+const schema = `
+CREATE TABLE metric_points (
+	device_id TEXT NOT NULL,
+	metric TEXT NOT NULL,
+	label TEXT NOT NULL DEFAULT '',
+	resolution TEXT NOT NULL,
+	bucket_start INTEGER NOT NULL,
+	sample_count INTEGER NOT NULL,
+	value_min REAL NOT NULL,
+	value_max REAL NOT NULL,
+	value_sum REAL NOT NULL,
+	PRIMARY KEY (device_id, metric, label, resolution, bucket_start)
+);
+
+CREATE TABLE metric_batch_summaries (
+	id TEXT PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	metric TEXT NOT NULL,
+	started_at DATETIME NOT NULL,
+	ended_at DATETIME NOT NULL,
+	sample_count INTEGER NOT NULL,
+	value_min REAL NOT NULL,
+	value_avg REAL NOT NULL,
+	value_max REAL NOT NULL,
+	value_p95 REAL NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_metric_batch_summaries_window ON metric_batch_summaries(device_id, metric, started_at);
+
+CREATE TABLE metric_sample_keys (
+	device_id TEXT NOT NULL,
+	metric TEXT NOT NULL,
+	label TEXT NOT NULL DEFAULT '',
+	ts INTEGER NOT NULL,
+	PRIMARY KEY (device_id, metric, label, ts)
+);`
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	db.MustExec(schema)
+	return db
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		MetricsRawRetention: 48 * time.Hour,
+		Metrics5mRetention:  14 * 24 * time.Hour,
+		Metrics1hRetention:  90 * 24 * time.Hour,
+		Metrics1dRetention:  2 * 365 * 24 * time.Hour,
+	}
+}
+
+func record(t *testing.T, db *sqlx.DB, deviceID uuid.UUID, samples []models.MetricSample) {
+	t.Helper()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := Record(tx, deviceID, samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := openTestDB(t)
+
+	device := uuid.New()
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	record(t, db, device, []models.MetricSample{
+		{Metric: models.MetricCPUPercent, Timestamp: at("2024-01-31T12:07:42Z"), Value: 10},
+		{Metric: models.MetricCPUPercent, Timestamp: at("2024-01-31T12:09:59Z"), Value: 30},
+		{Metric: models.MetricCPUPercent, Timestamp: at("2024-01-31T13:02:05Z"), Value: 20},
+	})
+	// Samples recorded later are merged into the existing buckets
+	record(t, db, device, []models.MetricSample{
+		{Metric: models.MetricCPUPercent, Timestamp: at("2024-01-31T12:07:42Z"), Value: 50},
+	})
+
+	type bucket struct {
+		start    string
+		count    int64
+		min, max float64
+		sum      float64
+	}
+	tests := []struct {
+		resolution string
+		want       []bucket
+	}{
+		{"raw", []bucket{
+			{"2024-01-31T12:07:42Z", 2, 10, 50, 60},
+			{"2024-01-31T12:09:59Z", 1, 30, 30, 30},
+			{"2024-01-31T13:02:05Z", 1, 20, 20, 20},
+		}},
+		{"5m", []bucket{
+			{"2024-01-31T12:05:00Z", 3, 10, 50, 90},
+			{"2024-01-31T13:00:00Z", 1, 20, 20, 20},
+		}},
+		{"1h", []bucket{
+			{"2024-01-31T12:00:00Z", 3, 10, 50, 90},
+			{"2024-01-31T13:00:00Z", 1, 20, 20, 20},
+		}},
+		{"1d", []bucket{
+			{"2024-01-31T00:00:00Z", 4, 10, 50, 110},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolution, func(t *testing.T) {
+			var rows []struct {
+				BucketStart int64   `db:"bucket_start"`
+				Count       int64   `db:"sample_count"`
+				Min         float64 `db:"value_min"`
+				Max         float64 `db:"value_max"`
+				Sum         float64 `db:"value_sum"`
+			}
+			if err := db.Select(&rows, `
+				SELECT bucket_start, sample_count, value_min, value_max, value_sum FROM metric_points
+				WHERE device_id = ? AND resolution = ? ORDER BY bucket_start`, device, tt.resolution); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != len(tt.want) {
+				t.Fatalf("buckets = %+v, want %d", rows, len(tt.want))
+			}
+			for i, want := range tt.want {
+				row := rows[i]
+				if start := time.Unix(row.BucketStart, 0).UTC(); !start.Equal(at(want.start)) {
+					t.Errorf("bucket %d starts at %v, want %s", i, start, want.start)
+				}
+				if row.Count != want.count || row.Min != want.min || row.Max != want.max || row.Sum != want.sum {
+					t.Errorf("bucket %d = %+v, want %+v", i, row, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordNew(t *testing.T) {
+	db := openTestDB(t)
+
+	device := uuid.New()
+	start := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	samples := []models.MetricSample{
+		{Metric: models.MetricCPUPercent, Timestamp: start, Value: 10},
+		{Metric: models.MetricCPUPercent, Timestamp: start.Add(time.Minute), Value: 20},
+		{Metric: models.MetricVolumeFreeBytes, Label: "C:", Timestamp: start, Value: 100},
+	}
+
+	recordNew := func(samples []models.MetricSample) int {
+		t.Helper()
+
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		recorded, err := RecordNew(tx, device, samples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		return recorded
+	}
+
+	if recorded := recordNew(samples); recorded != 3 {
+		t.Errorf("first upload recorded %d samples, want 3", recorded)
+	}
+	// A retried upload overlapping the first only records the new sample
+	retried := append(samples[1:], models.MetricSample{Metric: models.MetricCPUPercent, Timestamp: start.Add(2 * time.Minute), Value: 30})
+	if recorded := recordNew(retried); recorded != 1 {
+		t.Errorf("retried upload recorded %d samples, want 1", recorded)
+	}
+
+	var count int64
+	if err := db.Get(&count, `
+		SELECT sample_count FROM metric_points
+		WHERE device_id = ? AND metric = ? AND resolution = '1h'`, device, models.MetricCPUPercent); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("hourly cpu_percent count = %d, want 3", count)
+	}
+}
+
+func TestSelectTier(t *testing.T) {
+	cfg := testConfig()
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		ago      time.Duration
+		step     time.Duration
+		wantTier string
+		wantStep int64
+	}{
+		{"short range", 5 * time.Minute, 0, "raw", 1},
+		{"hour range", time.Hour, 0, "5m", 300},
+		{"past raw retention", 49 * time.Hour, 0, "1h", 3600},
+		{"past 5m retention", 30 * 24 * time.Hour, 0, "1d", 86400},
+		{"past 1h retention", 200 * 24 * time.Hour, 0, "1d", 86400},
+		{"step within raw retention", 47 * time.Hour, time.Minute, "raw", 60},
+		{"step past raw retention", 49 * time.Hour, time.Minute, "5m", 300},
+		{"step divisible by 5m", time.Hour, 10 * time.Minute, "5m", 600},
+		{"step divisible by 1h", 30 * 24 * time.Hour, 2 * time.Hour, "1h", 7200},
+		{"step not divisible", time.Hour, 90 * time.Second, "raw", 90},
+		{"step rounded up to 5m", 72 * time.Hour, 7 * time.Minute, "5m", 600},
+		{"step rounded up to 1d", 200 * 24 * time.Hour, 2 * time.Hour, "1d", 86400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier, step := SelectTier(cfg, now.Add(-tt.ago), now, tt.step)
+			if tier.Name != tt.wantTier || step != tt.wantStep {
+				t.Errorf("SelectTier = %s/%d, want %s/%d", tier.Name, step, tt.wantTier, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := openTestDB(t)
+	cfg := testConfig()
+
+	device := uuid.New()
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	// One sample per minute for half an hour, valued by the minute
+	var samples []models.MetricSample
+	for i := 0; i < 30; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		samples = append(samples,
+			models.MetricSample{Metric: models.MetricCPUPercent, Timestamp: timestamp, Value: float64(i)},
+			models.MetricSample{Metric: models.MetricVolumeFreeBytes, Label: "C:", Timestamp: timestamp, Value: 100},
+			models.MetricSample{Metric: models.MetricVolumeFreeBytes, Label: "D:", Timestamp: timestamp, Value: 200},
+		)
+	}
+	record(t, db, device, samples)
+	record(t, db, uuid.New(), samples)
+
+	tests := []struct {
+		name           string
+		metric         string
+		step           time.Duration
+		wantResolution string
+		wantLabels     []string
+		wantPoints     []models.MetricPoint
+	}{
+		{
+			name:           "explicit step",
+			metric:         models.MetricCPUPercent,
+			step:           10 * time.Minute,
+			wantResolution: "5m",
+			wantLabels:     []string{""},
+			wantPoints: []models.MetricPoint{
+				{Timestamp: start, Count: 10, Min: 0, Max: 9, Avg: 4.5},
+				{Timestamp: start.Add(10 * time.Minute), Count: 10, Min: 10, Max: 19, Avg: 14.5},
+				{Timestamp: start.Add(20 * time.Minute), Count: 10, Min: 20, Max: 29, Avg: 24.5},
+			},
+		},
+		{
+			name:           "automatic step",
+			metric:         models.MetricCPUPercent,
+			wantResolution: "5m",
+			wantLabels:     []string{""},
+			wantPoints: []models.MetricPoint{
+				{Timestamp: start, Count: 5, Min: 0, Max: 4, Avg: 2},
+				{Timestamp: start.Add(5 * time.Minute), Count: 5, Min: 5, Max: 9, Avg: 7},
+				{Timestamp: start.Add(10 * time.Minute), Count: 5, Min: 10, Max: 14, Avg: 12},
+				{Timestamp: start.Add(15 * time.Minute), Count: 5, Min: 15, Max: 19, Avg: 17},
+				{Timestamp: start.Add(20 * time.Minute), Count: 5, Min: 20, Max: 24, Avg: 22},
+				{Timestamp: start.Add(25 * time.Minute), Count: 5, Min: 25, Max: 29, Avg: 27},
+			},
+		},
+		{
+			name:           "labelled series",
+			metric:         models.MetricVolumeFreeBytes,
+			step:           30 * time.Minute,
+			wantResolution: "5m",
+			wantLabels:     []string{"C:", "D:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Query(db, cfg, device, tt.metric, start, start.Add(30*time.Minute), tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Resolution != tt.wantResolution {
+				t.Errorf("resolution = %s, want %s", result.Resolution, tt.wantResolution)
+			}
+
+			if len(result.Series) != len(tt.wantLabels) {
+				t.Fatalf("series = %+v, want labels %v", result.Series, tt.wantLabels)
+			}
+			for i, label := range tt.wantLabels {
+				if result.Series[i].Label != label {
+					t.Errorf("series %d label = %q, want %q", i, result.Series[i].Label, label)
+				}
+			}
+
+			if tt.wantPoints == nil {
+				return
+			}
+			points := result.Series[0].Points
+			if len(points) != len(tt.wantPoints) {
+				t.Fatalf("points = %+v, want %d", points, len(tt.wantPoints))
+			}
+			for i, want := range tt.wantPoints {
+				if !points[i].Timestamp.Equal(want.Timestamp) || points[i].Count != want.Count ||
+					points[i].Min != want.Min || points[i].Max != want.Max || points[i].Avg != want.Avg {
+					t.Errorf("point %d = %+v, want %+v", i, points[i], want)
+				}
+			}
+		})
+	}
+
+	if _, err := Query(db, cfg, device, models.MetricCPUPercent, start, start.Add(3*time.Hour), time.Second); err != ErrTooManyPoints {
+		t.Errorf("Query with a 1s step over 3h = %v, want ErrTooManyPoints", err)
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	db := openTestDB(t)
+	cfg := testConfig()
+
+	device := uuid.New()
+	now := time.Now()
+	for _, tier := range Tiers {
+		retention := Retention(cfg, tier)
+		for _, age := range []time.Duration{retention - time.Hour, retention + time.Hour} {
+			db.MustExec(`
+				INSERT INTO metric_points (device_id, metric, label, resolution, bucket_start, sample_count, value_min, value_max, value_sum)
+				VALUES (?, ?, '', ?, ?, 1, 0, 0, 0)`, device, models.MetricCPUPercent, tier.Name, now.Add(-age).Unix())
+		}
+	}
+
+	for _, age := range []time.Duration{cfg.Metrics5mRetention - time.Hour, cfg.Metrics5mRetention + time.Hour} {
+		startedAt := now.Add(-age).UTC()
+		db.MustExec(`
+			INSERT INTO metric_batch_summaries (id, device_id, metric, started_at, ended_at, sample_count, value_min, value_avg, value_max, value_p95)
+			VALUES (?, ?, ?, ?, ?, 1, 0, 0, 0, 0)`, uuid.New(), device, models.MetricCPUPercent, startedAt, startedAt.Add(5*time.Minute))
+	}
+	for _, age := range []time.Duration{cfg.MetricsRawRetention - time.Hour, cfg.MetricsRawRetention + time.Hour} {
+		db.MustExec(`INSERT INTO metric_sample_keys (device_id, metric, ts) VALUES (?, ?, ?)`,
+			device, models.MetricCPUPercent, now.Add(-age).Unix())
+	}
+
+	deleted, err := ApplyRetention(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(Tiers) + 2); deleted != want {
+		t.Errorf("deleted = %d, want %d", deleted, want)
+	}
+
+	for _, tier := range Tiers {
+		var oldest int64
+		if err := db.Get(&oldest, `SELECT MIN(bucket_start) FROM metric_points WHERE resolution = ?`, tier.Name); err != nil {
+			t.Fatal(err)
+		}
+		if cutoff := now.Add(-Retention(cfg, tier)).Unix(); oldest < cutoff {
+			t.Errorf("%s kept a point at %d, before the cut-off %d", tier.Name, oldest, cutoff)
+		}
+	}
+
+	for table, want := range map[string]int{"metric_batch_summaries": 1, "metric_sample_keys": 1} {
+		var count int
+		if err := db.Get(&count, `SELECT COUNT(*) FROM `+table); err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("%s has %d rows, want %d", table, count, want)
+		}
+	}
+}
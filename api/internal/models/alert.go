@@ -0,0 +1,141 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AlertRuleType string
+
+const (
+	AlertDiskFreeBelow     AlertRuleType = "disk_free_below"
+	AlertCPUHigh           AlertRuleType = "cpu_high"
+	AlertMemoryHigh        AlertRuleType = "memory_high"
+	AlertDeviceOffline     AlertRuleType = "device_offline"
+	AlertAgentVersionBelow AlertRuleType = "agent_version_below"
+)
+
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo     AlertSeverity = "info"
+	AlertSeverityWarning  AlertSeverity = "warning"
+	AlertSeverityCritical AlertSeverity = "critical"
+)
+
+// AlertState is the lifecycle state of an alert. Alerts start firing, may be
+// acknowledged by a user and are resolved once their condition clears.
+type AlertState string
+
+const (
+	AlertStateFiring       AlertState = "firing"
+	AlertStateAcknowledged AlertState = "acknowledged"
+	AlertStateResolved     AlertState = "resolved"
+)
+
+// AlertParams holds the parameters of an alert rule. Which fields apply
+// depends on the rule type, for example {"threshold_percent": 10, "volume": "C:"}
+// for disk_free_below or {"threshold_percent": 90, "duration_minutes": 15}
+// for cpu_high.
+type AlertParams struct {
+	ThresholdPercent *float64 `json:"threshold_percent,omitempty"`
+	Volume           string   `json:"volume,omitempty"`
+	DurationMinutes  *int     `json:"duration_minutes,omitempty"`
+	OfflineHours     *float64 `json:"offline_hours,omitempty"`
+	MinVersion       string   `json:"min_version,omitempty"`
+}
+
+// Value stores params as JSON
+func (p AlertParams) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads params stored as JSON
+func (p *AlertParams) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), p)
+	case []byte:
+		return json.Unmarshal(value, p)
+	}
+	return fmt.Errorf("cannot scan %T into AlertParams", src)
+}
+
+// AlertRule represents a threshold checked against the latest readings of every device
+type AlertRule struct {
+	ID          uuid.UUID     `json:"id" db:"id"`
+	Name        string        `json:"name" db:"name"`
+	Description string        `json:"description" db:"description"`
+	RuleType    AlertRuleType `json:"rule_type" db:"rule_type"`
+	Params      AlertParams   `json:"params" db:"params"`
+	Severity    AlertSeverity `json:"severity" db:"severity"`
+	Enabled     bool          `json:"enabled" db:"enabled"`
+	CreatedAt   time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at" db:"updated_at"`
+
+	// Computed fields
+	OpenAlerts int `json:"open_alerts" db:"open_alerts"`
+}
+
+// AlertRuleRequest represents an alert rule create or update request
+type AlertRuleRequest struct {
+	Name        *string        `json:"name" validate:"omitempty,min=1,max=100"`
+	Description *string        `json:"description" validate:"omitempty,max=500"`
+	RuleType    *AlertRuleType `json:"rule_type"`
+	Params      *AlertParams   `json:"params"`
+	Severity    *AlertSeverity `json:"severity"`
+	Enabled     *bool          `json:"enabled"`
+}
+
+// Alert records a breach of an alert rule by a device. Subject names the
+// volume for disk rules and is empty otherwise. Occurrences counts the
+// evaluations that found the condition holding while the alert was open.
+type Alert struct {
+	ID                  uuid.UUID     `json:"id" db:"id"`
+	RuleID              uuid.UUID     `json:"rule_id" db:"rule_id"`
+	DeviceID            uuid.UUID     `json:"device_id" db:"device_id"`
+	Subject             string        `json:"subject" db:"subject"`
+	DedupKey            string        `json:"dedup_key" db:"dedup_key"`
+	State               AlertState    `json:"state" db:"state"`
+	Severity            AlertSeverity `json:"severity" db:"severity"`
+	Message             string        `json:"message" db:"message"`
+	Value               *float64      `json:"value" db:"value"`
+	Occurrences         int           `json:"occurrences" db:"occurrences"`
+	FiredAt             time.Time     `json:"fired_at" db:"fired_at"`
+	LastFiredAt         time.Time     `json:"last_fired_at" db:"last_fired_at"`
+	AcknowledgedAt      *time.Time    `json:"acknowledged_at" db:"acknowledged_at"`
+	AcknowledgedBy      *uuid.UUID    `json:"acknowledged_by" db:"acknowledged_by"`
+	AcknowledgementNote string        `json:"acknowledgement_note" db:"acknowledgement_note"`
+	ResolvedAt          *time.Time    `json:"resolved_at" db:"resolved_at"`
+}
+
+// AlertListItem represents an alert in list views with joined data
+type AlertListItem struct {
+	Alert
+	RuleName               string        `json:"rule_name" db:"rule_name"`
+	RuleType               AlertRuleType `json:"rule_type" db:"rule_type"`
+	Hostname               string        `json:"hostname" db:"hostname"`
+	AcknowledgedByUsername *string       `json:"acknowledged_by_username" db:"acknowledged_by_username"`
+}
+
+// AlertAcknowledgeRequest represents the optional body of an acknowledgement
+type AlertAcknowledgeRequest struct {
+	Note string `json:"note" validate:"max=500"`
+}
+
+// AlertEvaluationResult reports the outcome of evaluating alert rules
+type AlertEvaluationResult struct {
+	Rules    int `json:"rules"`
+	Devices  int `json:"devices"`
+	Fired    int `json:"fired"`
+	Updated  int `json:"updated"`
+	Resolved int `json:"resolved"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AvailabilityInterval is a period during which a device kept checking in.
+// Check-ins that follow each other within the offline threshold extend the
+// same interval, so the history stays compact.
+type AvailabilityInterval struct {
+	ID        int64     `json:"-" db:"id"`
+	DeviceID  uuid.UUID `json:"-" db:"device_id"`
+	StartedAt time.Time `json:"started_at" db:"started_at"`
+	EndedAt   time.Time `json:"ended_at" db:"ended_at"`
+	Checkins  int       `json:"checkins" db:"checkins"`
+	Ongoing   bool      `json:"ongoing" db:"-"` // The device is still checking in
+}
+
+// DeviceReboot records a device reporting a later boot time than before
+type DeviceReboot struct {
+	ID               int64      `json:"-" db:"id"`
+	DeviceID         uuid.UUID  `json:"device_id" db:"device_id"`
+	BootTime         time.Time  `json:"boot_time" db:"boot_time"`
+	PreviousBootTime *time.Time `json:"previous_boot_time" db:"previous_boot_time"`
+	DetectedAt       time.Time  `json:"detected_at" db:"detected_at"`
+}
+
+// DeviceAvailability reports when a device was online within a time range.
+// Only the part of the range after the device was first seen is observed.
+type DeviceAvailability struct {
+	DeviceID        uuid.UUID              `json:"device_id"`
+	From            time.Time              `json:"from"`
+	To              time.Time              `json:"to"`
+	ObservedSeconds int64                  `json:"observed_seconds"`
+	OnlineSeconds   int64                  `json:"online_seconds"`
+	UptimePercent   *float64               `json:"uptime_percent"` // nil when nothing was observed
+	Intervals       []AvailabilityInterval `json:"intervals"`
+	Reboots         []DeviceReboot         `json:"reboots"`
+}
+
+// DeviceAvailabilitySummary is a device's entry in the fleet availability report
+type DeviceAvailabilitySummary struct {
+	DeviceID        uuid.UUID    `json:"device_id"`
+	Hostname        string       `json:"hostname"`
+	Status          DeviceStatus `json:"status"`
+	LastSeen        time.Time    `json:"last_seen"`
+	ObservedSeconds int64        `json:"observed_seconds"`
+	OnlineSeconds   int64        `json:"online_seconds"`
+	UptimePercent   *float64     `json:"uptime_percent"`
+	Reboots         int          `json:"reboots"`
+}
+
+// FleetAvailability ranks devices by availability within a time range, least
+// available first
+type FleetAvailability struct {
+	From             time.Time                   `json:"from"`
+	To               time.Time                   `json:"to"`
+	DevicesEvaluated int                         `json:"devices_evaluated"`
+	UptimePercent    *float64                    `json:"uptime_percent"`
+	Devices          []DeviceAvailabilitySummary `json:"devices"`
+	GeneratedAt      time.Time                   `json:"generated_at"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChannelMessageType identifies a message of the agent channel
+type ChannelMessageType string
+
+const (
+	// Sent by the API
+	ChannelCommand ChannelMessageType = "command" // a command leased to the agent
+	ChannelConfig  ChannelMessageType = "config"  // the agent configuration set for the device
+	ChannelError   ChannelMessageType = "error"   // a message from the agent was rejected
+
+	// Sent by the agent
+	ChannelHeartbeat ChannelMessageType = "heartbeat" // same body as a heartbeat request
+	ChannelProgress  ChannelMessageType = "progress"  // progress of a running command
+	ChannelAck       ChannelMessageType = "ack"       // result of a command
+)
+
+// ChannelMessage is a message of the agent channel, a WebSocket carrying one
+// JSON message per frame
+type ChannelMessage struct {
+	Type ChannelMessageType `json:"type"`
+	Data json.RawMessage    `json:"data,omitempty"`
+}
+
+// CommandProgress reports the progress of a running command
+type CommandProgress struct {
+	CommandID uuid.UUID `json:"command_id" validate:"required"`
+	Message   string    `json:"message" validate:"max=500"`
+	Percent   *int      `json:"percent,omitempty" validate:"omitempty,min=0,max=100"`
+}
+
+// CommandAck reports the result of a command over the agent channel
+type CommandAck struct {
+	CommandID uuid.UUID `json:"command_id" validate:"required"`
+	CommandResult
+}
+
+// AgentConfig holds agent settings managed from the console. Durations are
+// written like 15m or 30s. Settings left out keep the agent's own value.
+type AgentConfig struct {
+	CollectionInterval   *string `json:"collection_interval,omitempty"`
+	SampleInterval       *string `json:"sample_interval,omitempty"`
+	SampleUploadInterval *string `json:"sample_upload_interval,omitempty"`
+	HeartbeatInterval    *string `json:"heartbeat_interval,omitempty"`
+	CommandPollInterval  *string `json:"command_poll_interval,omitempty"`
+	CommandWait          *string `json:"command_wait,omitempty"`
+	LogLevel             *string `json:"log_level,omitempty" validate:"omitempty,oneof=DEBUG INFO WARN ERROR"`
+}
+
+// Value stores the config as JSON
+func (a AgentConfig) Value() (driver.Value, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads a config stored as JSON
+func (a *AgentConfig) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), a)
+	case []byte:
+		return json.Unmarshal(value, a)
+	}
+	return fmt.Errorf("cannot scan %T into AgentConfig", src)
+}
+
+// DeviceAgentConfig is the agent configuration set for a device
+type DeviceAgentConfig struct {
+	DeviceID  uuid.UUID   `json:"device_id" db:"device_id"`
+	Config    AgentConfig `json:"config" db:"config"`
+	UpdatedAt *time.Time  `json:"updated_at" db:"updated_at"`
+	UpdatedBy string      `json:"updated_by" db:"updated_by"`
+
+	// Computed fields
+	Connected bool `json:"connected" db:"-"`
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,12 +34,149 @@ type Command struct {
 	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
 	ExecutedAt  *time.Time      `json:"executed_at" db:"executed_at"`
 	Result      json.RawMessage `json:"result" db:"result"`
+	// TimeoutSeconds is how long the agent allows the command to run
+	TimeoutSeconds int `json:"timeout_seconds" db:"timeout_seconds"`
+
+	// Delivery: each poll that hands the command to the agent is an attempt,
+	// leased until the lease expires
+	Attempts       int        `json:"attempts" db:"attempts"`
+	MaxAttempts    int        `json:"max_attempts" db:"max_attempts"`
+	NotBefore      *time.Time `json:"not_before" db:"not_before"`
+	ExpiresAt      *time.Time `json:"expires_at" db:"expires_at"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at" db:"lease_expires_at"`
+
+	// BatchID is the batch the command was sent with, if any
+	BatchID *uuid.UUID `json:"batch_id,omitempty" db:"batch_id"`
+}
+
+// IsFinal reports whether the command has reached a status it never leaves
+func (c *Command) IsFinal() bool {
+	switch c.Status {
+	case CommandStatusCompleted, CommandStatusFailed, CommandStatusExpired:
+		return true
+	}
+	return false
+}
+
+// CommandStatusChange is an entry of a command's status history
+type CommandStatusChange struct {
+	ID             int64          `json:"id" db:"id"`
+	CommandID      uuid.UUID      `json:"command_id" db:"command_id"`
+	PreviousStatus *CommandStatus `json:"previous_status" db:"previous_status"`
+	Status         CommandStatus  `json:"status" db:"status"`
+	Reason         string         `json:"reason" db:"reason"`
+	Attempt        int            `json:"attempt" db:"attempt"`
+	ChangedAt      time.Time      `json:"changed_at" db:"changed_at"`
+}
+
+// CommandDetail is a command with its status history
+type CommandDetail struct {
+	Command
+	History []CommandStatusChange `json:"history"`
 }
 
 // CommandRequest represents a request to create a new command
 type CommandRequest struct {
 	CommandType CommandType     `json:"command_type" validate:"required"`
 	Payload     json.RawMessage `json:"payload"`
+	// TimeoutSeconds overrides the default timeout of the command type
+	TimeoutSeconds *int `json:"timeout_seconds,omitempty" validate:"omitempty,min=1,max=86400"`
+	// TTLSeconds is how long the command can be delivered once available,
+	// overriding the configured default
+	TTLSeconds *int `json:"ttl_seconds,omitempty" validate:"omitempty,min=60,max=2592000"`
+	// NotBefore holds the command back until the given time
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	MaxAttempts *int       `json:"max_attempts,omitempty" validate:"omitempty,min=1,max=10"`
+}
+
+// CommandBatch sends a command to a set of devices as one command per device
+type CommandBatch struct {
+	ID          uuid.UUID          `json:"id" db:"id"`
+	CommandType CommandType        `json:"command_type" db:"command_type"`
+	Payload     json.RawMessage    `json:"payload" db:"payload"`
+	Target      CommandBatchTarget `json:"target" db:"target"`
+	CreatedBy   string             `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" db:"created_at"`
+
+	// Computed fields
+	Counts CommandBatchCounts `json:"counts" db:"-"`
+}
+
+// CommandBatchTarget records how the devices of a batch were selected
+type CommandBatchTarget struct {
+	DeviceIDs []uuid.UUID `json:"device_ids,omitempty"`
+	GroupID   *uuid.UUID  `json:"group_id,omitempty"`
+	Query     string      `json:"q,omitempty"`
+}
+
+// Value stores the target as JSON
+func (t CommandBatchTarget) Value() (driver.Value, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads a target stored as JSON
+func (t *CommandBatchTarget) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), t)
+	case []byte:
+		return json.Unmarshal(value, t)
+	}
+	return fmt.Errorf("cannot scan %T into CommandBatchTarget", src)
+}
+
+// CommandBatchCounts counts the commands of a batch by status
+type CommandBatchCounts struct {
+	Total      int `json:"total"`
+	Queued     int `json:"queued"`
+	InProgress int `json:"in_progress"`
+	Completed  int `json:"completed"`
+	Failed     int `json:"failed"`
+	Expired    int `json:"expired"`
+}
+
+// Add counts n commands with a status
+func (c *CommandBatchCounts) Add(status CommandStatus, n int) {
+	c.Total += n
+	switch status {
+	case CommandStatusQueued:
+		c.Queued += n
+	case CommandStatusInProgress:
+		c.InProgress += n
+	case CommandStatusCompleted:
+		c.Completed += n
+	case CommandStatusFailed:
+		c.Failed += n
+	case CommandStatusExpired:
+		c.Expired += n
+	}
+}
+
+// CommandBatchCommand is a command of a batch with the device it was sent to
+type CommandBatchCommand struct {
+	Command
+	Hostname string `json:"hostname" db:"hostname"`
+}
+
+// CommandBatchDetail is a batch with the command sent to each device
+type CommandBatchDetail struct {
+	CommandBatch
+	Commands []CommandBatchCommand `json:"commands"`
+}
+
+// CommandBatchRequest sends a command to the devices listed in device_ids, or
+// to the devices of a group, matching a fleet query, or both
+type CommandBatchRequest struct {
+	CommandRequest
+	DeviceIDs []uuid.UUID `json:"device_ids,omitempty" validate:"max=10000"`
+	// Group is the ID or name of a device group
+	Group  string `json:"group,omitempty" validate:"max=255"`
+	Query  string `json:"q,omitempty" validate:"max=2000"`
+	DryRun bool   `json:"dry_run"`
 }
 
 // CommandResult represents the result of command execution
@@ -16,22 +16,43 @@ const (
 )
 
 type Device struct {
-	ID               uuid.UUID    `json:"id" db:"id"`
-	Hostname         string       `json:"hostname" db:"hostname" validate:"required,min=1,max=255"`
-	Domain           string       `json:"domain" db:"domain"`
-	Manufacturer     string       `json:"manufacturer" db:"manufacturer"`
-	Model            string       `json:"model" db:"model"`
-	SerialNumber     string       `json:"serial_number" db:"serial_number"`
-	OSCaption        string       `json:"os_caption" db:"os_caption"`
-	OSVersion        string       `json:"os_version" db:"os_version"`
-	OSBuild          string       `json:"os_build" db:"os_build"`
-	FirstSeen        time.Time    `json:"first_seen" db:"first_seen"`
-	LastSeen         time.Time    `json:"last_seen" db:"last_seen"`
-	DeviceTokenHash  string       `json:"-" db:"device_token_hash"` // Never expose token hash
-	TokenCreatedAt   time.Time    `json:"token_created_at" db:"token_created_at"`
-	Status           DeviceStatus `json:"status" db:"status"`
-	CreatedAt        time.Time    `json:"created_at" db:"created_at"`
-	UpdatedAt        time.Time    `json:"updated_at" db:"updated_at"`
+	ID              uuid.UUID    `json:"id" db:"id"`
+	Hostname        string       `json:"hostname" db:"hostname" validate:"required,min=1,max=255"`
+	Domain          string       `json:"domain" db:"domain"`
+	Manufacturer    string       `json:"manufacturer" db:"manufacturer"`
+	Model           string       `json:"model" db:"model"`
+	SerialNumber    string       `json:"serial_number" db:"serial_number"`
+	OSCaption       string       `json:"os_caption" db:"os_caption"`
+	OSVersion       string       `json:"os_version" db:"os_version"`
+	OSBuild         string       `json:"os_build" db:"os_build"`
+	FirstSeen       time.Time    `json:"first_seen" db:"first_seen"`
+	LastSeen        time.Time    `json:"last_seen" db:"last_seen"`
+	DeviceTokenHash string       `json:"-" db:"device_token_hash"` // Never expose token hash
+	TokenCreatedAt  time.Time    `json:"token_created_at" db:"token_created_at"`
+	Status          DeviceStatus `json:"status" db:"status"`
+	StatusReason    string       `json:"status_reason" db:"status_reason"`
+	StatusChangedAt *time.Time   `json:"status_changed_at" db:"status_changed_at"`
+	AgentError      string       `json:"agent_error" db:"agent_error"` // Errors reported with the latest heartbeat
+	CreatedAt       time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// DeviceStatusChange records a device moving from one status to another
+type DeviceStatusChange struct {
+	ID             int64         `json:"id" db:"id"`
+	DeviceID       uuid.UUID     `json:"device_id" db:"device_id"`
+	PreviousStatus *DeviceStatus `json:"previous_status" db:"previous_status"`
+	Status         DeviceStatus  `json:"status" db:"status"`
+	Reason         string        `json:"reason" db:"reason"`
+	ChangedAt      time.Time     `json:"changed_at" db:"changed_at"`
+}
+
+// HeartbeatRequest is the optional body of an agent heartbeat. Errors lists
+// the problems the agent ran into since its previous heartbeat, a heartbeat
+// without errors clears the previous ones.
+type HeartbeatRequest struct {
+	Timestamp *time.Time `json:"timestamp"`
+	Errors    []string   `json:"errors" validate:"max=20,dive,max=500"`
 }
 
 // DeviceListItem represents a device in list views (with computed fields)
@@ -40,6 +61,22 @@ type DeviceListItem struct {
 	LatestSnapshot *SnapshotSummary `json:"latest_snapshot,omitempty"`
 	IsOnline       bool             `json:"is_online"`
 	UptimeHours    int              `json:"uptime_hours,omitempty"`
+	SoftwareCount  int              `json:"software_count"`
+	Volumes        []Volume         `json:"volumes,omitempty"`
+	Tags           []string         `json:"tags"`
+}
+
+// DeviceCurrentState represents the latest known inventory readings of a device
+type DeviceCurrentState struct {
+	DeviceID         uuid.UUID  `json:"device_id" db:"device_id"`
+	SnapshotID       uuid.UUID  `json:"snapshot_id" db:"snapshot_id"`
+	CollectedAt      time.Time  `json:"collected_at" db:"collected_at"`
+	CPUPercent       *float64   `json:"cpu_percent" db:"cpu_percent"`
+	MemoryUsedBytes  *int64     `json:"memory_used_bytes" db:"memory_used_bytes"`
+	MemoryTotalBytes *int64     `json:"memory_total_bytes" db:"memory_total_bytes"`
+	BootTime         *time.Time `json:"boot_time" db:"boot_time"`
+	SoftwareCount    int        `json:"software_count" db:"software_count"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 // DeviceRegistration represents the payload for device registration
@@ -53,4 +90,32 @@ type DeviceRegistration struct {
 type DeviceRegistrationResponse struct {
 	DeviceID    uuid.UUID `json:"device_id"`
 	DeviceToken string    `json:"device_token"`
-}
\ No newline at end of file
+}
+
+// DeviceBulkAction identifies an action applied to every device matching a fleet query
+type DeviceBulkAction string
+
+const (
+	DeviceBulkAddTag          DeviceBulkAction = "add_tag"
+	DeviceBulkRemoveTag       DeviceBulkAction = "remove_tag"
+	DeviceBulkAddToGroup      DeviceBulkAction = "add_to_group"
+	DeviceBulkRemoveFromGroup DeviceBulkAction = "remove_from_group"
+)
+
+// DeviceBulkRequest applies an action to the devices matching a fleet query
+type DeviceBulkRequest struct {
+	Query   string           `json:"q" validate:"required,max=2000"`
+	Action  DeviceBulkAction `json:"action" validate:"required,oneof=add_tag remove_tag add_to_group remove_from_group"`
+	Tag     string           `json:"tag,omitempty" validate:"max=64"`
+	GroupID *uuid.UUID       `json:"group_id,omitempty"`
+	DryRun  bool             `json:"dry_run"`
+}
+
+// DeviceBulkResult reports the outcome of a bulk action
+type DeviceBulkResult struct {
+	Action  DeviceBulkAction `json:"action"`
+	Query   string           `json:"q"`
+	Matched int              `json:"matched"`
+	Changed int              `json:"changed"`
+	DryRun  bool             `json:"dry_run"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EventDeviceRegistered  EventType = "device.registered"
+	EventDeviceOffline     EventType = "device.offline"
+	EventDeviceStatus      EventType = "device.status_changed"
+	EventDeviceRebooted    EventType = "device.rebooted"
+	EventSnapshotCreated   EventType = "snapshot.created"
+	EventSoftwareInstalled EventType = "software.installed"
+	EventSoftwareRemoved   EventType = "software.removed"
+	EventCommandCompleted  EventType = "command.completed"
+	EventCommandFailed     EventType = "command.failed"
+	EventCommandExpired    EventType = "command.expired"
+	EventCommandProgress   EventType = "command.progress"
+	EventAlertFired        EventType = "alert.fired"
+	EventAlertResolved     EventType = "alert.resolved"
+	EventAuditCreated      EventType = "audit.created"
+	EventWebhookPing       EventType = "webhook.ping"
+)
+
+// EventTypes lists the event types that can be subscribed to
+var EventTypes = []EventType{
+	EventDeviceRegistered,
+	EventDeviceOffline,
+	EventDeviceStatus,
+	EventDeviceRebooted,
+	EventSnapshotCreated,
+	EventSoftwareInstalled,
+	EventSoftwareRemoved,
+	EventCommandCompleted,
+	EventCommandFailed,
+	EventCommandExpired,
+	EventCommandProgress,
+	EventAlertFired,
+	EventAlertResolved,
+	EventAuditCreated,
+}
+
+// Event is an entry of the event log. IDs increase monotonically, so
+// consumers track their position by the last ID they processed.
+type Event struct {
+	ID        int64           `json:"id" db:"id"`
+	Type      EventType       `json:"type" db:"type"`
+	DeviceID  *uuid.UUID      `json:"device_id" db:"device_id"`
+	Data      json.RawMessage `json:"data" db:"data"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// EventTypeList is a list of event types stored as JSON
+type EventTypeList []EventType
+
+// Value stores the list as JSON
+func (l EventTypeList) Value() (driver.Value, error) {
+	if l == nil {
+		l = EventTypeList{}
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads a list stored as JSON
+func (l *EventTypeList) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), l)
+	case []byte:
+		return json.Unmarshal(value, l)
+	}
+	return fmt.Errorf("cannot scan %T into EventTypeList", src)
+}
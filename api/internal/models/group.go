@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeviceGroupKind string
+
+const (
+	DeviceGroupStatic DeviceGroupKind = "static"
+	DeviceGroupSmart  DeviceGroupKind = "smart"
+)
+
+const (
+	GroupMatchAll = "all"
+	GroupMatchAny = "any"
+)
+
+// GroupCondition compares one inventory attribute of a device with a value,
+// for example {"field": "os_build", "op": "<", "value": "19045"}
+type GroupCondition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// GroupCriteria defines the membership of a smart group. Devices match when
+// all (or any) of the conditions hold.
+type GroupCriteria struct {
+	Match      string           `json:"match"`
+	Conditions []GroupCondition `json:"conditions"`
+}
+
+// Value stores criteria as JSON
+func (c GroupCriteria) Value() (driver.Value, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads criteria stored as JSON
+func (c *GroupCriteria) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), c)
+	case []byte:
+		return json.Unmarshal(value, c)
+	}
+	return fmt.Errorf("cannot scan %T into GroupCriteria", src)
+}
+
+// DeviceGroup represents a named group of devices. Static groups are managed
+// by hand, smart groups are computed from their criteria.
+type DeviceGroup struct {
+	ID          uuid.UUID       `json:"id" db:"id"`
+	Name        string          `json:"name" db:"name"`
+	Description string          `json:"description" db:"description"`
+	Kind        DeviceGroupKind `json:"kind" db:"kind"`
+	Criteria    *GroupCriteria  `json:"criteria,omitempty" db:"criteria"`
+	MemberCount int             `json:"member_count" db:"member_count"`
+	EvaluatedAt *time.Time      `json:"evaluated_at,omitempty" db:"evaluated_at"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// DeviceGroupRequest represents a device group create or update request
+type DeviceGroupRequest struct {
+	Name        *string          `json:"name" validate:"omitempty,min=1,max=100"`
+	Description *string          `json:"description" validate:"omitempty,max=500"`
+	Kind        *DeviceGroupKind `json:"kind"`
+	Criteria    *GroupCriteria   `json:"criteria"`
+}
+
+type GroupMembershipChange string
+
+const (
+	GroupMembershipJoined GroupMembershipChange = "joined"
+	GroupMembershipLeft   GroupMembershipChange = "left"
+)
+
+// GroupMembershipEvent records a device entering or leaving a group
+type GroupMembershipEvent struct {
+	ID        uuid.UUID             `json:"id" db:"id"`
+	GroupID   uuid.UUID             `json:"group_id" db:"group_id"`
+	DeviceID  uuid.UUID             `json:"device_id" db:"device_id"`
+	Change    GroupMembershipChange `json:"change" db:"change"`
+	Source    string                `json:"source" db:"source"`
+	ChangedAt time.Time             `json:"changed_at" db:"changed_at"`
+}
+
+// GroupMembershipEventListItem represents a membership event in list views with joined data
+type GroupMembershipEventListItem struct {
+	GroupMembershipEvent
+	GroupName string `json:"group_name" db:"group_name"`
+	Hostname  string `json:"hostname" db:"hostname"`
+}
+
+// DeviceMembershipRequest lists the devices to add to or remove from a group or tag
+type DeviceMembershipRequest struct {
+	DeviceIDs []uuid.UUID `json:"device_ids" validate:"required,min=1,max=1000"`
+}
+
+// DeviceMembershipResult reports the outcome of a bulk membership change
+type DeviceMembershipResult struct {
+	Requested int         `json:"requested"`
+	Changed   int         `json:"changed"`
+	NotFound  []uuid.UUID `json:"not_found"`
+}
+
+// DeviceTagsRequest replaces the tags of a device
+type DeviceTagsRequest struct {
+	Tags []string `json:"tags" validate:"max=50,dive,min=1,max=64"`
+}
+
+// TagSummary represents a tag and the number of devices carrying it
+type TagSummary struct {
+	Tag         string `json:"tag" db:"tag"`
+	DeviceCount int    `json:"device_count" db:"device_count"`
+}
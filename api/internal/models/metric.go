@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Metric names recorded in the metrics store
+const (
+	MetricCPUPercent      = "cpu_percent"
+	MetricMemoryUsedBytes = "memory_used_bytes"
+	MetricVolumeFreeBytes = "volume_free_bytes"
+)
+
+// MetricSample represents a single metric reading taken at a point in time
+type MetricSample struct {
+	Metric    string    `json:"metric"`
+	Label     string    `json:"label,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// MetricPoint represents an aggregated metric bucket
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int64     `json:"count"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+}
+
+// MetricSeries represents the points of one labelled series
+type MetricSeries struct {
+	Label  string        `json:"label"`
+	Points []MetricPoint `json:"points"`
+}
+
+// MetricQueryResult represents the response of a metric history query
+type MetricQueryResult struct {
+	DeviceID    uuid.UUID      `json:"device_id"`
+	Metric      string         `json:"metric"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	StepSeconds int64          `json:"step_seconds"`
+	Resolution  string         `json:"resolution"`
+	Series      []MetricSeries `json:"series"`
+}
+
+// PerformanceSample represents one CPU and memory reading taken by the agent between inventory uploads
+type PerformanceSample struct {
+	Timestamp        time.Time `json:"timestamp" validate:"required"`
+	CPUPercent       float64   `json:"cpu_percent" validate:"min=0,max=100"`
+	MemoryUsedBytes  uint64    `json:"memory_used_bytes"`
+	MemoryTotalBytes uint64    `json:"memory_total_bytes"`
+}
+
+// MetricSummary describes the distribution of one metric across a batch of samples
+type MetricSummary struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+	P95   float64 `json:"p95"`
+}
+
+// PerformanceBatchSubmission represents a batch of performance samples uploaded by an agent
+type PerformanceBatchSubmission struct {
+	StartedAt       time.Time                `json:"started_at" validate:"required"`
+	EndedAt         time.Time                `json:"ended_at" validate:"required"`
+	IntervalSeconds int                      `json:"interval_seconds" validate:"min=0"`
+	Samples         []PerformanceSample      `json:"samples" validate:"required,min=1,max=10000,dive"`
+	Summaries       map[string]MetricSummary `json:"summaries"`
+}
+
+// MetricBatchSummary represents the stored summary of one metric for an uploaded batch
+type MetricBatchSummary struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	DeviceID    uuid.UUID `json:"device_id" db:"device_id"`
+	Metric      string    `json:"metric" db:"metric"`
+	StartedAt   time.Time `json:"started_at" db:"started_at"`
+	EndedAt     time.Time `json:"ended_at" db:"ended_at"`
+	SampleCount int64     `json:"sample_count" db:"sample_count"`
+	Min         float64   `json:"min" db:"value_min"`
+	Avg         float64   `json:"avg" db:"value_avg"`
+	Max         float64   `json:"max" db:"value_max"`
+	P95         float64   `json:"p95" db:"value_p95"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
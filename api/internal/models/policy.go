@@ -0,0 +1,141 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PolicyRuleType string
+
+// volume_exists only checks the name and filesystem that inventory reports
+// for each volume. Inventory does not include the encryption state, so a
+// "BitLocker-style disk must exist" rule is a heuristic: it cannot tell an
+// encrypted volume from a plain one with the same name and filesystem.
+const (
+	PolicyVolumeExists       PolicyRuleType = "volume_exists"
+	PolicyVolumeFreeSpace    PolicyRuleType = "volume_free_space"
+	PolicyMinOSBuild         PolicyRuleType = "min_os_build"
+	PolicySoftwareRequired   PolicyRuleType = "software_required"
+	PolicySoftwareProhibited PolicyRuleType = "software_prohibited"
+)
+
+type PolicySeverity string
+
+const (
+	PolicySeverityLow      PolicySeverity = "low"
+	PolicySeverityMedium   PolicySeverity = "medium"
+	PolicySeverityHigh     PolicySeverity = "high"
+	PolicySeverityCritical PolicySeverity = "critical"
+)
+
+// PolicyParams holds the parameters of a policy rule. Which fields apply
+// depends on the rule type, for example {"volume": "C:", "filesystem": "NTFS"}
+// for volume_exists or {"software": "Google Chrome", "min_version": "120"}
+// for software_required.
+type PolicyParams struct {
+	Volume         string   `json:"volume,omitempty"`
+	FileSystem     string   `json:"filesystem,omitempty"`
+	MinFreePercent *float64 `json:"min_free_percent,omitempty"`
+	MinBuild       *int     `json:"min_build,omitempty"`
+	Software       string   `json:"software,omitempty"`
+	MinVersion     string   `json:"min_version,omitempty"`
+}
+
+// Value stores params as JSON
+func (p PolicyParams) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan reads params stored as JSON
+func (p *PolicyParams) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), p)
+	case []byte:
+		return json.Unmarshal(value, p)
+	}
+	return fmt.Errorf("cannot scan %T into PolicyParams", src)
+}
+
+// Policy represents a compliance rule evaluated against the current inventory of every device
+type Policy struct {
+	ID          uuid.UUID      `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	RuleType    PolicyRuleType `json:"rule_type" db:"rule_type"`
+	Params      PolicyParams   `json:"params" db:"params"`
+	Severity    PolicySeverity `json:"severity" db:"severity"`
+	Enabled     bool           `json:"enabled" db:"enabled"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+
+	// Computed fields
+	Passing           int      `json:"passing" db:"passing"`
+	Failing           int      `json:"failing" db:"failing"`
+	CompliancePercent *float64 `json:"compliance_percent"`
+}
+
+// PolicyRequest represents a policy create or update request
+type PolicyRequest struct {
+	Name        *string         `json:"name" validate:"omitempty,min=1,max=100"`
+	Description *string         `json:"description" validate:"omitempty,max=500"`
+	RuleType    *PolicyRuleType `json:"rule_type"`
+	Params      *PolicyParams   `json:"params"`
+	Severity    *PolicySeverity `json:"severity"`
+	Enabled     *bool           `json:"enabled"`
+}
+
+// PolicyResult records whether a device passed a policy at its latest evaluation
+type PolicyResult struct {
+	PolicyID    uuid.UUID  `json:"policy_id" db:"policy_id"`
+	DeviceID    uuid.UUID  `json:"device_id" db:"device_id"`
+	SnapshotID  *uuid.UUID `json:"snapshot_id" db:"snapshot_id"`
+	Passed      bool       `json:"passed" db:"passed"`
+	Detail      string     `json:"detail" db:"detail"`
+	EvaluatedAt time.Time  `json:"evaluated_at" db:"evaluated_at"`
+	ChangedAt   time.Time  `json:"changed_at" db:"changed_at"`
+}
+
+// PolicyResultListItem represents a policy result in list views with joined data
+type PolicyResultListItem struct {
+	PolicyResult
+	PolicyName string         `json:"policy_name" db:"policy_name"`
+	Severity   PolicySeverity `json:"severity" db:"severity"`
+	Hostname   string         `json:"hostname" db:"hostname"`
+}
+
+// DeviceCompliance summarizes the policy results of one device
+type DeviceCompliance struct {
+	DeviceID  uuid.UUID              `json:"device_id"`
+	Hostname  string                 `json:"hostname"`
+	Compliant bool                   `json:"compliant"`
+	Passing   int                    `json:"passing"`
+	Failing   int                    `json:"failing"`
+	Results   []PolicyResultListItem `json:"results"`
+}
+
+// FleetCompliance summarizes policy results across the fleet. A device is
+// compliant when it passes every enabled policy it was evaluated against.
+type FleetCompliance struct {
+	DevicesEvaluated  int       `json:"devices_evaluated"`
+	CompliantDevices  int       `json:"compliant_devices"`
+	CompliancePercent *float64  `json:"compliance_percent"`
+	Policies          []Policy  `json:"policies"`
+	GeneratedAt       time.Time `json:"generated_at"`
+}
+
+// PolicyEvaluationResult reports the outcome of re-evaluating policies
+type PolicyEvaluationResult struct {
+	Policies int `json:"policies"`
+	Devices  int `json:"devices"`
+	Passed   int `json:"passed"`
+	Failed   int `json:"failed"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SearchEntityType identifies the kind of record a search result points to
+type SearchEntityType string
+
+const (
+	SearchEntityDevice   SearchEntityType = "device"
+	SearchEntitySoftware SearchEntityType = "software"
+	SearchEntityAuditLog SearchEntityType = "audit_log"
+)
+
+// SearchResult represents a single full-text search hit
+type SearchResult struct {
+	Type        SearchEntityType `json:"type"`
+	ID          string           `json:"id"`
+	Title       string           `json:"title"`
+	Subtitle    string           `json:"subtitle,omitempty"`
+	Snippet     string           `json:"snippet,omitempty"`
+	Score       float64          `json:"score"`
+	DeviceID    *uuid.UUID       `json:"device_id,omitempty"`
+	DeviceCount int              `json:"device_count,omitempty"`
+	Timestamp   *time.Time       `json:"timestamp,omitempty"`
+}
+
+// SearchGroup holds the best ranked hits of one entity type. Scores only
+// compare results within the same group.
+type SearchGroup struct {
+	Type    SearchEntityType `json:"type"`
+	Total   int              `json:"total"`
+	Results []SearchResult   `json:"results"`
+}
+
+// SearchResponse represents the result of a search across all entity types,
+// with groups in the order of the requested types
+type SearchResponse struct {
+	Query  string        `json:"query"`
+	Groups []SearchGroup `json:"groups"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,6 +21,19 @@ type Snapshot struct {
 	LastInteractiveUser  string    `json:"last_interactive_user" db:"last_interactive_user"`
 	CreatedAt            time.Time `json:"created_at" db:"created_at"`
 
+	// Identity, OS and hardware attributes as recorded at collection time
+	Hostname     string `json:"-" db:"hostname"`
+	Domain       string `json:"-" db:"domain"`
+	OSCaption    string `json:"-" db:"os_caption"`
+	OSVersion    string `json:"-" db:"os_version"`
+	OSBuild      string `json:"-" db:"os_build"`
+	Manufacturer string `json:"-" db:"manufacturer"`
+	Model        string `json:"-" db:"model"`
+	SerialNumber string `json:"-" db:"serial_number"`
+
+	// Content-addressed software set referenced by this snapshot
+	SoftwareSetHash *string `json:"-" db:"software_set_hash"`
+
 	// Related data (loaded separately)
 	Identity    *Identity  `json:"identity,omitempty"`
 	OS          *OS        `json:"os,omitempty"`
@@ -39,6 +53,22 @@ type SnapshotSummary struct {
 	BootTime    *time.Time `json:"boot_time" db:"boot_time"`
 }
 
+// SnapshotCheckin records the volatile readings of an inventory submission
+// whose stable inventory matched the device's latest snapshot
+type SnapshotCheckin struct {
+	ID                  uuid.UUID       `json:"id" db:"id"`
+	SnapshotID          uuid.UUID       `json:"snapshot_id" db:"snapshot_id"`
+	DeviceID            uuid.UUID       `json:"device_id" db:"device_id"`
+	CollectedAt         time.Time       `json:"collected_at" db:"collected_at"`
+	CPUPercent          *float64        `json:"cpu_percent" db:"cpu_percent"`
+	MemoryUsedBytes     *int64          `json:"memory_used_bytes" db:"memory_used_bytes"`
+	MemoryTotalBytes    *int64          `json:"memory_total_bytes" db:"memory_total_bytes"`
+	BootTime            *time.Time      `json:"boot_time" db:"boot_time"`
+	LastInteractiveUser string          `json:"last_interactive_user" db:"last_interactive_user"`
+	VolumeFreeBytes     json.RawMessage `json:"volume_free_bytes" db:"volume_free_bytes"`
+	CreatedAt           time.Time       `json:"created_at" db:"created_at"`
+}
+
 // InventorySubmission represents the complete payload submitted by agents
 type InventorySubmission struct {
 	Identity    Identity    `json:"identity" validate:"required"`
@@ -106,6 +136,10 @@ type Software struct {
 	InstallDate *time.Time `json:"install_date" db:"install_date"`
 	SizeKB      *int64     `json:"size_kb" db:"size_kb" validate:"omitempty,min=0"`
 	CreatedAt   time.Time  `json:"created_at,omitempty" db:"created_at"`
+
+	// Name and publisher as reported by the agent, before normalization
+	RawName      string `json:"raw_name,omitempty" db:"raw_name"`
+	RawPublisher string `json:"raw_publisher,omitempty" db:"raw_publisher"`
 }
 
 // SoftwareCatalogItem represents aggregated software across all devices
@@ -115,4 +149,45 @@ type SoftwareCatalogItem struct {
 	Publisher   string `json:"publisher" db:"publisher"`
 	DeviceCount int    `json:"device_count" db:"device_count"`
 	LatestSeen  time.Time `json:"latest_seen" db:"latest_seen"`
+}
+
+// SnapshotDiff represents the changes between two snapshots of the same device
+type SnapshotDiff struct {
+	DeviceID        uuid.UUID        `json:"device_id"`
+	FromSnapshotID  uuid.UUID        `json:"from_snapshot_id"`
+	ToSnapshotID    uuid.UUID        `json:"to_snapshot_id"`
+	FromCollectedAt time.Time        `json:"from_collected_at"`
+	ToCollectedAt   time.Time        `json:"to_collected_at"`
+	SoftwareAdded   []Software       `json:"software_added"`
+	SoftwareRemoved []Software       `json:"software_removed"`
+	SoftwareChanged []SoftwareChange `json:"software_changed"`
+	VolumesAdded    []Volume         `json:"volumes_added"`
+	VolumesRemoved  []Volume         `json:"volumes_removed"`
+	VolumesChanged  []VolumeChange   `json:"volumes_changed"`
+	FieldChanges    []FieldChange    `json:"field_changes"`
+}
+
+// SoftwareChange represents a software title whose version differs between snapshots
+type SoftwareChange struct {
+	Name        string `json:"name"`
+	Publisher   string `json:"publisher"`
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+}
+
+// VolumeChange represents a volume whose capacity or free space differs between snapshots
+type VolumeChange struct {
+	Name           string `json:"name"`
+	FromTotalBytes int64  `json:"from_total_bytes"`
+	ToTotalBytes   int64  `json:"to_total_bytes"`
+	FromFreeBytes  int64  `json:"from_free_bytes"`
+	ToFreeBytes    int64  `json:"to_free_bytes"`
+	FreeBytesDelta int64  `json:"free_bytes_delta"`
+}
+
+// FieldChange represents an identity, OS or hardware attribute that differs between snapshots
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
 }
\ No newline at end of file
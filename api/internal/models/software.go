@@ -0,0 +1,136 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SoftwareEventType string
+
+const (
+	SoftwareEventInstall   SoftwareEventType = "install"
+	SoftwareEventUninstall SoftwareEventType = "uninstall"
+	SoftwareEventUpgrade   SoftwareEventType = "upgrade"
+	SoftwareEventDowngrade SoftwareEventType = "downgrade"
+)
+
+// SoftwareEvent represents a software change detected between two consecutive snapshots of a device
+type SoftwareEvent struct {
+	ID                 uuid.UUID         `json:"id" db:"id"`
+	DeviceID           uuid.UUID         `json:"device_id" db:"device_id"`
+	SnapshotID         uuid.UUID         `json:"snapshot_id" db:"snapshot_id"`
+	PreviousSnapshotID *uuid.UUID        `json:"previous_snapshot_id" db:"previous_snapshot_id"`
+	EventType          SoftwareEventType `json:"event_type" db:"event_type"`
+	Name               string            `json:"name" db:"name"`
+	Publisher          string            `json:"publisher" db:"publisher"`
+	RawName            string            `json:"raw_name" db:"raw_name"`
+	RawPublisher       string            `json:"raw_publisher" db:"raw_publisher"`
+	FromVersion        *string           `json:"from_version" db:"from_version"`
+	ToVersion          *string           `json:"to_version" db:"to_version"`
+	InteractiveUser    string            `json:"interactive_user" db:"interactive_user"`
+	OccurredAt         time.Time         `json:"occurred_at" db:"occurred_at"`
+	CreatedAt          time.Time         `json:"created_at" db:"created_at"`
+}
+
+// SoftwareEventListItem represents a software event in list views with joined device data
+type SoftwareEventListItem struct {
+	SoftwareEvent
+	Hostname string `json:"hostname" db:"hostname"`
+}
+
+// SoftwareDeviceItem represents a device that currently has a software title installed
+type SoftwareDeviceItem struct {
+	DeviceID    uuid.UUID    `json:"device_id" db:"device_id"`
+	Hostname    string       `json:"hostname" db:"hostname"`
+	Status      DeviceStatus `json:"status" db:"status"`
+	LastSeen    time.Time    `json:"last_seen" db:"last_seen"`
+	Name        string       `json:"name" db:"name"`
+	Version     string       `json:"version" db:"version"`
+	Publisher   string       `json:"publisher" db:"publisher"`
+	InstallDate *time.Time   `json:"install_date" db:"install_date"`
+}
+
+// SoftwareVersionCount represents the number of devices running one version of a title
+type SoftwareVersionCount struct {
+	Version     string `json:"version" db:"version"`
+	DeviceCount int    `json:"device_count" db:"device_count"`
+}
+
+// OutdatedSoftwareItem represents a software title with devices running versions
+// older than the newest version seen in the fleet
+type OutdatedSoftwareItem struct {
+	Name                string                 `json:"name"`
+	Publisher           string                 `json:"publisher"`
+	LatestVersion       string                 `json:"latest_version"`
+	LatestDeviceCount   int                    `json:"latest_device_count"`
+	OutdatedDeviceCount int                    `json:"outdated_device_count"`
+	OutdatedVersions    []SoftwareVersionCount `json:"outdated_versions"`
+	Devices             []SoftwareDeviceItem   `json:"devices"`
+}
+
+type NormalizationField string
+
+const (
+	NormalizationFieldName      NormalizationField = "name"
+	NormalizationFieldPublisher NormalizationField = "publisher"
+)
+
+type NormalizationMatchType string
+
+const (
+	NormalizationMatchRegex NormalizationMatchType = "regex"
+	NormalizationMatchAlias NormalizationMatchType = "alias"
+)
+
+// NormalizationRule rewrites software names or publishers at ingest. Alias rules
+// replace values equal to the pattern (ignoring case), regex rules replace every
+// match of the pattern and may reference capture groups ($1) in the replacement.
+type NormalizationRule struct {
+	ID          uuid.UUID              `json:"id" db:"id"`
+	Field       NormalizationField     `json:"field" db:"field"`
+	MatchType   NormalizationMatchType `json:"match_type" db:"match_type"`
+	Pattern     string                 `json:"pattern" db:"pattern"`
+	Replacement string                 `json:"replacement" db:"replacement"`
+	Priority    int                    `json:"priority" db:"priority"`
+	Enabled     bool                   `json:"enabled" db:"enabled"`
+	Description string                 `json:"description" db:"description"`
+	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+// NormalizationRuleRequest represents a normalization rule create or update request
+type NormalizationRuleRequest struct {
+	Field       *NormalizationField     `json:"field"`
+	MatchType   *NormalizationMatchType `json:"match_type"`
+	Pattern     *string                 `json:"pattern" validate:"omitempty,min=1,max=500"`
+	Replacement *string                 `json:"replacement" validate:"omitempty,max=500"`
+	Priority    *int                    `json:"priority" validate:"omitempty,min=0,max=10000"`
+	Enabled     *bool                   `json:"enabled"`
+	Description *string                 `json:"description" validate:"omitempty,max=500"`
+}
+
+// NormalizationPreviewRequest represents values to run through the current or proposed rules
+type NormalizationPreviewRequest struct {
+	Name      string              `json:"name" validate:"max=500"`
+	Publisher string              `json:"publisher" validate:"max=255"`
+	Rules     []NormalizationRule `json:"rules,omitempty"`
+}
+
+// NormalizationPreviewResult shows how a name and publisher would be normalized
+type NormalizationPreviewResult struct {
+	RawName             string `json:"raw_name"`
+	RawPublisher        string `json:"raw_publisher"`
+	NormalizedName      string `json:"normalized_name"`
+	NormalizedPublisher string `json:"normalized_publisher"`
+}
+
+// NormalizationBackfillStatus reports the progress of the normalization backfill job
+type NormalizationBackfillStatus struct {
+	Running     bool       `json:"running"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	RowsScanned int        `json:"rows_scanned"`
+	RowsUpdated int        `json:"rows_updated"`
+	Error       string     `json:"error,omitempty"`
+}
@@ -13,6 +13,18 @@ const (
 	UserRoleAdmin  UserRole = "admin"
 )
 
+// Satisfies reports whether the role grants access to what the required role
+// does. Admins can do everything viewers can.
+func (r UserRole) Satisfies(required UserRole) bool {
+	switch required {
+	case UserRoleViewer:
+		return r == UserRoleViewer || r == UserRoleAdmin
+	case UserRoleAdmin:
+		return r == UserRoleAdmin
+	}
+	return false
+}
+
 type User struct {
 	ID           uuid.UUID `json:"id" db:"id"`
 	Username     string    `json:"username" db:"username" validate:"required,min=3,max=100"`
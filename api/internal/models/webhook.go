@@ -0,0 +1,77 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// Webhook is a subscription delivering events to an HTTP endpoint. An empty
+// EventTypes list subscribes to every event type.
+type Webhook struct {
+	ID         uuid.UUID     `json:"id" db:"id"`
+	Name       string        `json:"name" db:"name"`
+	URL        string        `json:"url" db:"url"`
+	Secret     string        `json:"-" db:"secret"` // Only returned when created
+	EventTypes EventTypeList `json:"event_types" db:"event_types"`
+	Enabled    bool          `json:"enabled" db:"enabled"`
+	CreatedAt  time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" db:"updated_at"`
+
+	// Computed fields
+	PendingDeliveries int `json:"pending_deliveries" db:"pending_deliveries"`
+	FailedDeliveries  int `json:"failed_deliveries" db:"failed_deliveries"`
+}
+
+// WebhookRequest represents a webhook create or update request. A secret is
+// generated when none is given at creation.
+type WebhookRequest struct {
+	Name       *string      `json:"name" validate:"omitempty,min=1,max=100"`
+	URL        *string      `json:"url" validate:"omitempty,url,max=2000"`
+	Secret     *string      `json:"secret" validate:"omitempty,min=16,max=200"`
+	EventTypes *[]EventType `json:"event_types"`
+	Enabled    *bool        `json:"enabled"`
+}
+
+// WebhookCreateResponse includes the signing secret, which is not returned again
+type WebhookCreateResponse struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookDelivery records one event queued for a webhook and the outcome of
+// its latest delivery attempt. EventID is nil for test pings.
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id" db:"id"`
+	WebhookID      uuid.UUID             `json:"webhook_id" db:"webhook_id"`
+	EventID        *int64                `json:"event_id" db:"event_id"`
+	EventType      EventType             `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage       `json:"payload" db:"payload"`
+	Status         WebhookDeliveryStatus `json:"status" db:"status"`
+	Attempts       int                   `json:"attempts" db:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time            `json:"last_attempt_at" db:"last_attempt_at"`
+	ResponseStatus *int                  `json:"response_status" db:"response_status"`
+	ResponseBody   string                `json:"response_body" db:"response_body"`
+	Error          string                `json:"error" db:"error"`
+	CreatedAt      time.Time             `json:"created_at" db:"created_at"`
+	CompletedAt    *time.Time            `json:"completed_at" db:"completed_at"`
+}
+
+// WebhookPayload is the JSON body posted to webhook endpoints
+type WebhookPayload struct {
+	EventID    *int64          `json:"event_id"`
+	Type       EventType       `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	DeviceID   *uuid.UUID      `json:"device_id"`
+	Data       json.RawMessage `json:"data"`
+}
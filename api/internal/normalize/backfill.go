@@ -0,0 +1,139 @@
+package normalize
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/models"
+)
+
+// normalizedTables are the tables storing normalized software names next to
+// the raw values reported by agents
+var normalizedTables = []string{
+	"software_set_items",
+	"device_current_software",
+	"software_events",
+}
+
+// ErrBackfillRunning is returned when a backfill is requested while one is in progress
+var ErrBackfillRunning = errors.New("normalization backfill already running")
+
+// BackfillJob re-applies the normalization rules to stored software rows in
+// the background and tracks the progress of the latest run
+type BackfillJob struct {
+	mu     sync.Mutex
+	status models.NormalizationBackfillStatus
+}
+
+// Status returns a copy of the latest backfill status
+func (j *BackfillJob) Status() models.NormalizationBackfillStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Start launches a backfill with the given rules unless one is already running
+func (j *BackfillJob) Start(db *sqlx.DB, n *Normalizer) (models.NormalizationBackfillStatus, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status.Running {
+		return j.status, ErrBackfillRunning
+	}
+
+	now := time.Now().UTC()
+	j.status = models.NormalizationBackfillStatus{Running: true, StartedAt: &now}
+
+	go j.run(db, n)
+
+	return j.status, nil
+}
+
+func (j *BackfillJob) run(db *sqlx.DB, n *Normalizer) {
+	scanned, updated, err := Backfill(db, n)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	completed := time.Now().UTC()
+	j.status.Running = false
+	j.status.CompletedAt = &completed
+	j.status.RowsScanned = scanned
+	j.status.RowsUpdated = updated
+	if err != nil {
+		j.status.Error = err.Error()
+		log.Printf("[ERROR] Software normalization backfill failed: %v", err)
+		return
+	}
+
+	log.Printf("[INFO] Software normalization backfill completed: scanned=%d, updated=%d", scanned, updated)
+}
+
+// rawPair is a distinct raw name and publisher with its current normalized values
+type rawPair struct {
+	RawName      string `db:"raw_name"`
+	RawPublisher string `db:"raw_publisher"`
+	Name         string `db:"name"`
+	Publisher    string `db:"publisher"`
+	Rows         int    `db:"row_count"`
+}
+
+// Backfill recomputes the normalized name and publisher of every stored
+// software row from its raw values. Rows are processed per distinct raw pair,
+// so the work is proportional to the number of titles rather than installs.
+// Returns the number of rows scanned and updated.
+func Backfill(db *sqlx.DB, n *Normalizer) (int, int, error) {
+	scanned, updated := 0, 0
+
+	for _, table := range normalizedTables {
+		var pairs []rawPair
+		query := fmt.Sprintf(`
+			SELECT raw_name, raw_publisher, name, COALESCE(publisher, '') AS publisher, COUNT(*) AS row_count
+			FROM %s
+			GROUP BY raw_name, raw_publisher, name, COALESCE(publisher, '')`, table)
+		if err := db.Select(&pairs, query); err != nil {
+			return scanned, updated, fmt.Errorf("failed to read %s: %w", table, err)
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return scanned, updated, err
+		}
+
+		update := fmt.Sprintf(`
+			UPDATE %s SET name = ?, publisher = ?
+			WHERE raw_name = ? AND raw_publisher = ? AND name = ? AND COALESCE(publisher, '') = ?`, table)
+
+		for _, pair := range pairs {
+			scanned += pair.Rows
+
+			name := n.Apply(models.NormalizationFieldName, pair.RawName)
+			publisher := n.Apply(models.NormalizationFieldPublisher, pair.RawPublisher)
+			if name == pair.Name && publisher == pair.Publisher {
+				continue
+			}
+
+			result, err := tx.Exec(update, name, publisher, pair.RawName, pair.RawPublisher, pair.Name, pair.Publisher)
+			if err != nil {
+				tx.Rollback()
+				return scanned, updated, fmt.Errorf("failed to update %s: %w", table, err)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				tx.Rollback()
+				return scanned, updated, err
+			}
+			updated += int(affected)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return scanned, updated, err
+		}
+	}
+
+	return scanned, updated, nil
+}
@@ -0,0 +1,124 @@
+package normalize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/models"
+)
+
+const schema = `
+CREATE TABLE software_set_items (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	publisher TEXT,
+	raw_name TEXT NOT NULL DEFAULT '',
+	raw_publisher TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE device_current_software (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	publisher TEXT NOT NULL DEFAULT '',
+	raw_name TEXT NOT NULL DEFAULT '',
+	raw_publisher TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE software_events (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	publisher TEXT NOT NULL DEFAULT '',
+	raw_name TEXT NOT NULL DEFAULT '',
+	raw_publisher TEXT NOT NULL DEFAULT ''
+);`
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	db.MustExec(schema)
+	return db
+}
+
+func TestBackfillJob(t *testing.T) {
+	db := openTestDB(t)
+
+	// Every table holds one row normalized by earlier rules and one never normalized
+	for _, table := range normalizedTables {
+		db.MustExec(`INSERT INTO `+table+` (id, name, publisher, raw_name, raw_publisher) VALUES (?, ?, ?, ?, ?)`,
+			uuid.New(), "Firefox", "Mozilla", "Mozilla Firefox (x64 en-US)", "Mozilla Corporation")
+		db.MustExec(`INSERT INTO `+table+` (id, name, publisher, raw_name, raw_publisher) VALUES (?, ?, ?, ?, ?)`,
+			uuid.New(), "Git", "The Git Development Community", "Git", "The Git Development Community")
+	}
+
+	normalizer, err := Compile([]models.NormalizationRule{
+		rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `\s*\(x64[^)]*\)`, ""),
+		rule(models.NormalizationFieldPublisher, models.NormalizationMatchAlias, "mozilla corporation", "Mozilla Foundation"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var job BackfillJob
+	if _, err := job.Start(db, normalizer); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	status := job.Status()
+	for status.Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		status = job.Status()
+	}
+	if status.Running {
+		t.Fatal("backfill did not complete")
+	}
+	if status.Error != "" || status.CompletedAt == nil {
+		t.Fatalf("status = %+v, want a completed run", status)
+	}
+	if status.RowsScanned != 6 || status.RowsUpdated != 3 {
+		t.Errorf("scanned %d and updated %d rows, want 6 and 3", status.RowsScanned, status.RowsUpdated)
+	}
+
+	for _, table := range normalizedTables {
+		var rows []struct {
+			Name         string `db:"name"`
+			Publisher    string `db:"publisher"`
+			RawName      string `db:"raw_name"`
+			RawPublisher string `db:"raw_publisher"`
+		}
+		if err := db.Select(&rows, `SELECT name, publisher, raw_name, raw_publisher FROM `+table+` ORDER BY raw_name`); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []struct{ name, publisher, rawName, rawPublisher string }{
+			{"Git", "The Git Development Community", "Git", "The Git Development Community"},
+			{"Mozilla Firefox", "Mozilla Foundation", "Mozilla Firefox (x64 en-US)", "Mozilla Corporation"},
+		}
+		if len(rows) != len(want) {
+			t.Fatalf("%s rows = %+v, want %d", table, rows, len(want))
+		}
+		for i, w := range want {
+			row := rows[i]
+			if row.Name != w.name || row.Publisher != w.publisher || row.RawName != w.rawName || row.RawPublisher != w.rawPublisher {
+				t.Errorf("%s row %d = %+v, want %+v", table, i, row, w)
+			}
+		}
+	}
+
+	// Running again with the same rules changes nothing
+	scanned, updated, err := Backfill(db, normalizer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != 6 || updated != 0 {
+		t.Errorf("second run scanned %d and updated %d rows, want 6 and 0", scanned, updated)
+	}
+}
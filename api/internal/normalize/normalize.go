@@ -0,0 +1,96 @@
+package normalize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tracr/api/internal/models"
+)
+
+// compiledRule is a normalization rule ready to be applied
+type compiledRule struct {
+	rule  models.NormalizationRule
+	regex *regexp.Regexp
+}
+
+// Normalizer rewrites software names and publishers with an ordered rule set
+type Normalizer struct {
+	rules map[models.NormalizationField][]compiledRule
+}
+
+// Compile validates and compiles rules. Disabled rules are skipped, the rest
+// are applied in the order given.
+func Compile(rules []models.NormalizationRule) (*Normalizer, error) {
+	n := &Normalizer{rules: make(map[models.NormalizationField][]compiledRule)}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		compiled := compiledRule{rule: rule}
+		if rule.MatchType == models.NormalizationMatchRegex {
+			regex, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s has an invalid pattern: %w", rule.ID, err)
+			}
+			compiled.regex = regex
+		}
+		n.rules[rule.Field] = append(n.rules[rule.Field], compiled)
+	}
+
+	return n, nil
+}
+
+// ValidateRule checks that a rule's pattern can be compiled
+func ValidateRule(rule models.NormalizationRule) error {
+	if rule.MatchType != models.NormalizationMatchRegex {
+		return nil
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return nil
+}
+
+// Apply normalizes a value of the given field. Aliases replace the whole value
+// when it matches case-insensitively, regex rules rewrite every match. Runs of
+// whitespace are collapsed afterwards, and a rule set that would empty the
+// value leaves it unchanged.
+func (n *Normalizer) Apply(field models.NormalizationField, value string) string {
+	if n == nil {
+		return value
+	}
+
+	normalized := value
+	for _, compiled := range n.rules[field] {
+		switch compiled.rule.MatchType {
+		case models.NormalizationMatchAlias:
+			if strings.EqualFold(strings.TrimSpace(normalized), strings.TrimSpace(compiled.rule.Pattern)) {
+				normalized = compiled.rule.Replacement
+			}
+		case models.NormalizationMatchRegex:
+			normalized = compiled.regex.ReplaceAllString(normalized, compiled.rule.Replacement)
+		}
+	}
+
+	normalized = strings.Join(strings.Fields(normalized), " ")
+	if normalized == "" {
+		return value
+	}
+	return normalized
+}
+
+// Software normalizes the name and publisher of software items in place,
+// recording the values reported by the agent as the raw name and publisher
+func (n *Normalizer) Software(software []models.Software) {
+	for i := range software {
+		if software[i].RawName == "" {
+			software[i].RawName = software[i].Name
+			software[i].RawPublisher = software[i].Publisher
+		}
+		software[i].Name = n.Apply(models.NormalizationFieldName, software[i].RawName)
+		software[i].Publisher = n.Apply(models.NormalizationFieldPublisher, software[i].RawPublisher)
+	}
+}
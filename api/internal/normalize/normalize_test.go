@@ -0,0 +1,164 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+)
+
+func rule(field models.NormalizationField, matchType models.NormalizationMatchType, pattern, replacement string) models.NormalizationRule {
+	return models.NormalizationRule{
+		ID:          uuid.New(),
+		Field:       field,
+		MatchType:   matchType,
+		Pattern:     pattern,
+		Replacement: replacement,
+		Enabled:     true,
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []models.NormalizationRule
+		field models.NormalizationField
+		value string
+		want  string
+	}{
+		{
+			name:  "no rules",
+			field: models.NormalizationFieldName,
+			value: "Mozilla Firefox (x64 en-US)",
+			want:  "Mozilla Firefox (x64 en-US)",
+		},
+		{
+			name:  "alias ignores case and surrounding space",
+			rules: []models.NormalizationRule{rule(models.NormalizationFieldPublisher, models.NormalizationMatchAlias, "Microsoft Corp.", "Microsoft Corporation")},
+			field: models.NormalizationFieldPublisher,
+			value: "  MICROSOFT corp. ",
+			want:  "Microsoft Corporation",
+		},
+		{
+			name:  "alias matches the whole value only",
+			rules: []models.NormalizationRule{rule(models.NormalizationFieldPublisher, models.NormalizationMatchAlias, "Microsoft", "Microsoft Corporation")},
+			field: models.NormalizationFieldPublisher,
+			value: "Microsoft Research",
+			want:  "Microsoft Research",
+		},
+		{
+			name:  "rules of the other field are not applied",
+			rules: []models.NormalizationRule{rule(models.NormalizationFieldPublisher, models.NormalizationMatchAlias, "Git", "Git SCM")},
+			field: models.NormalizationFieldName,
+			value: "Git",
+			want:  "Git",
+		},
+		{
+			name: "regex rules rewrite in order",
+			rules: []models.NormalizationRule{
+				rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `\s*\((x64|x86)[^)]*\)`, ""),
+				rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `^Mozilla Firefox.*$`, "Firefox"),
+			},
+			field: models.NormalizationFieldName,
+			value: "Mozilla Firefox (x64 en-US)",
+			want:  "Firefox",
+		},
+		{
+			name: "later rules see earlier rewrites",
+			rules: []models.NormalizationRule{
+				rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `^Mozilla `, ""),
+				rule(models.NormalizationFieldName, models.NormalizationMatchAlias, "Firefox (x64 en-US)", "Firefox"),
+			},
+			field: models.NormalizationFieldName,
+			value: "Mozilla Firefox (x64 en-US)",
+			want:  "Firefox",
+		},
+		{
+			name: "order matters",
+			rules: []models.NormalizationRule{
+				rule(models.NormalizationFieldName, models.NormalizationMatchAlias, "Firefox (x64 en-US)", "Firefox"),
+				rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `^Mozilla `, ""),
+			},
+			field: models.NormalizationFieldName,
+			value: "Mozilla Firefox (x64 en-US)",
+			want:  "Firefox (x64 en-US)",
+		},
+		{
+			name:  "regex with capture groups",
+			rules: []models.NormalizationRule{rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `^Python (\d+\.\d+)\.\d+ \(64-bit\)$`, "Python $1")},
+			field: models.NormalizationFieldName,
+			value: "Python 3.12.1 (64-bit)",
+			want:  "Python 3.12",
+		},
+		{
+			name:  "whitespace is collapsed",
+			rules: []models.NormalizationRule{rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `Update`, "")},
+			field: models.NormalizationFieldName,
+			value: "Microsoft  Edge Update \t Helper",
+			want:  "Microsoft Edge Helper",
+		},
+		{
+			name:  "emptied value falls back to the raw value",
+			rules: []models.NormalizationRule{rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `.*`, "")},
+			field: models.NormalizationFieldName,
+			value: "7-Zip 23.01 (x64)",
+			want:  "7-Zip 23.01 (x64)",
+		},
+		{
+			name: "disabled rules are skipped",
+			rules: []models.NormalizationRule{func() models.NormalizationRule {
+				disabled := rule(models.NormalizationFieldName, models.NormalizationMatchAlias, "Git", "Git SCM")
+				disabled.Enabled = false
+				return disabled
+			}()},
+			field: models.NormalizationFieldName,
+			value: "Git",
+			want:  "Git",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizer, err := Compile(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := normalizer.Apply(tt.field, tt.value); got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileInvalidPattern(t *testing.T) {
+	if _, err := Compile([]models.NormalizationRule{rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `(`, "")}); err == nil {
+		t.Error("Compile accepted an invalid regular expression")
+	}
+}
+
+func TestSoftware(t *testing.T) {
+	normalizer, err := Compile([]models.NormalizationRule{
+		rule(models.NormalizationFieldName, models.NormalizationMatchRegex, `\s*\(x64\)$`, ""),
+		rule(models.NormalizationFieldPublisher, models.NormalizationMatchAlias, "Igor Pavlov", "7-Zip Project"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	software := []models.Software{
+		{Name: "7-Zip 23.01 (x64)", Publisher: "Igor Pavlov"},
+		// Items normalized before keep their raw values
+		{Name: "Git", Publisher: "Git SCM", RawName: "Git (x64)", RawPublisher: "The Git Development Community"},
+	}
+	normalizer.Software(software)
+
+	want := []models.Software{
+		{Name: "7-Zip 23.01", Publisher: "7-Zip Project", RawName: "7-Zip 23.01 (x64)", RawPublisher: "Igor Pavlov"},
+		{Name: "Git", Publisher: "The Git Development Community", RawName: "Git (x64)", RawPublisher: "The Git Development Community"},
+	}
+	for i := range want {
+		if software[i] != want[i] {
+			t.Errorf("software[%d] = %+v, want %+v", i, software[i], want[i])
+		}
+	}
+}
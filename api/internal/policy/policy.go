@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/versions"
+)
+
+// Inventory is the device state a policy is evaluated against
+type Inventory struct {
+	OSBuild  string
+	Software []models.Software
+	Volumes  []models.Volume
+}
+
+// Outcome is the result of evaluating a policy against one device
+type Outcome struct {
+	Passed bool
+	Detail string
+}
+
+// Validate checks that params hold what the rule type needs
+func Validate(ruleType models.PolicyRuleType, params models.PolicyParams) error {
+	switch ruleType {
+	case models.PolicyVolumeExists:
+		if strings.TrimSpace(params.Volume) == "" && strings.TrimSpace(params.FileSystem) == "" {
+			return fmt.Errorf("volume_exists requires params.volume, params.filesystem or both")
+		}
+	case models.PolicyVolumeFreeSpace:
+		if params.MinFreePercent == nil || *params.MinFreePercent <= 0 || *params.MinFreePercent > 100 {
+			return fmt.Errorf("volume_free_space requires params.min_free_percent between 0 and 100")
+		}
+	case models.PolicyMinOSBuild:
+		if params.MinBuild == nil || *params.MinBuild <= 0 {
+			return fmt.Errorf("min_os_build requires a positive params.min_build")
+		}
+	case models.PolicySoftwareRequired, models.PolicySoftwareProhibited:
+		if strings.TrimSpace(params.Software) == "" {
+			return fmt.Errorf("%s requires params.software", ruleType)
+		}
+		if ruleType == models.PolicySoftwareProhibited && params.MinVersion != "" {
+			return fmt.Errorf("software_prohibited does not support params.min_version")
+		}
+	default:
+		return fmt.Errorf("rule_type must be one of: volume_exists, volume_free_space, min_os_build, software_required, software_prohibited")
+	}
+	return nil
+}
+
+// Evaluate checks a device inventory against a policy. Software names match
+// case-insensitively and may use * as a wildcard.
+func Evaluate(p models.Policy, inv Inventory) Outcome {
+	params := p.Params
+
+	switch p.RuleType {
+	case models.PolicyVolumeExists:
+		// Only the name and filesystem are known, not whether the volume is
+		// encrypted
+		for _, volume := range inv.Volumes {
+			if matchesVolume(volume, params.Volume) &&
+				(params.FileSystem == "" || strings.EqualFold(volume.FileSystem, params.FileSystem)) {
+				return Outcome{Passed: true, Detail: fmt.Sprintf("Volume %s (%s) present", volume.Name, volume.FileSystem)}
+			}
+		}
+		return Outcome{Detail: "No " + describeVolume(params) + " found"}
+
+	case models.PolicyVolumeFreeSpace:
+		minFree := *params.MinFreePercent
+		checked := 0
+		for _, volume := range inv.Volumes {
+			if !matchesVolume(volume, params.Volume) || volume.TotalBytes <= 0 {
+				continue
+			}
+			checked++
+			free := float64(volume.FreeBytes) * 100 / float64(volume.TotalBytes)
+			if free < minFree {
+				return Outcome{Detail: fmt.Sprintf("Volume %s has %.1f%% free, below %g%%", volume.Name, free, minFree)}
+			}
+		}
+		if checked == 0 {
+			return Outcome{Detail: "No " + describeVolume(params) + " found"}
+		}
+		return Outcome{Passed: true, Detail: fmt.Sprintf("Every volume has at least %g%% free", minFree)}
+
+	case models.PolicyMinOSBuild:
+		build, err := strconv.Atoi(strings.TrimSpace(inv.OSBuild))
+		if err != nil {
+			return Outcome{Detail: fmt.Sprintf("OS build %q is not a number", inv.OSBuild)}
+		}
+		if build < *params.MinBuild {
+			return Outcome{Detail: fmt.Sprintf("OS build %d is below %d", build, *params.MinBuild)}
+		}
+		return Outcome{Passed: true, Detail: fmt.Sprintf("OS build %d meets %d", build, *params.MinBuild)}
+
+	case models.PolicySoftwareRequired:
+		installed := findSoftware(inv.Software, params.Software)
+		if len(installed) == 0 {
+			return Outcome{Detail: params.Software + " is not installed"}
+		}
+		if params.MinVersion == "" {
+			return Outcome{Passed: true, Detail: describeSoftware(installed[0]) + " is installed"}
+		}
+		for _, item := range installed {
+			if versions.Compare(item.Version, params.MinVersion) >= 0 {
+				return Outcome{Passed: true, Detail: describeSoftware(item) + " is installed"}
+			}
+		}
+		return Outcome{Detail: fmt.Sprintf("%s is older than %s", describeSoftware(installed[0]), params.MinVersion)}
+
+	case models.PolicySoftwareProhibited:
+		installed := findSoftware(inv.Software, params.Software)
+		if len(installed) > 0 {
+			return Outcome{Detail: describeSoftware(installed[0]) + " is installed"}
+		}
+		return Outcome{Passed: true, Detail: params.Software + " is not installed"}
+	}
+
+	return Outcome{Detail: fmt.Sprintf("Unknown rule type %q", p.RuleType)}
+}
+
+func matchesVolume(volume models.Volume, name string) bool {
+	if name == "" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSuffix(volume.Name, `\`), strings.TrimSuffix(name, `\`))
+}
+
+func describeVolume(params models.PolicyParams) string {
+	switch {
+	case params.Volume != "" && params.FileSystem != "":
+		return fmt.Sprintf("%s volume %s", params.FileSystem, params.Volume)
+	case params.Volume != "":
+		return "volume " + params.Volume
+	case params.FileSystem != "":
+		return params.FileSystem + " volume"
+	}
+	return "volume"
+}
+
+// findSoftware returns the installed items whose name matches pattern
+func findSoftware(software []models.Software, pattern string) []models.Software {
+	expression := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSpace(pattern)), `\*`, ".*") + "$"
+	matcher := regexp.MustCompile(expression)
+
+	var matches []models.Software
+	for _, item := range software {
+		if matcher.MatchString(item.Name) {
+			matches = append(matches, item)
+		}
+	}
+	return matches
+}
+
+func describeSoftware(item models.Software) string {
+	if item.Version == "" {
+		return item.Name
+	}
+	return item.Name + " " + item.Version
+}
@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/tracr/api/internal/models"
+)
+
+func float(v float64) *float64 { return &v }
+func integer(v int) *int       { return &v }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		ruleType models.PolicyRuleType
+		params   models.PolicyParams
+		valid    bool
+	}{
+		{"volume by name", models.PolicyVolumeExists, models.PolicyParams{Volume: "C:"}, true},
+		{"volume by filesystem", models.PolicyVolumeExists, models.PolicyParams{FileSystem: "NTFS"}, true},
+		{"volume without params", models.PolicyVolumeExists, models.PolicyParams{Volume: " "}, false},
+		{"free space", models.PolicyVolumeFreeSpace, models.PolicyParams{MinFreePercent: float(10)}, true},
+		{"free space missing", models.PolicyVolumeFreeSpace, models.PolicyParams{}, false},
+		{"free space zero", models.PolicyVolumeFreeSpace, models.PolicyParams{MinFreePercent: float(0)}, false},
+		{"free space above 100", models.PolicyVolumeFreeSpace, models.PolicyParams{MinFreePercent: float(101)}, false},
+		{"os build", models.PolicyMinOSBuild, models.PolicyParams{MinBuild: integer(19045)}, true},
+		{"os build negative", models.PolicyMinOSBuild, models.PolicyParams{MinBuild: integer(-1)}, false},
+		{"required software", models.PolicySoftwareRequired, models.PolicyParams{Software: "Chrome", MinVersion: "120"}, true},
+		{"required software missing", models.PolicySoftwareRequired, models.PolicyParams{}, false},
+		{"prohibited software", models.PolicySoftwareProhibited, models.PolicyParams{Software: "uTorrent"}, true},
+		{"prohibited with version", models.PolicySoftwareProhibited, models.PolicyParams{Software: "uTorrent", MinVersion: "1"}, false},
+		{"unknown rule type", "disk_encrypted", models.PolicyParams{Volume: "C:"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.ruleType, tt.params)
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate(%s, %+v) = %v, want valid %v", tt.ruleType, tt.params, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	inv := Inventory{
+		OSBuild: "19045",
+		Volumes: []models.Volume{
+			{Name: `C:\`, FileSystem: "NTFS", TotalBytes: 100, FreeBytes: 30},
+			{Name: "D:", FileSystem: "FAT32", TotalBytes: 100, FreeBytes: 5},
+			{Name: "E:", FileSystem: "", TotalBytes: 0, FreeBytes: 0},
+		},
+		Software: []models.Software{
+			{Name: "Google Chrome", Version: "118.0.5993.89"},
+			{Name: "OpenSSL", Version: "1.1.1w"},
+			{Name: "7-Zip 23.01 (x64)", Version: "23.01"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ruleType models.PolicyRuleType
+		params   models.PolicyParams
+		inv      Inventory
+		passed   bool
+		detail   string
+	}{
+		// Volumes
+		{"volume present", models.PolicyVolumeExists, models.PolicyParams{Volume: "c:"}, inv, true, `Volume C:\ (NTFS) present`},
+		{"volume with filesystem", models.PolicyVolumeExists, models.PolicyParams{Volume: `C:\`, FileSystem: "ntfs"}, inv, true, `Volume C:\ (NTFS) present`},
+		{"volume with other filesystem", models.PolicyVolumeExists, models.PolicyParams{Volume: "D:", FileSystem: "NTFS"}, inv, false, "No NTFS volume D: found"},
+		{"volume missing", models.PolicyVolumeExists, models.PolicyParams{Volume: "F:"}, inv, false, "No volume F: found"},
+
+		// Free space
+		{"every volume has space", models.PolicyVolumeFreeSpace, models.PolicyParams{MinFreePercent: float(5)}, inv, true, "Every volume has at least 5% free"},
+		{"one volume is full", models.PolicyVolumeFreeSpace, models.PolicyParams{MinFreePercent: float(10)}, inv, false, "Volume D: has 5.0% free, below 10%"},
+		{"only the named volume", models.PolicyVolumeFreeSpace, models.PolicyParams{Volume: "C:", MinFreePercent: float(10)}, inv, true, "Every volume has at least 10% free"},
+		{"empty volumes skipped", models.PolicyVolumeFreeSpace, models.PolicyParams{Volume: "E:", MinFreePercent: float(10)}, inv, false, "No volume E: found"},
+
+		// OS build
+		{"build meets minimum", models.PolicyMinOSBuild, models.PolicyParams{MinBuild: integer(19045)}, inv, true, "OS build 19045 meets 19045"},
+		{"build below minimum", models.PolicyMinOSBuild, models.PolicyParams{MinBuild: integer(22621)}, inv, false, "OS build 19045 is below 22621"},
+		{"build not a number", models.PolicyMinOSBuild, models.PolicyParams{MinBuild: integer(1)}, Inventory{OSBuild: "unknown"}, false, `OS build "unknown" is not a number`},
+
+		// Required software
+		{"required installed", models.PolicySoftwareRequired, models.PolicyParams{Software: "google chrome"}, inv, true, "Google Chrome 118.0.5993.89 is installed"},
+		{"required wildcard", models.PolicySoftwareRequired, models.PolicyParams{Software: "7-Zip *"}, inv, true, "7-Zip 23.01 (x64) 23.01 is installed"},
+		{"wildcard is not a regexp", models.PolicySoftwareRequired, models.PolicyParams{Software: "7-Zip 23.01 (x64"}, inv, false, "7-Zip 23.01 (x64 is not installed"},
+		{"required missing", models.PolicySoftwareRequired, models.PolicyParams{Software: "Firefox"}, inv, false, "Firefox is not installed"},
+		{"version meets minimum", models.PolicySoftwareRequired, models.PolicyParams{Software: "Google Chrome", MinVersion: "118.0.5993"}, inv, true, "Google Chrome 118.0.5993.89 is installed"},
+		{"version numerically older", models.PolicySoftwareRequired, models.PolicyParams{Software: "Google Chrome", MinVersion: "118.0.10000"}, inv, false, "Google Chrome 118.0.5993.89 is older than 118.0.10000"},
+		{"letter suffix newer", models.PolicySoftwareRequired, models.PolicyParams{Software: "OpenSSL", MinVersion: "1.1.1"}, inv, true, "OpenSSL 1.1.1w is installed"},
+		{"letter suffix older", models.PolicySoftwareRequired, models.PolicyParams{Software: "OpenSSL", MinVersion: "1.1.1x"}, inv, false, "OpenSSL 1.1.1w is older than 1.1.1x"},
+
+		// Prohibited software
+		{"prohibited absent", models.PolicySoftwareProhibited, models.PolicyParams{Software: "uTorrent"}, inv, true, "uTorrent is not installed"},
+		{"prohibited installed", models.PolicySoftwareProhibited, models.PolicyParams{Software: "*chrome"}, inv, false, "Google Chrome 118.0.5993.89 is installed"},
+
+		{"unknown rule type", "disk_encrypted", models.PolicyParams{}, inv, false, `Unknown rule type "disk_encrypted"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate(models.Policy{RuleType: tt.ruleType, Params: tt.params}, tt.inv)
+			if got.Passed != tt.passed || got.Detail != tt.detail {
+				t.Errorf("Evaluate() = %+v, want {Passed:%v Detail:%s}", got, tt.passed, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package routes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/versions"
+)
+
+// Snapshot diff utilities
+
+// DiffSnapshots compares two snapshots of the same device along with their
+// software and volume lists and returns the differences from -> to
+func DiffSnapshots(from, to *models.Snapshot, fromSoftware, toSoftware []models.Software, fromVolumes, toVolumes []models.Volume) *models.SnapshotDiff {
+	diff := &models.SnapshotDiff{
+		DeviceID:        to.DeviceID,
+		FromSnapshotID:  from.ID,
+		ToSnapshotID:    to.ID,
+		FromCollectedAt: from.CollectedAt,
+		ToCollectedAt:   to.CollectedAt,
+	}
+
+	diff.SoftwareAdded, diff.SoftwareRemoved, diff.SoftwareChanged = diffSoftware(fromSoftware, toSoftware)
+	diff.VolumesAdded, diff.VolumesRemoved, diff.VolumesChanged = diffVolumes(fromVolumes, toVolumes)
+	diff.FieldChanges = diffSnapshotFields(from, to)
+
+	return diff
+}
+
+// softwareKey identifies a software title independently of its version
+func softwareKey(item models.Software) string {
+	return item.Name + "\x00" + item.Publisher
+}
+
+// diffSoftware groups software by title and publisher and classifies each
+// version as added, removed or changed. When a title has several versions on
+// both sides, unmatched versions are paired in sorted order as upgrades.
+func diffSoftware(from, to []models.Software) ([]models.Software, []models.Software, []models.SoftwareChange) {
+	added := []models.Software{}
+	removed := []models.Software{}
+	changed := []models.SoftwareChange{}
+
+	fromByKey := make(map[string][]models.Software)
+	toByKey := make(map[string][]models.Software)
+	var keys []string
+
+	for _, item := range from {
+		key := softwareKey(item)
+		if _, seen := fromByKey[key]; !seen {
+			keys = append(keys, key)
+		}
+		fromByKey[key] = append(fromByKey[key], item)
+	}
+	for _, item := range to {
+		key := softwareKey(item)
+		if _, seen := fromByKey[key]; !seen {
+			if _, seen := toByKey[key]; !seen {
+				keys = append(keys, key)
+			}
+		}
+		toByKey[key] = append(toByKey[key], item)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fromItems := fromByKey[key]
+		toItems := toByKey[key]
+
+		// Drop versions present on both sides
+		toVersions := make(map[string]int)
+		for _, item := range toItems {
+			toVersions[item.Version]++
+		}
+		var fromOnly []models.Software
+		for _, item := range fromItems {
+			if toVersions[item.Version] > 0 {
+				toVersions[item.Version]--
+				continue
+			}
+			fromOnly = append(fromOnly, item)
+		}
+		fromVersions := make(map[string]int)
+		for _, item := range fromItems {
+			fromVersions[item.Version]++
+		}
+		var toOnly []models.Software
+		for _, item := range toItems {
+			if fromVersions[item.Version] > 0 {
+				fromVersions[item.Version]--
+				continue
+			}
+			toOnly = append(toOnly, item)
+		}
+
+		sort.Slice(fromOnly, func(i, j int) bool { return versions.Less(fromOnly[i].Version, fromOnly[j].Version) })
+		sort.Slice(toOnly, func(i, j int) bool { return versions.Less(toOnly[i].Version, toOnly[j].Version) })
+
+		// Pair remaining versions as changes, leftovers are plain adds/removes
+		paired := len(fromOnly)
+		if len(toOnly) < paired {
+			paired = len(toOnly)
+		}
+		for i := 0; i < paired; i++ {
+			changed = append(changed, models.SoftwareChange{
+				Name:        toOnly[i].Name,
+				Publisher:   toOnly[i].Publisher,
+				FromVersion: fromOnly[i].Version,
+				ToVersion:   toOnly[i].Version,
+			})
+		}
+		removed = append(removed, fromOnly[paired:]...)
+		added = append(added, toOnly[paired:]...)
+	}
+
+	return added, removed, changed
+}
+
+// BuildSoftwareEvents derives install, uninstall, upgrade and downgrade events
+// from the software lists of a snapshot and the device's previous snapshot
+func BuildSoftwareEvents(previous, current *models.Snapshot, previousSoftware, currentSoftware []models.Software) []models.SoftwareEvent {
+	added, removed, changed := diffSoftware(previousSoftware, currentSoftware)
+
+	// Keep the reported name and publisher of each normalized title
+	type title struct{ name, publisher string }
+	raw := make(map[title]title)
+	for _, list := range [][]models.Software{previousSoftware, currentSoftware} {
+		for _, item := range list {
+			if item.RawName != "" {
+				raw[title{item.Name, item.Publisher}] = title{item.RawName, item.RawPublisher}
+			}
+		}
+	}
+
+	newEvent := func(eventType models.SoftwareEventType, name, publisher string, fromVersion, toVersion *string) models.SoftwareEvent {
+		previousID := previous.ID
+		reported, ok := raw[title{name, publisher}]
+		if !ok {
+			reported = title{name, publisher}
+		}
+		return models.SoftwareEvent{
+			ID:                 uuid.New(),
+			DeviceID:           current.DeviceID,
+			SnapshotID:         current.ID,
+			PreviousSnapshotID: &previousID,
+			EventType:          eventType,
+			Name:               name,
+			Publisher:          publisher,
+			RawName:            reported.name,
+			RawPublisher:       reported.publisher,
+			FromVersion:        fromVersion,
+			ToVersion:          toVersion,
+			InteractiveUser:    current.LastInteractiveUser,
+			OccurredAt:         current.CollectedAt,
+			CreatedAt:          time.Now().UTC(),
+		}
+	}
+
+	events := make([]models.SoftwareEvent, 0, len(added)+len(removed)+len(changed))
+	for _, item := range added {
+		version := item.Version
+		events = append(events, newEvent(models.SoftwareEventInstall, item.Name, item.Publisher, nil, &version))
+	}
+	for _, item := range removed {
+		version := item.Version
+		events = append(events, newEvent(models.SoftwareEventUninstall, item.Name, item.Publisher, &version, nil))
+	}
+	for _, change := range changed {
+		fromVersion, toVersion := change.FromVersion, change.ToVersion
+		eventType := models.SoftwareEventUpgrade
+		if versions.Compare(toVersion, fromVersion) < 0 {
+			eventType = models.SoftwareEventDowngrade
+		}
+		events = append(events, newEvent(eventType, change.Name, change.Publisher, &fromVersion, &toVersion))
+	}
+
+	return events
+}
+
+// diffVolumes matches volumes by name and reports capacity or free space changes
+func diffVolumes(from, to []models.Volume) ([]models.Volume, []models.Volume, []models.VolumeChange) {
+	added := []models.Volume{}
+	removed := []models.Volume{}
+	changed := []models.VolumeChange{}
+
+	fromByName := make(map[string]models.Volume)
+	for _, volume := range from {
+		fromByName[volume.Name] = volume
+	}
+	toByName := make(map[string]models.Volume)
+	for _, volume := range to {
+		toByName[volume.Name] = volume
+	}
+
+	for _, volume := range to {
+		previous, exists := fromByName[volume.Name]
+		if !exists {
+			CalculateVolumeUsage(&volume)
+			added = append(added, volume)
+			continue
+		}
+
+		if previous.TotalBytes != volume.TotalBytes || previous.FreeBytes != volume.FreeBytes {
+			changed = append(changed, models.VolumeChange{
+				Name:           volume.Name,
+				FromTotalBytes: previous.TotalBytes,
+				ToTotalBytes:   volume.TotalBytes,
+				FromFreeBytes:  previous.FreeBytes,
+				ToFreeBytes:    volume.FreeBytes,
+				FreeBytesDelta: volume.FreeBytes - previous.FreeBytes,
+			})
+		}
+	}
+
+	for _, volume := range from {
+		if _, exists := toByName[volume.Name]; !exists {
+			CalculateVolumeUsage(&volume)
+			removed = append(removed, volume)
+		}
+	}
+
+	return added, removed, changed
+}
+
+// diffSnapshotFields compares the identity, OS and hardware attributes of two
+// snapshots. Snapshots taken before these attributes were recorded have them
+// all empty, including the hostname that every inventory carries, so they are
+// only compared when the older snapshot has them.
+func diffSnapshotFields(from, to *models.Snapshot) []models.FieldChange {
+	recorded := from.Hostname != ""
+	fields := []struct {
+		name     string
+		from, to string
+		recorded bool
+	}{
+		{"hostname", from.Hostname, to.Hostname, recorded},
+		{"domain", from.Domain, to.Domain, recorded},
+		{"os_caption", from.OSCaption, to.OSCaption, recorded},
+		{"os_version", from.OSVersion, to.OSVersion, recorded},
+		{"os_build", from.OSBuild, to.OSBuild, recorded},
+		{"manufacturer", from.Manufacturer, to.Manufacturer, recorded},
+		{"model", from.Model, to.Model, recorded},
+		{"serial_number", from.SerialNumber, to.SerialNumber, recorded},
+		{"agent_version", from.AgentVersion, to.AgentVersion, true},
+	}
+
+	changes := []models.FieldChange{}
+	for _, field := range fields {
+		if field.recorded && field.from != field.to {
+			changes = append(changes, models.FieldChange{
+				Field: field.name,
+				From:  field.from,
+				To:    field.to,
+			})
+		}
+	}
+
+	return changes
+}
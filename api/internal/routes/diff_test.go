@@ -0,0 +1,149 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tracr/api/internal/models"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	sw := func(name, version string) models.Software {
+		return models.Software{Name: name, Publisher: "Vendor", Version: version}
+	}
+	change := func(name, from, to string) models.SoftwareChange {
+		return models.SoftwareChange{Name: name, Publisher: "Vendor", FromVersion: from, ToVersion: to}
+	}
+	attributes := func(hostname, osBuild string) *models.Snapshot {
+		return &models.Snapshot{Hostname: hostname, OSCaption: "Windows 11 Pro", OSBuild: osBuild, Model: "OptiPlex 7090", AgentVersion: "1.4.0"}
+	}
+
+	tests := []struct {
+		name                                 string
+		from, to                             *models.Snapshot
+		fromSoftware, toSoftware             []models.Software
+		fromVolumes, toVolumes               []models.Volume
+		wantAdded, wantRemoved               []string
+		wantChanged                          []models.SoftwareChange
+		wantVolumesAdded, wantVolumesRemoved []string
+		wantVolumesChanged                   []models.VolumeChange
+		wantFields                           []models.FieldChange
+	}{
+		{
+			name:         "identical",
+			from:         attributes("WS-0142", "22631"),
+			to:           attributes("WS-0142", "22631"),
+			fromSoftware: []models.Software{sw("7-Zip", "23.01"), sw("Git", "2.44.0")},
+			toSoftware:   []models.Software{sw("Git", "2.44.0"), sw("7-Zip", "23.01")},
+			fromVolumes:  []models.Volume{{Name: "C:", TotalBytes: 500, FreeBytes: 200}},
+			toVolumes:    []models.Volume{{Name: "C:", TotalBytes: 500, FreeBytes: 200}},
+		},
+		{
+			name:         "software added removed and changed",
+			from:         attributes("WS-0142", "22631"),
+			to:           attributes("WS-0142", "22631"),
+			fromSoftware: []models.Software{sw("7-Zip", "23.01"), sw("Git", "2.44.0"), sw("Notepad++", "8.6")},
+			toSoftware:   []models.Software{sw("7-Zip", "24.07"), sw("Git", "2.44.0"), sw("VLC", "3.0.20")},
+			wantAdded:    []string{"VLC 3.0.20"},
+			wantRemoved:  []string{"Notepad++ 8.6"},
+			wantChanged:  []models.SoftwareChange{change("7-Zip", "23.01", "24.07")},
+		},
+		{
+			name:         "several versions of one title",
+			from:         attributes("WS-0142", "22631"),
+			to:           attributes("WS-0142", "22631"),
+			fromSoftware: []models.Software{sw("Visual C++", "14.38"), sw("Visual C++", "12.0"), sw("Visual C++", "11.0")},
+			toSoftware:   []models.Software{sw("Visual C++", "12.0"), sw("Visual C++", "14.40"), sw("Visual C++", "11.1"), sw("Visual C++", "15.0")},
+			wantAdded:    []string{"Visual C++ 15.0"},
+			wantChanged:  []models.SoftwareChange{change("Visual C++", "11.0", "11.1"), change("Visual C++", "14.38", "14.40")},
+		},
+		{
+			name:         "versions paired in version order",
+			from:         attributes("WS-0142", "22631"),
+			to:           attributes("WS-0142", "22631"),
+			fromSoftware: []models.Software{sw("Python", "3.10.4"), sw("Python", "3.9.7")},
+			toSoftware:   []models.Software{sw("Python", "3.9.13"), sw("Python", "3.12.1")},
+			wantChanged:  []models.SoftwareChange{change("Python", "3.9.7", "3.9.13"), change("Python", "3.10.4", "3.12.1")},
+		},
+		{
+			name:               "volume capacity and free space",
+			from:               attributes("WS-0142", "22631"),
+			to:                 attributes("WS-0142", "22631"),
+			fromVolumes:        []models.Volume{{Name: "C:", TotalBytes: 500, FreeBytes: 200}, {Name: "D:", TotalBytes: 1000, FreeBytes: 900}, {Name: "E:", TotalBytes: 64, FreeBytes: 64}},
+			toVolumes:          []models.Volume{{Name: "C:", TotalBytes: 1000, FreeBytes: 650}, {Name: "D:", TotalBytes: 1000, FreeBytes: 850}, {Name: "F:", TotalBytes: 32, FreeBytes: 30}},
+			wantVolumesAdded:   []string{"F:"},
+			wantVolumesRemoved: []string{"E:"},
+			wantVolumesChanged: []models.VolumeChange{
+				{Name: "C:", FromTotalBytes: 500, ToTotalBytes: 1000, FromFreeBytes: 200, ToFreeBytes: 650, FreeBytesDelta: 450},
+				{Name: "D:", FromTotalBytes: 1000, ToTotalBytes: 1000, FromFreeBytes: 900, ToFreeBytes: 850, FreeBytesDelta: -50},
+			},
+		},
+		{
+			name: "os and hardware fields",
+			from: attributes("WS-0142", "22631"),
+			to:   attributes("WS-0142-RENAMED", "22635"),
+			wantFields: []models.FieldChange{
+				{Field: "hostname", From: "WS-0142", To: "WS-0142-RENAMED"},
+				{Field: "os_build", From: "22631", To: "22635"},
+			},
+		},
+		{
+			name: "attributes missing from the older snapshot",
+			from: &models.Snapshot{AgentVersion: "1.3.2"},
+			to:   attributes("WS-0142", "22631"),
+			wantFields: []models.FieldChange{
+				{Field: "agent_version", From: "1.3.2", To: "1.4.0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffSnapshots(tt.from, tt.to, tt.fromSoftware, tt.toSoftware, tt.fromVolumes, tt.toVolumes)
+
+			if got := softwareLabels(diff.SoftwareAdded); !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Errorf("added = %v, want %v", got, tt.wantAdded)
+			}
+			if got := softwareLabels(diff.SoftwareRemoved); !reflect.DeepEqual(got, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", got, tt.wantRemoved)
+			}
+			if len(diff.SoftwareChanged) != 0 || len(tt.wantChanged) != 0 {
+				if !reflect.DeepEqual(diff.SoftwareChanged, tt.wantChanged) {
+					t.Errorf("changed = %+v, want %+v", diff.SoftwareChanged, tt.wantChanged)
+				}
+			}
+			if got := volumeNames(diff.VolumesAdded); !reflect.DeepEqual(got, tt.wantVolumesAdded) {
+				t.Errorf("volumes added = %v, want %v", got, tt.wantVolumesAdded)
+			}
+			if got := volumeNames(diff.VolumesRemoved); !reflect.DeepEqual(got, tt.wantVolumesRemoved) {
+				t.Errorf("volumes removed = %v, want %v", got, tt.wantVolumesRemoved)
+			}
+			if len(diff.VolumesChanged) != 0 || len(tt.wantVolumesChanged) != 0 {
+				if !reflect.DeepEqual(diff.VolumesChanged, tt.wantVolumesChanged) {
+					t.Errorf("volumes changed = %+v, want %+v", diff.VolumesChanged, tt.wantVolumesChanged)
+				}
+			}
+			if len(diff.FieldChanges) != 0 || len(tt.wantFields) != 0 {
+				if !reflect.DeepEqual(diff.FieldChanges, tt.wantFields) {
+					t.Errorf("field changes = %+v, want %+v", diff.FieldChanges, tt.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func softwareLabels(items []models.Software) []string {
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Name+" "+item.Version)
+	}
+	return labels
+}
+
+func volumeNames(volumes []models.Volume) []string {
+	var names []string
+	for _, volume := range volumes {
+		names = append(names, volume.Name)
+	}
+	return names
+}
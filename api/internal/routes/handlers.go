@@ -1,17 +1,34 @@
 package routes
 
 import (
+	"bufio"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
+	"github.com/tracr/api/internal/alerting"
+	"github.com/tracr/api/internal/availability"
+	"github.com/tracr/api/internal/channels"
+	"github.com/tracr/api/internal/commands"
+	"github.com/tracr/api/internal/devicefilter"
+	"github.com/tracr/api/internal/events"
+	"github.com/tracr/api/internal/metrics"
 	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/normalize"
+	"github.com/tracr/api/internal/search"
+	"github.com/tracr/api/internal/webhooks"
 )
 
 // RegisterDevice handles device registration and token generation
@@ -59,12 +76,20 @@ func (h *Handler) RegisterDevice(c *fiber.Ctx) error {
 			FirstSeen:       time.Now().UTC(),
 			LastSeen:        time.Now().UTC(),
 			Status:          models.DeviceStatusActive,
+			StatusReason:    "registered",
 			TokenCreatedAt:  time.Now().UTC(),
 		}
 
 		if err := CreateDevice(h.DB, device); err != nil {
 			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create device")
 		}
+
+		if err := events.Record(h.DB, models.EventDeviceRegistered, &deviceID, fiber.Map{
+			"hostname":   device.Hostname,
+			"os_version": device.OSVersion,
+		}); err != nil {
+			log.Printf("[ERROR] Failed to record device registration: device_id=%s, error=%v", deviceID, err)
+		}
 	}
 
 	response := models.DeviceRegistrationResponse{
@@ -105,28 +130,77 @@ func (h *Handler) SubmitInventory(c *fiber.Ctx) error {
 	}
 
 	if existingSnapshot != nil {
-		// Duplicate snapshot, return existing ID
-		log.Printf("[INFO] Duplicate snapshot detected: device_id=%s, existing_snapshot_id=%s, collected_at=%v", 
-			device.ID, existingSnapshot.ID, existingSnapshot.CollectedAt)
+		// Stable inventory unchanged, record volatile readings as a check-in
+		checkin, err := BuildSnapshotCheckin(existingSnapshot.ID, device.ID, &req)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to build check-in")
+		}
+
+		tx, err := h.DB.Beginx()
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+		}
+		defer tx.Rollback()
+
+		inserted, err := CreateSnapshotCheckin(tx, checkin)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create check-in")
+		}
+
+		// The readings of a replayed submission were recorded with its check-in
+		if inserted {
+			if _, err := metrics.RecordNew(tx, device.ID, metrics.InventorySamples(&req)); err != nil {
+				return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record metrics")
+			}
+
+			if _, err := availability.DetectReboot(tx, device.ID, device.Hostname, req.Identity.BootTime); err != nil {
+				return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to detect reboot")
+			}
+
+			if err := UpdateDeviceCurrentReadings(tx, checkin, req.Volumes); err != nil {
+				return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update current inventory")
+			}
+		}
+
+		if err := TouchDeviceLastSeen(tx, device.ID); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update device information")
+		}
+
+		if err := RecordDeviceCheckin(tx, h.Config, device, device.AgentError); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record check-in")
+		}
+
+		if err := tx.Commit(); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+		}
+
+		log.Printf("[INFO] Duplicate snapshot detected: device_id=%s, existing_snapshot_id=%s, checkin_id=%s, collected_at=%v", 
+			device.ID, existingSnapshot.ID, checkin.ID, checkin.CollectedAt)
+
+		h.evaluateDeviceSmartGroups(device.ID)
+		h.evaluateDevicePolicies(device.ID)
+		h.evaluateDeviceAlerts(device.ID)
+
+		if !inserted {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"snapshot_id": existingSnapshot.ID,
+				"message":     "Inventory unchanged, check-in already recorded",
+			})
+		}
+
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"snapshot_id": existingSnapshot.ID,
-			"message":     "Duplicate snapshot, no changes recorded",
+			"checkin_id":  checkin.ID,
+			"message":     "Inventory unchanged, check-in recorded",
 		})
 	}
 
-	// Begin transaction for atomic operations
-	tx, err := h.DB.Beginx()
-	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
-	}
-	defer tx.Rollback()
-
 	// Create new snapshot
 	snapshotID := uuid.New()
 	snapshot := &models.Snapshot{
 		ID:           snapshotID,
 		DeviceID:     device.ID,
-		CollectedAt:  req.CollectedAt,
+		CollectedAt:  req.CollectedAt.UTC(),
 		AgentVersion: req.AgentVersion,
 		SnapshotHash: snapshotHash,
 	}
@@ -144,6 +218,45 @@ func (h *Handler) SubmitInventory(c *fiber.Ctx) error {
 	snapshot.BootTime = &bt
 	snapshot.LastInteractiveUser = req.Identity.LastInteractiveUser
 
+	// Record identity, OS and hardware attributes for historical comparison
+	snapshot.Hostname = req.Identity.Hostname
+	snapshot.Domain = req.Identity.Domain
+	snapshot.OSCaption = req.OS.Caption
+	snapshot.OSVersion = req.OS.Version
+	snapshot.OSBuild = req.OS.BuildNumber
+	snapshot.Manufacturer = req.Hardware.Manufacturer
+	snapshot.Model = req.Hardware.Model
+	snapshot.SerialNumber = req.Hardware.SerialNumber
+
+	// Begin transaction for atomic operations
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	// Load the previous snapshot's software to derive software change events,
+	// within the transaction so that a concurrent submission cannot slip in
+	var previousSnapshot *models.Snapshot
+	var previousSoftware []models.Software
+	previousSnapshot, err = FindPreviousSnapshot(tx, snapshot)
+	if err != nil && err != sql.ErrNoRows {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	// Normalize both lists with the current rules so that rule changes made
+	// since the previous snapshot do not show up as software changes
+	normalizer, err := LoadNormalizer(tx)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to load normalization rules")
+	}
+	if previousSnapshot != nil {
+		previousSoftware, err = GetSoftwareBySnapshot(tx, previousSnapshot.ID)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		normalizer.Software(previousSoftware)
+	}
+
 	if _, err := CreateSnapshot(tx, snapshot); err != nil {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create snapshot")
 	}
@@ -151,6 +264,22 @@ func (h *Handler) SubmitInventory(c *fiber.Ctx) error {
 	log.Printf("[INFO] Created new snapshot: snapshot_id=%s, hash=%s, collected_at=%v", 
 		snapshotID, snapshotHash, snapshot.CollectedAt)
 
+	// Record the volatile readings of this submission against the new snapshot
+	checkin, err := BuildSnapshotCheckin(snapshotID, device.ID, &req)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to build check-in")
+	}
+	inserted, err := CreateSnapshotCheckin(tx, checkin)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create check-in")
+	}
+
+	if inserted {
+		if _, err := metrics.RecordNew(tx, device.ID, metrics.InventorySamples(&req)); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record metrics")
+		}
+	}
+
 	// Insert volumes
 	if len(req.Volumes) > 0 {
 		if err := CreateVolumes(tx, snapshotID, req.Volumes); err != nil {
@@ -160,16 +289,49 @@ func (h *Handler) SubmitInventory(c *fiber.Ctx) error {
 
 	// Insert software items
 	if len(req.Software) > 0 {
-		if err := CreateSoftwareItems(tx, snapshotID, req.Software); err != nil {
+		if err := CreateSoftwareItems(tx, snapshotID, req.Software, normalizer); err != nil {
 			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create software items")
 		}
 	}
 
+	// Record software changes since the previous snapshot. The first snapshot of
+	// a device is the baseline and produces no events.
+	var softwareEvents []models.SoftwareEvent
+	if previousSnapshot != nil {
+		softwareEvents = BuildSoftwareEvents(previousSnapshot, snapshot, previousSoftware, req.Software)
+		if err := CreateSoftwareEvents(tx, softwareEvents); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record software events")
+		}
+		if len(softwareEvents) > 0 {
+			log.Printf("[INFO] Recorded software events: device_id=%s, snapshot_id=%s, events=%d",
+				device.ID, snapshotID, len(softwareEvents))
+		}
+	}
+
+	if err := RecordSnapshotEvents(tx, snapshot, softwareEvents, len(req.Software), len(req.Volumes)); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record events")
+	}
+
+	if inserted {
+		if _, err := availability.DetectReboot(tx, device.ID, device.Hostname, req.Identity.BootTime); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to detect reboot")
+		}
+	}
+
+	// Make this snapshot the device's current inventory
+	if _, err := ReplaceDeviceCurrentInventory(tx, snapshot, req.Software, req.Volumes); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update current inventory")
+	}
+
 	// Update device information from inventory
 	if err := UpdateDeviceFromInventory(tx, device.ID, &req); err != nil {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update device information")
 	}
 
+	if err := RecordDeviceCheckin(tx, h.Config, device, device.AgentError); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record check-in")
+	}
+
 	log.Printf("[INFO] Updated device information: device_id=%s, hostname=%s, os_version=%s %s, last_seen=%v", 
 		device.ID, req.Identity.Hostname, req.OS.Caption, req.OS.Version, time.Now())
 
@@ -180,40 +342,230 @@ func (h *Handler) SubmitInventory(c *fiber.Ctx) error {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
 	}
 
+	h.evaluateDeviceSmartGroups(device.ID)
+	h.evaluateDevicePolicies(device.ID)
+	h.evaluateDeviceAlerts(device.ID)
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"snapshot_id": snapshotID,
 	})
 }
 
-// Heartbeat updates device last seen timestamp
+// Heartbeat updates device last seen timestamp and the errors reported by its
+// agent. A device whose agent reports errors is moved to the error status.
 func (h *Handler) Heartbeat(c *fiber.Ctx) error {
 	device := c.Locals("device").(*models.Device)
 
-	if err := UpdateDeviceLastSeen(h.DB, device.ID); err != nil {
+	// The body is optional for agents that only report they are alive
+	var req models.HeartbeatRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+		if err := ValidateStruct(req); err != nil {
+			return ValidationErrorResponse(c, err)
+		}
+	}
+	if err := h.recordHeartbeat(device, &req); err != nil {
+		log.Printf("[ERROR] Failed to record heartbeat: device_id=%s, error=%v", device.ID, err)
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update heartbeat")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Heartbeat received",
+		"status":  device.Status,
+	})
+}
+
+// recordHeartbeat stores the errors reported with a heartbeat and records the
+// check-in, for heartbeats sent over HTTP and over the agent channel
+func (h *Handler) recordHeartbeat(device *models.Device, req *models.HeartbeatRequest) error {
+	agentError := strings.Join(req.Errors, "; ")
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := UpdateDeviceHeartbeat(tx, device.ID, agentError); err != nil {
+		return fmt.Errorf("failed to update heartbeat: %w", err)
+	}
+
+	if err := RecordDeviceCheckin(tx, h.Config, device, agentError); err != nil {
+		return fmt.Errorf("failed to record check-in: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	h.evaluateDeviceAlerts(device.ID)
+	return nil
+}
+
+// SubmitPerformanceSamples stores a batch of performance samples from an agent.
+// Samples uploaded before are skipped, so agents can safely retry a batch.
+func (h *Handler) SubmitPerformanceSamples(c *fiber.Ctx) error {
+	device := c.Locals("device").(*models.Device)
+
+	var req models.PerformanceBatchSubmission
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.EndedAt.Before(req.StartedAt) {
+		return ErrorResponse(c, fiber.StatusBadRequest, "ended_at must not be before started_at")
+	}
+
+	for metric := range req.Summaries {
+		if metric != models.MetricCPUPercent && metric != models.MetricMemoryUsedBytes {
+			return ErrorResponse(c, fiber.StatusBadRequest, fmt.Sprintf("Unsupported summary metric: %s", metric))
+		}
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	samples := metrics.BatchSamples(&req)
+	recorded, err := metrics.RecordNew(tx, device.ID, samples)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record metrics")
+	}
+	duplicates := (len(samples) - recorded) / len(metrics.BatchMetrics)
+
+	summaries := metrics.BatchSummaries(device.ID, &req)
+	if err := metrics.CreateBatchSummaries(tx, summaries); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to store metric summaries")
+	}
+
+	if err := TouchDeviceLastSeen(tx, device.ID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update device information")
+	}
+
+	if err := RecordDeviceCheckin(tx, h.Config, device, device.AgentError); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record check-in")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	log.Printf("[INFO] Recorded performance samples: device_id=%s, samples=%d, duplicates=%d, window=%v-%v",
+		device.ID, len(req.Samples), duplicates, req.StartedAt, req.EndedAt)
+
+	h.evaluateDeviceAlerts(device.ID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"samples":    len(req.Samples),
+		"duplicates": duplicates,
+		"summaries":  summaries,
 	})
 }
 
-// PollCommands returns pending commands for the device
+// PollCommands leases the due commands of the device to its agent. With
+// ?wait=30s it holds the request until a command is queued when there is none.
 func (h *Handler) PollCommands(c *fiber.Ctx) error {
 	device := c.Locals("device").(*models.Device)
 
-	// Expire old commands first (older than 5 minutes)
-	if err := ExpireOldCommands(h.DB, device.ID, 5*time.Minute); err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to expire old commands")
+	wait, err := parseCommandWait(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	// Polling counts as a check-in
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := TouchDeviceLastSeen(tx, device.ID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update device information")
+	}
+
+	if err := RecordDeviceCheckin(tx, h.Config, device, device.AgentError); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record check-in")
+	}
+
+	// Take the notification channel before looking for commands so that a
+	// command queued in between still wakes a long poll
+	var queued <-chan struct{}
+	release := func() {}
+	defer func() { release() }()
+	if wait > 0 {
+		queued, release = commands.Queued(device.ID)
 	}
 
-	// Get pending commands
-	commands, err := GetPendingCommands(h.DB, device.ID)
+	leased, err := DeliverCommands(tx, h.Config, device.ID, time.Now().UTC())
 	if err != nil {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve commands")
 	}
 
-	return c.Status(fiber.StatusOK).JSON(commands)
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	// With ?wait, hold the request until a command is queued for the device
+	// or the wait is over
+	if len(leased) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		for len(leased) == 0 {
+			select {
+			case <-queued:
+			case <-timer.C:
+				return c.Status(fiber.StatusOK).JSON(leased)
+			}
+
+			release()
+			queued, release = commands.Queued(device.ID)
+
+			// Commands leased to a client that went away meanwhile are
+			// delivered again once their lease expires
+			tx, err := h.DB.Beginx()
+			if err != nil {
+				return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+			}
+			leased, err = DeliverCommands(tx, h.Config, device.ID, time.Now().UTC())
+			if err != nil {
+				tx.Rollback()
+				return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve commands")
+			}
+			if err := tx.Commit(); err != nil {
+				return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+			}
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(leased)
+}
+
+// maxCommandWait bounds how long a command poll can be held open
+const maxCommandWait = time.Minute
+
+// parseCommandWait reads the optional wait parameter of a command poll
+func parseCommandWait(c *fiber.Ctx) (time.Duration, error) {
+	value := c.Query("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("wait must be a duration such as 30s")
+	}
+	if wait > maxCommandWait {
+		return 0, fmt.Errorf("wait must be at most %s", maxCommandWait)
+	}
+	return wait, nil
 }
 
 // AckCommand acknowledges command execution result
@@ -235,25 +587,15 @@ func (h *Handler) AckCommand(c *fiber.Ctx) error {
 		return ValidationErrorResponse(c, err)
 	}
 
-	// Validate command belongs to this device
-	isValid, err := ValidateCommandOwnership(h.DB, commandID, device.ID)
-	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to validate command ownership")
-	}
-	if !isValid {
+	command, err := h.acknowledgeCommand(device, commandID, &result)
+	if err == sql.ErrNoRows {
 		return ErrorResponse(c, fiber.StatusNotFound, "Command not found")
 	}
-
-	// Determine status based on result
-	var status models.CommandStatus
-	if result.Success {
-		status = models.CommandStatusCompleted
-	} else {
-		status = models.CommandStatusFailed
+	if err == errCommandFinal {
+		return ErrorResponse(c, fiber.StatusConflict, fmt.Sprintf("Command is already %s", command.Status))
 	}
-
-	// Update command with result
-	if err := UpdateCommandStatus(h.DB, commandID, status, &result); err != nil {
+	if err != nil {
+		log.Printf("[ERROR] Failed to acknowledge command: command_id=%s, error=%v", commandID, err)
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update command status")
 	}
 
@@ -262,95 +604,393 @@ func (h *Handler) AckCommand(c *fiber.Ctx) error {
 	})
 }
 
-// Login handles user authentication
-func (h *Handler) Login(c *fiber.Ctx) error {
-	var req models.UserLogin
-	
-	if err := c.BodyParser(&req); err != nil {
-		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
-	}
+// errCommandFinal is returned when a result is reported for a command that
+// already has a final status
+var errCommandFinal = errors.New("command already has a final status")
 
-	if err := ValidateStruct(req); err != nil {
-		return ValidationErrorResponse(c, err)
+// acknowledgeCommand records the result of a command of the device. It returns
+// sql.ErrNoRows when the device has no such command, and errCommandFinal along
+// with the command when the command already has a final status.
+func (h *Handler) acknowledgeCommand(device *models.Device, commandID uuid.UUID, result *models.CommandResult) (*models.Command, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Find user by username
-	user, err := FindUserByUsername(h.DB, req.Username)
+	command, err := FindCommand(tx, commandID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid username or password")
-		}
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		return nil, err
+	}
+	if command.DeviceID != device.ID {
+		return nil, sql.ErrNoRows
+	}
+	if command.IsFinal() {
+		return command, errCommandFinal
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid username or password")
+	if _, err := AcknowledgeCommand(tx, command, result, time.Now().UTC()); err != nil {
+		return nil, err
 	}
 
-	// Generate JWT token
-	token, expiresAt, err := GenerateJWTToken(user, h.Config)
-	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to generate token")
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return command, nil
+}
+
+// Agent channel timings. The API pings the agent on the ping interval and
+// drops the connection when nothing is read for longer than the read timeout.
+const (
+	channelPingInterval = 30 * time.Second
+	channelReadTimeout  = 75 * time.Second
+	channelWriteTimeout = 10 * time.Second
+)
+
+// RequireWebSocketUpgrade rejects requests to the agent channel that are not
+// WebSocket upgrades
+func (h *Handler) RequireWebSocketUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return ErrorResponse(c, fiber.StatusUpgradeRequired, "WebSocket upgrade required")
 	}
+	return c.Next()
+}
 
-	// Set JWT token in cookie
-	c.Cookie(&fiber.Cookie{
-		Name:     "jwt_token",
-		Value:    token,
-		Expires:  expiresAt,
-		HTTPOnly: true,
-		Secure:   true,
-		SameSite: fiber.CookieSameSiteLaxMode,
-	})
+// AgentChannel serves the WebSocket an agent keeps open to receive commands
+// and configuration changes as they happen, and to report heartbeats, command
+// progress and command results. Commands are leased as with PollCommands, so
+// a command lost with a dropped connection is delivered again once its lease
+// expires.
+func (h *Handler) AgentChannel(conn *websocket.Conn) {
+	device := conn.Locals("device").(*models.Device)
 
-	// Return response without password hash
-	userResponse := *user
-	userResponse.PasswordHash = ""
+	ch := channels.Open(device.ID)
+	defer ch.Close()
 
-	response := models.LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
-		User:      userResponse,
+	log.Printf("[INFO] Agent channel opened: device_id=%s", device.ID)
+	defer log.Printf("[INFO] Agent channel closed: device_id=%s", device.ID)
+
+	// Connecting counts as a check-in
+	if err := h.recordChannelCheckin(device); err != nil {
+		log.Printf("[ERROR] Failed to record check-in: device_id=%s, error=%v", device.ID, err)
+		return
 	}
 
-	return c.Status(fiber.StatusOK).JSON(response)
-}
+	conn.SetReadDeadline(time.Now().Add(channelReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(channelReadTimeout))
+	})
+	go h.readAgentChannel(conn, device, ch)
 
-// ListUsers handles user listing with pagination
-func (h *Handler) ListUsers(c *fiber.Ctx) error {
-	// Extract pagination parameters
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "50"))
-	
-	if page < 1 {
-		page = 1
+	// Send the configuration set for the device, which may have changed while
+	// the agent was disconnected
+	config, err := FindDeviceAgentConfig(h.DB, device.ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[ERROR] Failed to load agent configuration: device_id=%s, error=%v", device.ID, err)
+		return
 	}
-	if limit < 1 || limit > 100 {
-		limit = 50
+	if err == nil {
+		if _, err := channels.Send(device.ID, models.ChannelConfig, config.Config); err != nil {
+			log.Printf("[ERROR] Failed to send agent configuration: device_id=%s, error=%v", device.ID, err)
+		}
 	}
-	
-	offset := (page - 1) * limit
 
-	// Get users with pagination
-	users, err := ListUsers(h.DB, offset, limit)
-	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve users")
+	ping := time.NewTicker(channelPingInterval)
+	defer ping.Stop()
+
+	// Only this loop writes to the connection. Commands are delivered when
+	// the channel opens, when one is queued and on every ping, which picks up
+	// commands whose not_before has passed and expired leases.
+	for {
+		if !h.serveAgentChannel(conn, device.ID, ch, ping.C) {
+			return
+		}
 	}
+}
 
-	// Get total count
-	total, err := CountUsers(h.DB)
+// serveAgentChannel delivers the due commands of the device, then waits for
+// the next command, ping or message to write. It returns false once the
+// connection is done.
+func (h *Handler) serveAgentChannel(conn *websocket.Conn, deviceID uuid.UUID, ch *channels.Channel, ping <-chan time.Time) bool {
+	queued, release := commands.Queued(deviceID)
+	defer release()
+
+	leased, err := h.deliverChannelCommands(deviceID)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count users")
+		log.Printf("[ERROR] Failed to deliver commands: device_id=%s, error=%v", deviceID, err)
+		return false
+	}
+	for _, command := range leased {
+		message, err := channels.NewMessage(models.ChannelCommand, command)
+		if err == nil {
+			err = writeChannelMessage(conn, message)
+		}
+		if err != nil {
+			return false
+		}
 	}
 
-	totalPages := (total + limit - 1) / limit
+	select {
+	case <-queued:
+	case <-ping:
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(channelWriteTimeout)); err != nil {
+			return false
+		}
+	case message := <-ch.Outbound():
+		if err := writeChannelMessage(conn, message); err != nil {
+			return false
+		}
+	case <-ch.Done():
+		return false
+	}
+	return true
+}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"data": users,
-		"pagination": fiber.Map{
-			"total":       total,
-			"page":        page,
+// readAgentChannel handles the messages of an agent until the connection
+// fails, then closes the channel. Rejected messages are answered with an
+// error message.
+func (h *Handler) readAgentChannel(conn *websocket.Conn, device *models.Device, ch *channels.Channel) {
+	defer ch.Close()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(channelReadTimeout))
+
+		var message models.ChannelMessage
+		if err = json.Unmarshal(data, &message); err != nil {
+			err = fmt.Errorf("message must be a JSON object with a type and data")
+		} else {
+			err = h.handleChannelMessage(device, message)
+		}
+		if err != nil {
+			if _, err := channels.Send(device.ID, models.ChannelError, fiber.Map{
+				"type":  message.Type,
+				"error": err.Error(),
+			}); err != nil {
+				log.Printf("[ERROR] Failed to send channel error: device_id=%s, error=%v", device.ID, err)
+			}
+		}
+	}
+}
+
+// handleChannelMessage handles a message sent by an agent over its channel
+func (h *Handler) handleChannelMessage(device *models.Device, message models.ChannelMessage) error {
+	switch message.Type {
+	case models.ChannelHeartbeat:
+		var req models.HeartbeatRequest
+		if err := decodeChannelData(message, &req); err != nil {
+			return err
+		}
+		// The device was loaded when the channel opened, so reload it for
+		// its current status
+		current, err := FindDeviceByID(h.DB, device.ID)
+		if err != nil {
+			log.Printf("[ERROR] Failed to load device: device_id=%s, error=%v", device.ID, err)
+			return fmt.Errorf("failed to update heartbeat")
+		}
+		if err := h.recordHeartbeat(current, &req); err != nil {
+			log.Printf("[ERROR] Failed to record heartbeat: device_id=%s, error=%v", device.ID, err)
+			return fmt.Errorf("failed to update heartbeat")
+		}
+
+	case models.ChannelProgress:
+		var progress models.CommandProgress
+		if err := decodeChannelData(message, &progress); err != nil {
+			return err
+		}
+		return h.recordCommandProgress(device, &progress)
+
+	case models.ChannelAck:
+		var ack models.CommandAck
+		if err := decodeChannelData(message, &ack); err != nil {
+			return err
+		}
+		command, err := h.acknowledgeCommand(device, ack.CommandID, &ack.CommandResult)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("command not found")
+		}
+		if err == errCommandFinal {
+			return fmt.Errorf("command is already %s", command.Status)
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to acknowledge command: command_id=%s, error=%v", ack.CommandID, err)
+			return fmt.Errorf("failed to update command status")
+		}
+
+	default:
+		return fmt.Errorf("unknown message type: %s", message.Type)
+	}
+
+	return nil
+}
+
+// recordCommandProgress records a command.progress event for a running
+// command of the device
+func (h *Handler) recordCommandProgress(device *models.Device, progress *models.CommandProgress) error {
+	command, err := FindCommand(h.DB, progress.CommandID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load command")
+	}
+	if err == sql.ErrNoRows || command.DeviceID != device.ID {
+		return fmt.Errorf("command not found")
+	}
+	if command.Status != models.CommandStatusInProgress {
+		return fmt.Errorf("command is %s, not in progress", command.Status)
+	}
+
+	if err := events.Record(h.DB, models.EventCommandProgress, &device.ID, map[string]interface{}{
+		"command_id":   command.ID,
+		"command_type": command.CommandType,
+		"message":      progress.Message,
+		"percent":      progress.Percent,
+	}); err != nil {
+		log.Printf("[ERROR] Failed to record command progress: command_id=%s, error=%v", command.ID, err)
+		return fmt.Errorf("failed to record progress")
+	}
+	return nil
+}
+
+// recordChannelCheckin records the check-in of an agent opening its channel
+func (h *Handler) recordChannelCheckin(device *models.Device) error {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TouchDeviceLastSeen(tx, device.ID); err != nil {
+		return err
+	}
+	if err := RecordDeviceCheckin(tx, h.Config, device, device.AgentError); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deliverChannelCommands leases the due commands of a device to its channel
+func (h *Handler) deliverChannelCommands(deviceID uuid.UUID) ([]models.Command, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	leased, err := DeliverCommands(tx, h.Config, deviceID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return leased, tx.Commit()
+}
+
+// decodeChannelData decodes and validates the data of a channel message. A
+// message without data decodes to the zero value.
+func decodeChannelData(message models.ChannelMessage, v interface{}) error {
+	if len(message.Data) > 0 {
+		if err := json.Unmarshal(message.Data, v); err != nil {
+			return fmt.Errorf("invalid %s data", message.Type)
+		}
+	}
+	return ValidateStruct(v)
+}
+
+// writeChannelMessage writes a message to an agent channel
+func writeChannelMessage(conn *websocket.Conn, message models.ChannelMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(channelWriteTimeout))
+	return conn.WriteJSON(message)
+}
+
+// Login handles user authentication
+func (h *Handler) Login(c *fiber.Ctx) error {
+	var req models.UserLogin
+	
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	// Find user by username
+	user, err := FindUserByUsername(h.DB, req.Username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid username or password")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	// Verify password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid username or password")
+	}
+
+	// Generate JWT token
+	token, expiresAt, err := GenerateJWTToken(user, h.Config)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to generate token")
+	}
+
+	// Set JWT token in cookie
+	c.Cookie(&fiber.Cookie{
+		Name:     "jwt_token",
+		Value:    token,
+		Expires:  expiresAt,
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	// Return response without password hash
+	userResponse := *user
+	userResponse.PasswordHash = ""
+
+	response := models.LoginResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		User:      userResponse,
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// ListUsers handles user listing with pagination
+func (h *Handler) ListUsers(c *fiber.Ctx) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	
+	offset := (page - 1) * limit
+
+	// Get users with pagination
+	users, err := ListUsers(h.DB, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve users")
+	}
+
+	// Get total count
+	total, err := CountUsers(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count users")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": users,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
 			"limit":       limit,
 			"total_pages": totalPages,
 		},
@@ -511,6 +1151,212 @@ func (h *Handler) DeleteUser(c *fiber.Ctx) error {
 
 // ListDevices handles device listing with pagination, search, and filtering
 func (h *Handler) ListDevices(c *fiber.Ctx) error {
+	filter, err := h.parseDeviceFilter(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	return h.respondDevices(c, filter)
+}
+
+// maxDeviceSelection bounds the number of devices exported or changed by a bulk action
+const maxDeviceSelection = 10000
+
+// parseDeviceFilter extracts the search, status, scope and fleet query
+// filters shared by the device list and export
+func (h *Handler) parseDeviceFilter(c *fiber.Ctx) (DeviceFilter, error) {
+	scope, err := h.parseDeviceScope(c)
+	if err != nil {
+		return DeviceFilter{}, err
+	}
+
+	filter := DeviceFilter{
+		Search:      c.Query("search"),
+		Status:      c.Query("status"),
+		DeviceScope: scope,
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		filter.Query, err = devicefilter.Parse(q)
+		if err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// deviceFilterErrorResponse writes the response for an error from parseDeviceFilter
+func deviceFilterErrorResponse(c *fiber.Ctx, err error) error {
+	var syntaxErr *devicefilter.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid query: "+syntaxErr.Error())
+	}
+	if errors.Is(err, errUnknownGroup) {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+	return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+}
+
+// ExportDevices handles exporting the devices matching the list filters as CSV or JSON
+func (h *Handler) ExportDevices(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format", "csv"))
+	if format != "csv" && format != "json" {
+		return ErrorResponse(c, fiber.StatusBadRequest, "format must be one of: csv, json")
+	}
+
+	filter, err := h.parseDeviceFilter(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	total, err := CountDevices(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count devices")
+	}
+	if total > maxDeviceSelection {
+		return ErrorResponse(c, fiber.StatusBadRequest,
+			fmt.Sprintf("Query matches %d devices, exports are limited to %d", total, maxDeviceSelection))
+	}
+
+	devices, err := ListAllDevices(h.DB, filter, maxDeviceSelection)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve devices")
+	}
+
+	items, err := h.buildDeviceListItems(devices)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve current inventory")
+	}
+
+	filename := "devices-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	if format == "json" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"data": items})
+	}
+
+	body, err := DevicesCSV(items)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to encode export")
+	}
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	return c.Status(fiber.StatusOK).Send(body)
+}
+
+// BulkDeviceAction handles applying a tag or static group change to every
+// device matching a fleet query
+func (h *Handler) BulkDeviceAction(c *fiber.Ctx) error {
+	var req models.DeviceBulkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	query, err := devicefilter.Parse(strings.TrimSpace(req.Query))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid query: "+err.Error())
+	}
+
+	var tag string
+	var group *models.DeviceGroup
+	switch req.Action {
+	case models.DeviceBulkAddTag, models.DeviceBulkRemoveTag:
+		tags, err := NormalizeTags([]string{req.Tag})
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+		}
+		tag = tags[0]
+	case models.DeviceBulkAddToGroup, models.DeviceBulkRemoveFromGroup:
+		if req.GroupID == nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "group_id is required for group actions")
+		}
+		group, err = FindDeviceGroupByID(h.DB, *req.GroupID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrorResponse(c, fiber.StatusBadRequest, "Unknown group")
+			}
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if group.Kind == models.DeviceGroupSmart {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Membership of smart groups is computed from their criteria")
+		}
+	}
+
+	filter := DeviceFilter{Query: query}
+	total, err := CountDevices(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count devices")
+	}
+	if total > maxDeviceSelection {
+		return ErrorResponse(c, fiber.StatusBadRequest,
+			fmt.Sprintf("Query matches %d devices, bulk actions are limited to %d", total, maxDeviceSelection))
+	}
+
+	devices, err := ListAllDevices(h.DB, filter, maxDeviceSelection)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve devices")
+	}
+	deviceIDs := make([]uuid.UUID, 0, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.ID)
+	}
+
+	result := models.DeviceBulkResult{
+		Action:  req.Action,
+		Query:   query.Text,
+		Matched: len(deviceIDs),
+		DryRun:  req.DryRun,
+	}
+	if req.DryRun || len(deviceIDs) == 0 {
+		return c.Status(fiber.StatusOK).JSON(result)
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	switch req.Action {
+	case models.DeviceBulkAddTag:
+		result.Changed, err = AddDeviceTag(tx, tag, deviceIDs)
+	case models.DeviceBulkRemoveTag:
+		result.Changed, err = RemoveDeviceTag(tx, tag, deviceIDs)
+	case models.DeviceBulkAddToGroup:
+		result.Changed, err = AddDeviceGroupMembers(tx, group.ID, deviceIDs, MembershipSourceManual)
+	case models.DeviceBulkRemoveFromGroup:
+		result.Changed, err = RemoveDeviceGroupMembers(tx, group.ID, deviceIDs, MembershipSourceManual)
+	}
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to apply bulk action")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	details := fiber.Map{
+		"action":  req.Action,
+		"q":       query.Text,
+		"matched": result.Matched,
+		"changed": result.Changed,
+	}
+	if tag != "" {
+		details["tag"] = tag
+	}
+	if group != nil {
+		details["group_id"] = group.ID
+	}
+	LogAuditAction(h.DB, c, "bulk_device_action", nil, details)
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// respondDevices writes a paginated list of devices matching filter
+func (h *Handler) respondDevices(c *fiber.Ctx, filter DeviceFilter) error {
 	// Extract pagination parameters
 	page, _ := strconv.Atoi(c.Query("page", "1"))
 	limit, _ := strconv.Atoi(c.Query("limit", "50"))
@@ -524,45 +1370,25 @@ func (h *Handler) ListDevices(c *fiber.Ctx) error {
 	
 	offset := (page - 1) * limit
 
-	// Extract optional search and status filters
-	search := c.Query("search")
-	status := c.Query("status")
-
-	log.Printf("[DEBUG] ListDevices request: page=%d, limit=%d, offset=%d, search='%s', status='%s'", 
-		page, limit, offset, search, status)
+	log.Printf("[DEBUG] ListDevices request: page=%d, limit=%d, offset=%d, search='%s', status='%s', tag='%s'", 
+		page, limit, offset, filter.Search, filter.Status, filter.Tag)
 
 	// Get devices with filters and pagination
-	devices, err := ListDevices(h.DB, offset, limit, search, status)
+	devices, err := ListDevices(h.DB, filter, offset, limit)
 	if err != nil {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve devices")
 	}
 
 	log.Printf("[DEBUG] Retrieved %d devices from database", len(devices))
 
-	// Convert to DeviceListItem with computed fields
-	var deviceItems []models.DeviceListItem
-	for _, device := range devices {
-		// Get latest snapshot summary
-		latestSnapshot, _ := GetLatestSnapshotSummary(h.DB, device.ID)
-
-		// Calculate computed fields
-		isOnline := CalculateDeviceOnlineStatus(device.LastSeen)
-		uptimeHours := 0
-		if latestSnapshot != nil && latestSnapshot.BootTime != nil {
-			uptimeHours = CalculateUptimeHours(latestSnapshot.BootTime)
-		}
-
-		deviceItem := models.DeviceListItem{
-			Device:         device,
-			LatestSnapshot: latestSnapshot,
-			IsOnline:       isOnline,
-			UptimeHours:    uptimeHours,
-		}
-		deviceItems = append(deviceItems, deviceItem)
+	// Convert to DeviceListItem with current inventory and computed fields
+	deviceItems, err := h.buildDeviceListItems(devices)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve current inventory")
 	}
 
 	// Get total count
-	total, err := CountDevices(h.DB, search, status)
+	total, err := CountDevices(h.DB, filter)
 	if err != nil {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count devices")
 	}
@@ -598,7 +1424,76 @@ func (h *Handler) ListDevices(c *fiber.Ctx) error {
 	})
 }
 
-// GetDevice handles retrieving a specific device with latest snapshot
+// buildDeviceListItems loads the current inventory and tags of devices and builds their list items
+func (h *Handler) buildDeviceListItems(devices []models.Device) ([]models.DeviceListItem, error) {
+	deviceIDs := make([]uuid.UUID, 0, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.ID)
+	}
+
+	states, err := GetDeviceCurrentStates(h.DB, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := GetDeviceCurrentVolumes(h.DB, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := GetDeviceTags(h.DB, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.DeviceListItem, 0, len(devices))
+	for _, device := range devices {
+		item := BuildDeviceListItem(device, states[device.ID], volumes[device.ID], h.Config.DeviceOfflineAfter)
+		if deviceTags := tags[device.ID]; deviceTags != nil {
+			item.Tags = deviceTags
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// errUnknownGroup is returned when a group filter names a group that does not exist
+var errUnknownGroup = errors.New("Unknown group")
+
+// parseDeviceScope extracts the tag and group filters shared by device and
+// software views. Groups can be given by ID or by name.
+func (h *Handler) parseDeviceScope(c *fiber.Ctx) (DeviceScope, error) {
+	scope := DeviceScope{Tag: strings.TrimSpace(c.Query("tag"))}
+
+	groupParam := strings.TrimSpace(c.Query("group"))
+	if groupParam == "" {
+		return scope, nil
+	}
+
+	group, err := h.findGroupByReference(groupParam)
+	if err != nil {
+		return scope, err
+	}
+
+	scope.GroupID = &group.ID
+	return scope, nil
+}
+
+// findGroupByReference finds a device group by ID or name, returning
+// errUnknownGroup when there is none
+func (h *Handler) findGroupByReference(reference string) (*models.DeviceGroup, error) {
+	var group *models.DeviceGroup
+	var err error
+	if groupID, parseErr := uuid.Parse(reference); parseErr == nil {
+		group, err = FindDeviceGroupByID(h.DB, groupID)
+	} else {
+		group, err = FindDeviceGroupByName(h.DB, reference)
+	}
+	if err == sql.ErrNoRows {
+		return nil, errUnknownGroup
+	}
+	return group, err
+}
+
+// GetDevice handles retrieving a specific device with latest snapshot
 func (h *Handler) GetDevice(c *fiber.Ctx) error {
 	deviceIDStr := c.Params("device_id")
 	deviceID, err := uuid.Parse(deviceIDStr)
@@ -614,24 +1509,13 @@ func (h *Handler) GetDevice(c *fiber.Ctx) error {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
 	}
 
-	// Get latest snapshot summary
-	latestSnapshot, _ := GetLatestSnapshotSummary(h.DB, device.ID)
-
-	// Calculate computed fields
-	isOnline := CalculateDeviceOnlineStatus(device.LastSeen)
-	uptimeHours := 0
-	if latestSnapshot != nil && latestSnapshot.BootTime != nil {
-		uptimeHours = CalculateUptimeHours(latestSnapshot.BootTime)
-	}
-
-	deviceItem := models.DeviceListItem{
-		Device:         *device,
-		LatestSnapshot: latestSnapshot,
-		IsOnline:       isOnline,
-		UptimeHours:    uptimeHours,
+	// Load the device's current inventory and tags
+	deviceItems, err := h.buildDeviceListItems([]models.Device{*device})
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve current inventory")
 	}
 
-	return c.Status(fiber.StatusOK).JSON(deviceItem)
+	return c.Status(fiber.StatusOK).JSON(deviceItems[0])
 }
 
 // ListSnapshots handles listing snapshots for a specific device
@@ -741,8 +1625,11 @@ func (h *Handler) GetSnapshot(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(snapshot)
 }
 
-// CreateCommand handles creating a new command for a device
-func (h *Handler) CreateCommand(c *fiber.Ctx) error {
+// GetSnapshotDiff handles comparing two snapshots of a device. Without query
+// parameters the latest two snapshots are compared; a missing "from" defaults
+// to the snapshot preceding "to" and a missing "to" defaults to the latest.
+// A given "from" must have been collected before "to".
+func (h *Handler) GetSnapshotDiff(c *fiber.Ctx) error {
 	deviceIDStr := c.Params("device_id")
 	deviceID, err := uuid.Parse(deviceIDStr)
 	if err != nil {
@@ -758,45 +1645,100 @@ func (h *Handler) CreateCommand(c *fiber.Ctx) error {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
 	}
 
-	var req models.CommandRequest
-	if err := c.BodyParser(&req); err != nil {
-		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	var from, to *models.Snapshot
+
+	if toStr := c.Query("to"); toStr != "" {
+		toID, err := uuid.Parse(toStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid to parameter")
+		}
+		to, err = FindSnapshotByID(h.DB, toID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrorResponse(c, fiber.StatusNotFound, "Snapshot not found")
+			}
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if to.DeviceID != deviceID {
+			return ErrorResponse(c, fiber.StatusNotFound, "Snapshot not found")
+		}
 	}
 
-	if err := ValidateStruct(req); err != nil {
-		return ValidationErrorResponse(c, err)
+	if fromStr := c.Query("from"); fromStr != "" {
+		fromID, err := uuid.Parse(fromStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid from parameter")
+		}
+		from, err = FindSnapshotByID(h.DB, fromID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrorResponse(c, fiber.StatusNotFound, "Snapshot not found")
+			}
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if from.DeviceID != deviceID {
+			return ErrorResponse(c, fiber.StatusNotFound, "Snapshot not found")
+		}
 	}
 
-	// Validate command type
-	if req.CommandType != models.CommandTypeRefreshNow {
-		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid command type")
+	switch {
+	case from == nil && to == nil:
+		latest, err := FindLatestSnapshots(h.DB, deviceID, 2)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve snapshots")
+		}
+		if len(latest) < 2 {
+			return ErrorResponse(c, fiber.StatusNotFound, "At least two snapshots are required for a diff")
+		}
+		to, from = &latest[0], &latest[1]
+	case from == nil:
+		from, err = FindPreviousSnapshot(h.DB, to)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrorResponse(c, fiber.StatusNotFound, "No earlier snapshot to compare against")
+			}
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+	case to == nil:
+		latest, err := FindLatestSnapshots(h.DB, deviceID, 1)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve snapshots")
+		}
+		if len(latest) == 0 {
+			return ErrorResponse(c, fiber.StatusNotFound, "Snapshot not found")
+		}
+		to = &latest[0]
 	}
 
-	// Create command
-	command := &models.Command{
-		ID:          uuid.New(),
-		DeviceID:    deviceID,
-		CommandType: req.CommandType,
-		Payload:     req.Payload,
-		Status:      models.CommandStatusQueued,
-		CreatedAt:   time.Now().UTC(),
+	if c.Query("from") != "" && !from.CollectedAt.Before(to.CollectedAt) {
+		return ErrorResponse(c, fiber.StatusBadRequest, "from must be a snapshot collected before to")
 	}
 
-	if err := CreateCommand(h.DB, command); err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create command")
+	fromSoftware, err := GetSoftwareBySnapshot(h.DB, from.ID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software")
+	}
+	toSoftware, err := GetSoftwareBySnapshot(h.DB, to.ID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software")
 	}
 
-	// Log audit entry
-	if err := LogAuditAction(h.DB, c, "create_command", &deviceID, command); err != nil {
-		// Log error but don't fail the request
-		// In production, you might want to log this error to monitoring system
+	fromVolumes, err := GetVolumesBySnapshot(h.DB, from.ID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve volumes")
+	}
+	toVolumes, err := GetVolumesBySnapshot(h.DB, to.ID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve volumes")
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(command)
+	diff := DiffSnapshots(from, to, fromSoftware, toSoftware, fromVolumes, toVolumes)
+
+	return c.Status(fiber.StatusOK).JSON(diff)
 }
 
-// ListDeviceCommands handles listing commands for a specific device
-func (h *Handler) ListDeviceCommands(c *fiber.Ctx) error {
+// GetDeviceMetrics handles retrieving metric history for a device
+func (h *Handler) GetDeviceMetrics(c *fiber.Ctx) error {
 	deviceIDStr := c.Params("device_id")
 	deviceID, err := uuid.Parse(deviceIDStr)
 	if err != nil {
@@ -812,93 +1754,103 @@ func (h *Handler) ListDeviceCommands(c *fiber.Ctx) error {
 		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
 	}
 
-	// Extract pagination parameters
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "50"))
-	
-	if page < 1 {
-		page = 1
+	metric := c.Query("metric")
+	if !metrics.ValidMetrics[metric] {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid metric parameter")
 	}
-	if limit < 1 || limit > 100 {
-		limit = 50
+
+	// Default to the last 24 hours
+	to := time.Now().UTC()
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid to parameter, use RFC3339 format")
+		}
+		to = parsed.UTC()
 	}
-	
-	offset := (page - 1) * limit
 
-	// Extract optional status filter
-	status := c.Query("status")
+	from := to.Add(-24 * time.Hour)
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid from parameter, use RFC3339 format")
+		}
+		from = parsed.UTC()
+	}
 
-	// Get commands with pagination and filters
-	commands, err := ListCommandsByDevice(h.DB, deviceID, offset, limit, status)
+	if !from.Before(to) {
+		return ErrorResponse(c, fiber.StatusBadRequest, "from must be before to")
+	}
+
+	var step time.Duration
+	if stepStr := c.Query("step"); stepStr != "" {
+		step, err = time.ParseDuration(stepStr)
+		if err != nil || step < time.Second {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid step parameter, use a duration of at least 1s such as 5m or 1h")
+		}
+	}
+
+	result, err := metrics.Query(h.DB, h.Config, deviceID, metric, from, to, step)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve commands")
+		if errors.Is(err, metrics.ErrTooManyPoints) {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Step is too small for the requested range")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve metrics")
 	}
 
-	// Get total count
-	total, err := CountCommandsByDevice(h.DB, deviceID, status)
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// ListMetricSummaries handles listing the performance batch summaries of a
+// device, newest first, optionally filtered by metric
+func (h *Handler) ListMetricSummaries(c *fiber.Ctx) error {
+	deviceIDStr := c.Params("device_id")
+	deviceID, err := uuid.Parse(deviceIDStr)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count commands")
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
 	}
 
-	totalPages := (total + limit - 1) / limit
+	// Verify device exists
+	_, err = FindDeviceByID(h.DB, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"data": commands,
-		"pagination": fiber.Map{
-			"total":       total,
-			"page":        page,
-			"limit":       limit,
-			"total_pages": totalPages,
-		},
-	})
-}
+	metric := c.Query("metric")
+	if metric != "" && metric != models.MetricCPUPercent && metric != models.MetricMemoryUsedBytes {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid metric parameter")
+	}
 
-// ListSoftwareCatalog handles listing software catalog with aggregation
-func (h *Handler) ListSoftwareCatalog(c *fiber.Ctx) error {
 	// Extract pagination parameters
 	page, _ := strconv.Atoi(c.Query("page", "1"))
 	limit, _ := strconv.Atoi(c.Query("limit", "50"))
-	
+
 	if page < 1 {
 		page = 1
 	}
 	if limit < 1 || limit > 100 {
 		limit = 50
 	}
-	
-	offset := (page - 1) * limit
-
-	// Extract optional filters
-	search := c.Query("search")
-	publisher := c.Query("publisher")
-	sortBy := c.Query("sort", "device_count")
 
-	// Validate sort parameter
-	validSorts := map[string]bool{
-		"name":         true,
-		"device_count": true,
-		"latest_seen":  true,
-	}
-	if !validSorts[sortBy] {
-		sortBy = "device_count"
-	}
+	offset := (page - 1) * limit
 
-	// Get software catalog with filters and pagination
-	catalog, err := ListSoftwareCatalog(h.DB, offset, limit, search, publisher, sortBy)
+	summaries, err := metrics.ListBatchSummaries(h.DB, deviceID, metric, offset, limit)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software catalog")
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve metric summaries")
 	}
 
-	// Get total count
-	total, err := CountSoftwareCatalog(h.DB, search, publisher)
+	total, err := metrics.CountBatchSummaries(h.DB, deviceID, metric)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count software items")
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count metric summaries")
 	}
 
 	totalPages := (total + limit - 1) / limit
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"data": catalog,
+		"data": summaries,
 		"pagination": fiber.Map{
 			"total":       total,
 			"page":        page,
@@ -908,75 +1860,270 @@ func (h *Handler) ListSoftwareCatalog(c *fiber.Ctx) error {
 	})
 }
 
-// ListAuditLogs handles listing audit logs with filtering
-func (h *Handler) ListAuditLogs(c *fiber.Ctx) error {
-	// Extract pagination parameters
-	page, _ := strconv.Atoi(c.Query("page", "1"))
-	limit, _ := strconv.Atoi(c.Query("limit", "50"))
-	
-	if page < 1 {
-		page = 1
+// CreateCommand handles creating a new command for a device
+func (h *Handler) CreateCommand(c *fiber.Ctx) error {
+	deviceIDStr := c.Params("device_id")
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
 	}
-	if limit < 1 || limit > 100 {
-		limit = 50
+
+	// Verify device exists
+	_, err = FindDeviceByID(h.DB, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
 	}
-	
-	offset := (page - 1) * limit
 
-	// Extract optional filter parameters
-	var userID, deviceID *uuid.UUID
-	var startDate, endDate *time.Time
+	var req models.CommandRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
 
-	if userIDStr := c.Query("user_id"); userIDStr != "" {
-		parsed, err := uuid.Parse(userIDStr)
-		if err != nil {
-			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid user_id parameter")
-		}
-		userID = &parsed
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
 	}
 
-	if deviceIDStr := c.Query("device_id"); deviceIDStr != "" {
-		parsed, err := uuid.Parse(deviceIDStr)
-		if err != nil {
-			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device_id parameter")
-		}
-		deviceID = &parsed
+	definition, payload, username, fiberErr := h.prepareCommand(c, &req)
+	if fiberErr != nil {
+		return ErrorResponse(c, fiberErr.Code, fiberErr.Message)
 	}
 
-	action := c.Query("action")
+	// Create command
+	command := BuildCommand(h.Config, definition, deviceID, &req, payload, time.Now().UTC())
 
-	if startDateStr := c.Query("start_date"); startDateStr != "" {
-		parsed, err := time.Parse(time.RFC3339, startDateStr)
-		if err != nil {
-			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid start_date parameter, use RFC3339 format")
-		}
-		startDate = &parsed
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
 	}
+	defer tx.Rollback()
 
-	if endDateStr := c.Query("end_date"); endDateStr != "" {
-		parsed, err := time.Parse(time.RFC3339, endDateStr)
-		if err != nil {
-			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid end_date parameter, use RFC3339 format")
+	if err := CreateCommand(tx, command, fmt.Sprintf("created by %s", username)); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create command")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+	commands.NotifyQueued(deviceID)
+
+	// Log audit entry
+	if err := LogAuditAction(h.DB, c, "create_command", &deviceID, command); err != nil {
+		// Log error but don't fail the request
+		// In production, you might want to log this error to monitoring system
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(command)
+}
+
+// prepareCommand looks up the command type of a request, checks that the
+// user's role may send it and validates the payload. It returns the definition,
+// the normalized payload and the user's name, or the error to respond with.
+func (h *Handler) prepareCommand(c *fiber.Ctx, req *models.CommandRequest) (commands.Definition, json.RawMessage, string, *fiber.Error) {
+	definition, ok := commands.Lookup(req.CommandType)
+	if !ok {
+		known := []string{}
+		for _, d := range commands.Definitions() {
+			known = append(known, string(d.Type))
 		}
-		endDate = &parsed
+		return definition, nil, "", fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("Invalid command type, must be one of: %s", strings.Join(known, ", ")))
 	}
 
-	// Get audit logs with filters and pagination
-	auditLogs, err := ListAuditLogs(h.DB, offset, limit, userID, deviceID, action, startDate, endDate)
+	_, username, role, err := ExtractUserFromContext(c)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve audit logs")
+		return definition, nil, "", fiber.NewError(fiber.StatusUnauthorized, "User not authenticated")
+	}
+	if !role.Satisfies(definition.RequiredRole) {
+		return definition, nil, "", fiber.NewError(fiber.StatusForbidden,
+			fmt.Sprintf("The %s role is required to send %s commands", definition.RequiredRole, definition.Type))
 	}
 
-	// Get total count
-	total, err := CountAuditLogs(h.DB, userID, deviceID, action, startDate, endDate)
+	payload, err := definition.ValidatePayload(req.Payload)
 	if err != nil {
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count audit logs")
+		return definition, nil, "", fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
-	totalPages := (total + limit - 1) / limit
+	return definition, payload, username, nil
+}
+
+// CreateCommandBatch sends a command to a set of devices, creating one command
+// per device. Devices are listed in device_ids, or selected by group, fleet
+// query or both. With dry_run, only the number of matching devices is returned.
+func (h *Handler) CreateCommandBatch(c *fiber.Ctx) error {
+	var req models.CommandBatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	definition, payload, username, fiberErr := h.prepareCommand(c, &req.CommandRequest)
+	if fiberErr != nil {
+		return ErrorResponse(c, fiberErr.Code, fiberErr.Message)
+	}
+
+	target, deviceIDs, fiberErr := h.resolveBatchDevices(&req)
+	if fiberErr != nil {
+		return ErrorResponse(c, fiberErr.Code, fiberErr.Message)
+	}
+
+	if req.DryRun {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"target":  target,
+			"matched": len(deviceIDs),
+			"dry_run": true,
+		})
+	}
+	if len(deviceIDs) == 0 {
+		return ErrorResponse(c, fiber.StatusBadRequest, "No devices match the batch target")
+	}
+
+	now := time.Now().UTC()
+	batch := &models.CommandBatch{
+		ID:          uuid.New(),
+		CommandType: req.CommandType,
+		Payload:     payload,
+		Target:      target,
+		CreatedBy:   username,
+		CreatedAt:   now,
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := CreateCommandBatch(tx, batch); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create command batch")
+	}
+
+	reason := fmt.Sprintf("created by %s in batch %s", username, batch.ID)
+	for _, deviceID := range deviceIDs {
+		command := BuildCommand(h.Config, definition, deviceID, &req.CommandRequest, payload, now)
+		command.BatchID = &batch.ID
+		if err := CreateCommand(tx, command, reason); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create command")
+		}
+		batch.Counts.Add(command.Status, 1)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+	for _, deviceID := range deviceIDs {
+		commands.NotifyQueued(deviceID)
+	}
+
+	log.Printf("[INFO] Created command batch: batch_id=%s, command_type=%s, devices=%d",
+		batch.ID, batch.CommandType, len(deviceIDs))
+
+	LogAuditAction(h.DB, c, "create_command_batch", nil, batch)
+
+	return c.Status(fiber.StatusCreated).JSON(batch)
+}
+
+// resolveBatchDevices returns the target of a batch request and the IDs of the
+// devices it selects, or the error to respond with
+func (h *Handler) resolveBatchDevices(req *models.CommandBatchRequest) (models.CommandBatchTarget, []uuid.UUID, *fiber.Error) {
+	var target models.CommandBatchTarget
+	groupRef := strings.TrimSpace(req.Group)
+	queryText := strings.TrimSpace(req.Query)
+
+	if len(req.DeviceIDs) > 0 {
+		if groupRef != "" || queryText != "" {
+			return target, nil, fiber.NewError(fiber.StatusBadRequest, "device_ids cannot be combined with group or q")
+		}
+
+		result, found, err := h.resolveMembershipDevices(req.DeviceIDs)
+		if err != nil {
+			return target, nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to look up devices")
+		}
+		if len(result.NotFound) > 0 {
+			return target, nil, fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("Device not found: %s", result.NotFound[0]))
+		}
+		target.DeviceIDs = found
+		return target, found, nil
+	}
+
+	if groupRef == "" && queryText == "" {
+		return target, nil, fiber.NewError(fiber.StatusBadRequest, "device_ids, group or q is required")
+	}
+
+	var filter DeviceFilter
+	if groupRef != "" {
+		group, err := h.findGroupByReference(groupRef)
+		if errors.Is(err, errUnknownGroup) {
+			return target, nil, fiber.NewError(fiber.StatusBadRequest, errUnknownGroup.Error())
+		}
+		if err != nil {
+			return target, nil, fiber.NewError(fiber.StatusInternalServerError, "Database error")
+		}
+		filter.GroupID = &group.ID
+		target.GroupID = &group.ID
+	}
+	if queryText != "" {
+		query, err := devicefilter.Parse(queryText)
+		if err != nil {
+			return target, nil, fiber.NewError(fiber.StatusBadRequest, "Invalid query: "+err.Error())
+		}
+		filter.Query = query
+		target.Query = query.Text
+	}
+
+	total, err := CountDevices(h.DB, filter)
+	if err != nil {
+		return target, nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to count devices")
+	}
+	if total > maxDeviceSelection {
+		return target, nil, fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("Target matches %d devices, command batches are limited to %d", total, maxDeviceSelection))
+	}
+
+	devices, err := ListAllDevices(h.DB, filter, maxDeviceSelection)
+	if err != nil {
+		return target, nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to retrieve devices")
+	}
+	deviceIDs := make([]uuid.UUID, 0, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.ID)
+	}
+	return target, deviceIDs, nil
+}
+
+// ListCommandBatches lists command batches, newest first, with the counts of
+// their commands by status
+func (h *Handler) ListCommandBatches(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	batches, err := ListCommandBatches(h.DB, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve command batches")
+	}
+
+	total, err := CountCommandBatches(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count command batches")
+	}
+
+	totalPages := (total + limit - 1) / limit
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"data": auditLogs,
+		"data": batches,
 		"pagination": fiber.Map{
 			"total":       total,
 			"page":        page,
@@ -986,55 +2133,2879 @@ func (h *Handler) ListAuditLogs(c *fiber.Ctx) error {
 	})
 }
 
-// DeleteDevice handles device deletion
-func (h *Handler) DeleteDevice(c *fiber.Ctx) error {
+// GetCommandBatch returns a command batch with the counts of its commands and
+// the command sent to each device, optionally limited to a ?status
+func (h *Handler) GetCommandBatch(c *fiber.Ctx) error {
+	batchID, err := uuid.Parse(c.Params("batch_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid batch ID")
+	}
+
+	status := c.Query("status")
+	switch models.CommandStatus(status) {
+	case "", models.CommandStatusQueued, models.CommandStatusInProgress, models.CommandStatusCompleted,
+		models.CommandStatusFailed, models.CommandStatusExpired:
+	default:
+		return ErrorResponse(c, fiber.StatusBadRequest,
+			"Invalid status, must be one of: queued, in_progress, completed, failed, expired")
+	}
+
+	batch, err := FindCommandBatch(h.DB, batchID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Command batch not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	batchCommands, err := ListCommandBatchCommands(h.DB, batchID, status)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve batch commands")
+	}
+
+	return c.JSON(models.CommandBatchDetail{
+		CommandBatch: *batch,
+		Commands:     batchCommands,
+	})
+}
+
+// ListCommandTypes lists the command types that can be sent to devices with
+// the schema of their payload, so that clients can build forms for them
+func (h *Handler) ListCommandTypes(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"data": commands.Definitions(),
+	})
+}
+
+// ListDeviceCommands handles listing commands for a specific device
+func (h *Handler) ListDeviceCommands(c *fiber.Ctx) error {
 	deviceIDStr := c.Params("device_id")
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	// Verify device exists
+	_, err = FindDeviceByID(h.DB, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
 	
-	var device *models.Device
-	var deviceID uuid.UUID
-	var err error
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
 	
-	// Try to parse as UUID first
-	if parsedID, parseErr := uuid.Parse(deviceIDStr); parseErr == nil {
-		// Valid UUID, find device by ID
-		deviceID = parsedID
-		device, err = FindDeviceByID(h.DB, deviceID)
-		if err != nil {
+	offset := (page - 1) * limit
+
+	// Extract optional status filter
+	status := c.Query("status")
+
+	// Get commands with pagination and filters
+	commands, err := ListCommandsByDevice(h.DB, deviceID, offset, limit, status)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve commands")
+	}
+
+	// Get total count
+	total, err := CountCommandsByDevice(h.DB, deviceID, status)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count commands")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": commands,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// GetDeviceCommand returns a command of a device with its status history
+func (h *Handler) GetDeviceCommand(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	commandID, err := uuid.Parse(c.Params("command_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid command ID")
+	}
+
+	command, err := FindCommand(h.DB, commandID)
+	if err != nil && err != sql.ErrNoRows {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	if err == sql.ErrNoRows || command.DeviceID != deviceID {
+		return ErrorResponse(c, fiber.StatusNotFound, "Command not found")
+	}
+
+	history, err := ListCommandStatusHistory(h.DB, commandID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve command history")
+	}
+
+	return c.JSON(models.CommandDetail{
+		Command: *command,
+		History: history,
+	})
+}
+
+// GetDeviceAgentConfig returns the agent configuration set for a device and
+// whether its agent is connected over the agent channel
+func (h *Handler) GetDeviceAgentConfig(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	if _, err := FindDeviceByID(h.DB, deviceID); err != nil {
+		if err == sql.ErrNoRows {
 			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
 		}
-	} else {
-		// Not a valid UUID, treat as hostname
-		log.Printf("[INFO] Device ID %s is not a valid UUID, looking up by hostname", deviceIDStr)
-		device, err = FindDeviceByHostname(h.DB, deviceIDStr)
-		if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	config, err := FindDeviceAgentConfig(h.DB, deviceID)
+	if err == sql.ErrNoRows {
+		// Nothing set, the agent keeps its own configuration
+		config = &models.DeviceAgentConfig{DeviceID: deviceID}
+	} else if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve agent configuration")
+	}
+	config.Connected = channels.Connected(deviceID)
+
+	return c.JSON(config)
+}
+
+// UpdateDeviceAgentConfig sets the agent configuration of a device and pushes
+// it to the agent when it is connected over the agent channel. Otherwise the
+// agent receives it the next time it connects.
+func (h *Handler) UpdateDeviceAgentConfig(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	if _, err := FindDeviceByID(h.DB, deviceID); err != nil {
+		if err == sql.ErrNoRows {
 			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
 		}
-		deviceID = device.ID
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
 	}
-	
-	if device == nil {
-		return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+
+	var req models.AgentConfig
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
-	// Delete device (this will cascade delete related records)
-	if err := DeleteDevice(h.DB, deviceID); err != nil {
-		log.Printf("[ERROR] Failed to delete device %s: %v", deviceID, err)
-		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete device")
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
 	}
 
-	// Log the deletion
-	log.Printf("[INFO] Device deleted: %s (%s)", device.Hostname, deviceID)
+	if err := ValidateAgentConfig(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
 
-	// Log audit event
-	LogAuditAction(h.DB, c, "delete_device", &deviceID, fiber.Map{
-		"hostname": device.Hostname,
-	})
+	_, username, _, err := ExtractUserFromContext(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid user context")
+	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"success": true,
-		"message": fmt.Sprintf("Device %s deleted successfully", device.Hostname),
+	now := time.Now().UTC()
+	config := &models.DeviceAgentConfig{
+		DeviceID:  deviceID,
+		Config:    req,
+		UpdatedAt: &now,
+		UpdatedBy: username,
+	}
+
+	if err := SetDeviceAgentConfig(h.DB, config); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to save agent configuration")
+	}
+
+	LogAuditAction(h.DB, c, "update_agent_config", &deviceID, req)
+
+	delivered, err := channels.Send(deviceID, models.ChannelConfig, req)
+	if err != nil {
+		log.Printf("[ERROR] Failed to push agent configuration: device_id=%s, error=%v", deviceID, err)
+	}
+	config.Connected = channels.Connected(deviceID)
+
+	return c.JSON(fiber.Map{
+		"config":    config,
+		"delivered": delivered,
 	})
 }
+
+// ListSoftwareCatalog handles listing software catalog with aggregation
+func (h *Handler) ListSoftwareCatalog(c *fiber.Ctx) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	
+	offset := (page - 1) * limit
+
+	// Extract optional filters
+	search := c.Query("search")
+	publisher := c.Query("publisher")
+	sortBy := c.Query("sort", "device_count")
+
+	// Validate sort parameter
+	validSorts := map[string]bool{
+		"name":         true,
+		"device_count": true,
+		"latest_seen":  true,
+		"version":      true,
+	}
+	if !validSorts[sortBy] {
+		sortBy = "device_count"
+	}
+
+	// Limit the catalog to the devices of a tag or group
+	scope, err := h.parseDeviceScope(c)
+	if err != nil {
+		if errors.Is(err, errUnknownGroup) {
+			return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	// Get software catalog with filters and pagination
+	catalog, err := ListSoftwareCatalog(h.DB, offset, limit, search, publisher, sortBy, scope)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software catalog")
+	}
+
+	// Get total count
+	total, err := CountSoftwareCatalog(h.DB, search, publisher, scope)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count software items")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": catalog,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// ListSoftwareDevices handles listing the devices that currently have a
+// software title installed, optionally restricted to a version range
+func (h *Handler) ListSoftwareDevices(c *fiber.Ctx) error {
+	name := c.Query("name")
+	if name == "" {
+		return ErrorResponse(c, fiber.StatusBadRequest, "name parameter is required")
+	}
+	publisher := c.Query("publisher")
+
+	constraints, err := parseVersionConstraints(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+
+	// Resolve version constraints to the installed versions that satisfy them
+	var versions []string
+	if len(constraints) > 0 {
+		installed, err := ListSoftwareTitleVersions(h.DB, name, publisher)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software versions")
+		}
+		versions = []string{}
+		for _, version := range installed {
+			matches := true
+			for _, constraint := range constraints {
+				if !constraint.Matches(version) {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				versions = append(versions, version)
+			}
+		}
+	}
+
+	items := []models.SoftwareDeviceItem{}
+	total := 0
+	if versions == nil || len(versions) > 0 {
+		items, err = ListSoftwareDevices(h.DB, name, publisher, versions, offset, limit)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software devices")
+		}
+
+		total, err = CountSoftwareDevices(h.DB, name, publisher, versions)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count software devices")
+		}
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": items,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// ListOutdatedSoftware handles listing the software titles some devices run
+// an older version of than the newest one installed in the fleet, with the
+// devices running each outdated version. Titles can be filtered by search and
+// publisher and are ordered by the number of outdated devices.
+func (h *Handler) ListOutdatedSoftware(c *fiber.Ctx) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+
+	// Extract optional filters
+	search := c.Query("search")
+	publisher := c.Query("publisher")
+
+	rows, err := ListSoftwareVersionCounts(h.DB, search, publisher)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software versions")
+	}
+
+	outdated := BuildOutdatedSoftware(rows)
+	total := len(outdated)
+
+	if offset >= len(outdated) {
+		outdated = outdated[:0]
+	} else {
+		outdated = outdated[offset:]
+	}
+	if len(outdated) > limit {
+		outdated = outdated[:limit]
+	}
+
+	// Attach the devices running outdated versions of each title on this page
+	for i := range outdated {
+		item := &outdated[i]
+		outdatedVersions := make([]string, 0, len(item.OutdatedVersions))
+		for _, count := range item.OutdatedVersions {
+			outdatedVersions = append(outdatedVersions, count.Version)
+		}
+
+		// A negative limit returns every matching device
+		devices, err := ListSoftwareDevices(h.DB, item.Name, item.Publisher, outdatedVersions, 0, -1)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve outdated devices")
+		}
+		item.Devices = devices
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": outdated,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// parseVersionConstraints collects the version filters of a request. Besides
+// version=118.0 and version=<120.0, operators may be written directly in the
+// query string (version<120.0, version>=118), in which case they end up in the
+// parameter name.
+func parseVersionConstraints(c *fiber.Ctx) ([]VersionConstraint, error) {
+	var constraints []VersionConstraint
+	var parseErr error
+
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		name := string(key)
+		if parseErr != nil || !strings.HasPrefix(name, "version") {
+			return
+		}
+
+		expr := strings.TrimPrefix(name, "version")
+		if expr != "" && !strings.ContainsRune("<>=!", rune(expr[0])) {
+			return
+		}
+		if len(value) > 0 {
+			expr += "=" + string(value)
+		}
+		// version=<120.0 carries the operator in the value
+		if strings.HasPrefix(expr, "=") && len(expr) > 1 && strings.ContainsRune("<>=!", rune(expr[1])) {
+			expr = expr[1:]
+		}
+
+		constraint, err := ParseVersionConstraint(expr)
+		if err != nil {
+			parseErr = fmt.Errorf("invalid version filter: %v", err)
+			return
+		}
+		constraints = append(constraints, constraint)
+	})
+
+	return constraints, parseErr
+}
+
+// ListDeviceSoftwareEvents handles listing the software installs, uninstalls
+// and version changes recorded for a device
+func (h *Handler) ListDeviceSoftwareEvents(c *fiber.Ctx) error {
+	deviceIDStr := c.Params("device_id")
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	// Verify device exists
+	_, err = FindDeviceByID(h.DB, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	filter, err := parseSoftwareEventFilter(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+	filter.DeviceID = &deviceID
+
+	return h.respondSoftwareEvents(c, filter)
+}
+
+// ListSoftwareEvents handles listing software events across all devices
+func (h *Handler) ListSoftwareEvents(c *fiber.Ctx) error {
+	filter, err := parseSoftwareEventFilter(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	return h.respondSoftwareEvents(c, filter)
+}
+
+// parseSoftwareEventFilter extracts the name, publisher, type and since filters shared by software event lists
+func parseSoftwareEventFilter(c *fiber.Ctx) (SoftwareEventFilter, error) {
+	filter := SoftwareEventFilter{
+		Name:      c.Query("name"),
+		Publisher: c.Query("publisher"),
+		EventType: c.Query("type"),
+	}
+
+	switch models.SoftwareEventType(filter.EventType) {
+	case "", models.SoftwareEventInstall, models.SoftwareEventUninstall, models.SoftwareEventUpgrade, models.SoftwareEventDowngrade:
+	default:
+		return filter, fmt.Errorf("invalid type parameter, use install, uninstall, upgrade or downgrade")
+	}
+
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since parameter, use RFC3339 format")
+		}
+		since := parsed.UTC()
+		filter.Since = &since
+	}
+
+	return filter, nil
+}
+
+// respondSoftwareEvents writes a paginated list of software events matching filter
+func (h *Handler) respondSoftwareEvents(c *fiber.Ctx, filter SoftwareEventFilter) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+
+	events, err := ListSoftwareEvents(h.DB, filter, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve software events")
+	}
+
+	total, err := CountSoftwareEvents(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count software events")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": events,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// ListNormalizationRules returns all software normalization rules in the order they are applied
+func (h *Handler) ListNormalizationRules(c *fiber.Ctx) error {
+	rules, err := ListNormalizationRules(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve normalization rules")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": rules,
+	})
+}
+
+// CreateNormalizationRule adds a software normalization rule. New rules only
+// affect later inventory submissions until the backfill job is run.
+func (h *Handler) CreateNormalizationRule(c *fiber.Ctx) error {
+	var req models.NormalizationRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.Field == nil || req.MatchType == nil || req.Pattern == nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "field, match_type and pattern are required")
+	}
+
+	now := time.Now().UTC()
+	rule := &models.NormalizationRule{
+		ID:        uuid.New(),
+		Priority:  100,
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	applyNormalizationRuleRequest(rule, &req)
+
+	if err := ValidateNormalizationRule(rule); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := CreateNormalizationRule(h.DB, rule); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create normalization rule")
+	}
+
+	LogAuditAction(h.DB, c, "create_normalization_rule", nil, rule)
+
+	return c.Status(fiber.StatusCreated).JSON(rule)
+}
+
+// UpdateNormalizationRule changes the provided fields of a software normalization rule
+func (h *Handler) UpdateNormalizationRule(c *fiber.Ctx) error {
+	ruleID, err := uuid.Parse(c.Params("rule_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid rule ID")
+	}
+
+	var req models.NormalizationRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	rule, err := FindNormalizationRuleByID(h.DB, ruleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Normalization rule not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	previous := *rule
+
+	applyNormalizationRuleRequest(rule, &req)
+	rule.UpdatedAt = time.Now().UTC()
+
+	if err := ValidateNormalizationRule(rule); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := UpdateNormalizationRule(h.DB, rule); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update normalization rule")
+	}
+
+	LogAuditAction(h.DB, c, "update_normalization_rule", nil, fiber.Map{
+		"before": previous,
+		"after":  rule,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(rule)
+}
+
+// DeleteNormalizationRule removes a software normalization rule
+func (h *Handler) DeleteNormalizationRule(c *fiber.Ctx) error {
+	ruleID, err := uuid.Parse(c.Params("rule_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid rule ID")
+	}
+
+	rule, err := FindNormalizationRuleByID(h.DB, ruleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Normalization rule not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	if err := DeleteNormalizationRule(h.DB, ruleID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete normalization rule")
+	}
+
+	LogAuditAction(h.DB, c, "delete_normalization_rule", nil, rule)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": "Normalization rule deleted successfully",
+	})
+}
+
+// PreviewNormalization shows how a software name and publisher are normalized,
+// either by the stored rules or by the rules given in the request
+func (h *Handler) PreviewNormalization(c *fiber.Ctx) error {
+	var req models.NormalizationPreviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	rules := req.Rules
+	if rules == nil {
+		var err error
+		rules, err = ListNormalizationRules(h.DB)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve normalization rules")
+		}
+	} else {
+		// Proposed rules are all applied, in priority order
+		for i := range rules {
+			rules[i].Enabled = true
+			if err := ValidateNormalizationRule(&rules[i]); err != nil {
+				return ErrorResponse(c, fiber.StatusBadRequest, fmt.Sprintf("rules[%d]: %v", i, err))
+			}
+		}
+		SortNormalizationRules(rules)
+	}
+
+	normalizer, err := normalize.Compile(rules)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.NormalizationPreviewResult{
+		RawName:             req.Name,
+		RawPublisher:        req.Publisher,
+		NormalizedName:      normalizer.Apply(models.NormalizationFieldName, req.Name),
+		NormalizedPublisher: normalizer.Apply(models.NormalizationFieldPublisher, req.Publisher),
+	})
+}
+
+// StartNormalizationBackfill re-applies the current rules to all stored software in the background
+func (h *Handler) StartNormalizationBackfill(c *fiber.Ctx) error {
+	normalizer, err := LoadNormalizer(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to load normalization rules")
+	}
+
+	status, err := h.NormalizationBackfill.Start(h.DB, normalizer)
+	if err != nil {
+		if errors.Is(err, normalize.ErrBackfillRunning) {
+			return ErrorResponse(c, fiber.StatusConflict, "Normalization backfill already running")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to start normalization backfill")
+	}
+
+	LogAuditAction(h.DB, c, "start_normalization_backfill", nil, fiber.Map{
+		"started_at": status.StartedAt,
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(status)
+}
+
+// GetNormalizationBackfill reports the progress of the latest normalization backfill
+func (h *Handler) GetNormalizationBackfill(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.NormalizationBackfill.Status())
+}
+
+// ListDeviceGroups handles listing device groups with member counts
+func (h *Handler) ListDeviceGroups(c *fiber.Ctx) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+	search := c.Query("search")
+
+	groups, err := ListDeviceGroups(h.DB, search, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve groups")
+	}
+
+	total, err := CountDeviceGroups(h.DB, search)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count groups")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": groups,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// CreateDeviceGroup handles device group creation
+func (h *Handler) CreateDeviceGroup(c *fiber.Ctx) error {
+	var req models.DeviceGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		return ErrorResponse(c, fiber.StatusBadRequest, "name is required")
+	}
+
+	now := time.Now().UTC()
+	group := &models.DeviceGroup{
+		ID:        uuid.New(),
+		Name:      strings.TrimSpace(*req.Name),
+		Kind:      models.DeviceGroupStatic,
+		Criteria:  req.Criteria,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.Description != nil {
+		group.Description = *req.Description
+	}
+	if req.Kind != nil {
+		group.Kind = *req.Kind
+	}
+	if err := ValidateDeviceGroup(group); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	// Check if the group name is already taken
+	existing, err := FindDeviceGroupByName(h.DB, group.Name)
+	if err != nil && err != sql.ErrNoRows {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	if existing != nil {
+		return ErrorResponse(c, fiber.StatusConflict, "Group name already exists")
+	}
+
+	if err := CreateDeviceGroup(h.DB, group); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create group")
+	}
+
+	LogAuditAction(h.DB, c, "create_group", nil, group)
+
+	if group.Kind == models.DeviceGroupSmart {
+		group, err = h.evaluateSmartGroup(group)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate group membership")
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(group)
+}
+
+// GetDeviceGroup handles retrieving a specific device group
+func (h *Handler) GetDeviceGroup(c *fiber.Ctx) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(group)
+}
+
+// UpdateDeviceGroup handles renaming a device group or changing its description
+func (h *Handler) UpdateDeviceGroup(c *fiber.Ctx) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+
+	var req models.DeviceGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return ErrorResponse(c, fiber.StatusBadRequest, "name cannot be empty")
+		}
+		existing, err := FindDeviceGroupByName(h.DB, name)
+		if err != nil && err != sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if existing != nil && existing.ID != group.ID {
+			return ErrorResponse(c, fiber.StatusConflict, "Group name already exists")
+		}
+		group.Name = name
+	}
+	if req.Description != nil {
+		group.Description = *req.Description
+	}
+	if req.Kind != nil && *req.Kind != group.Kind {
+		return ErrorResponse(c, fiber.StatusBadRequest, "kind cannot be changed")
+	}
+	if req.Criteria != nil {
+		group.Criteria = req.Criteria
+	}
+	if err := ValidateDeviceGroup(group); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+	group.UpdatedAt = time.Now().UTC()
+
+	if err := UpdateDeviceGroup(h.DB, group); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update group")
+	}
+
+	LogAuditAction(h.DB, c, "update_group", nil, group)
+
+	if req.Criteria != nil {
+		group, err = h.evaluateSmartGroup(group)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate group membership")
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(group)
+}
+
+// DeleteDeviceGroup handles device group deletion. Member devices are not affected.
+func (h *Handler) DeleteDeviceGroup(c *fiber.Ctx) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+
+	if err := DeleteDeviceGroup(h.DB, group.ID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete group")
+	}
+
+	LogAuditAction(h.DB, c, "delete_group", nil, fiber.Map{
+		"group_id": group.ID,
+		"name":     group.Name,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": fmt.Sprintf("Group %s deleted successfully", group.Name),
+	})
+}
+
+// ListDeviceGroupDevices handles listing the member devices of a group
+func (h *Handler) ListDeviceGroupDevices(c *fiber.Ctx) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+
+	filter := DeviceFilter{
+		Search:      c.Query("search"),
+		Status:      c.Query("status"),
+		DeviceScope: DeviceScope{Tag: strings.TrimSpace(c.Query("tag")), GroupID: &group.ID},
+	}
+
+	return h.respondDevices(c, filter)
+}
+
+// AddDeviceGroupMembers handles adding devices to a group in bulk
+func (h *Handler) AddDeviceGroupMembers(c *fiber.Ctx) error {
+	return h.changeGroupMembership(c, true)
+}
+
+// RemoveDeviceGroupMembers handles removing devices from a group in bulk
+func (h *Handler) RemoveDeviceGroupMembers(c *fiber.Ctx) error {
+	return h.changeGroupMembership(c, false)
+}
+
+// EvaluateDeviceGroup handles re-computing the membership of a smart group
+func (h *Handler) EvaluateDeviceGroup(c *fiber.Ctx) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+	if group.Kind != models.DeviceGroupSmart {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Only smart groups can be evaluated")
+	}
+
+	group, err = h.evaluateSmartGroup(group)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate group membership")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(group)
+}
+
+// ListDeviceGroupHistory handles listing when devices entered or left a group
+func (h *Handler) ListDeviceGroupHistory(c *fiber.Ctx) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+
+	filter := GroupMembershipFilter{GroupID: &group.ID}
+	if deviceIDStr := c.Query("device_id"); deviceIDStr != "" {
+		deviceID, err := uuid.Parse(deviceIDStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+		}
+		filter.DeviceID = &deviceID
+	}
+
+	return h.respondGroupMembershipHistory(c, filter)
+}
+
+// ListDeviceGroupMembershipHistory handles listing the groups a device entered or left
+func (h *Handler) ListDeviceGroupMembershipHistory(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	if _, err := FindDeviceByID(h.DB, deviceID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	return h.respondGroupMembershipHistory(c, GroupMembershipFilter{DeviceID: &deviceID})
+}
+
+// ListDeviceStatusHistory handles listing the status changes of a device, newest first
+func (h *Handler) ListDeviceStatusHistory(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	if _, err := FindDeviceByID(h.DB, deviceID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	changes, err := ListDeviceStatusHistory(h.DB, deviceID, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve status history")
+	}
+
+	total, err := CountDeviceStatusHistory(h.DB, deviceID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count status history")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": changes,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": (total + limit - 1) / limit,
+		},
+	})
+}
+
+// GetDeviceAvailability handles reporting when a device was online within a
+// time range, defaulting to the last 7 days, along with the reboots in it
+func (h *Handler) GetDeviceAvailability(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	device, err := FindDeviceByID(h.DB, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	from, to, err := parseAvailabilityRange(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	// Intervals ending up to the offline threshold before the range may still be ongoing
+	intervals, err := availability.Intervals(h.DB, []uuid.UUID{deviceID}, from.Add(-h.Config.DeviceOfflineAfter), to)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve availability")
+	}
+
+	reboots, err := availability.Reboots(h.DB, []uuid.UUID{deviceID}, from, to)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve reboots")
+	}
+
+	now := time.Now().UTC()
+	observed, online, uptime := availability.Summarize(intervals, from, to, device.FirstSeen, now, h.Config.DeviceOfflineAfter)
+
+	// Report the intervals clipped to the range, ongoing ones lasting until now
+	clipped := make([]models.AvailabilityInterval, 0, len(intervals))
+	for _, interval := range intervals {
+		if availability.Ongoing(interval, now, h.Config.DeviceOfflineAfter) {
+			interval.Ongoing = true
+			interval.EndedAt = now
+		}
+		if interval.EndedAt.Before(from) {
+			continue
+		}
+		if interval.StartedAt.Before(from) {
+			interval.StartedAt = from
+		}
+		if interval.EndedAt.After(to) {
+			interval.EndedAt = to
+		}
+		clipped = append(clipped, interval)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.DeviceAvailability{
+		DeviceID:        deviceID,
+		From:            from,
+		To:              to,
+		ObservedSeconds: int64(observed / time.Second),
+		OnlineSeconds:   int64(online / time.Second),
+		UptimePercent:   uptime,
+		Intervals:       clipped,
+		Reboots:         reboots,
+	})
+}
+
+// GetFleetAvailability handles ranking the devices matching the device list
+// filters by availability within a time range, least available first
+func (h *Handler) GetFleetAvailability(c *fiber.Ctx) error {
+	filter, err := h.parseDeviceFilter(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	from, to, err := parseAvailabilityRange(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	devices, err := ListAllDevices(h.DB, filter, maxDeviceSelection)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve devices")
+	}
+
+	intervals, err := availability.Intervals(h.DB, nil, from.Add(-h.Config.DeviceOfflineAfter), to)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve availability")
+	}
+	byDevice := make(map[uuid.UUID][]models.AvailabilityInterval)
+	for _, interval := range intervals {
+		byDevice[interval.DeviceID] = append(byDevice[interval.DeviceID], interval)
+	}
+
+	reboots, err := availability.Reboots(h.DB, nil, from, to)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve reboots")
+	}
+	rebootCounts := make(map[uuid.UUID]int)
+	for _, reboot := range reboots {
+		rebootCounts[reboot.DeviceID]++
+	}
+
+	now := time.Now().UTC()
+	var totalObserved, totalOnline time.Duration
+	summaries := make([]models.DeviceAvailabilitySummary, 0, len(devices))
+	for _, device := range devices {
+		observed, online, uptime := availability.Summarize(byDevice[device.ID], from, to, device.FirstSeen, now, h.Config.DeviceOfflineAfter)
+		if uptime == nil {
+			continue
+		}
+		totalObserved += observed
+		totalOnline += online
+		summaries = append(summaries, models.DeviceAvailabilitySummary{
+			DeviceID:        device.ID,
+			Hostname:        device.Hostname,
+			Status:          device.Status,
+			LastSeen:        device.LastSeen,
+			ObservedSeconds: int64(observed / time.Second),
+			OnlineSeconds:   int64(online / time.Second),
+			UptimePercent:   uptime,
+			Reboots:         rebootCounts[device.ID],
+		})
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if *summaries[i].UptimePercent != *summaries[j].UptimePercent {
+			return *summaries[i].UptimePercent < *summaries[j].UptimePercent
+		}
+		return summaries[i].Hostname < summaries[j].Hostname
+	})
+	evaluated := len(summaries)
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.FleetAvailability{
+		From:             from,
+		To:               to,
+		DevicesEvaluated: evaluated,
+		UptimePercent:    availability.Percent(totalOnline, totalObserved),
+		Devices:          summaries,
+		GeneratedAt:      now,
+	})
+}
+
+// parseAvailabilityRange extracts the from and to parameters of availability
+// reports, defaulting to the last 7 days
+func parseAvailabilityRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return to, to, fmt.Errorf("invalid to parameter, use RFC3339 format")
+		}
+		to = parsed.UTC()
+	}
+
+	from := to.Add(-7 * 24 * time.Hour)
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from parameter, use RFC3339 format")
+		}
+		from = parsed.UTC()
+	}
+
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// respondGroupMembershipHistory writes a paginated list of membership changes matching filter
+func (h *Handler) respondGroupMembershipHistory(c *fiber.Ctx, filter GroupMembershipFilter) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+
+	events, err := ListGroupMembershipHistory(h.DB, filter, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve membership history")
+	}
+
+	total, err := CountGroupMembershipHistory(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count membership history")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": events,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// evaluateSmartGroup recomputes the members of a smart group from its criteria
+// and returns the reloaded group
+func (h *Handler) evaluateSmartGroup(group *models.DeviceGroup) (*models.DeviceGroup, error) {
+	deviceIDs, err := FindDevicesMatchingCriteria(h.DB, *group.Criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	joined, left, err := SyncDeviceGroupMembers(h.DB, group.ID, deviceIDs, MembershipSourceCriteria)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] Evaluated smart group: group_id=%s, name=%s, members=%d, joined=%d, left=%d",
+		group.ID, group.Name, len(deviceIDs), joined, left)
+
+	return FindDeviceGroupByID(h.DB, group.ID)
+}
+
+// evaluateDeviceSmartGroups updates the smart group memberships of a device
+// after an inventory submission. Failures are logged and do not affect the
+// submission.
+func (h *Handler) evaluateDeviceSmartGroups(deviceID uuid.UUID) {
+	joined, left, err := EvaluateDeviceSmartGroups(h.DB, deviceID)
+	if err != nil {
+		log.Printf("[ERROR] Failed to evaluate smart groups: device_id=%s, error=%v", deviceID, err)
+		return
+	}
+	if joined > 0 || left > 0 {
+		log.Printf("[INFO] Updated smart group membership: device_id=%s, joined=%d, left=%d", deviceID, joined, left)
+	}
+}
+
+func (h *Handler) changeGroupMembership(c *fiber.Ctx, add bool) error {
+	group, err := h.findGroupParam(c)
+	if err != nil || group == nil {
+		return err
+	}
+	if group.Kind == models.DeviceGroupSmart {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Membership of smart groups is computed from their criteria")
+	}
+
+	var req models.DeviceMembershipRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	result, found, err := h.resolveMembershipDevices(req.DeviceIDs)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	action := "add_group_members"
+	if add {
+		result.Changed, err = AddDeviceGroupMembers(tx, group.ID, found, MembershipSourceManual)
+	} else {
+		action = "remove_group_members"
+		result.Changed, err = RemoveDeviceGroupMembers(tx, group.ID, found, MembershipSourceManual)
+	}
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update group members")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	LogAuditAction(h.DB, c, action, nil, fiber.Map{
+		"group_id":   group.ID,
+		"device_ids": found,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// findGroupParam loads the group named by the group_id route parameter. On
+// failure it writes the error response and returns a nil group.
+func (h *Handler) findGroupParam(c *fiber.Ctx) (*models.DeviceGroup, error) {
+	groupID, err := uuid.Parse(c.Params("group_id"))
+	if err != nil {
+		return nil, ErrorResponse(c, fiber.StatusBadRequest, "Invalid group ID")
+	}
+
+	group, err := FindDeviceGroupByID(h.DB, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorResponse(c, fiber.StatusNotFound, "Group not found")
+		}
+		return nil, ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	return group, nil
+}
+
+// resolveMembershipDevices splits requested device IDs into registered devices
+// and unknown IDs, dropping duplicates
+func (h *Handler) resolveMembershipDevices(deviceIDs []uuid.UUID) (models.DeviceMembershipResult, []uuid.UUID, error) {
+	result := models.DeviceMembershipResult{
+		Requested: len(deviceIDs),
+		NotFound:  []uuid.UUID{},
+	}
+
+	existing, err := FindExistingDeviceIDs(h.DB, deviceIDs)
+	if err != nil {
+		return result, nil, err
+	}
+
+	seen := make(map[uuid.UUID]bool, len(deviceIDs))
+	found := make([]uuid.UUID, 0, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		if seen[deviceID] {
+			continue
+		}
+		seen[deviceID] = true
+		if existing[deviceID] {
+			found = append(found, deviceID)
+		} else {
+			result.NotFound = append(result.NotFound, deviceID)
+		}
+	}
+	return result, found, nil
+}
+
+// ListTags handles listing the tags in use with their device counts
+func (h *Handler) ListTags(c *fiber.Ctx) error {
+	tags, err := ListTags(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve tags")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": tags,
+	})
+}
+
+// AddDeviceTag handles tagging devices in bulk
+func (h *Handler) AddDeviceTag(c *fiber.Ctx) error {
+	return h.changeTagAssignment(c, true)
+}
+
+// RemoveDeviceTag handles removing a tag from devices in bulk
+func (h *Handler) RemoveDeviceTag(c *fiber.Ctx) error {
+	return h.changeTagAssignment(c, false)
+}
+
+func (h *Handler) changeTagAssignment(c *fiber.Ctx, add bool) error {
+	tagParam, err := url.PathUnescape(c.Params("tag"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid tag")
+	}
+	tags, err := NormalizeTags([]string{tagParam})
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+	tag := tags[0]
+
+	var req models.DeviceMembershipRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	result, found, err := h.resolveMembershipDevices(req.DeviceIDs)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	action := "add_device_tag"
+	if add {
+		result.Changed, err = AddDeviceTag(tx, tag, found)
+	} else {
+		action = "remove_device_tag"
+		result.Changed, err = RemoveDeviceTag(tx, tag, found)
+	}
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update tags")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	LogAuditAction(h.DB, c, action, nil, fiber.Map{
+		"tag":        tag,
+		"device_ids": found,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// SetDeviceTags handles replacing all tags of a device
+func (h *Handler) SetDeviceTags(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	var req models.DeviceTagsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	tags, err := NormalizeTags(req.Tags)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if _, err := FindDeviceByID(h.DB, deviceID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := ReplaceDeviceTags(tx, deviceID, tags); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update tags")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to commit transaction")
+	}
+
+	LogAuditAction(h.DB, c, "set_device_tags", &deviceID, fiber.Map{
+		"tags": tags,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"device_id": deviceID,
+		"tags":      tags,
+	})
+}
+
+// ListAuditLogs handles listing audit logs with filtering
+func (h *Handler) ListAuditLogs(c *fiber.Ctx) error {
+	// Extract pagination parameters
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	
+	offset := (page - 1) * limit
+
+	// Extract optional filter parameters
+	var userID, deviceID *uuid.UUID
+	var startDate, endDate *time.Time
+
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		parsed, err := uuid.Parse(userIDStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid user_id parameter")
+		}
+		userID = &parsed
+	}
+
+	if deviceIDStr := c.Query("device_id"); deviceIDStr != "" {
+		parsed, err := uuid.Parse(deviceIDStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device_id parameter")
+		}
+		deviceID = &parsed
+	}
+
+	action := c.Query("action")
+
+	if startDateStr := c.Query("start_date"); startDateStr != "" {
+		parsed, err := time.Parse(time.RFC3339, startDateStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid start_date parameter, use RFC3339 format")
+		}
+		startDate = &parsed
+	}
+
+	if endDateStr := c.Query("end_date"); endDateStr != "" {
+		parsed, err := time.Parse(time.RFC3339, endDateStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid end_date parameter, use RFC3339 format")
+		}
+		endDate = &parsed
+	}
+
+	// Get audit logs with filters and pagination
+	auditLogs, err := ListAuditLogs(h.DB, offset, limit, userID, deviceID, action, startDate, endDate)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve audit logs")
+	}
+
+	// Get total count
+	total, err := CountAuditLogs(h.DB, userID, deviceID, action, startDate, endDate)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count audit logs")
+	}
+
+	totalPages := (total + limit - 1) / limit
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": auditLogs,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": totalPages,
+		},
+	})
+}
+
+// DeleteDevice handles device deletion
+func (h *Handler) DeleteDevice(c *fiber.Ctx) error {
+	deviceIDStr := c.Params("device_id")
+	
+	var device *models.Device
+	var deviceID uuid.UUID
+	var err error
+	
+	// Try to parse as UUID first
+	if parsedID, parseErr := uuid.Parse(deviceIDStr); parseErr == nil {
+		// Valid UUID, find device by ID
+		deviceID = parsedID
+		device, err = FindDeviceByID(h.DB, deviceID)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+	} else {
+		// Not a valid UUID, treat as hostname
+		log.Printf("[INFO] Device ID %s is not a valid UUID, looking up by hostname", deviceIDStr)
+		device, err = FindDeviceByHostname(h.DB, deviceIDStr)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		deviceID = device.ID
+	}
+	
+	if device == nil {
+		return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+	}
+
+	// Delete device (this will cascade delete related records)
+	if err := DeleteDevice(h.DB, deviceID); err != nil {
+		log.Printf("[ERROR] Failed to delete device %s: %v", deviceID, err)
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete device")
+	}
+
+	// Log the deletion
+	log.Printf("[INFO] Device deleted: %s (%s)", device.Hostname, deviceID)
+
+	// Remove software sets that were only referenced by this device's snapshots
+	if removed, err := DeleteOrphanedSoftwareSets(h.DB); err != nil {
+		log.Printf("[ERROR] Failed to delete orphaned software sets: %v", err)
+	} else if removed > 0 {
+		log.Printf("[INFO] Deleted %d orphaned software sets", removed)
+	}
+
+	// Log audit event
+	LogAuditAction(h.DB, c, "delete_device", &deviceID, fiber.Map{
+		"hostname": device.Hostname,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success": true,
+		"message": fmt.Sprintf("Device %s deleted successfully", device.Hostname),
+	})
+}
+
+// ListPolicies handles listing compliance policies with their pass and fail
+// counts, optionally limited to a tag or group of devices
+func (h *Handler) ListPolicies(c *fiber.Ctx) error {
+	scope, err := h.parseDeviceScope(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	policies, err := ListPolicies(h.DB, scope)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve policies")
+	}
+	for i := range policies {
+		policies[i].CompliancePercent = CompliancePercent(policies[i].Passing, policies[i].Failing)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": policies,
+	})
+}
+
+// CreatePolicy handles creating a compliance policy. Enabled policies are
+// evaluated against every device right away.
+func (h *Handler) CreatePolicy(c *fiber.Ctx) error {
+	var req models.PolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.Name == nil || req.RuleType == nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "name and rule_type are required")
+	}
+
+	now := time.Now().UTC()
+	p := &models.Policy{
+		ID:        uuid.New(),
+		Severity:  models.PolicySeverityMedium,
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	applyPolicyRequest(p, &req)
+
+	if err := ValidatePolicy(p); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	existing, err := FindPolicyByName(h.DB, p.Name)
+	if err != nil && err != sql.ErrNoRows {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	if existing != nil {
+		return ErrorResponse(c, fiber.StatusConflict, "Policy name already exists")
+	}
+
+	if err := CreatePolicy(h.DB, p); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create policy")
+	}
+
+	LogAuditAction(h.DB, c, "create_policy", nil, p)
+
+	return h.respondEvaluatedPolicy(c, fiber.StatusCreated, p)
+}
+
+// GetPolicy handles retrieving a compliance policy with its pass and fail counts
+func (h *Handler) GetPolicy(c *fiber.Ctx) error {
+	p, err := h.findPolicyParam(c)
+	if err != nil || p == nil {
+		return err
+	}
+
+	p.CompliancePercent = CompliancePercent(p.Passing, p.Failing)
+	return c.Status(fiber.StatusOK).JSON(p)
+}
+
+// UpdatePolicy handles changing the provided fields of a compliance policy.
+// Enabled policies are re-evaluated, disabled ones lose their results.
+func (h *Handler) UpdatePolicy(c *fiber.Ctx) error {
+	var req models.PolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	p, err := h.findPolicyParam(c)
+	if err != nil || p == nil {
+		return err
+	}
+	previous := *p
+
+	applyPolicyRequest(p, &req)
+	p.UpdatedAt = time.Now().UTC()
+
+	if err := ValidatePolicy(p); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if !strings.EqualFold(p.Name, previous.Name) {
+		existing, err := FindPolicyByName(h.DB, p.Name)
+		if err != nil && err != sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if existing != nil {
+			return ErrorResponse(c, fiber.StatusConflict, "Policy name already exists")
+		}
+	}
+
+	if err := UpdatePolicy(h.DB, p); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update policy")
+	}
+
+	LogAuditAction(h.DB, c, "update_policy", nil, fiber.Map{
+		"before": previous,
+		"after":  p,
+	})
+
+	if !p.Enabled {
+		if err := DeletePolicyResults(h.DB, p.ID); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to clear policy results")
+		}
+	}
+
+	return h.respondEvaluatedPolicy(c, fiber.StatusOK, p)
+}
+
+// DeletePolicy handles removing a compliance policy and its results
+func (h *Handler) DeletePolicy(c *fiber.Ctx) error {
+	p, err := h.findPolicyParam(c)
+	if err != nil || p == nil {
+		return err
+	}
+
+	if err := DeletePolicy(h.DB, p.ID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete policy")
+	}
+
+	LogAuditAction(h.DB, c, "delete_policy", nil, fiber.Map{
+		"policy_id": p.ID,
+		"name":      p.Name,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Policy deleted successfully",
+	})
+}
+
+// respondEvaluatedPolicy evaluates an enabled policy against every device and
+// writes the policy with its fresh counts
+func (h *Handler) respondEvaluatedPolicy(c *fiber.Ctx, status int, p *models.Policy) error {
+	if p.Enabled {
+		if _, err := EvaluatePolicies(h.DB, []models.Policy{*p}, nil); err != nil {
+			log.Printf("[ERROR] Failed to evaluate policy: policy_id=%s, error=%v", p.ID, err)
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate policy")
+		}
+	}
+
+	evaluated, err := FindPolicyByID(h.DB, p.ID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve policy")
+	}
+	evaluated.CompliancePercent = CompliancePercent(evaluated.Passing, evaluated.Failing)
+
+	return c.Status(status).JSON(evaluated)
+}
+
+// findPolicyParam loads the policy named by the policy_id route parameter. On
+// failure it writes the error response and returns a nil policy.
+func (h *Handler) findPolicyParam(c *fiber.Ctx) (*models.Policy, error) {
+	policyID, err := uuid.Parse(c.Params("policy_id"))
+	if err != nil {
+		return nil, ErrorResponse(c, fiber.StatusBadRequest, "Invalid policy ID")
+	}
+
+	p, err := FindPolicyByID(h.DB, policyID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorResponse(c, fiber.StatusNotFound, "Policy not found")
+		}
+		return nil, ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	return p, nil
+}
+
+// ListPolicyResults handles listing the per-device results of a policy,
+// failures first, optionally filtered by outcome, tag or group
+func (h *Handler) ListPolicyResults(c *fiber.Ctx) error {
+	p, err := h.findPolicyParam(c)
+	if err != nil || p == nil {
+		return err
+	}
+
+	scope, err := h.parseDeviceScope(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	filter := PolicyResultFilter{PolicyID: &p.ID, DeviceScope: scope}
+	if passedParam := c.Query("passed"); passedParam != "" {
+		passed, err := strconv.ParseBool(passedParam)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "passed must be true or false")
+		}
+		filter.Passed = &passed
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	results, err := ListPolicyResults(h.DB, filter, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve policy results")
+	}
+
+	total, err := CountPolicyResults(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count policy results")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": results,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": (total + limit - 1) / limit,
+		},
+	})
+}
+
+// GetDeviceCompliance handles retrieving the results of every enabled policy for a device
+func (h *Handler) GetDeviceCompliance(c *fiber.Ctx) error {
+	deviceID, err := uuid.Parse(c.Params("device_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+	}
+
+	device, err := FindDeviceByID(h.DB, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Device not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	filter := PolicyResultFilter{DeviceID: &deviceID}
+	total, err := CountPolicyResults(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count policy results")
+	}
+	results, err := ListPolicyResults(h.DB, filter, 0, total)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve policy results")
+	}
+
+	compliance := models.DeviceCompliance{
+		DeviceID: device.ID,
+		Hostname: device.Hostname,
+		Results:  results,
+	}
+	for _, result := range results {
+		if result.Passed {
+			compliance.Passing++
+		} else {
+			compliance.Failing++
+		}
+	}
+	compliance.Compliant = len(results) > 0 && compliance.Failing == 0
+
+	return c.Status(fiber.StatusOK).JSON(compliance)
+}
+
+// GetFleetCompliance handles summarizing compliance across the fleet, or
+// across a tag or group of devices
+func (h *Handler) GetFleetCompliance(c *fiber.Ctx) error {
+	scope, err := h.parseDeviceScope(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	policies, err := ListPolicies(h.DB, scope)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve policies")
+	}
+
+	enabled := make([]models.Policy, 0, len(policies))
+	for _, p := range policies {
+		if !p.Enabled {
+			continue
+		}
+		p.CompliancePercent = CompliancePercent(p.Passing, p.Failing)
+		enabled = append(enabled, p)
+	}
+
+	evaluated, compliant, err := CountCompliantDevices(h.DB, scope)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count compliant devices")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.FleetCompliance{
+		DevicesEvaluated:  evaluated,
+		CompliantDevices:  compliant,
+		CompliancePercent: CompliancePercent(compliant, evaluated-compliant),
+		Policies:          enabled,
+		GeneratedAt:       time.Now().UTC(),
+	})
+}
+
+// EvaluateAllPolicies handles re-evaluating every enabled policy against the
+// current inventory of every device
+func (h *Handler) EvaluateAllPolicies(c *fiber.Ctx) error {
+	policies, err := ListEnabledPolicies(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve policies")
+	}
+
+	result, err := EvaluatePolicies(h.DB, policies, nil)
+	if err != nil {
+		log.Printf("[ERROR] Failed to evaluate policies: %v", err)
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate policies")
+	}
+
+	LogAuditAction(h.DB, c, "evaluate_policies", nil, result)
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// evaluateDevicePolicies evaluates the enabled policies against the current
+// inventory of a device after an inventory submission. Failures are logged
+// and do not affect the submission.
+func (h *Handler) evaluateDevicePolicies(deviceID uuid.UUID) {
+	policies, err := ListEnabledPolicies(h.DB)
+	if err == nil {
+		_, err = EvaluatePolicies(h.DB, policies, []uuid.UUID{deviceID})
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to evaluate policies: device_id=%s, error=%v", deviceID, err)
+	}
+}
+
+// ListAlertRules handles listing alert rules with their open alert counts
+func (h *Handler) ListAlertRules(c *fiber.Ctx) error {
+	rules, err := ListAlertRules(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve alert rules")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": rules,
+	})
+}
+
+// CreateAlertRule handles creating an alert rule. Enabled rules are evaluated
+// against every device right away.
+func (h *Handler) CreateAlertRule(c *fiber.Ctx) error {
+	var req models.AlertRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.Name == nil || req.RuleType == nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "name and rule_type are required")
+	}
+
+	now := time.Now().UTC()
+	rule := &models.AlertRule{
+		ID:        uuid.New(),
+		Severity:  models.AlertSeverityWarning,
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	applyAlertRuleRequest(rule, &req)
+
+	if err := ValidateAlertRule(rule); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	existing, err := FindAlertRuleByName(h.DB, rule.Name)
+	if err != nil && err != sql.ErrNoRows {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	if existing != nil {
+		return ErrorResponse(c, fiber.StatusConflict, "Alert rule name already exists")
+	}
+
+	if err := CreateAlertRule(h.DB, rule); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create alert rule")
+	}
+
+	LogAuditAction(h.DB, c, "create_alert_rule", nil, rule)
+
+	return h.respondEvaluatedAlertRule(c, fiber.StatusCreated, rule)
+}
+
+// GetAlertRule handles retrieving an alert rule with its open alert count
+func (h *Handler) GetAlertRule(c *fiber.Ctx) error {
+	rule, err := h.findAlertRuleParam(c)
+	if err != nil || rule == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(rule)
+}
+
+// UpdateAlertRule handles changing the provided fields of an alert rule.
+// Enabled rules are re-evaluated, which resolves alerts that no longer apply,
+// and disabling a rule resolves all of its open alerts.
+func (h *Handler) UpdateAlertRule(c *fiber.Ctx) error {
+	var req models.AlertRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	rule, err := h.findAlertRuleParam(c)
+	if err != nil || rule == nil {
+		return err
+	}
+	previous := *rule
+
+	applyAlertRuleRequest(rule, &req)
+	rule.UpdatedAt = time.Now().UTC()
+
+	if err := ValidateAlertRule(rule); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if !strings.EqualFold(rule.Name, previous.Name) {
+		existing, err := FindAlertRuleByName(h.DB, rule.Name)
+		if err != nil && err != sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if existing != nil {
+			return ErrorResponse(c, fiber.StatusConflict, "Alert rule name already exists")
+		}
+	}
+
+	if err := UpdateAlertRule(h.DB, rule); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update alert rule")
+	}
+
+	LogAuditAction(h.DB, c, "update_alert_rule", nil, fiber.Map{
+		"before": previous,
+		"after":  rule,
+	})
+
+	if !rule.Enabled {
+		if _, err := alerting.ResolveRuleAlerts(h.DB, *rule); err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to resolve alerts")
+		}
+	}
+
+	return h.respondEvaluatedAlertRule(c, fiber.StatusOK, rule)
+}
+
+// DeleteAlertRule handles removing an alert rule and its alerts
+func (h *Handler) DeleteAlertRule(c *fiber.Ctx) error {
+	rule, err := h.findAlertRuleParam(c)
+	if err != nil || rule == nil {
+		return err
+	}
+
+	if err := DeleteAlertRule(h.DB, rule.ID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete alert rule")
+	}
+
+	LogAuditAction(h.DB, c, "delete_alert_rule", nil, fiber.Map{
+		"rule_id": rule.ID,
+		"name":    rule.Name,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Alert rule deleted successfully",
+	})
+}
+
+// respondEvaluatedAlertRule evaluates an enabled alert rule against every
+// device and writes the rule with its fresh open alert count
+func (h *Handler) respondEvaluatedAlertRule(c *fiber.Ctx, status int, rule *models.AlertRule) error {
+	if rule.Enabled {
+		if _, err := alerting.EvaluateRules(h.DB, []models.AlertRule{*rule}, nil); err != nil {
+			log.Printf("[ERROR] Failed to evaluate alert rule: rule_id=%s, error=%v", rule.ID, err)
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate alert rule")
+		}
+	}
+
+	evaluated, err := FindAlertRuleByID(h.DB, rule.ID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve alert rule")
+	}
+
+	return c.Status(status).JSON(evaluated)
+}
+
+// findAlertRuleParam loads the alert rule named by the rule_id route
+// parameter. On failure it writes the error response and returns a nil rule.
+func (h *Handler) findAlertRuleParam(c *fiber.Ctx) (*models.AlertRule, error) {
+	ruleID, err := uuid.Parse(c.Params("rule_id"))
+	if err != nil {
+		return nil, ErrorResponse(c, fiber.StatusBadRequest, "Invalid alert rule ID")
+	}
+
+	rule, err := FindAlertRuleByID(h.DB, ruleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorResponse(c, fiber.StatusNotFound, "Alert rule not found")
+		}
+		return nil, ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	return rule, nil
+}
+
+// ListAlerts handles listing alerts, most recently fired first, optionally
+// filtered by state ("open" for firing and acknowledged), severity, rule,
+// device, tag or group
+func (h *Handler) ListAlerts(c *fiber.Ctx) error {
+	scope, err := h.parseDeviceScope(c)
+	if err != nil {
+		return deviceFilterErrorResponse(c, err)
+	}
+
+	filter := AlertFilter{
+		State:       c.Query("state"),
+		Severity:    c.Query("severity"),
+		DeviceScope: scope,
+	}
+
+	switch models.AlertState(filter.State) {
+	case "", "open", models.AlertStateFiring, models.AlertStateAcknowledged, models.AlertStateResolved:
+	default:
+		return ErrorResponse(c, fiber.StatusBadRequest, "state must be one of: open, firing, acknowledged, resolved")
+	}
+
+	switch models.AlertSeverity(filter.Severity) {
+	case "", models.AlertSeverityInfo, models.AlertSeverityWarning, models.AlertSeverityCritical:
+	default:
+		return ErrorResponse(c, fiber.StatusBadRequest, "severity must be one of: info, warning, critical")
+	}
+
+	if ruleParam := c.Query("rule_id"); ruleParam != "" {
+		ruleID, err := uuid.Parse(ruleParam)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid alert rule ID")
+		}
+		filter.RuleID = &ruleID
+	}
+
+	if deviceParam := c.Query("device_id"); deviceParam != "" {
+		deviceID, err := uuid.Parse(deviceParam)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+		}
+		filter.DeviceID = &deviceID
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	alerts, err := ListAlerts(h.DB, filter, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve alerts")
+	}
+
+	total, err := CountAlerts(h.DB, filter)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count alerts")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": alerts,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": (total + limit - 1) / limit,
+		},
+	})
+}
+
+// GetAlert handles retrieving an alert with its rule and device
+func (h *Handler) GetAlert(c *fiber.Ctx) error {
+	alertID, err := uuid.Parse(c.Params("alert_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid alert ID")
+	}
+
+	alert, err := FindAlertByID(h.DB, alertID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Alert not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(alert)
+}
+
+// AcknowledgeAlert handles acknowledging a firing alert. The alert stays
+// acknowledged until its condition clears and it is resolved.
+func (h *Handler) AcknowledgeAlert(c *fiber.Ctx) error {
+	alertID, err := uuid.Parse(c.Params("alert_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid alert ID")
+	}
+
+	var req models.AlertAcknowledgeRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+		if err := ValidateStruct(req); err != nil {
+			return ValidationErrorResponse(c, err)
+		}
+	}
+
+	userID, _, _, err := ExtractUserFromContext(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid user context")
+	}
+
+	alert, err := FindAlertByID(h.DB, alertID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Alert not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	acknowledged, err := AcknowledgeAlert(h.DB, alertID, userID, strings.TrimSpace(req.Note))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to acknowledge alert")
+	}
+	if !acknowledged {
+		return ErrorResponse(c, fiber.StatusConflict, fmt.Sprintf("Alert is %s, only firing alerts can be acknowledged", alert.State))
+	}
+
+	LogAuditAction(h.DB, c, "acknowledge_alert", &alert.DeviceID, fiber.Map{
+		"alert_id":  alert.ID,
+		"rule_name": alert.RuleName,
+		"message":   alert.Message,
+		"note":      strings.TrimSpace(req.Note),
+	})
+
+	alert, err = FindAlertByID(h.DB, alertID)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve alert")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(alert)
+}
+
+// EvaluateAllAlertRules handles evaluating every enabled alert rule against
+// the latest readings of every device
+func (h *Handler) EvaluateAllAlertRules(c *fiber.Ctx) error {
+	rules, err := alerting.ListEnabledRules(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve alert rules")
+	}
+
+	result, err := alerting.EvaluateRules(h.DB, rules, nil)
+	if err != nil {
+		log.Printf("[ERROR] Failed to evaluate alert rules: %v", err)
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to evaluate alert rules")
+	}
+
+	LogAuditAction(h.DB, c, "evaluate_alert_rules", nil, result)
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// evaluateDeviceAlerts evaluates the enabled alert rules against the latest
+// readings of a device after it reported in. Failures are logged and do not
+// affect the submission.
+func (h *Handler) evaluateDeviceAlerts(deviceID uuid.UUID) {
+	rules, err := alerting.ListEnabledRules(h.DB)
+	if err == nil {
+		_, err = alerting.EvaluateRules(h.DB, rules, []uuid.UUID{deviceID})
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to evaluate alert rules: device_id=%s, error=%v", deviceID, err)
+	}
+}
+
+// ListWebhookEventTypes handles listing the event types webhooks can subscribe to
+func (h *Handler) ListWebhookEventTypes(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": models.EventTypes,
+	})
+}
+
+// ListWebhooks handles listing webhooks with their pending and failed delivery counts
+func (h *Handler) ListWebhooks(c *fiber.Ctx) error {
+	webhooks, err := ListWebhooks(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve webhooks")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": webhooks,
+	})
+}
+
+// CreateWebhook handles creating a webhook. The signing secret is generated
+// unless one is given and is only returned in this response.
+func (h *Handler) CreateWebhook(c *fiber.Ctx) error {
+	var req models.WebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	if req.Name == nil || req.URL == nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "name and url are required")
+	}
+
+	now := time.Now().UTC()
+	webhook := &models.Webhook{
+		ID:         uuid.New(),
+		EventTypes: models.EventTypeList{},
+		Enabled:    true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	applyWebhookRequest(webhook, &req)
+
+	if err := ValidateWebhook(webhook); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if webhook.Secret == "" {
+		secret, err := GenerateDeviceToken()
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to generate webhook secret")
+		}
+		webhook.Secret = secret
+	}
+
+	existing, err := FindWebhookByName(h.DB, webhook.Name)
+	if err != nil && err != sql.ErrNoRows {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	if existing != nil {
+		return ErrorResponse(c, fiber.StatusConflict, "Webhook name already exists")
+	}
+
+	if err := CreateWebhook(h.DB, webhook); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to create webhook")
+	}
+
+	LogAuditAction(h.DB, c, "create_webhook", nil, webhook)
+
+	return c.Status(fiber.StatusCreated).JSON(models.WebhookCreateResponse{
+		Webhook: *webhook,
+		Secret:  webhook.Secret,
+	})
+}
+
+// GetWebhook handles retrieving a webhook with its delivery counts
+func (h *Handler) GetWebhook(c *fiber.Ctx) error {
+	webhook, err := h.findWebhookParam(c)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(webhook)
+}
+
+// UpdateWebhook handles changing the provided fields of a webhook. Pending
+// deliveries are sent to the new URL and signed with the new secret.
+func (h *Handler) UpdateWebhook(c *fiber.Ctx) error {
+	var req models.WebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := ValidateStruct(req); err != nil {
+		return ValidationErrorResponse(c, err)
+	}
+
+	webhook, err := h.findWebhookParam(c)
+	if err != nil || webhook == nil {
+		return err
+	}
+	previous := *webhook
+
+	applyWebhookRequest(webhook, &req)
+	webhook.UpdatedAt = time.Now().UTC()
+
+	if err := ValidateWebhook(webhook); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if !strings.EqualFold(webhook.Name, previous.Name) {
+		existing, err := FindWebhookByName(h.DB, webhook.Name)
+		if err != nil && err != sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+		if existing != nil {
+			return ErrorResponse(c, fiber.StatusConflict, "Webhook name already exists")
+		}
+	}
+
+	if err := UpdateWebhook(h.DB, webhook); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update webhook")
+	}
+
+	LogAuditAction(h.DB, c, "update_webhook", nil, fiber.Map{
+		"before":         previous,
+		"after":          webhook,
+		"secret_rotated": webhook.Secret != previous.Secret,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(webhook)
+}
+
+// DeleteWebhook handles removing a webhook and its delivery log
+func (h *Handler) DeleteWebhook(c *fiber.Ctx) error {
+	webhook, err := h.findWebhookParam(c)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	if err := DeleteWebhook(h.DB, webhook.ID); err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete webhook")
+	}
+
+	LogAuditAction(h.DB, c, "delete_webhook", nil, fiber.Map{
+		"webhook_id": webhook.ID,
+		"name":       webhook.Name,
+		"url":        webhook.URL,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// ListWebhookDeliveries handles listing the delivery log of a webhook, newest
+// first, optionally filtered by status
+func (h *Handler) ListWebhookDeliveries(c *fiber.Ctx) error {
+	webhook, err := h.findWebhookParam(c)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	status := c.Query("status")
+	switch models.WebhookDeliveryStatus(status) {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliverySucceeded, models.WebhookDeliveryFailed:
+	default:
+		return ErrorResponse(c, fiber.StatusBadRequest, "status must be one of: pending, succeeded, failed")
+	}
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	deliveries, err := ListWebhookDeliveries(h.DB, webhook.ID, status, offset, limit)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to retrieve webhook deliveries")
+	}
+
+	total, err := CountWebhookDeliveries(h.DB, webhook.ID, status)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count webhook deliveries")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data": deliveries,
+		"pagination": fiber.Map{
+			"total":       total,
+			"page":        page,
+			"limit":       limit,
+			"total_pages": (total + limit - 1) / limit,
+		},
+	})
+}
+
+// TestWebhook handles sending a webhook.ping delivery and waits for its first
+// attempt. A failed ping is retried like any other delivery.
+func (h *Handler) TestWebhook(c *fiber.Ctx) error {
+	webhook, err := h.findWebhookParam(c)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	data, err := json.Marshal(fiber.Map{
+		"webhook_id": webhook.ID,
+		"name":       webhook.Name,
+	})
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to build ping payload")
+	}
+	payload, err := json.Marshal(models.WebhookPayload{
+		Type:       models.EventWebhookPing,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to build ping payload")
+	}
+
+	return h.attemptWebhookDelivery(c, webhook, nil, models.EventWebhookPing, payload)
+}
+
+// RedeliverWebhookDelivery handles sending the payload of an earlier delivery
+// again as a new delivery, leaving the original in the log
+func (h *Handler) RedeliverWebhookDelivery(c *fiber.Ctx) error {
+	webhook, err := h.findWebhookParam(c)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	deliveryID, err := uuid.Parse(c.Params("delivery_id"))
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid delivery ID")
+	}
+
+	original, err := FindWebhookDelivery(h.DB, webhook.ID, deliveryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrorResponse(c, fiber.StatusNotFound, "Webhook delivery not found")
+		}
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+
+	return h.attemptWebhookDelivery(c, webhook, original.EventID, original.EventType, original.Payload)
+}
+
+// attemptWebhookDelivery queues a delivery, attempts it right away and writes
+// the delivery with the outcome of the attempt
+func (h *Handler) attemptWebhookDelivery(c *fiber.Ctx, webhook *models.Webhook, eventID *int64, eventType models.EventType, payload json.RawMessage) error {
+	delivery, err := webhooks.Queue(h.DB, webhook.ID, eventID, eventType, payload, nil)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to queue webhook delivery")
+	}
+
+	if err := webhooks.Attempt(h.DB, h.Config, *webhook, delivery); err != nil {
+		log.Printf("[ERROR] Failed to record webhook delivery: delivery_id=%s, error=%v", delivery.ID, err)
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Failed to record webhook delivery")
+	}
+
+	LogAuditAction(h.DB, c, "deliver_webhook", nil, fiber.Map{
+		"webhook_id":  webhook.ID,
+		"delivery_id": delivery.ID,
+		"event_type":  eventType,
+		"status":      delivery.Status,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(delivery)
+}
+
+// findWebhookParam loads the webhook named by the webhook_id route parameter.
+// On failure it writes the error response and returns a nil webhook.
+func (h *Handler) findWebhookParam(c *fiber.Ctx) (*models.Webhook, error) {
+	webhookID, err := uuid.Parse(c.Params("webhook_id"))
+	if err != nil {
+		return nil, ErrorResponse(c, fiber.StatusBadRequest, "Invalid webhook ID")
+	}
+
+	webhook, err := FindWebhookByID(h.DB, webhookID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorResponse(c, fiber.StatusNotFound, "Webhook not found")
+		}
+		return nil, ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	return webhook, nil
+}
+
+// Event stream timing. Keep-alive comments stop proxies from closing idle
+// streams, and polling picks up events whose transaction committed after the
+// change notification was consumed.
+const (
+	streamKeepAlive    = 15 * time.Second
+	streamPollInterval = time.Second
+	streamBatch        = 100
+)
+
+// StreamEvents handles streaming events as Server-Sent Events, optionally
+// filtered by device_id and a comma-separated list of types. Clients resume
+// after the last event they received with the Last-Event-ID header or the
+// last_event_id parameter, otherwise only new events are sent. Audit events
+// are only streamed to admins.
+func (h *Handler) StreamEvents(c *fiber.Ctx) error {
+	_, _, role, err := ExtractUserFromContext(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusUnauthorized, "Invalid user context")
+	}
+
+	filter := events.Filter{IncludeAudits: role == models.UserRoleAdmin}
+
+	if deviceParam := c.Query("device_id"); deviceParam != "" {
+		deviceID, err := uuid.Parse(deviceParam)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid device ID")
+		}
+		filter.DeviceID = &deviceID
+	}
+
+	if typesParam := c.Query("types"); typesParam != "" {
+		filter.Types = make(map[models.EventType]bool)
+		for _, name := range strings.Split(typesParam, ",") {
+			eventType := models.EventType(strings.TrimSpace(name))
+			known := false
+			for _, streamable := range models.EventTypes {
+				if eventType == streamable {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return ErrorResponse(c, fiber.StatusBadRequest, fmt.Sprintf("Unknown event type: %s", eventType))
+			}
+			filter.Types[eventType] = true
+		}
+	}
+
+	lastEventID := c.Get("Last-Event-ID", c.Query("last_event_id"))
+	var cursor int64
+	if lastEventID != "" {
+		cursor, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || cursor < 0 {
+			return ErrorResponse(c, fiber.StatusBadRequest, "Invalid last event ID")
+		}
+	} else {
+		cursor, err = events.LatestID(h.DB)
+		if err != nil {
+			return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+		}
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// The server write timeout would otherwise end the stream, so the
+	// deadline is pushed back on every write
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(streamPollInterval)
+		defer ticker.Stop()
+
+		lastWrite := time.Time{}
+		for {
+			changed := events.Changed()
+			batch, err := events.After(h.DB, cursor, streamBatch)
+			if err != nil {
+				log.Printf("[ERROR] Failed to read events for stream: %v", err)
+				return
+			}
+
+			wrote := false
+			for _, event := range batch {
+				cursor = event.ID
+				if !filter.Matches(event) {
+					continue
+				}
+				if err := events.WriteSSE(w, event); err != nil {
+					return
+				}
+				wrote = true
+			}
+			if !wrote && time.Since(lastWrite) >= streamKeepAlive {
+				if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+					return
+				}
+				wrote = true
+			}
+			if wrote {
+				conn.SetWriteDeadline(time.Now().Add(2 * streamKeepAlive))
+				if err := w.Flush(); err != nil {
+					return
+				}
+				lastWrite = time.Now()
+			}
+
+			if len(batch) == streamBatch {
+				continue
+			}
+			select {
+			case <-changed:
+			case <-ticker.C:
+			}
+		}
+	})
+
+	return nil
+}
+
+// Search handles full-text search across devices, software and audit logs.
+// Audit logs are only searched for admins.
+func (h *Handler) Search(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return ErrorResponse(c, fiber.StatusBadRequest, "q parameter is required")
+	}
+	if _, err := search.MatchQuery(q); err != nil {
+		return ErrorResponse(c, fiber.StatusBadRequest, "Invalid search query: "+err.Error())
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	_, _, role, err := ExtractUserFromContext(c)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	types := []models.SearchEntityType{models.SearchEntityDevice, models.SearchEntitySoftware}
+	if role == models.UserRoleAdmin {
+		types = append(types, models.SearchEntityAuditLog)
+	}
+	if typesParam := strings.TrimSpace(c.Query("types")); typesParam != "" {
+		types = nil
+		for _, value := range strings.Split(typesParam, ",") {
+			entityType := models.SearchEntityType(strings.TrimSpace(value))
+			switch entityType {
+			case models.SearchEntityDevice, models.SearchEntitySoftware:
+			case models.SearchEntityAuditLog:
+				if role != models.UserRoleAdmin {
+					return ErrorResponse(c, fiber.StatusForbidden, "Searching audit logs requires the admin role")
+				}
+			default:
+				return ErrorResponse(c, fiber.StatusBadRequest, "types must be a comma-separated list of: device, software, audit_log")
+			}
+			types = append(types, entityType)
+		}
+	}
+
+	available, err := search.Available(h.DB)
+	if err != nil {
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Database error")
+	}
+	if !available {
+		return ErrorResponse(c, fiber.StatusServiceUnavailable, "Full-text search is not available")
+	}
+
+	groups, err := search.Search(h.DB, q, types, limit)
+	if err != nil {
+		log.Printf("[ERROR] Search failed for %q: %v", q, err)
+		return ErrorResponse(c, fiber.StatusInternalServerError, "Search failed")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.SearchResponse{
+		Query:  q,
+		Groups: groups,
+	})
+}
+
 // HealthCheck handles health check requests
 func (h *Handler) HealthCheck(c *fiber.Ctx) error {
 	// Check database connectivity
@@ -1065,7 +5036,15 @@ func (h *Handler) Root(c *fiber.Ctx) error {
 			"/v1/agents/*",
 			"/v1/auth/*",
 			"/v1/devices/*",
+			"/v1/command-types",
+			"/v1/command-batches/*",
 			"/v1/software",
+			"/v1/groups/*",
+			"/v1/search",
+			"/v1/policies/*",
+			"/v1/alerts/*",
+			"/v1/webhooks/*",
+			"/v1/events/stream",
 			"/v1/users/*",
 			"/v1/audit-logs",
 		},
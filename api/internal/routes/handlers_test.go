@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/models"
+)
+
+func addDevice(t *testing.T, db *sqlx.DB, hostname string, status models.DeviceStatus) uuid.UUID {
+	t.Helper()
+
+	deviceID := uuid.New()
+	db.MustExec(`INSERT INTO devices (id, hostname, status) VALUES (?, ?, ?)`, deviceID, hostname, status)
+	return deviceID
+}
+
+func TestResolveBatchDevices(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+
+	first := addDevice(t, db, "WS-0001", models.DeviceStatusActive)
+	second := addDevice(t, db, "WS-0002", models.DeviceStatusError)
+	third := addDevice(t, db, "WS-0003", models.DeviceStatusError)
+
+	groupID := uuid.New()
+	db.MustExec(`INSERT INTO device_groups (id, name) VALUES (?, 'Lab')`, groupID)
+	db.MustExec(`INSERT INTO device_group_members (group_id, device_id) VALUES (?, ?), (?, ?)`,
+		groupID, first, groupID, second)
+
+	tests := []struct {
+		name    string
+		req     models.CommandBatchRequest
+		devices []uuid.UUID
+		target  models.CommandBatchTarget
+		code    int
+		message string
+	}{
+		{
+			name:    "device IDs without duplicates",
+			req:     models.CommandBatchRequest{DeviceIDs: []uuid.UUID{second, first, second}},
+			devices: []uuid.UUID{second, first},
+			target:  models.CommandBatchTarget{DeviceIDs: []uuid.UUID{second, first}},
+		},
+		{
+			name:    "unknown device ID",
+			req:     models.CommandBatchRequest{DeviceIDs: []uuid.UUID{first, uuid.Nil}},
+			code:    fiber.StatusBadRequest,
+			message: "Device not found",
+		},
+		{
+			name:    "device IDs with a group",
+			req:     models.CommandBatchRequest{DeviceIDs: []uuid.UUID{first}, Group: "Lab"},
+			code:    fiber.StatusBadRequest,
+			message: "cannot be combined",
+		},
+		{
+			name:    "device IDs with a query",
+			req:     models.CommandBatchRequest{DeviceIDs: []uuid.UUID{first}, Query: "status:error"},
+			code:    fiber.StatusBadRequest,
+			message: "cannot be combined",
+		},
+		{
+			name:    "group by name",
+			req:     models.CommandBatchRequest{Group: "Lab"},
+			devices: []uuid.UUID{first, second},
+			target:  models.CommandBatchTarget{GroupID: &groupID},
+		},
+		{
+			name:    "group by ID",
+			req:     models.CommandBatchRequest{Group: groupID.String()},
+			devices: []uuid.UUID{first, second},
+			target:  models.CommandBatchTarget{GroupID: &groupID},
+		},
+		{
+			name:    "unknown group",
+			req:     models.CommandBatchRequest{Group: "Kiosks"},
+			code:    fiber.StatusBadRequest,
+			message: errUnknownGroup.Error(),
+		},
+		{
+			name:    "query",
+			req:     models.CommandBatchRequest{Query: "status:error"},
+			devices: []uuid.UUID{second, third},
+			target:  models.CommandBatchTarget{Query: "status:error"},
+		},
+		{
+			name:    "group and query",
+			req:     models.CommandBatchRequest{Group: "Lab", Query: "status:error"},
+			devices: []uuid.UUID{second},
+			target:  models.CommandBatchTarget{GroupID: &groupID, Query: "status:error"},
+		},
+		{
+			name:    "invalid query",
+			req:     models.CommandBatchRequest{Query: "status:("},
+			code:    fiber.StatusBadRequest,
+			message: "Invalid query",
+		},
+		{
+			name:    "no target",
+			req:     models.CommandBatchRequest{Group: " ", Query: " "},
+			code:    fiber.StatusBadRequest,
+			message: "is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, devices, ferr := h.resolveBatchDevices(&tt.req)
+			if tt.code != 0 {
+				if ferr == nil || ferr.Code != tt.code || !strings.Contains(ferr.Message, tt.message) {
+					t.Fatalf("resolveBatchDevices() error = %v, want %d with %q", ferr, tt.code, tt.message)
+				}
+				return
+			}
+			if ferr != nil {
+				t.Fatalf("resolveBatchDevices() error = %v", ferr)
+			}
+
+			if !sameDevices(devices, tt.devices) {
+				t.Errorf("resolveBatchDevices() devices = %v, want %v", devices, tt.devices)
+			}
+			if !sameDevices(target.DeviceIDs, tt.target.DeviceIDs) ||
+				(target.GroupID == nil) != (tt.target.GroupID == nil) ||
+				(target.GroupID != nil && *target.GroupID != *tt.target.GroupID) ||
+				target.Query != tt.target.Query {
+				t.Errorf("resolveBatchDevices() target = %+v, want %+v", target, tt.target)
+			}
+		})
+	}
+}
+
+func TestResolveBatchDevicesLimit(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+
+	// One device more than a batch may target
+	db.MustExec(`
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i <= ?)
+		INSERT INTO devices (id, hostname, last_seen)
+		SELECT printf('00000000-0000-0000-0000-%012d', i), printf('WS-%05d', i), ?
+		FROM n`, maxDeviceSelection, time.Now().UTC())
+
+	_, _, ferr := h.resolveBatchDevices(&models.CommandBatchRequest{Query: "hostname:WS-*"})
+	if ferr == nil || ferr.Code != fiber.StatusBadRequest || !strings.Contains(ferr.Message, "limited to") {
+		t.Fatalf("resolveBatchDevices() error = %v, want the selection limit", ferr)
+	}
+
+	// At the limit the batch is accepted
+	db.MustExec(`DELETE FROM devices WHERE hostname = 'WS-00001'`)
+	_, devices, ferr := h.resolveBatchDevices(&models.CommandBatchRequest{Query: "hostname:WS-*"})
+	if ferr != nil {
+		t.Fatalf("resolveBatchDevices() error = %v", ferr)
+	}
+	if len(devices) != maxDeviceSelection {
+		t.Errorf("resolveBatchDevices() selected %d devices, want %d", len(devices), maxDeviceSelection)
+	}
+}
+
+// sameDevices reports whether two lists hold the same device IDs in any order
+func sameDevices(got, want []uuid.UUID) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	counts := make(map[uuid.UUID]int, len(want))
+	for _, id := range want {
+		counts[id]++
+	}
+	for _, id := range got {
+		if counts[id] == 0 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
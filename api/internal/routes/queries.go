@@ -3,13 +3,24 @@ package routes
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/availability"
+	"github.com/tracr/api/internal/commands"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/devicefilter"
+	"github.com/tracr/api/internal/events"
 	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/normalize"
+	"github.com/tracr/api/internal/policy"
+	"github.com/tracr/api/internal/versions"
 )
 
 // Device queries
@@ -25,27 +36,56 @@ func FindDeviceByHostname(db *sqlx.DB, hostname string) (*models.Device, error)
 	return &device, nil
 }
 
-// CreateDevice inserts a new device into the database
+// CreateDevice inserts a new device into the database and starts its status
+// and availability history
 func CreateDevice(db *sqlx.DB, device *models.Device) error {
+	if device.StatusChangedAt == nil {
+		device.StatusChangedAt = &device.FirstSeen
+	}
+
 	query := `
 		INSERT INTO devices (
 			id, hostname, domain, manufacturer, model, serial_number,
 			os_caption, os_version, os_build, device_token_hash,
-			first_seen, last_seen, status, token_created_at
+			first_seen, last_seen, status, status_reason, status_changed_at, token_created_at
 		) VALUES (
 			:id, :hostname, :domain, :manufacturer, :model, :serial_number,
 			:os_caption, :os_version, :os_build, :device_token_hash,
-			:first_seen, :last_seen, :status, :token_created_at
+			:first_seen, :last_seen, :status, :status_reason, :status_changed_at, :token_created_at
 		)`
-	
-	_, err := db.NamedExec(query, device)
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.NamedExec(query, device); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO device_status_history (device_id, previous_status, status, reason, changed_at)
+		VALUES (?, NULL, ?, ?, ?)`, device.ID, device.Status, device.StatusReason, device.StatusChangedAt); err != nil {
+		return err
+	}
+	if err := availability.RecordCheckin(tx, device.ID, device.FirstSeen, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateDeviceHeartbeat updates the last_seen timestamp for a device along
+// with the errors its agent reported
+func UpdateDeviceHeartbeat(tx *sqlx.Tx, deviceID uuid.UUID, agentError string) error {
+	query := `UPDATE devices SET last_seen = datetime('now'), agent_error = ? WHERE id = ?`
+	_, err := tx.Exec(query, agentError, deviceID)
 	return err
 }
 
-// UpdateDeviceLastSeen updates the last_seen timestamp for a device
-func UpdateDeviceLastSeen(db *sqlx.DB, deviceID uuid.UUID) error {
-	query := `UPDATE devices SET last_seen = datetime('now'), status = 'active' WHERE id = ?`
-	_, err := db.Exec(query, deviceID)
+// TouchDeviceLastSeen updates the last_seen timestamp for a device within a transaction
+func TouchDeviceLastSeen(tx *sqlx.Tx, deviceID uuid.UUID) error {
+	query := `UPDATE devices SET last_seen = datetime('now') WHERE id = ?`
+	_, err := tx.Exec(query, deviceID)
 	return err
 }
 
@@ -63,9 +103,8 @@ func UpdateDeviceFromInventory(tx *sqlx.Tx, deviceID uuid.UUID, inventory *model
 			os_build = ?,
 			last_seen = datetime('now')
 		WHERE id = ?`
-	
+
 	_, err := tx.Exec(query,
-		deviceID,
 		inventory.Identity.Hostname,
 		inventory.Identity.Domain,
 		inventory.Hardware.Manufacturer,
@@ -74,6 +113,7 @@ func UpdateDeviceFromInventory(tx *sqlx.Tx, deviceID uuid.UUID, inventory *model
 		inventory.OS.Caption,
 		inventory.OS.Version,
 		inventory.OS.BuildNumber,
+		deviceID,
 	)
 	return err
 }
@@ -96,32 +136,69 @@ func FindDeviceByID(db *sqlx.DB, deviceID uuid.UUID) (*models.Device, error) {
 	return &device, nil
 }
 
-// ListDevices retrieves devices with optional search and status filters
-func ListDevices(db *sqlx.DB, offset, limit int, search, status string) ([]models.Device, error) {
-	var devices []models.Device
-	var args []interface{}
+// DeviceScope restricts a query to the devices carrying a tag or belonging to a group
+type DeviceScope struct {
+	Tag     string
+	GroupID *uuid.UUID
+}
+
+// conditions returns the WHERE conditions limiting column, a device ID column, to the scope
+func (s DeviceScope) conditions(column string) ([]string, []interface{}) {
 	var whereClauses []string
-	argCount := 1
+	var args []interface{}
 
-	// Build dynamic WHERE clause
-	if search != "" {
-		whereClauses = append(whereClauses, "hostname LIKE '%' || ?"+strconv.Itoa(argCount) + " || '%'")
-		args = append(args, search)
-		argCount++
+	if s.Tag != "" {
+		whereClauses = append(whereClauses, column+" IN (SELECT device_id FROM device_tags WHERE tag = ?)")
+		args = append(args, s.Tag)
+	}
+	if s.GroupID != nil {
+		whereClauses = append(whereClauses, column+" IN (SELECT device_id FROM device_group_members WHERE group_id = ?)")
+		args = append(args, *s.GroupID)
 	}
+	return whereClauses, args
+}
 
-	if status != "" {
-		whereClauses = append(whereClauses, "status = ?"+strconv.Itoa(argCount))
-		args = append(args, status)
-		argCount++
+// DeviceFilter holds the optional filters of device list queries
+type DeviceFilter struct {
+	Search string
+	Status string
+	Query  *devicefilter.Query
+	DeviceScope
+}
+
+func buildDeviceWhere(filter DeviceFilter) (string, []interface{}) {
+	var whereClauses []string
+	var args []interface{}
+
+	if filter.Search != "" {
+		whereClauses = append(whereClauses, "d.hostname LIKE '%' || ? || '%'")
+		args = append(args, filter.Search)
+	}
+	if filter.Status != "" {
+		whereClauses = append(whereClauses, "d.status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Query != nil {
+		whereClauses = append(whereClauses, filter.Query.SQL)
+		args = append(args, filter.Query.Args...)
 	}
 
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+	scopeClauses, scopeArgs := filter.DeviceScope.conditions("d.id")
+	whereClauses = append(whereClauses, scopeClauses...)
+	args = append(args, scopeArgs...)
+
+	if len(whereClauses) == 0 {
+		return "", args
 	}
+	return " WHERE " + strings.Join(whereClauses, " AND "), args
+}
+
+// ListDevices retrieves devices matching filter, most recently seen first
+func ListDevices(db *sqlx.DB, filter DeviceFilter, offset, limit int) ([]models.Device, error) {
+	var devices []models.Device
 
-	query := "SELECT * FROM devices" + whereClause + " ORDER BY last_seen DESC LIMIT ?" + strconv.Itoa(argCount) + " OFFSET ?" + strconv.Itoa(argCount+1)
+	whereClause, args := buildDeviceWhere(filter)
+	query := "SELECT d.* FROM devices d" + whereClause + " ORDER BY d.last_seen DESC LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
 
 	err := db.Select(&devices, query, args...)
@@ -137,672 +214,3069 @@ func ListDevices(db *sqlx.DB, offset, limit int, search, status string) ([]model
 	return devices, nil
 }
 
-// CountDevices returns the total number of devices with optional filters
-func CountDevices(db *sqlx.DB, search, status string) (int, error) {
+// CountDevices returns the total number of devices matching filter
+func CountDevices(db *sqlx.DB, filter DeviceFilter) (int, error) {
 	var count int
-	var args []interface{}
-	var whereClauses []string
-	argCount := 1
+	whereClause, args := buildDeviceWhere(filter)
+	err := db.Get(&count, "SELECT COUNT(*) FROM devices d"+whereClause, args...)
+	return count, err
+}
 
-	// Build same dynamic WHERE clause as ListDevices
-	if search != "" {
-		whereClauses = append(whereClauses, "hostname LIKE '%' || ?"+strconv.Itoa(argCount) + " || '%'")
-		args = append(args, search)
-		argCount++
-	}
+// ListAllDevices retrieves every device matching filter, up to max rows, most
+// recently seen first. Used by exports and bulk actions.
+func ListAllDevices(db *sqlx.DB, filter DeviceFilter, max int) ([]models.Device, error) {
+	var devices []models.Device
 
-	if status != "" {
-		whereClauses = append(whereClauses, "status = ?"+strconv.Itoa(argCount))
-		args = append(args, status)
-		argCount++
+	whereClause, args := buildDeviceWhere(filter)
+	query := "SELECT d.* FROM devices d" + whereClause + " ORDER BY d.last_seen DESC LIMIT ?"
+	args = append(args, max)
+
+	if err := db.Select(&devices, query, args...); err != nil {
+		return nil, err
+	}
+	if devices == nil {
+		devices = []models.Device{}
 	}
+	return devices, nil
+}
 
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+// Device group queries
+
+const deviceGroupColumns = `
+	g.id, g.name, g.description, g.kind, g.criteria, g.evaluated_at, g.created_at, g.updated_at,
+	(SELECT COUNT(*) FROM device_group_members m WHERE m.group_id = g.id) AS member_count`
+
+// ListDeviceGroups retrieves device groups ordered by name, optionally filtered by a name search
+func ListDeviceGroups(db *sqlx.DB, search string, offset, limit int) ([]models.DeviceGroup, error) {
+	var groups []models.DeviceGroup
+	query := `SELECT ` + deviceGroupColumns + `
+		FROM device_groups g
+		WHERE (? = '' OR g.name LIKE '%' || ? || '%')
+		ORDER BY g.name ASC
+		LIMIT ? OFFSET ?`
+
+	if err := db.Select(&groups, query, search, search, limit, offset); err != nil {
+		return nil, err
 	}
+	if groups == nil {
+		groups = []models.DeviceGroup{}
+	}
+	return groups, nil
+}
 
-	query := "SELECT COUNT(*) FROM devices" + whereClause
-	err := db.Get(&count, query, args...)
+// CountDeviceGroups returns the number of device groups matching a name search
+func CountDeviceGroups(db *sqlx.DB, search string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM device_groups WHERE (? = '' OR name LIKE '%' || ? || '%')`
+	err := db.Get(&count, query, search, search)
 	return count, err
 }
 
-// Snapshot queries
-
-// FindSnapshotByHash retrieves a snapshot by device ID and hash
-func FindSnapshotByHash(db *sqlx.DB, deviceID uuid.UUID, hash string) (*models.Snapshot, error) {
-	var snapshot models.Snapshot
-	query := `SELECT * FROM snapshots WHERE device_id = ? AND snapshot_hash = ?`
-	err := db.Get(&snapshot, query, deviceID, hash)
-	if err != nil {
+// FindDeviceGroupByID retrieves a device group by its ID
+func FindDeviceGroupByID(db *sqlx.DB, groupID uuid.UUID) (*models.DeviceGroup, error) {
+	var group models.DeviceGroup
+	query := `SELECT ` + deviceGroupColumns + ` FROM device_groups g WHERE g.id = ?`
+	if err := db.Get(&group, query, groupID); err != nil {
 		return nil, err
 	}
-	return &snapshot, nil
+	return &group, nil
 }
 
-// CreateSnapshot inserts a new snapshot and returns the generated ID
-func CreateSnapshot(tx *sqlx.Tx, snapshot *models.Snapshot) (uuid.UUID, error) {
-        query := `
-                INSERT INTO snapshots (
-                        id, device_id, collected_at, agent_version, snapshot_hash,
-                        cpu_percent, memory_used_bytes, memory_total_bytes,
-                        boot_time, last_interactive_user
-                ) VALUES (
-                        :id, :device_id, :collected_at, :agent_version, :snapshot_hash,
-                        :cpu_percent, :memory_used_bytes, :memory_total_bytes,
-                        :boot_time, :last_interactive_user
-                )`
-
-        _, err := tx.NamedExec(query, snapshot)
-        return snapshot.ID, err
-}// CreateVolumes batch inserts volumes for a snapshot
-func CreateVolumes(tx *sqlx.Tx, snapshotID uuid.UUID, volumes []models.Volume) error {
-	if len(volumes) == 0 {
-		return nil
+// FindDeviceGroupByName retrieves a device group by its name, ignoring case
+func FindDeviceGroupByName(db *sqlx.DB, name string) (*models.DeviceGroup, error) {
+	var group models.DeviceGroup
+	query := `SELECT ` + deviceGroupColumns + ` FROM device_groups g WHERE g.name = ?`
+	if err := db.Get(&group, query, name); err != nil {
+		return nil, err
 	}
-	
+	return &group, nil
+}
+
+// CreateDeviceGroup inserts a new device group
+func CreateDeviceGroup(db *sqlx.DB, group *models.DeviceGroup) error {
 	query := `
-		INSERT INTO volumes (id, snapshot_id, name, filesystem, total_bytes, free_bytes)
-		VALUES (:id, :snapshot_id, :name, :filesystem, :total_bytes, :free_bytes)`
-	
-	// Add snapshot_id and generate UUIDs for each volume
-	for i := range volumes {
-		volumes[i].ID = uuid.New()
-		volumes[i].SnapshotID = snapshotID
-	}
-	
-	_, err := tx.NamedExec(query, volumes)
+		INSERT INTO device_groups (id, name, description, kind, criteria, created_at, updated_at)
+		VALUES (:id, :name, :description, :kind, :criteria, :created_at, :updated_at)`
+
+	_, err := db.NamedExec(query, group)
 	return err
 }
 
-// CreateSoftwareItems batch inserts software items for a snapshot
-func CreateSoftwareItems(tx *sqlx.Tx, snapshotID uuid.UUID, software []models.Software) error {
-	if len(software) == 0 {
-		return nil
-	}
-	
+// UpdateDeviceGroup saves the name, description and criteria of a device group
+func UpdateDeviceGroup(db *sqlx.DB, group *models.DeviceGroup) error {
 	query := `
-		INSERT INTO software_items (id, snapshot_id, name, version, publisher, install_date, size_kb)
-		VALUES (:id, :snapshot_id, :name, :version, :publisher, :install_date, :size_kb)`
-	
-	// Add snapshot_id and generate UUIDs for each software item
-	for i := range software {
-		software[i].ID = uuid.New()
-		software[i].SnapshotID = snapshotID
-	}
-	
-	_, err := tx.NamedExec(query, software)
+		UPDATE device_groups
+		SET name = :name, description = :description, criteria = :criteria, updated_at = :updated_at
+		WHERE id = :id`
+
+	_, err := db.NamedExec(query, group)
 	return err
 }
 
-// GetLatestSnapshotSummary retrieves the most recent snapshot summary for a device
-func GetLatestSnapshotSummary(db *sqlx.DB, deviceID uuid.UUID) (*models.SnapshotSummary, error) {
-	var summary models.SnapshotSummary
-	query := `
-		SELECT id, collected_at, cpu_percent, memory_used_bytes, memory_total_bytes, boot_time
-		FROM snapshots 
-		WHERE device_id = ? 
-		ORDER BY collected_at DESC 
-		LIMIT 1`
-	
-	err := db.Get(&summary, query, deviceID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No snapshots exist, return nil instead of error
-		}
-		return nil, err
-	}
-	return &summary, nil
+// DeleteDeviceGroup removes a device group and its memberships
+func DeleteDeviceGroup(db *sqlx.DB, groupID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM device_groups WHERE id = ?`, groupID)
+	return err
 }
 
-// FindSnapshotByID retrieves a snapshot by its ID
-func FindSnapshotByID(db *sqlx.DB, snapshotID uuid.UUID) (*models.Snapshot, error) {
-	var snapshot models.Snapshot
-	query := `SELECT * FROM snapshots WHERE id = ?`
-	err := db.Get(&snapshot, query, snapshotID)
+// FindExistingDeviceIDs returns the subset of deviceIDs that belong to registered devices
+func FindExistingDeviceIDs(db sqlx.Queryer, deviceIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	existing := make(map[uuid.UUID]bool)
+	if len(deviceIDs) == 0 {
+		return existing, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT id FROM devices WHERE id IN (?)`, deviceIDs)
 	if err != nil {
 		return nil, err
 	}
-	return &snapshot, nil
-}
 
-// ListSnapshotsByDevice retrieves snapshot summaries for a device with pagination
-func ListSnapshotsByDevice(db *sqlx.DB, deviceID uuid.UUID, offset, limit int) ([]models.SnapshotSummary, error) {
-	var summaries []models.SnapshotSummary
-	query := `
-		SELECT id, collected_at, cpu_percent, memory_used_bytes, memory_total_bytes, boot_time
-		FROM snapshots 
-		WHERE device_id = ? 
-		ORDER BY collected_at DESC 
-		LIMIT ? OFFSET ?`
-	
-	err := db.Select(&summaries, query, deviceID, limit, offset)
-	if err != nil {
+	var ids []uuid.UUID
+	if err := sqlx.Select(db, &ids, query, args...); err != nil {
 		return nil, err
 	}
-	
-	// Return empty slice if no snapshots found
-	if summaries == nil {
-		summaries = []models.SnapshotSummary{}
+	for _, id := range ids {
+		existing[id] = true
 	}
-	
-	return summaries, nil
+	return existing, nil
 }
 
-// CountSnapshotsByDevice returns the number of snapshots for a device
-func CountSnapshotsByDevice(db *sqlx.DB, deviceID uuid.UUID) (int, error) {
-	var count int
-	query := `SELECT COUNT(*) FROM snapshots WHERE device_id = ?`
-	err := db.Get(&count, query, deviceID)
-	return count, err
-}
+// Membership change sources recorded in the group membership history
+const (
+	MembershipSourceManual    = "manual"
+	MembershipSourceCriteria  = "criteria"
+	MembershipSourceInventory = "inventory"
+)
 
-// GetVolumesBySnapshot retrieves volumes for a snapshot
-func GetVolumesBySnapshot(db *sqlx.DB, snapshotID uuid.UUID) ([]models.Volume, error) {
-	var volumes []models.Volume
-	query := `SELECT * FROM volumes WHERE snapshot_id = ? ORDER BY name ASC`
-	
-	err := db.Select(&volumes, query, snapshotID)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Return empty slice if no volumes found
-	if volumes == nil {
-		volumes = []models.Volume{}
+// AddDeviceGroupMembers adds devices to a group, ignoring devices that are
+// already members, and records each join. Returns the number of devices added.
+func AddDeviceGroupMembers(tx *sqlx.Tx, groupID uuid.UUID, deviceIDs []uuid.UUID, source string) (int, error) {
+	added := 0
+	now := time.Now().UTC()
+	for _, deviceID := range deviceIDs {
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO device_group_members (group_id, device_id, added_at)
+			VALUES (?, ?, ?)`, groupID, deviceID, now)
+		if err != nil {
+			return added, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return added, err
+		}
+		if affected == 0 {
+			continue
+		}
+		if err := createGroupMembershipEvent(tx, groupID, deviceID, models.GroupMembershipJoined, source, now); err != nil {
+			return added, err
+		}
+		added++
 	}
-	
-	return volumes, nil
+	return added, nil
 }
 
-// GetSoftwareBySnapshot retrieves software items for a snapshot
-func GetSoftwareBySnapshot(db *sqlx.DB, snapshotID uuid.UUID) ([]models.Software, error) {
-	var software []models.Software
-	query := `SELECT * FROM software_items WHERE snapshot_id = ? ORDER BY name ASC`
-	
-	err := db.Select(&software, query, snapshotID)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Return empty slice if no software found
-	if software == nil {
-		software = []models.Software{}
+// RemoveDeviceGroupMembers removes devices from a group and records each
+// departure. Returns the number of devices removed.
+func RemoveDeviceGroupMembers(tx *sqlx.Tx, groupID uuid.UUID, deviceIDs []uuid.UUID, source string) (int, error) {
+	removed := 0
+	now := time.Now().UTC()
+	for _, deviceID := range deviceIDs {
+		result, err := tx.Exec(`DELETE FROM device_group_members WHERE group_id = ? AND device_id = ?`, groupID, deviceID)
+		if err != nil {
+			return removed, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return removed, err
+		}
+		if affected == 0 {
+			continue
+		}
+		if err := createGroupMembershipEvent(tx, groupID, deviceID, models.GroupMembershipLeft, source, now); err != nil {
+			return removed, err
+		}
+		removed++
 	}
-	
-	return software, nil
+	return removed, nil
 }
 
-// Command queries
-
-// CreateCommand inserts a new command into the database
-func CreateCommand(db *sqlx.DB, command *models.Command) error {
-	query := `
-		INSERT INTO commands (id, device_id, command_type, payload, status, created_at)
-		VALUES (:id, :device_id, :command_type, :payload, :status, :created_at)`
-	
-	_, err := db.NamedExec(query, command)
+func createGroupMembershipEvent(tx *sqlx.Tx, groupID, deviceID uuid.UUID, change models.GroupMembershipChange, source string, changedAt time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO device_group_membership_history (id, group_id, device_id, change, source, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, uuid.New(), groupID, deviceID, change, source, changedAt)
 	return err
 }
 
-// ListCommandsByDevice retrieves commands for a device with optional status filter
-func ListCommandsByDevice(db *sqlx.DB, deviceID uuid.UUID, offset, limit int, status string) ([]models.Command, error) {
-	var commands []models.Command
-	var args []interface{}
-	argCount := 2
-
-	whereClause := "WHERE device_id = ?"
-	args = append(args, deviceID)
-
-	if status != "" {
-		argCount++
-		whereClause += " AND status = ?" + strconv.Itoa(argCount)
-		args = append(args, status)
-	}
+// ListDeviceGroupMemberIDs retrieves the IDs of a group's member devices
+func ListDeviceGroupMemberIDs(db sqlx.Queryer, groupID uuid.UUID) ([]uuid.UUID, error) {
+	var deviceIDs []uuid.UUID
+	err := sqlx.Select(db, &deviceIDs, `SELECT device_id FROM device_group_members WHERE group_id = ?`, groupID)
+	return deviceIDs, err
+}
 
-	query := "SELECT * FROM commands " + whereClause + " ORDER BY created_at DESC LIMIT ?" + strconv.Itoa(argCount+1) + " OFFSET ?" + strconv.Itoa(argCount+2)
-	args = append(args, limit, offset)
+// ListSmartGroups retrieves all smart groups
+func ListSmartGroups(db *sqlx.DB) ([]models.DeviceGroup, error) {
+	var groups []models.DeviceGroup
+	query := `SELECT ` + deviceGroupColumns + ` FROM device_groups g WHERE g.kind = ? ORDER BY g.name ASC`
+	err := db.Select(&groups, query, models.DeviceGroupSmart)
+	return groups, err
+}
 
-	err := db.Select(&commands, query, args...)
+// FindDevicesMatchingCriteria retrieves the IDs of all devices matching smart group criteria
+func FindDevicesMatchingCriteria(db *sqlx.DB, criteria models.GroupCriteria) ([]uuid.UUID, error) {
+	where, args, err := devicefilter.CriteriaSQL(criteria)
 	if err != nil {
 		return nil, err
 	}
 
-	// Return empty slice if no commands found
-	if commands == nil {
-		commands = []models.Command{}
-	}
-
-	return commands, nil
+	var deviceIDs []uuid.UUID
+	err = db.Select(&deviceIDs, `SELECT d.id FROM devices d WHERE `+where, args...)
+	return deviceIDs, err
 }
 
-// CountCommandsByDevice returns the number of commands for a device with optional status filter
-func CountCommandsByDevice(db *sqlx.DB, deviceID uuid.UUID, status string) (int, error) {
+// DeviceMatchesCriteria reports whether a single device matches smart group criteria
+func DeviceMatchesCriteria(db sqlx.Queryer, deviceID uuid.UUID, criteria models.GroupCriteria) (bool, error) {
+	where, args, err := devicefilter.CriteriaSQL(criteria)
+	if err != nil {
+		return false, err
+	}
+
 	var count int
-	var args []interface{}
-	argCount := 1
+	args = append([]interface{}{deviceID}, args...)
+	err = sqlx.Get(db, &count, `SELECT COUNT(*) FROM devices d WHERE d.id = ? AND `+where, args...)
+	return count > 0, err
+}
 
-	whereClause := "WHERE device_id = ?"
-	args = append(args, deviceID)
+// SyncDeviceGroupMembers makes the given devices the exact membership of a
+// group, recording joins and departures. Returns the number of devices that
+// joined and left.
+func SyncDeviceGroupMembers(db *sqlx.DB, groupID uuid.UUID, deviceIDs []uuid.UUID, source string) (int, int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
 
-	if status != "" {
-		argCount++
-		whereClause += " AND status = ?" + strconv.Itoa(argCount)
-		args = append(args, status)
+	current, err := ListDeviceGroupMemberIDs(tx, groupID)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	query := "SELECT COUNT(*) FROM commands " + whereClause
-	err := db.Get(&count, query, args...)
-	return count, err
-}
+	wanted := make(map[uuid.UUID]bool, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		wanted[deviceID] = true
+	}
+	var leaving []uuid.UUID
+	for _, deviceID := range current {
+		if !wanted[deviceID] {
+			leaving = append(leaving, deviceID)
+		}
+	}
 
-// Software catalog queries
+	joined, err := AddDeviceGroupMembers(tx, groupID, deviceIDs, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	left, err := RemoveDeviceGroupMembers(tx, groupID, leaving, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`UPDATE device_groups SET evaluated_at = ? WHERE id = ?`, time.Now().UTC(), groupID); err != nil {
+		return 0, 0, err
+	}
 
-// ListSoftwareCatalog retrieves aggregated software catalog with filters
-func ListSoftwareCatalog(db *sqlx.DB, offset, limit int, search, publisher, sortBy string) ([]models.SoftwareCatalogItem, error) {
-	var catalog []models.SoftwareCatalogItem
-	var args []interface{}
-	var whereClauses []string
-	argCount := 1
+	return joined, left, tx.Commit()
+}
 
-	// Build dynamic WHERE clause
-	if search != "" {
-		whereClauses = append(whereClauses, "si.name LIKE '%' || ?"+strconv.Itoa(argCount)+" || '%'")
-		args = append(args, search)
-		argCount++
+// EvaluateDeviceSmartGroups re-evaluates the smart groups of a single device
+// after its inventory changed. Returns the number of groups joined and left.
+func EvaluateDeviceSmartGroups(db *sqlx.DB, deviceID uuid.UUID) (int, int, error) {
+	groups, err := ListSmartGroups(db)
+	if err != nil || len(groups) == 0 {
+		return 0, 0, err
 	}
 
-	if publisher != "" {
-		whereClauses = append(whereClauses, "si.publisher = ?"+strconv.Itoa(argCount))
-		args = append(args, publisher)
-		argCount++
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, 0, err
 	}
+	defer tx.Rollback()
 
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+	var memberOf []uuid.UUID
+	if err := tx.Select(&memberOf, `SELECT group_id FROM device_group_members WHERE device_id = ?`, deviceID); err != nil {
+		return 0, 0, err
+	}
+	isMember := make(map[uuid.UUID]bool, len(memberOf))
+	for _, groupID := range memberOf {
+		isMember[groupID] = true
 	}
 
-	// Build ORDER BY clause
-	var orderBy string
-	switch sortBy {
-	case "name":
-		orderBy = "si.name ASC"
-	case "latest_seen":
-		orderBy = "latest_seen DESC"
-	default: // "device_count"
-		orderBy = "device_count DESC"
+	var joining, leaving []uuid.UUID
+	for _, group := range groups {
+		if group.Criteria == nil {
+			continue
+		}
+		// Criteria saved before their fields were disallowed stay as they are
+		// until the group is updated
+		if _, _, err := devicefilter.CriteriaSQL(*group.Criteria); err != nil {
+			continue
+		}
+		matches, err := DeviceMatchesCriteria(tx, deviceID, *group.Criteria)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to evaluate group %s: %w", group.ID, err)
+		}
+		switch {
+		case matches && !isMember[group.ID]:
+			joining = append(joining, group.ID)
+		case !matches && isMember[group.ID]:
+			leaving = append(leaving, group.ID)
+		}
+	}
+	if len(joining) == 0 && len(leaving) == 0 {
+		return 0, 0, nil
+	}
+
+	for _, groupID := range joining {
+		if _, err := AddDeviceGroupMembers(tx, groupID, []uuid.UUID{deviceID}, MembershipSourceInventory); err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, groupID := range leaving {
+		if _, err := RemoveDeviceGroupMembers(tx, groupID, []uuid.UUID{deviceID}, MembershipSourceInventory); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return len(joining), len(leaving), tx.Commit()
+}
+
+// GroupMembershipFilter holds the optional filters of membership history queries
+type GroupMembershipFilter struct {
+	GroupID  *uuid.UUID
+	DeviceID *uuid.UUID
+}
+
+func buildGroupMembershipWhere(filter GroupMembershipFilter) (string, []interface{}) {
+	var whereClauses []string
+	var args []interface{}
+
+	if filter.GroupID != nil {
+		whereClauses = append(whereClauses, "h.group_id = ?")
+		args = append(args, *filter.GroupID)
+	}
+	if filter.DeviceID != nil {
+		whereClauses = append(whereClauses, "h.device_id = ?")
+		args = append(args, *filter.DeviceID)
 	}
 
+	if len(whereClauses) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(whereClauses, " AND "), args
+}
+
+// ListGroupMembershipHistory retrieves membership changes, newest first
+func ListGroupMembershipHistory(db *sqlx.DB, filter GroupMembershipFilter, offset, limit int) ([]models.GroupMembershipEventListItem, error) {
+	var events []models.GroupMembershipEventListItem
+	whereClause, args := buildGroupMembershipWhere(filter)
+
 	query := `
-		SELECT 
-			si.name, 
-			si.version, 
-			si.publisher,
-			COUNT(DISTINCT s.device_id) as device_count,
-			MAX(s.collected_at) as latest_seen
-		FROM software_items si
-		JOIN snapshots s ON si.snapshot_id = s.id` +
+		SELECT h.*, g.name AS group_name, d.hostname
+		FROM device_group_membership_history h
+		JOIN device_groups g ON g.id = h.group_id
+		JOIN devices d ON d.id = h.device_id` +
 		whereClause +
-		` GROUP BY si.name, si.version, si.publisher
-		ORDER BY ` + orderBy +
-		` LIMIT ?` + strconv.Itoa(argCount) + ` OFFSET ?` + strconv.Itoa(argCount+1)
-
+		` ORDER BY h.changed_at DESC
+		LIMIT ? OFFSET ?`
 	args = append(args, limit, offset)
 
-	err := db.Select(&catalog, query, args...)
+	if err := db.Select(&events, query, args...); err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []models.GroupMembershipEventListItem{}
+	}
+	return events, nil
+}
+
+// CountGroupMembershipHistory returns the number of membership changes matching filter
+func CountGroupMembershipHistory(db *sqlx.DB, filter GroupMembershipFilter) (int, error) {
+	var count int
+	whereClause, args := buildGroupMembershipWhere(filter)
+	err := db.Get(&count, `SELECT COUNT(*) FROM device_group_membership_history h`+whereClause, args...)
+	return count, err
+}
+
+// Device tag queries
+
+// ListTags retrieves all tags in use with the number of devices carrying each
+func ListTags(db *sqlx.DB) ([]models.TagSummary, error) {
+	var tags []models.TagSummary
+	query := `
+		SELECT tag, COUNT(*) AS device_count
+		FROM device_tags
+		GROUP BY tag
+		ORDER BY tag ASC`
+
+	if err := db.Select(&tags, query); err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = []models.TagSummary{}
+	}
+	return tags, nil
+}
+
+// AddDeviceTag tags devices, ignoring devices that already carry the tag.
+// Returns the number of devices tagged.
+func AddDeviceTag(tx *sqlx.Tx, tag string, deviceIDs []uuid.UUID) (int, error) {
+	added := 0
+	now := time.Now().UTC()
+	for _, deviceID := range deviceIDs {
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO device_tags (device_id, tag, created_at)
+			VALUES (?, ?, ?)`, deviceID, tag, now)
+		if err != nil {
+			return added, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return added, err
+		}
+		added += int(affected)
+	}
+	return added, nil
+}
+
+// RemoveDeviceTag removes a tag from devices. Returns the number of devices untagged.
+func RemoveDeviceTag(tx *sqlx.Tx, tag string, deviceIDs []uuid.UUID) (int, error) {
+	query, args, err := sqlx.In(`DELETE FROM device_tags WHERE tag = ? AND device_id IN (?)`, tag, deviceIDs)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	return int(removed), err
+}
+
+// ReplaceDeviceTags sets the tags of a device, removing any tags not listed
+func ReplaceDeviceTags(tx *sqlx.Tx, deviceID uuid.UUID, tags []string) error {
+	if _, err := tx.Exec(`DELETE FROM device_tags WHERE device_id = ?`, deviceID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := AddDeviceTag(tx, tag, []uuid.UUID{deviceID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDeviceTags retrieves the tags of several devices, keyed by device ID
+func GetDeviceTags(db *sqlx.DB, deviceIDs []uuid.UUID) (map[uuid.UUID][]string, error) {
+	tags := make(map[uuid.UUID][]string)
+	if len(deviceIDs) == 0 {
+		return tags, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT device_id, tag FROM device_tags WHERE device_id IN (?) ORDER BY tag ASC`, deviceIDs)
 	if err != nil {
 		return nil, err
 	}
 
-	// Return empty slice if no software found
-	if catalog == nil {
-		catalog = []models.SoftwareCatalogItem{}
+	var rows []struct {
+		DeviceID uuid.UUID `db:"device_id"`
+		Tag      string    `db:"tag"`
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tags[row.DeviceID] = append(tags[row.DeviceID], row.Tag)
 	}
+	return tags, nil
+}
 
-	return catalog, nil
+// Snapshot queries
+
+// FindSnapshotByHash retrieves the device's latest snapshot if it matches the given hash.
+// Older snapshots are not considered so that reverting to a previous state still
+// records a new snapshot after the intervening one.
+func FindSnapshotByHash(db *sqlx.DB, deviceID uuid.UUID, hash string) (*models.Snapshot, error) {
+	var snapshot models.Snapshot
+	query := `
+		SELECT * FROM snapshots
+		WHERE id = (
+			SELECT id FROM snapshots
+			WHERE device_id = ?
+			ORDER BY collected_at DESC
+			LIMIT 1
+		) AND snapshot_hash = ?`
+	err := db.Get(&snapshot, query, deviceID, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
 }
 
-// CountSoftwareCatalog returns the count of unique software items with filters
-func CountSoftwareCatalog(db *sqlx.DB, search, publisher string) (int, error) {
-	var count int
-	var args []interface{}
-	var whereClauses []string
-	argCount := 1
+// CreateSnapshot inserts a new snapshot and returns the generated ID
+func CreateSnapshot(tx *sqlx.Tx, snapshot *models.Snapshot) (uuid.UUID, error) {
+	query := `
+                INSERT INTO snapshots (
+                        id, device_id, collected_at, agent_version, snapshot_hash,
+                        cpu_percent, memory_used_bytes, memory_total_bytes,
+                        boot_time, last_interactive_user,
+                        hostname, domain, os_caption, os_version, os_build,
+                        manufacturer, model, serial_number
+                ) VALUES (
+                        :id, :device_id, :collected_at, :agent_version, :snapshot_hash,
+                        :cpu_percent, :memory_used_bytes, :memory_total_bytes,
+                        :boot_time, :last_interactive_user,
+                        :hostname, :domain, :os_caption, :os_version, :os_build,
+                        :manufacturer, :model, :serial_number
+                )`
 
-	// Build same dynamic WHERE clause as ListSoftwareCatalog
-	if search != "" {
-		whereClauses = append(whereClauses, "si.name LIKE '%' || ?"+strconv.Itoa(argCount)+" || '%'")
-		args = append(args, search)
-		argCount++
+	_, err := tx.NamedExec(query, snapshot)
+	return snapshot.ID, err
+}
+
+// CreateSnapshotCheckin inserts a check-in record for an existing snapshot and
+// reports whether it was inserted. A replayed submission with a collection
+// time already recorded for the device is ignored.
+func CreateSnapshotCheckin(tx *sqlx.Tx, checkin *models.SnapshotCheckin) (bool, error) {
+	query := `
+		INSERT INTO snapshot_checkins (
+			id, snapshot_id, device_id, collected_at,
+			cpu_percent, memory_used_bytes, memory_total_bytes,
+			boot_time, last_interactive_user, volume_free_bytes
+		) VALUES (
+			:id, :snapshot_id, :device_id, :collected_at,
+			:cpu_percent, :memory_used_bytes, :memory_total_bytes,
+			:boot_time, :last_interactive_user, :volume_free_bytes
+		)
+		ON CONFLICT DO NOTHING`
+
+	result, err := tx.NamedExec(query, checkin)
+	if err != nil {
+		return false, err
 	}
 
-	if publisher != "" {
-		whereClauses = append(whereClauses, "si.publisher = ?"+strconv.Itoa(argCount))
-		args = append(args, publisher)
-		argCount++
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
 	}
+	return inserted > 0, nil
+}
 
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+// CreateVolumes batch inserts volumes for a snapshot
+func CreateVolumes(tx *sqlx.Tx, snapshotID uuid.UUID, volumes []models.Volume) error {
+	if len(volumes) == 0 {
+		return nil
 	}
 
 	query := `
-		SELECT COUNT(*) FROM (
-			SELECT si.name, si.version, si.publisher
-			FROM software_items si
-			JOIN snapshots s ON si.snapshot_id = s.id` +
-		whereClause +
-		` GROUP BY si.name, si.version, si.publisher
-		) AS catalog`
+		INSERT INTO volumes (id, snapshot_id, name, filesystem, total_bytes, free_bytes)
+		VALUES (:id, :snapshot_id, :name, :filesystem, :total_bytes, :free_bytes)`
 
-	err := db.Get(&count, query, args...)
-	return count, err
+	// Add snapshot_id and generate UUIDs for each volume
+	for i := range volumes {
+		volumes[i].ID = uuid.New()
+		volumes[i].SnapshotID = snapshotID
+	}
+
+	_, err := tx.NamedExec(query, volumes)
+	return err
 }
 
-// Audit log queries
+// CreateSoftwareItems stores the software list of a snapshot as a content-addressed
+// set and links the snapshot to it. Items are only inserted the first time a set is seen.
+func CreateSoftwareItems(tx *sqlx.Tx, snapshotID uuid.UUID, software []models.Software, normalizer *normalize.Normalizer) error {
+	if len(software) == 0 {
+		return nil
+	}
 
-// ListAuditLogs retrieves audit logs with filters and joined data
-func ListAuditLogs(db *sqlx.DB, offset, limit int, userID, deviceID *uuid.UUID, action string, startDate, endDate *time.Time) ([]models.AuditLogListItem, error) {
-	var auditLogs []models.AuditLogListItem
-	var args []interface{}
-	var whereClauses []string
-	argCount := 1
+	// Sets are keyed by the software as reported so that changing the
+	// normalization rules does not split identical lists into new sets
+	for i := range software {
+		software[i].RawName = software[i].Name
+		software[i].RawPublisher = software[i].Publisher
+	}
 
-	// Build dynamic WHERE clause
-	if userID != nil {
-		whereClauses = append(whereClauses, "al.user_id = ?"+strconv.Itoa(argCount))
-		args = append(args, *userID)
-		argCount++
+	setHash, err := CalculateSoftwareSetHash(software)
+	if err != nil {
+		return err
 	}
 
-	if deviceID != nil {
-		whereClauses = append(whereClauses, "al.device_id = ?"+strconv.Itoa(argCount))
-		args = append(args, *deviceID)
-		argCount++
+	normalizer.Software(software)
+
+	result, err := tx.Exec(`INSERT OR IGNORE INTO software_sets (hash, item_count) VALUES (?, ?)`, setHash, len(software))
+	if err != nil {
+		return err
 	}
 
-	if action != "" {
-		whereClauses = append(whereClauses, "al.action = ?"+strconv.Itoa(argCount))
-		args = append(args, action)
-		argCount++
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if inserted > 0 {
+		query := `
+			INSERT INTO software_set_items (id, set_hash, name, version, publisher, install_date, size_kb, raw_name, raw_publisher)
+			VALUES (:id, :set_hash, :name, :version, :publisher, :install_date, :size_kb, :raw_name, :raw_publisher)`
+
+		items := make([]softwareSetItemRow, len(software))
+		for i := range software {
+			software[i].ID = uuid.New()
+			items[i] = softwareSetItemRow{Software: software[i], SetHash: setHash}
+		}
+
+		if _, err := tx.NamedExec(query, items); err != nil {
+			return err
+		}
+	}
+
+	for i := range software {
+		software[i].SnapshotID = snapshotID
+	}
+
+	_, err = tx.Exec(`UPDATE snapshots SET software_set_hash = ? WHERE id = ?`, setHash, snapshotID)
+	return err
+}
+
+// softwareSetItemRow binds a software item to its set for insertion
+type softwareSetItemRow struct {
+	models.Software
+	SetHash string `db:"set_hash"`
+}
+
+// GetLatestSnapshotCheckin retrieves the most recent check-in recorded against a snapshot
+func GetLatestSnapshotCheckin(db *sqlx.DB, snapshotID uuid.UUID) (*models.SnapshotCheckin, error) {
+	var checkin models.SnapshotCheckin
+	query := `
+		SELECT * FROM snapshot_checkins
+		WHERE snapshot_id = ?
+		ORDER BY collected_at DESC
+		LIMIT 1`
+
+	err := db.Get(&checkin, query, snapshotID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &checkin, nil
+}
+
+// FindSnapshotByID retrieves a snapshot by its ID
+func FindSnapshotByID(db *sqlx.DB, snapshotID uuid.UUID) (*models.Snapshot, error) {
+	var snapshot models.Snapshot
+	query := `SELECT * FROM snapshots WHERE id = ?`
+	err := db.Get(&snapshot, query, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// ListSnapshotsByDevice retrieves snapshot summaries for a device with pagination
+func ListSnapshotsByDevice(db *sqlx.DB, deviceID uuid.UUID, offset, limit int) ([]models.SnapshotSummary, error) {
+	var summaries []models.SnapshotSummary
+	query := `
+		SELECT id, collected_at, cpu_percent, memory_used_bytes, memory_total_bytes, boot_time
+		FROM snapshots 
+		WHERE device_id = ? 
+		ORDER BY collected_at DESC 
+		LIMIT ? OFFSET ?`
+
+	err := db.Select(&summaries, query, deviceID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return empty slice if no snapshots found
+	if summaries == nil {
+		summaries = []models.SnapshotSummary{}
+	}
+
+	return summaries, nil
+}
+
+// FindLatestSnapshots retrieves the most recent snapshots for a device, newest first
+func FindLatestSnapshots(db *sqlx.DB, deviceID uuid.UUID, limit int) ([]models.Snapshot, error) {
+	var snapshots []models.Snapshot
+	query := `
+		SELECT * FROM snapshots
+		WHERE device_id = ?
+		ORDER BY collected_at DESC
+		LIMIT ?`
+
+	err := db.Select(&snapshots, query, deviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// FindPreviousSnapshot retrieves the snapshot collected immediately before the given one
+func FindPreviousSnapshot(db sqlx.Queryer, snapshot *models.Snapshot) (*models.Snapshot, error) {
+	var previous models.Snapshot
+	query := `
+		SELECT * FROM snapshots
+		WHERE device_id = ? AND collected_at < ?
+		ORDER BY collected_at DESC
+		LIMIT 1`
+
+	err := sqlx.Get(db, &previous, query, snapshot.DeviceID, snapshot.CollectedAt)
+	if err != nil {
+		return nil, err
 	}
+	return &previous, nil
+}
+
+// CountSnapshotsByDevice returns the number of snapshots for a device
+func CountSnapshotsByDevice(db *sqlx.DB, deviceID uuid.UUID) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM snapshots WHERE device_id = ?`
+	err := db.Get(&count, query, deviceID)
+	return count, err
+}
+
+// GetVolumesBySnapshot retrieves volumes for a snapshot
+func GetVolumesBySnapshot(db *sqlx.DB, snapshotID uuid.UUID) ([]models.Volume, error) {
+	var volumes []models.Volume
+	query := `SELECT * FROM volumes WHERE snapshot_id = ? ORDER BY name ASC`
+
+	err := db.Select(&volumes, query, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return empty slice if no volumes found
+	if volumes == nil {
+		volumes = []models.Volume{}
+	}
+
+	return volumes, nil
+}
+
+// GetSoftwareBySnapshot retrieves software items for a snapshot
+func GetSoftwareBySnapshot(db sqlx.Queryer, snapshotID uuid.UUID) ([]models.Software, error) {
+	var software []models.Software
+	query := `
+		SELECT si.id, s.id AS snapshot_id, si.name, si.version, si.publisher,
+			si.install_date, si.size_kb, si.created_at, si.raw_name, si.raw_publisher
+		FROM snapshots s
+		JOIN software_set_items si ON si.set_hash = s.software_set_hash
+		WHERE s.id = ?
+		ORDER BY si.name ASC`
+
+	err := sqlx.Select(db, &software, query, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return empty slice if no software found
+	if software == nil {
+		software = []models.Software{}
+	}
+
+	return software, nil
+}
+
+// Software device queries
+
+// ListSoftwareTitleVersions returns the distinct versions of a software title currently installed
+func ListSoftwareTitleVersions(db *sqlx.DB, name, publisher string) ([]string, error) {
+	versions := []string{}
+	query := `
+		SELECT DISTINCT version FROM device_current_software
+		WHERE name = ? COLLATE NOCASE AND (? = '' OR publisher = ? COLLATE NOCASE)`
+	err := db.Select(&versions, query, name, publisher, publisher)
+	return versions, err
+}
+
+func buildSoftwareDevicesWhere(name, publisher string, versions []string) (string, []interface{}, error) {
+	whereClause := ` WHERE cs.name = ? COLLATE NOCASE AND (? = '' OR cs.publisher = ? COLLATE NOCASE)`
+	args := []interface{}{name, publisher, publisher}
+
+	if versions != nil {
+		inClause, inArgs, err := sqlx.In(` AND cs.version IN (?)`, versions)
+		if err != nil {
+			return "", nil, err
+		}
+		whereClause += inClause
+		args = append(args, inArgs...)
+	}
+	return whereClause, args, nil
+}
+
+// ListSoftwareDevices returns the devices that currently have a software title
+// installed, optionally restricted to the given versions
+func ListSoftwareDevices(db *sqlx.DB, name, publisher string, versions []string, offset, limit int) ([]models.SoftwareDeviceItem, error) {
+	items := []models.SoftwareDeviceItem{}
+	whereClause, args, err := buildSoftwareDevicesWhere(name, publisher, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT d.id AS device_id, d.hostname, d.status, d.last_seen,
+			cs.name, cs.version, cs.publisher, cs.install_date
+		FROM device_current_software cs
+		JOIN devices d ON d.id = cs.device_id` +
+		whereClause +
+		` ORDER BY d.hostname ASC, cs.version ASC
+		LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	err = db.Select(&items, query, args...)
+	return items, err
+}
+
+// CountSoftwareDevices counts the rows returned by ListSoftwareDevices
+func CountSoftwareDevices(db *sqlx.DB, name, publisher string, versions []string) (int, error) {
+	var count int
+	whereClause, args, err := buildSoftwareDevicesWhere(name, publisher, versions)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `SELECT COUNT(*) FROM device_current_software cs` + whereClause
+	err = db.Get(&count, query, args...)
+	return count, err
+}
+
+// ListSoftwareVersionCounts returns the number of devices currently running each
+// version of every software title, optionally filtered by name and publisher
+func ListSoftwareVersionCounts(db *sqlx.DB, search, publisher string) ([]SoftwareVersionRow, error) {
+	rows := []SoftwareVersionRow{}
+	query := `
+		SELECT name, publisher, version, COUNT(DISTINCT device_id) AS device_count
+		FROM device_current_software
+		WHERE (? = '' OR name LIKE '%' || ? || '%')
+		  AND (? = '' OR publisher = ?)
+		GROUP BY name, publisher, version`
+	err := db.Select(&rows, query, search, search, publisher, publisher)
+	return rows, err
+}
+
+// SoftwareVersionRow is the number of devices running one version of a title
+type SoftwareVersionRow struct {
+	Name      string `db:"name"`
+	Publisher string `db:"publisher"`
+	models.SoftwareVersionCount
+}
+
+// BuildOutdatedSoftware determines the newest version of each title and the
+// versions lagging behind it. Pre-releases only count as the newest version
+// when a title has no stable release installed. Titles are ordered by the
+// number of outdated devices.
+func BuildOutdatedSoftware(rows []SoftwareVersionRow) []models.OutdatedSoftwareItem {
+	type titleKey struct{ name, publisher string }
+	byTitle := make(map[titleKey][]models.SoftwareVersionCount)
+	var keys []titleKey
+	for _, row := range rows {
+		key := titleKey{row.Name, row.Publisher}
+		if _, seen := byTitle[key]; !seen {
+			keys = append(keys, key)
+		}
+		byTitle[key] = append(byTitle[key], row.SoftwareVersionCount)
+	}
+
+	items := []models.OutdatedSoftwareItem{}
+	for _, key := range keys {
+		counts := byTitle[key]
+		sort.Slice(counts, func(i, j int) bool { return versions.Less(counts[j].Version, counts[i].Version) })
+
+		latest := counts[0]
+		for _, count := range counts {
+			if !versions.Parse(count.Version).IsPrerelease() {
+				latest = count
+				break
+			}
+		}
+
+		item := models.OutdatedSoftwareItem{
+			Name:             key.name,
+			Publisher:        key.publisher,
+			LatestVersion:    latest.Version,
+			OutdatedVersions: []models.SoftwareVersionCount{},
+			Devices:          []models.SoftwareDeviceItem{},
+		}
+		for _, count := range counts {
+			switch cmp := versions.Compare(count.Version, latest.Version); {
+			case cmp == 0:
+				item.LatestDeviceCount += count.DeviceCount
+			case cmp < 0:
+				item.OutdatedVersions = append(item.OutdatedVersions, count)
+				item.OutdatedDeviceCount += count.DeviceCount
+			}
+		}
+		if item.OutdatedDeviceCount > 0 {
+			items = append(items, item)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].OutdatedDeviceCount != items[j].OutdatedDeviceCount {
+			return items[i].OutdatedDeviceCount > items[j].OutdatedDeviceCount
+		}
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
+
+// Software event queries
+
+// CreateSoftwareEvents inserts the software events derived from a new snapshot
+func CreateSoftwareEvents(tx *sqlx.Tx, events []models.SoftwareEvent) error {
+	query := `
+		INSERT INTO software_events (
+			id, device_id, snapshot_id, previous_snapshot_id, event_type,
+			name, publisher, raw_name, raw_publisher, from_version, to_version,
+			interactive_user, occurred_at, created_at
+		) VALUES (
+			:id, :device_id, :snapshot_id, :previous_snapshot_id, :event_type,
+			:name, :publisher, :raw_name, :raw_publisher, :from_version, :to_version,
+			:interactive_user, :occurred_at, :created_at
+		)`
+
+	for _, event := range events {
+		if _, err := tx.NamedExec(query, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSnapshotEvents records a snapshot.created event for a new snapshot and
+// one software.installed and software.removed event listing the installs and
+// uninstalls found since the previous snapshot
+func RecordSnapshotEvents(tx *sqlx.Tx, snapshot *models.Snapshot, softwareEvents []models.SoftwareEvent, software, volumes int) error {
+	if err := events.Record(tx, models.EventSnapshotCreated, &snapshot.DeviceID, map[string]interface{}{
+		"snapshot_id":    snapshot.ID,
+		"collected_at":   snapshot.CollectedAt,
+		"agent_version":  snapshot.AgentVersion,
+		"hostname":       snapshot.Hostname,
+		"software_count": software,
+		"volume_count":   volumes,
+	}); err != nil {
+		return err
+	}
+
+	changes := map[models.EventType][]map[string]interface{}{}
+	for _, event := range softwareEvents {
+		switch event.EventType {
+		case models.SoftwareEventInstall:
+			changes[models.EventSoftwareInstalled] = append(changes[models.EventSoftwareInstalled], map[string]interface{}{
+				"name":      event.Name,
+				"publisher": event.Publisher,
+				"version":   event.ToVersion,
+			})
+		case models.SoftwareEventUninstall:
+			changes[models.EventSoftwareRemoved] = append(changes[models.EventSoftwareRemoved], map[string]interface{}{
+				"name":      event.Name,
+				"publisher": event.Publisher,
+				"version":   event.FromVersion,
+			})
+		}
+	}
+
+	for _, eventType := range []models.EventType{models.EventSoftwareInstalled, models.EventSoftwareRemoved} {
+		if len(changes[eventType]) == 0 {
+			continue
+		}
+		if err := events.Record(tx, eventType, &snapshot.DeviceID, map[string]interface{}{
+			"snapshot_id": snapshot.ID,
+			"software":    changes[eventType],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SoftwareEventFilter holds the optional filters of software event list queries
+type SoftwareEventFilter struct {
+	DeviceID  *uuid.UUID
+	Name      string
+	Publisher string
+	EventType string
+	Since     *time.Time
+}
+
+func buildSoftwareEventWhere(filter SoftwareEventFilter) (string, []interface{}) {
+	var whereClauses []string
+	var args []interface{}
+
+	if filter.DeviceID != nil {
+		whereClauses = append(whereClauses, "e.device_id = ?")
+		args = append(args, *filter.DeviceID)
+	}
+	if filter.Name != "" {
+		whereClauses = append(whereClauses, "e.name = ? COLLATE NOCASE")
+		args = append(args, filter.Name)
+	}
+	if filter.Publisher != "" {
+		whereClauses = append(whereClauses, "e.publisher = ? COLLATE NOCASE")
+		args = append(args, filter.Publisher)
+	}
+	if filter.EventType != "" {
+		whereClauses = append(whereClauses, "e.event_type = ?")
+		args = append(args, filter.EventType)
+	}
+	if filter.Since != nil {
+		whereClauses = append(whereClauses, "e.occurred_at >= ?")
+		args = append(args, *filter.Since)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+	return whereClause, args
+}
+
+// ListSoftwareEvents retrieves software events matching filter, newest first,
+// with the hostname of their device
+func ListSoftwareEvents(db *sqlx.DB, filter SoftwareEventFilter, offset, limit int) ([]models.SoftwareEventListItem, error) {
+	events := []models.SoftwareEventListItem{}
+	whereClause, args := buildSoftwareEventWhere(filter)
+
+	query := `
+		SELECT e.*, d.hostname
+		FROM software_events e
+		JOIN devices d ON d.id = e.device_id` +
+		whereClause +
+		` ORDER BY e.occurred_at DESC, e.name ASC
+		LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	err := db.Select(&events, query, args...)
+	return events, err
+}
+
+// CountSoftwareEvents returns the number of software events matching filter
+func CountSoftwareEvents(db *sqlx.DB, filter SoftwareEventFilter) (int, error) {
+	var count int
+	whereClause, args := buildSoftwareEventWhere(filter)
+
+	query := `SELECT COUNT(*) FROM software_events e` + whereClause
+	err := db.Get(&count, query, args...)
+	return count, err
+}
+
+// CreateCommand inserts a new command and starts its status history
+func CreateCommand(db sqlx.Ext, command *models.Command, reason string) error {
+	query := `
+		INSERT INTO commands (id, device_id, command_type, payload, status, created_at, timeout_seconds,
+			attempts, max_attempts, not_before, expires_at, batch_id)
+		VALUES (:id, :device_id, :command_type, :payload, :status, :created_at, :timeout_seconds,
+			:attempts, :max_attempts, :not_before, :expires_at, :batch_id)`
+
+	if _, err := sqlx.NamedExec(db, query, command); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO command_status_history (command_id, previous_status, status, reason, attempt, changed_at)
+		VALUES (?, NULL, ?, ?, 0, ?)`, command.ID, command.Status, reason, command.CreatedAt)
+	return err
+}
+
+// ListCommandsByDevice retrieves commands for a device with optional status filter
+func ListCommandsByDevice(db *sqlx.DB, deviceID uuid.UUID, offset, limit int, status string) ([]models.Command, error) {
+	var commands []models.Command
+	var args []interface{}
+
+	whereClause := "WHERE device_id = ?"
+	args = append(args, deviceID)
+
+	if status != "" {
+		whereClause += " AND status = ?"
+		args = append(args, status)
+	}
+
+	query := "SELECT " + commandColumns + " FROM commands " + whereClause + " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	err := db.Select(&commands, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return empty slice if no commands found
+	if commands == nil {
+		commands = []models.Command{}
+	}
+
+	return commands, nil
+}
+
+// CountCommandsByDevice returns the number of commands for a device with optional status filter
+func CountCommandsByDevice(db *sqlx.DB, deviceID uuid.UUID, status string) (int, error) {
+	var count int
+	var args []interface{}
+
+	whereClause := "WHERE device_id = ?"
+	args = append(args, deviceID)
+
+	if status != "" {
+		whereClause += " AND status = ?"
+		args = append(args, status)
+	}
+
+	query := "SELECT COUNT(*) FROM commands " + whereClause
+	err := db.Get(&count, query, args...)
+	return count, err
+}
+
+// Software catalog queries
+
+// ListSoftwareCatalog retrieves aggregated software catalog with filters
+func ListSoftwareCatalog(db *sqlx.DB, offset, limit int, search, publisher, sortBy string, scope DeviceScope) ([]models.SoftwareCatalogItem, error) {
+	var catalog []models.SoftwareCatalogItem
+	whereClause, args := buildSoftwareCatalogWhere(search, publisher, scope)
+
+	// Build ORDER BY clause
+	var orderBy string
+	switch sortBy {
+	case "name":
+		orderBy = "cs.name ASC"
+	case "latest_seen":
+		orderBy = "latest_seen DESC"
+	case "version":
+		// Versions are ordered in Go, so the whole catalog is read and paginated afterwards
+		orderBy = "cs.name ASC"
+	default: // "device_count"
+		orderBy = "device_count DESC"
+	}
+
+	// Aggregated timestamps lose their column type, so latest_seen is scanned as text
+	var rows []struct {
+		models.SoftwareCatalogItem
+		LatestSeen string `db:"latest_seen"`
+	}
+
+	query := `
+		SELECT 
+			cs.name, 
+			cs.version, 
+			cs.publisher,
+			COUNT(DISTINCT cs.device_id) as device_count,
+			MAX(st.collected_at) as latest_seen
+		FROM device_current_software cs
+		JOIN device_current_state st ON st.device_id = cs.device_id` +
+		whereClause +
+		` GROUP BY cs.name, cs.version, cs.publisher
+		ORDER BY ` + orderBy + `, cs.name ASC, cs.version ASC
+		LIMIT ? OFFSET ?`
+
+	if sortBy == "version" {
+		args = append(args, -1, 0)
+	} else {
+		args = append(args, limit, offset)
+	}
+
+	err := db.Select(&rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if sortBy == "version" {
+		// Titles by name, newest version first within each title
+		sort.SliceStable(rows, func(i, j int) bool {
+			if rows[i].Name != rows[j].Name {
+				return rows[i].Name < rows[j].Name
+			}
+			return versions.Less(rows[j].Version, rows[i].Version)
+		})
+		if offset >= len(rows) {
+			rows = rows[:0]
+		} else {
+			rows = rows[offset:]
+		}
+		if len(rows) > limit {
+			rows = rows[:limit]
+		}
+	}
+
+	catalog = make([]models.SoftwareCatalogItem, 0, len(rows))
+	for _, row := range rows {
+		item := row.SoftwareCatalogItem
+		if item.LatestSeen, err = ParseDBTime(row.LatestSeen); err != nil {
+			return nil, err
+		}
+		catalog = append(catalog, item)
+	}
+
+	return catalog, nil
+}
+
+// buildSoftwareCatalogWhere builds the WHERE clause shared by the catalog list and count queries
+func buildSoftwareCatalogWhere(search, publisher string, scope DeviceScope) (string, []interface{}) {
+	var whereClauses []string
+	var args []interface{}
+
+	if search != "" {
+		whereClauses = append(whereClauses, "cs.name LIKE '%' || ? || '%'")
+		args = append(args, search)
+	}
+	if publisher != "" {
+		whereClauses = append(whereClauses, "cs.publisher = ?")
+		args = append(args, publisher)
+	}
+
+	scopeClauses, scopeArgs := scope.conditions("cs.device_id")
+	whereClauses = append(whereClauses, scopeClauses...)
+	args = append(args, scopeArgs...)
+
+	if len(whereClauses) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(whereClauses, " AND "), args
+}
+
+// CountSoftwareCatalog returns the count of unique software items with filters
+func CountSoftwareCatalog(db *sqlx.DB, search, publisher string, scope DeviceScope) (int, error) {
+	var count int
+	whereClause, args := buildSoftwareCatalogWhere(search, publisher, scope)
+
+	query := `
+		SELECT COUNT(*) FROM (
+			SELECT cs.name, cs.version, cs.publisher
+			FROM device_current_software cs` +
+		whereClause +
+		` GROUP BY cs.name, cs.version, cs.publisher
+		) AS catalog`
+
+	err := db.Get(&count, query, args...)
+	return count, err
+}
+
+// Audit log queries
+
+// ListAuditLogs retrieves audit logs with filters and joined data
+func ListAuditLogs(db *sqlx.DB, offset, limit int, userID, deviceID *uuid.UUID, action string, startDate, endDate *time.Time) ([]models.AuditLogListItem, error) {
+	var auditLogs []models.AuditLogListItem
+	var args []interface{}
+	var whereClauses []string
+	argCount := 1
+
+	// Build dynamic WHERE clause
+	if userID != nil {
+		whereClauses = append(whereClauses, "al.user_id = ?"+strconv.Itoa(argCount))
+		args = append(args, *userID)
+		argCount++
+	}
+
+	if deviceID != nil {
+		whereClauses = append(whereClauses, "al.device_id = ?"+strconv.Itoa(argCount))
+		args = append(args, *deviceID)
+		argCount++
+	}
+
+	if action != "" {
+		whereClauses = append(whereClauses, "al.action = ?"+strconv.Itoa(argCount))
+		args = append(args, action)
+		argCount++
+	}
+
+	if startDate != nil {
+		whereClauses = append(whereClauses, "al.timestamp >= ?"+strconv.Itoa(argCount))
+		args = append(args, *startDate)
+		argCount++
+	}
+
+	if endDate != nil {
+		whereClauses = append(whereClauses, "al.timestamp <= ?"+strconv.Itoa(argCount))
+		args = append(args, *endDate)
+		argCount++
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := `
+		SELECT 
+			al.id, al.user_id, al.device_id, al.action, al.details, 
+			al.timestamp, al.ip_address, al.user_agent,
+			u.username,
+			d.hostname
+		FROM audit_logs al
+		LEFT JOIN users u ON al.user_id = u.id
+		LEFT JOIN devices d ON al.device_id = d.id` +
+		whereClause +
+		` ORDER BY al.timestamp DESC
+		LIMIT ?` + strconv.Itoa(argCount) + ` OFFSET ?` + strconv.Itoa(argCount+1)
+
+	args = append(args, limit, offset)
+
+	err := db.Select(&auditLogs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return empty slice if no logs found
+	if auditLogs == nil {
+		auditLogs = []models.AuditLogListItem{}
+	}
+
+	return auditLogs, nil
+}
+
+// CountAuditLogs returns the count of audit logs with filters
+func CountAuditLogs(db *sqlx.DB, userID, deviceID *uuid.UUID, action string, startDate, endDate *time.Time) (int, error) {
+	var count int
+	var args []interface{}
+	var whereClauses []string
+	argCount := 1
+
+	// Build same dynamic WHERE clause as ListAuditLogs
+	if userID != nil {
+		whereClauses = append(whereClauses, "user_id = ?"+strconv.Itoa(argCount))
+		args = append(args, *userID)
+		argCount++
+	}
+
+	if deviceID != nil {
+		whereClauses = append(whereClauses, "device_id = ?"+strconv.Itoa(argCount))
+		args = append(args, *deviceID)
+		argCount++
+	}
+
+	if action != "" {
+		whereClauses = append(whereClauses, "action = ?"+strconv.Itoa(argCount))
+		args = append(args, action)
+		argCount++
+	}
+
+	if startDate != nil {
+		whereClauses = append(whereClauses, "timestamp >= ?"+strconv.Itoa(argCount))
+		args = append(args, *startDate)
+		argCount++
+	}
+
+	if endDate != nil {
+		whereClauses = append(whereClauses, "timestamp <= ?"+strconv.Itoa(argCount))
+		args = append(args, *endDate)
+		argCount++
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := "SELECT COUNT(*) FROM audit_logs" + whereClause
+	err := db.Get(&count, query, args...)
+	return count, err
+}
+
+// CreateAuditLog inserts a new audit log entry
+func CreateAuditLog(db *sqlx.DB, auditLog *models.AuditLog) error {
+	query := `
+		INSERT INTO audit_logs (id, user_id, device_id, action, details, timestamp, ip_address, user_agent)
+		VALUES (:id, :user_id, :device_id, :action, :details, :timestamp, :ip_address, :user_agent)`
+
+	_, err := db.NamedExec(query, auditLog)
+	return err
+}
+
+// Command lifecycle queries
+
+// commandColumns selects the columns of commands, reading a missing payload or
+// result as JSON null since json.RawMessage cannot be scanned from NULL
+const commandColumns = `
+	id, device_id, command_type,
+	COALESCE(payload, CAST('null' AS BLOB)) AS payload,
+	status, created_at, executed_at,
+	COALESCE(result, CAST('null' AS BLOB)) AS result,
+	timeout_seconds, attempts, max_attempts, not_before, expires_at, lease_expires_at, batch_id`
+
+// FindCommand retrieves a command by ID
+func FindCommand(db sqlx.Queryer, commandID uuid.UUID) (*models.Command, error) {
+	var command models.Command
+	err := sqlx.Get(db, &command, `SELECT `+commandColumns+` FROM commands WHERE id = ?`, commandID)
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// ListCommandStatusHistory returns the status changes of a command, oldest first
+func ListCommandStatusHistory(db sqlx.Queryer, commandID uuid.UUID) ([]models.CommandStatusChange, error) {
+	changes := []models.CommandStatusChange{}
+	err := sqlx.Select(db, &changes, `
+		SELECT * FROM command_status_history
+		WHERE command_id = ?
+		ORDER BY id ASC`, commandID)
+	return changes, err
+}
+
+// transitionCommand moves a command to a status, also setting the columns in
+// set, and records the change in its history. The update is guarded by the
+// status the command was loaded with, so it reports false when the command has
+// moved on in the meantime.
+func transitionCommand(db sqlx.Execer, command *models.Command, status models.CommandStatus, reason string, now time.Time, set string, args ...interface{}) (bool, error) {
+	query := `UPDATE commands SET status = ?`
+	if set != "" {
+		query += `, ` + set
+	}
+	query += ` WHERE id = ? AND status = ?`
+
+	queryArgs := append([]interface{}{status}, args...)
+	queryArgs = append(queryArgs, command.ID, command.Status)
+	result, err := db.Exec(query, queryArgs...)
+	if err != nil {
+		return false, err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return false, nil
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO command_status_history (command_id, previous_status, status, reason, attempt, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, command.ID, command.Status, status, reason, command.Attempts, now); err != nil {
+		return false, err
+	}
+
+	command.Status = status
+	return true, nil
+}
+
+// LeaseCommands hands the queued commands of a device that are due to its
+// agent. Each is moved to in_progress for an attempt, leased for its timeout
+// plus the configured grace period. Release expired leases first.
+func LeaseCommands(db sqlx.Ext, cfg *config.Config, deviceID uuid.UUID, now time.Time) ([]models.Command, error) {
+	commands := []models.Command{}
+	err := sqlx.Select(db, &commands, `
+		SELECT `+commandColumns+` FROM commands
+		WHERE device_id = ? AND status = 'queued'
+		  AND (not_before IS NULL OR not_before <= ?)
+		  AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY created_at ASC`, deviceID, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	leased := []models.Command{}
+	for i := range commands {
+		command := &commands[i]
+		leaseExpiresAt := now.Add(time.Duration(command.TimeoutSeconds)*time.Second + cfg.CommandLeaseGrace)
+
+		command.Attempts++
+		moved, err := transitionCommand(db, command, models.CommandStatusInProgress,
+			fmt.Sprintf("leased to agent until %s", leaseExpiresAt.Format(time.RFC3339)), now,
+			`attempts = ?, lease_expires_at = ?`, command.Attempts, leaseExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if !moved {
+			continue
+		}
+		command.LeaseExpiresAt = &leaseExpiresAt
+		leased = append(leased, *command)
+	}
+
+	return leased, nil
+}
+
+// ReleaseExpiredLeases returns commands whose lease expired without an
+// acknowledgement to the queue, or fails them once their attempts are used up,
+// and returns the commands it moved. All devices are considered when deviceID
+// is nil.
+func ReleaseExpiredLeases(db sqlx.Ext, deviceID *uuid.UUID, now time.Time) ([]models.Command, error) {
+	query := `SELECT ` + commandColumns + ` FROM commands WHERE status = 'in_progress' AND lease_expires_at <= ?`
+	args := []interface{}{now}
+	if deviceID != nil {
+		query += ` AND device_id = ?`
+		args = append(args, *deviceID)
+	}
+
+	commands := []models.Command{}
+	if err := sqlx.Select(db, &commands, query, args...); err != nil {
+		return nil, err
+	}
+
+	released := []models.Command{}
+	for i := range commands {
+		command := &commands[i]
+
+		if command.Attempts < command.MaxAttempts {
+			moved, err := transitionCommand(db, command, models.CommandStatusQueued, "lease expired without acknowledgement", now,
+				`lease_expires_at = NULL`)
+			if err != nil {
+				return nil, err
+			}
+			if moved {
+				released = append(released, *command)
+			}
+			continue
+		}
+
+		result := models.CommandResult{
+			Success: false,
+			Error:   fmt.Sprintf("not acknowledged after %d attempts", command.Attempts),
+		}
+		resultJSON, _ := json.Marshal(result)
+		moved, err := transitionCommand(db, command, models.CommandStatusFailed, "lease expired with no attempts left", now,
+			`lease_expires_at = NULL, result = ?`, resultJSON)
+		if err != nil {
+			return nil, err
+		}
+		if !moved {
+			continue
+		}
+		if err := events.Record(db, models.EventCommandFailed, &command.DeviceID, map[string]interface{}{
+			"command_id":   command.ID,
+			"command_type": command.CommandType,
+			"result":       result,
+		}); err != nil {
+			return nil, err
+		}
+		released = append(released, *command)
+	}
+
+	return released, nil
+}
+
+// ExpireCommands expires the queued commands whose TTL has passed before they
+// could be delivered. All devices are considered when deviceID is nil.
+func ExpireCommands(db sqlx.Ext, deviceID *uuid.UUID, now time.Time) (int, error) {
+	query := `SELECT ` + commandColumns + ` FROM commands WHERE status = 'queued' AND expires_at <= ?`
+	args := []interface{}{now}
+	if deviceID != nil {
+		query += ` AND device_id = ?`
+		args = append(args, *deviceID)
+	}
+
+	commands := []models.Command{}
+	if err := sqlx.Select(db, &commands, query, args...); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range commands {
+		command := &commands[i]
+		moved, err := transitionCommand(db, command, models.CommandStatusExpired, "not delivered before it expired", now, "")
+		if err != nil {
+			return expired, err
+		}
+		if !moved {
+			continue
+		}
+		if err := events.Record(db, models.EventCommandExpired, &command.DeviceID, map[string]interface{}{
+			"command_id":   command.ID,
+			"command_type": command.CommandType,
+			"attempts":     command.Attempts,
+			"expires_at":   command.ExpiresAt,
+		}); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// AcknowledgeCommand records the result reported by the agent, completing or
+// failing the command. A late acknowledgement of a command whose lease
+// expired is still accepted as long as the command has not reached a final
+// status.
+func AcknowledgeCommand(db sqlx.Ext, command *models.Command, result *models.CommandResult, now time.Time) (bool, error) {
+	status := models.CommandStatusCompleted
+	eventType := models.EventCommandCompleted
+	reason := "completed by agent"
+	if !result.Success {
+		status = models.CommandStatusFailed
+		eventType = models.EventCommandFailed
+		reason = "failed on agent"
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	moved, err := transitionCommand(db, command, status, reason, now,
+		`executed_at = ?, result = ?, lease_expires_at = NULL`, now, resultJSON)
+	if err != nil || !moved {
+		return moved, err
+	}
+
+	if err := events.Record(db, eventType, &command.DeviceID, map[string]interface{}{
+		"command_id":   command.ID,
+		"command_type": command.CommandType,
+		"result":       result,
+	}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeliverCommands releases the expired leases and expires the commands of a
+// device, then leases the commands that are due to its agent
+func DeliverCommands(db sqlx.Ext, cfg *config.Config, deviceID uuid.UUID, now time.Time) ([]models.Command, error) {
+	if _, err := ReleaseExpiredLeases(db, &deviceID, now); err != nil {
+		return nil, fmt.Errorf("failed to release expired leases: %w", err)
+	}
+	if _, err := ExpireCommands(db, &deviceID, now); err != nil {
+		return nil, fmt.Errorf("failed to expire commands: %w", err)
+	}
+	return LeaseCommands(db, cfg, deviceID, now)
+}
+
+// SweepCommands releases expired leases and expires commands of all devices,
+// for devices that have stopped polling. Agents waiting for commands are
+// woken for the commands returned to the queue.
+func SweepCommands(db *sqlx.DB) (released, expired int, err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	moved, err := ReleaseExpiredLeases(tx, nil, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	if expired, err = ExpireCommands(tx, nil, now); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, command := range moved {
+		if command.Status == models.CommandStatusQueued {
+			commands.NotifyQueued(command.DeviceID)
+		}
+	}
+	return len(moved), expired, nil
+}
+
+// StartCommandSweeper periodically releases expired command leases and
+// expires commands that were not delivered in time
+func StartCommandSweeper(db *sqlx.DB, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.CommandSweepInterval)
+	go func() {
+		for range ticker.C {
+			released, expired, err := SweepCommands(db)
+			if err != nil {
+				log.Printf("[ERROR] Command sweep failed: %v", err)
+				continue
+			}
+			if released > 0 || expired > 0 {
+				log.Printf("[INFO] Released %d expired command leases and expired %d commands", released, expired)
+			}
+		}
+	}()
+}
+
+// Command batch queries
+
+const commandBatchColumns = `
+	id, command_type,
+	COALESCE(payload, CAST('null' AS BLOB)) AS payload,
+	target, created_by, created_at`
+
+// CreateCommandBatch inserts a command batch. Its commands are created
+// separately with the batch ID set.
+func CreateCommandBatch(db sqlx.Ext, batch *models.CommandBatch) error {
+	query := `
+		INSERT INTO command_batches (id, command_type, payload, target, created_by, created_at)
+		VALUES (:id, :command_type, :payload, :target, :created_by, :created_at)`
+	_, err := sqlx.NamedExec(db, query, batch)
+	return err
+}
+
+// FindCommandBatch retrieves a command batch by ID with the counts of its commands
+func FindCommandBatch(db *sqlx.DB, batchID uuid.UUID) (*models.CommandBatch, error) {
+	var batch models.CommandBatch
+	err := db.Get(&batch, `SELECT `+commandBatchColumns+` FROM command_batches WHERE id = ?`, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := CountCommandBatchStatuses(db, []uuid.UUID{batchID})
+	if err != nil {
+		return nil, err
+	}
+	batch.Counts = counts[batchID]
+	return &batch, nil
+}
+
+// ListCommandBatches retrieves command batches, newest first, with the counts
+// of their commands
+func ListCommandBatches(db *sqlx.DB, offset, limit int) ([]models.CommandBatch, error) {
+	batches := []models.CommandBatch{}
+	err := db.Select(&batches, `
+		SELECT `+commandBatchColumns+`
+		FROM command_batches
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil || len(batches) == 0 {
+		return batches, err
+	}
+
+	batchIDs := make([]uuid.UUID, len(batches))
+	for i, batch := range batches {
+		batchIDs[i] = batch.ID
+	}
+	counts, err := CountCommandBatchStatuses(db, batchIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range batches {
+		batches[i].Counts = counts[batches[i].ID]
+	}
+	return batches, nil
+}
+
+// CountCommandBatches counts all command batches
+func CountCommandBatches(db *sqlx.DB) (int, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM command_batches`)
+	return count, err
+}
+
+// CountCommandBatchStatuses counts the commands of the given batches by status
+func CountCommandBatchStatuses(db *sqlx.DB, batchIDs []uuid.UUID) (map[uuid.UUID]models.CommandBatchCounts, error) {
+	query, args, err := sqlx.In(`
+		SELECT batch_id, status, COUNT(*) AS count
+		FROM commands
+		WHERE batch_id IN (?)
+		GROUP BY batch_id, status`, batchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		BatchID uuid.UUID            `db:"batch_id"`
+		Status  models.CommandStatus `db:"status"`
+		Count   int                  `db:"count"`
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]models.CommandBatchCounts, len(batchIDs))
+	for _, row := range rows {
+		batchCounts := counts[row.BatchID]
+		batchCounts.Add(row.Status, row.Count)
+		counts[row.BatchID] = batchCounts
+	}
+	return counts, nil
+}
+
+// ListCommandBatchCommands retrieves the commands of a batch with the hostname
+// of their device, optionally limited to a status
+func ListCommandBatchCommands(db *sqlx.DB, batchID uuid.UUID, status string) ([]models.CommandBatchCommand, error) {
+	whereClause := "WHERE batch_id = ?"
+	args := []interface{}{batchID}
+	if status != "" {
+		whereClause += " AND status = ?"
+		args = append(args, status)
+	}
+
+	commands := []models.CommandBatchCommand{}
+	err := db.Select(&commands, `
+		SELECT c.*, COALESCE(d.hostname, '') AS hostname
+		FROM (SELECT `+commandColumns+` FROM commands `+whereClause+`) c
+		LEFT JOIN devices d ON d.id = c.device_id
+		ORDER BY hostname ASC`, args...)
+	return commands, err
+}
+
+// Agent configuration queries
+
+// FindDeviceAgentConfig retrieves the agent configuration set for a device
+func FindDeviceAgentConfig(db sqlx.Queryer, deviceID uuid.UUID) (*models.DeviceAgentConfig, error) {
+	var config models.DeviceAgentConfig
+	err := sqlx.Get(db, &config, `SELECT * FROM device_agent_config WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SetDeviceAgentConfig creates or replaces the agent configuration of a device
+func SetDeviceAgentConfig(db sqlx.Execer, config *models.DeviceAgentConfig) error {
+	query := `
+		INSERT INTO device_agent_config (device_id, config, updated_at, updated_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET
+			config = excluded.config,
+			updated_at = excluded.updated_at,
+			updated_by = excluded.updated_by`
+	_, err := db.Exec(query, config.DeviceID, config.Config, config.UpdatedAt, config.UpdatedBy)
+	return err
+}
+
+// User queries
+
+// FindUserByUsername retrieves a user by username
+func FindUserByUsername(db *sqlx.DB, username string) (*models.User, error) {
+	var user models.User
+	query := `SELECT * FROM users WHERE username = ?`
+	err := db.Get(&user, query, username)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindUserByID retrieves a user by ID
+func FindUserByID(db *sqlx.DB, userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	query := `SELECT * FROM users WHERE id = ?`
+	err := db.Get(&user, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers retrieves users with pagination
+func ListUsers(db *sqlx.DB, offset, limit int) ([]models.User, error) {
+	var users []models.User
+	query := `SELECT * FROM users ORDER BY created_at DESC LIMIT ? OFFSET ?`
+
+	err := db.Select(&users, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return empty slice if no users found
+	if users == nil {
+		users = []models.User{}
+	}
+
+	return users, nil
+}
+
+// CountUsers returns the total number of users
+func CountUsers(db *sqlx.DB) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM users`
+	err := db.Get(&count, query)
+	return count, err
+}
+
+// CountAdminUsers returns the number of admin users
+func CountAdminUsers(db *sqlx.DB) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM users WHERE role = 'admin'`
+	err := db.Get(&count, query)
+	return count, err
+}
+
+// CreateUser inserts a new user into the database
+func CreateUser(db *sqlx.DB, user *models.User) error {
+	query := `
+		INSERT INTO users (id, username, password_hash, role, created_at, updated_at)
+		VALUES (:id, :username, :password_hash, :role, :created_at, :updated_at)`
+
+	_, err := db.NamedExec(query, user)
+	return err
+}
+
+// UpdateUser updates user fields
+func UpdateUser(db *sqlx.DB, userID uuid.UUID, update *models.UserUpdate) error {
+	setParts := []string{}
+	args := []interface{}{}
+	argCount := 1
+
+	if update.Password != nil {
+		setParts = append(setParts, "password_hash = ?"+strconv.Itoa(argCount))
+		args = append(args, *update.Password)
+		argCount++
+	}
+
+	if update.Role != nil {
+		setParts = append(setParts, "role = ?"+strconv.Itoa(argCount))
+		args = append(args, string(*update.Role))
+		argCount++
+	}
+
+	if len(setParts) == 0 {
+		return nil // No updates to make
+	}
+
+	setParts = append(setParts, "updated_at = datetime('now')")
+
+	query := "UPDATE users SET " + strings.Join(setParts, ", ") + " WHERE id = ?" + strconv.Itoa(argCount)
+	args = append(args, userID)
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// DeleteUser removes a user from the database
+func DeleteUser(db *sqlx.DB, userID uuid.UUID) error {
+	query := `DELETE FROM users WHERE id = ?`
+	_, err := db.Exec(query, userID)
+	return err
+}
+
+func DeleteDevice(db *sqlx.DB, deviceID uuid.UUID) error {
+	query := `DELETE FROM devices WHERE id = ?`
+	_, err := db.Exec(query, deviceID)
+	return err
+}
+
+// Software set queries
+
+// DeleteOrphanedSoftwareSets removes software sets no longer referenced by any snapshot
+func DeleteOrphanedSoftwareSets(db *sqlx.DB) (int64, error) {
+	query := `
+		DELETE FROM software_sets
+		WHERE NOT EXISTS (
+			SELECT 1 FROM snapshots WHERE software_set_hash = software_sets.hash
+		)`
+
+	result, err := db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// BackfillSoftwareSets moves legacy per-snapshot software_items rows into
+// content-addressed software sets. It processes snapshots in batches and is
+// safe to run repeatedly; once all rows are migrated it is a no-op.
+func BackfillSoftwareSets(db *sqlx.DB) (int, error) {
+	migrated := 0
+
+	normalizer, err := LoadNormalizer(db)
+	if err != nil {
+		return migrated, err
+	}
+
+	for {
+		var snapshotIDs []uuid.UUID
+		if err := db.Select(&snapshotIDs, `SELECT DISTINCT snapshot_id FROM software_items LIMIT 100`); err != nil {
+			return migrated, err
+		}
+		if len(snapshotIDs) == 0 {
+			return migrated, nil
+		}
+
+		for _, snapshotID := range snapshotIDs {
+			if err := backfillSnapshotSoftwareSet(db, snapshotID, normalizer); err != nil {
+				return migrated, fmt.Errorf("failed to backfill snapshot %s: %w", snapshotID, err)
+			}
+			migrated++
+		}
+	}
+}
+
+func backfillSnapshotSoftwareSet(db *sqlx.DB, snapshotID uuid.UUID, normalizer *normalize.Normalizer) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var software []models.Software
+	if err := tx.Select(&software, `SELECT * FROM software_items WHERE snapshot_id = ?`, snapshotID); err != nil {
+		return err
+	}
+
+	if err := CreateSoftwareItems(tx, snapshotID, software, normalizer); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM software_items WHERE snapshot_id = ?`, snapshotID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Software normalization queries
+
+// ListNormalizationRules retrieves all normalization rules in the order they are applied
+func ListNormalizationRules(db sqlx.Queryer) ([]models.NormalizationRule, error) {
+	var rules []models.NormalizationRule
+	query := `
+		SELECT * FROM software_normalization_rules
+		ORDER BY field ASC, priority ASC, created_at ASC`
+
+	if err := sqlx.Select(db, &rules, query); err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []models.NormalizationRule{}
+	}
+	return rules, nil
+}
+
+// LoadNormalizer compiles the enabled normalization rules
+func LoadNormalizer(db sqlx.Queryer) (*normalize.Normalizer, error) {
+	rules, err := ListNormalizationRules(db)
+	if err != nil {
+		return nil, err
+	}
+	return normalize.Compile(rules)
+}
+
+// FindNormalizationRuleByID retrieves a normalization rule by its ID
+func FindNormalizationRuleByID(db *sqlx.DB, ruleID uuid.UUID) (*models.NormalizationRule, error) {
+	var rule models.NormalizationRule
+	if err := db.Get(&rule, `SELECT * FROM software_normalization_rules WHERE id = ?`, ruleID); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// CreateNormalizationRule inserts a new normalization rule
+func CreateNormalizationRule(db *sqlx.DB, rule *models.NormalizationRule) error {
+	query := `
+		INSERT INTO software_normalization_rules (
+			id, field, match_type, pattern, replacement, priority, enabled,
+			description, created_at, updated_at
+		) VALUES (
+			:id, :field, :match_type, :pattern, :replacement, :priority, :enabled,
+			:description, :created_at, :updated_at
+		)`
+
+	_, err := db.NamedExec(query, rule)
+	return err
+}
+
+// UpdateNormalizationRule saves all fields of an existing normalization rule
+func UpdateNormalizationRule(db *sqlx.DB, rule *models.NormalizationRule) error {
+	query := `
+		UPDATE software_normalization_rules
+		SET field = :field, match_type = :match_type, pattern = :pattern,
+			replacement = :replacement, priority = :priority, enabled = :enabled,
+			description = :description, updated_at = :updated_at
+		WHERE id = :id`
+
+	_, err := db.NamedExec(query, rule)
+	return err
+}
+
+// DeleteNormalizationRule removes a normalization rule
+func DeleteNormalizationRule(db *sqlx.DB, ruleID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM software_normalization_rules WHERE id = ?`, ruleID)
+	return err
+}
+
+// Current inventory queries
+
+// ReplaceDeviceCurrentInventory makes a new snapshot the device's current inventory.
+// Snapshots collected before the current one are ignored so that late, out of
+// order submissions do not roll the current state back. Returns whether the
+// current inventory was replaced.
+func ReplaceDeviceCurrentInventory(tx *sqlx.Tx, snapshot *models.Snapshot, software []models.Software, volumes []models.Volume) (bool, error) {
+	var newer int
+	err := tx.Get(&newer, `SELECT COUNT(*) FROM device_current_state WHERE device_id = ? AND collected_at > ?`,
+		snapshot.DeviceID, snapshot.CollectedAt)
+	if err != nil {
+		return false, err
+	}
+	if newer > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM device_current_software WHERE device_id = ?`, snapshot.DeviceID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM device_current_volumes WHERE device_id = ?`, snapshot.DeviceID); err != nil {
+		return false, err
+	}
+
+	softwareStmt, err := tx.Preparex(`
+		INSERT INTO device_current_software (device_id, name, version, publisher, install_date, size_kb, raw_name, raw_publisher)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return false, err
+	}
+	defer softwareStmt.Close()
+
+	for _, item := range software {
+		rawName, rawPublisher := item.RawName, item.RawPublisher
+		if rawName == "" {
+			rawName, rawPublisher = item.Name, item.Publisher
+		}
+		if _, err := softwareStmt.Exec(snapshot.DeviceID, item.Name, item.Version, item.Publisher, item.InstallDate, item.SizeKB, rawName, rawPublisher); err != nil {
+			return false, err
+		}
+	}
+
+	for _, volume := range volumes {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO device_current_volumes (device_id, name, filesystem, total_bytes, free_bytes)
+			VALUES (?, ?, ?, ?, ?)`,
+			snapshot.DeviceID, volume.Name, volume.FileSystem, volume.TotalBytes, volume.FreeBytes)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	query := `
+		INSERT INTO device_current_state (
+			device_id, snapshot_id, collected_at, cpu_percent, memory_used_bytes,
+			memory_total_bytes, boot_time, software_count, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, datetime('now'))
+		ON CONFLICT (device_id) DO UPDATE SET
+			snapshot_id = excluded.snapshot_id,
+			collected_at = excluded.collected_at,
+			cpu_percent = excluded.cpu_percent,
+			memory_used_bytes = excluded.memory_used_bytes,
+			memory_total_bytes = excluded.memory_total_bytes,
+			boot_time = excluded.boot_time,
+			software_count = excluded.software_count,
+			updated_at = excluded.updated_at`
+
+	_, err = tx.Exec(query,
+		snapshot.DeviceID,
+		snapshot.ID,
+		snapshot.CollectedAt,
+		snapshot.CPUPercent,
+		snapshot.MemoryUsedBytes,
+		snapshot.MemoryTotalBytes,
+		snapshot.BootTime,
+		len(software),
+	)
+	return err == nil, err
+}
+
+// UpdateDeviceCurrentReadings refreshes the volatile readings of the current
+// inventory from a check-in against the device's current snapshot
+func UpdateDeviceCurrentReadings(tx *sqlx.Tx, checkin *models.SnapshotCheckin, volumes []models.Volume) error {
+	result, err := tx.Exec(`
+		UPDATE device_current_state SET
+			collected_at = ?,
+			cpu_percent = ?,
+			memory_used_bytes = ?,
+			memory_total_bytes = ?,
+			boot_time = ?,
+			updated_at = datetime('now')
+		WHERE device_id = ? AND snapshot_id = ? AND collected_at <= ?`,
+		checkin.CollectedAt,
+		checkin.CPUPercent,
+		checkin.MemoryUsedBytes,
+		checkin.MemoryTotalBytes,
+		checkin.BootTime,
+		checkin.DeviceID,
+		checkin.SnapshotID,
+		checkin.CollectedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return nil
+	}
+
+	for _, volume := range volumes {
+		_, err := tx.Exec(`UPDATE device_current_volumes SET free_bytes = ? WHERE device_id = ? AND name = ?`,
+			volume.FreeBytes, checkin.DeviceID, volume.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDeviceCurrentStates returns the current inventory state of the given devices keyed by device ID
+func GetDeviceCurrentStates(db *sqlx.DB, deviceIDs []uuid.UUID) (map[uuid.UUID]*models.DeviceCurrentState, error) {
+	states := make(map[uuid.UUID]*models.DeviceCurrentState)
+	if len(deviceIDs) == 0 {
+		return states, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT * FROM device_current_state WHERE device_id IN (?)`, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []models.DeviceCurrentState
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		states[rows[i].DeviceID] = &rows[i]
+	}
+	return states, nil
+}
+
+// GetDeviceCurrentVolumes returns the current volumes of the given devices keyed by device ID
+func GetDeviceCurrentVolumes(db *sqlx.DB, deviceIDs []uuid.UUID) (map[uuid.UUID][]models.Volume, error) {
+	volumes := make(map[uuid.UUID][]models.Volume)
+	if len(deviceIDs) == 0 {
+		return volumes, nil
+	}
+
+	query, args, err := sqlx.In(`
+		SELECT device_id, name, filesystem, total_bytes, free_bytes
+		FROM device_current_volumes
+		WHERE device_id IN (?)
+		ORDER BY name ASC`, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		DeviceID uuid.UUID `db:"device_id"`
+		models.Volume
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		volume := row.Volume
+		CalculateVolumeUsage(&volume)
+		volumes[row.DeviceID] = append(volumes[row.DeviceID], volume)
+	}
+	return volumes, nil
+}
+
+// BackfillDeviceCurrentInventory builds the current inventory of devices that
+// have snapshots but no current state yet, from their latest snapshot
+func BackfillDeviceCurrentInventory(db *sqlx.DB) (int, error) {
+	var snapshotIDs []uuid.UUID
+	query := `
+		SELECT s.id FROM snapshots s
+		LEFT JOIN device_current_state st ON st.device_id = s.device_id
+		WHERE st.device_id IS NULL
+		  AND s.id = (
+			SELECT id FROM snapshots
+			WHERE device_id = s.device_id
+			ORDER BY collected_at DESC
+			LIMIT 1
+		  )`
+	if err := db.Select(&snapshotIDs, query); err != nil {
+		return 0, err
+	}
+
+	for i, snapshotID := range snapshotIDs {
+		if err := backfillDeviceCurrentInventory(db, snapshotID); err != nil {
+			return i, fmt.Errorf("failed to backfill snapshot %s: %w", snapshotID, err)
+		}
+	}
+	return len(snapshotIDs), nil
+}
+
+func backfillDeviceCurrentInventory(db *sqlx.DB, snapshotID uuid.UUID) error {
+	snapshot, err := FindSnapshotByID(db, snapshotID)
+	if err != nil {
+		return err
+	}
+	software, err := GetSoftwareBySnapshot(db, snapshotID)
+	if err != nil {
+		return err
+	}
+	volumes, err := GetVolumesBySnapshot(db, snapshotID)
+	if err != nil {
+		return err
+	}
+	checkin, err := GetLatestSnapshotCheckin(db, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := ReplaceDeviceCurrentInventory(tx, snapshot, software, volumes); err != nil {
+		return err
+	}
+	if checkin != nil {
+		var freeBytes map[string]int64
+		if len(checkin.VolumeFreeBytes) > 0 {
+			if err := json.Unmarshal(checkin.VolumeFreeBytes, &freeBytes); err != nil {
+				return err
+			}
+		}
+		for i := range volumes {
+			if free, ok := freeBytes[volumes[i].Name]; ok {
+				volumes[i].FreeBytes = free
+			}
+		}
+		if err := UpdateDeviceCurrentReadings(tx, checkin, volumes); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Compliance policy queries
+
+// policyColumns selects a policy with its passing and failing device counts.
+// The scope conditions, applied to r.device_id, are repeated in both counts.
+func policyColumns(scope DeviceScope) (string, []interface{}) {
+	scopeClauses, scopeArgs := scope.conditions("r.device_id")
+	scopeWhere := ""
+	for _, clause := range scopeClauses {
+		scopeWhere += " AND " + clause
+	}
+
+	columns := `p.*,
+		(SELECT COUNT(*) FROM policy_results r WHERE r.policy_id = p.id AND r.passed = 1` + scopeWhere + `) AS passing,
+		(SELECT COUNT(*) FROM policy_results r WHERE r.policy_id = p.id AND r.passed = 0` + scopeWhere + `) AS failing`
+	return columns, append(append([]interface{}{}, scopeArgs...), scopeArgs...)
+}
+
+// ListPolicies retrieves all policies ordered by name with their result counts within scope
+func ListPolicies(db *sqlx.DB, scope DeviceScope) ([]models.Policy, error) {
+	var policies []models.Policy
+	columns, args := policyColumns(scope)
+	if err := db.Select(&policies, `SELECT `+columns+` FROM policies p ORDER BY p.name ASC`, args...); err != nil {
+		return nil, err
+	}
+	if policies == nil {
+		policies = []models.Policy{}
+	}
+	return policies, nil
+}
+
+// ListEnabledPolicies retrieves the policies evaluated against devices
+func ListEnabledPolicies(db *sqlx.DB) ([]models.Policy, error) {
+	var policies []models.Policy
+	if err := db.Select(&policies, `SELECT * FROM policies WHERE enabled = 1 ORDER BY name ASC`); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// FindPolicyByID retrieves a policy by its ID with its result counts
+func FindPolicyByID(db *sqlx.DB, policyID uuid.UUID) (*models.Policy, error) {
+	var policy models.Policy
+	columns, args := policyColumns(DeviceScope{})
+	if err := db.Get(&policy, `SELECT `+columns+` FROM policies p WHERE p.id = ?`, append(args, policyID)...); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// FindPolicyByName retrieves a policy by its name, ignoring case
+func FindPolicyByName(db *sqlx.DB, name string) (*models.Policy, error) {
+	var policy models.Policy
+	if err := db.Get(&policy, `SELECT * FROM policies WHERE name = ?`, name); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// CreatePolicy inserts a new policy
+func CreatePolicy(db *sqlx.DB, policy *models.Policy) error {
+	query := `
+		INSERT INTO policies (
+			id, name, description, rule_type, params, severity, enabled, created_at, updated_at
+		) VALUES (
+			:id, :name, :description, :rule_type, :params, :severity, :enabled, :created_at, :updated_at
+		)`
+
+	_, err := db.NamedExec(query, policy)
+	return err
+}
+
+// UpdatePolicy saves all fields of an existing policy
+func UpdatePolicy(db *sqlx.DB, policy *models.Policy) error {
+	query := `
+		UPDATE policies
+		SET name = :name, description = :description, rule_type = :rule_type, params = :params,
+			severity = :severity, enabled = :enabled, updated_at = :updated_at
+		WHERE id = :id`
+
+	_, err := db.NamedExec(query, policy)
+	return err
+}
+
+// DeletePolicy removes a policy and its results
+func DeletePolicy(db *sqlx.DB, policyID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM policies WHERE id = ?`, policyID)
+	return err
+}
+
+// DeletePolicyResults removes the results of a policy, used when it is disabled
+func DeletePolicyResults(db *sqlx.DB, policyID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM policy_results WHERE policy_id = ?`, policyID)
+	return err
+}
+
+// GetDeviceCurrentSoftware returns the current software of the given devices keyed by device ID
+func GetDeviceCurrentSoftware(db *sqlx.DB, deviceIDs []uuid.UUID) (map[uuid.UUID][]models.Software, error) {
+	software := make(map[uuid.UUID][]models.Software)
+	if len(deviceIDs) == 0 {
+		return software, nil
+	}
+
+	query, args, err := sqlx.In(`
+		SELECT device_id, name, version, publisher
+		FROM device_current_software
+		WHERE device_id IN (?)
+		ORDER BY name ASC`, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		DeviceID  uuid.UUID `db:"device_id"`
+		Name      string    `db:"name"`
+		Version   string    `db:"version"`
+		Publisher string    `db:"publisher"`
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		software[row.DeviceID] = append(software[row.DeviceID], models.Software{
+			Name:      row.Name,
+			Version:   row.Version,
+			Publisher: row.Publisher,
+		})
+	}
+	return software, nil
+}
+
+// policyDevice is a device's current inventory prepared for policy evaluation
+type policyDevice struct {
+	DeviceID   uuid.UUID
+	SnapshotID uuid.UUID
+	Inventory  policy.Inventory
+}
+
+// loadPolicyDevices loads the current inventory of the given devices. Devices
+// without a current inventory are skipped.
+func loadPolicyDevices(db *sqlx.DB, deviceIDs []uuid.UUID) ([]policyDevice, error) {
+	var rows []struct {
+		ID         uuid.UUID `db:"id"`
+		OSBuild    string    `db:"os_build"`
+		SnapshotID uuid.UUID `db:"snapshot_id"`
+	}
+	query, args, err := sqlx.In(`
+		SELECT d.id, d.os_build, s.snapshot_id
+		FROM devices d
+		JOIN device_current_state s ON s.device_id = d.id
+		WHERE d.id IN (?)`, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Select(&rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	software, err := GetDeviceCurrentSoftware(db, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := GetDeviceCurrentVolumes(db, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]policyDevice, 0, len(rows))
+	for _, row := range rows {
+		devices = append(devices, policyDevice{
+			DeviceID:   row.ID,
+			SnapshotID: row.SnapshotID,
+			Inventory: policy.Inventory{
+				OSBuild:  row.OSBuild,
+				Software: software[row.ID],
+				Volumes:  volumes[row.ID],
+			},
+		})
+	}
+	return devices, nil
+}
+
+// policyEvaluationBatch bounds the number of devices loaded at once when evaluating the fleet
+const policyEvaluationBatch = 500
+
+// EvaluatePolicies evaluates policies against the current inventory of the
+// given devices, or of every device when deviceIDs is nil, and stores the
+// results. Returns the number of devices evaluated and the passed and failed results.
+func EvaluatePolicies(db *sqlx.DB, policies []models.Policy, deviceIDs []uuid.UUID) (models.PolicyEvaluationResult, error) {
+	result := models.PolicyEvaluationResult{Policies: len(policies)}
+	if len(policies) == 0 {
+		return result, nil
+	}
+
+	if deviceIDs == nil {
+		if err := db.Select(&deviceIDs, `SELECT device_id FROM device_current_state ORDER BY device_id`); err != nil {
+			return result, err
+		}
+	}
+
+	for start := 0; start < len(deviceIDs); start += policyEvaluationBatch {
+		end := start + policyEvaluationBatch
+		if end > len(deviceIDs) {
+			end = len(deviceIDs)
+		}
+
+		devices, err := loadPolicyDevices(db, deviceIDs[start:end])
+		if err != nil {
+			return result, err
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return result, err
+		}
+		now := time.Now().UTC()
+		for _, device := range devices {
+			for _, p := range policies {
+				outcome := policy.Evaluate(p, device.Inventory)
+				if err := savePolicyResult(tx, p.ID, device, outcome, now); err != nil {
+					tx.Rollback()
+					return result, err
+				}
+				if outcome.Passed {
+					result.Passed++
+				} else {
+					result.Failed++
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return result, err
+		}
+		result.Devices += len(devices)
+	}
+
+	return result, nil
+}
+
+// savePolicyResult stores the latest outcome of a policy for a device,
+// keeping changed_at when the outcome did not flip
+func savePolicyResult(tx *sqlx.Tx, policyID uuid.UUID, device policyDevice, outcome policy.Outcome, now time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO policy_results (policy_id, device_id, snapshot_id, passed, detail, evaluated_at, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (policy_id, device_id) DO UPDATE SET
+			snapshot_id = excluded.snapshot_id,
+			passed = excluded.passed,
+			detail = excluded.detail,
+			evaluated_at = excluded.evaluated_at,
+			changed_at = CASE WHEN policy_results.passed = excluded.passed
+				THEN policy_results.changed_at ELSE excluded.changed_at END`,
+		policyID, device.DeviceID, device.SnapshotID, outcome.Passed, outcome.Detail, now, now)
+	return err
+}
+
+// PolicyResultFilter holds the optional filters of policy result queries
+type PolicyResultFilter struct {
+	PolicyID *uuid.UUID
+	DeviceID *uuid.UUID
+	Passed   *bool
+	DeviceScope
+}
 
-	if startDate != nil {
-		whereClauses = append(whereClauses, "al.timestamp >= ?"+strconv.Itoa(argCount))
-		args = append(args, *startDate)
-		argCount++
-	}
+func buildPolicyResultWhere(filter PolicyResultFilter) (string, []interface{}) {
+	whereClauses := []string{"p.enabled = 1"}
+	var args []interface{}
 
-	if endDate != nil {
-		whereClauses = append(whereClauses, "al.timestamp <= ?"+strconv.Itoa(argCount))
-		args = append(args, *endDate)
-		argCount++
+	if filter.PolicyID != nil {
+		whereClauses = append(whereClauses, "r.policy_id = ?")
+		args = append(args, *filter.PolicyID)
 	}
-
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+	if filter.DeviceID != nil {
+		whereClauses = append(whereClauses, "r.device_id = ?")
+		args = append(args, *filter.DeviceID)
+	}
+	if filter.Passed != nil {
+		whereClauses = append(whereClauses, "r.passed = ?")
+		args = append(args, *filter.Passed)
 	}
 
-	query := `
-		SELECT 
-			al.id, al.user_id, al.device_id, al.action, al.details, 
-			al.timestamp, al.ip_address, al.user_agent,
-			u.username,
-			d.hostname
-		FROM audit_logs al
-		LEFT JOIN users u ON al.user_id = u.id
-		LEFT JOIN devices d ON al.device_id = d.id` +
-		whereClause +
-		` ORDER BY al.timestamp DESC
-		LIMIT ?` + strconv.Itoa(argCount) + ` OFFSET ?` + strconv.Itoa(argCount+1)
+	scopeClauses, scopeArgs := filter.DeviceScope.conditions("r.device_id")
+	whereClauses = append(whereClauses, scopeClauses...)
+	args = append(args, scopeArgs...)
+
+	return " WHERE " + strings.Join(whereClauses, " AND "), args
+}
 
+// ListPolicyResults retrieves policy results matching filter, failures first
+func ListPolicyResults(db *sqlx.DB, filter PolicyResultFilter, offset, limit int) ([]models.PolicyResultListItem, error) {
+	var results []models.PolicyResultListItem
+	whereClause, args := buildPolicyResultWhere(filter)
+	query := `
+		SELECT r.*, p.name AS policy_name, p.severity, d.hostname
+		FROM policy_results r
+		JOIN policies p ON p.id = r.policy_id
+		JOIN devices d ON d.id = r.device_id` + whereClause + `
+		ORDER BY r.passed ASC, p.name ASC, d.hostname ASC
+		LIMIT ? OFFSET ?`
 	args = append(args, limit, offset)
 
-	err := db.Select(&auditLogs, query, args...)
-	if err != nil {
+	if err := db.Select(&results, query, args...); err != nil {
 		return nil, err
 	}
-
-	// Return empty slice if no logs found
-	if auditLogs == nil {
-		auditLogs = []models.AuditLogListItem{}
+	if results == nil {
+		results = []models.PolicyResultListItem{}
 	}
-
-	return auditLogs, nil
+	return results, nil
 }
 
-// CountAuditLogs returns the count of audit logs with filters
-func CountAuditLogs(db *sqlx.DB, userID, deviceID *uuid.UUID, action string, startDate, endDate *time.Time) (int, error) {
+// CountPolicyResults returns the total number of policy results matching filter
+func CountPolicyResults(db *sqlx.DB, filter PolicyResultFilter) (int, error) {
 	var count int
-	var args []interface{}
-	var whereClauses []string
-	argCount := 1
+	whereClause, args := buildPolicyResultWhere(filter)
+	err := db.Get(&count, `
+		SELECT COUNT(*) FROM policy_results r
+		JOIN policies p ON p.id = r.policy_id`+whereClause, args...)
+	return count, err
+}
 
-	// Build same dynamic WHERE clause as ListAuditLogs
-	if userID != nil {
-		whereClauses = append(whereClauses, "user_id = ?"+strconv.Itoa(argCount))
-		args = append(args, *userID)
-		argCount++
-	}
+// CountCompliantDevices returns the number of devices with results for
+// enabled policies within scope, and how many of them pass all of them
+func CountCompliantDevices(db *sqlx.DB, scope DeviceScope) (int, int, error) {
+	whereClause, args := buildPolicyResultWhere(PolicyResultFilter{DeviceScope: scope})
 
-	if deviceID != nil {
-		whereClauses = append(whereClauses, "device_id = ?"+strconv.Itoa(argCount))
-		args = append(args, *deviceID)
-		argCount++
+	var counts struct {
+		Evaluated int `db:"evaluated"`
+		Compliant int `db:"compliant"`
 	}
+	err := db.Get(&counts, `
+		SELECT COUNT(*) AS evaluated, COALESCE(SUM(failing = 0), 0) AS compliant
+		FROM (
+			SELECT r.device_id, SUM(r.passed = 0) AS failing
+			FROM policy_results r
+			JOIN policies p ON p.id = r.policy_id`+whereClause+`
+			GROUP BY r.device_id
+		)`, args...)
+	return counts.Evaluated, counts.Compliant, err
+}
 
-	if action != "" {
-		whereClauses = append(whereClauses, "action = ?"+strconv.Itoa(argCount))
-		args = append(args, action)
-		argCount++
-	}
+// Alert queries
 
-	if startDate != nil {
-		whereClauses = append(whereClauses, "timestamp >= ?"+strconv.Itoa(argCount))
-		args = append(args, *startDate)
-		argCount++
+// alertRuleColumns selects an alert rule with the number of its unresolved alerts
+const alertRuleColumns = `r.*,
+	(SELECT COUNT(*) FROM alerts a WHERE a.rule_id = r.id AND a.state != 'resolved') AS open_alerts`
+
+// ListAlertRules retrieves all alert rules ordered by name with their open alert counts
+func ListAlertRules(db *sqlx.DB) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	if err := db.Select(&rules, `SELECT `+alertRuleColumns+` FROM alert_rules r ORDER BY r.name ASC`); err != nil {
+		return nil, err
 	}
+	if rules == nil {
+		rules = []models.AlertRule{}
+	}
+	return rules, nil
+}
 
-	if endDate != nil {
-		whereClauses = append(whereClauses, "timestamp <= ?"+strconv.Itoa(argCount))
-		args = append(args, *endDate)
-		argCount++
+// FindAlertRuleByID retrieves an alert rule by its ID with its open alert count
+func FindAlertRuleByID(db *sqlx.DB, ruleID uuid.UUID) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := db.Get(&rule, `SELECT `+alertRuleColumns+` FROM alert_rules r WHERE r.id = ?`, ruleID); err != nil {
+		return nil, err
 	}
+	return &rule, nil
+}
 
-	whereClause := ""
-	if len(whereClauses) > 0 {
-		whereClause = " WHERE " + strings.Join(whereClauses, " AND ")
+// FindAlertRuleByName retrieves an alert rule by its name, ignoring case
+func FindAlertRuleByName(db *sqlx.DB, name string) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := db.Get(&rule, `SELECT * FROM alert_rules WHERE name = ?`, name); err != nil {
+		return nil, err
 	}
+	return &rule, nil
+}
 
-	query := "SELECT COUNT(*) FROM audit_logs" + whereClause
-	err := db.Get(&count, query, args...)
-	return count, err
+// CreateAlertRule inserts a new alert rule
+func CreateAlertRule(db *sqlx.DB, rule *models.AlertRule) error {
+	query := `
+		INSERT INTO alert_rules (
+			id, name, description, rule_type, params, severity, enabled, created_at, updated_at
+		) VALUES (
+			:id, :name, :description, :rule_type, :params, :severity, :enabled, :created_at, :updated_at
+		)`
+
+	_, err := db.NamedExec(query, rule)
+	return err
 }
 
-// CreateAuditLog inserts a new audit log entry
-func CreateAuditLog(db *sqlx.DB, auditLog *models.AuditLog) error {
+// UpdateAlertRule saves all fields of an existing alert rule
+func UpdateAlertRule(db *sqlx.DB, rule *models.AlertRule) error {
 	query := `
-		INSERT INTO audit_logs (id, user_id, device_id, action, details, timestamp, ip_address, user_agent)
-		VALUES (:id, :user_id, :device_id, :action, :details, :timestamp, :ip_address, :user_agent)`
-	
-	_, err := db.NamedExec(query, auditLog)
+		UPDATE alert_rules
+		SET name = :name, description = :description, rule_type = :rule_type, params = :params,
+			severity = :severity, enabled = :enabled, updated_at = :updated_at
+		WHERE id = :id`
+
+	_, err := db.NamedExec(query, rule)
+	return err
+}
+
+// DeleteAlertRule removes an alert rule and its alerts
+func DeleteAlertRule(db *sqlx.DB, ruleID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM alert_rules WHERE id = ?`, ruleID)
 	return err
 }
 
-// Command queries
+// AlertFilter holds the optional filters of alert queries. State is one of
+// the alert states or "open" for firing and acknowledged alerts.
+type AlertFilter struct {
+	State    string
+	Severity string
+	RuleID   *uuid.UUID
+	DeviceID *uuid.UUID
+	DeviceScope
+}
 
-// GetPendingCommands retrieves pending commands for a device
-func GetPendingCommands(db *sqlx.DB, deviceID uuid.UUID) ([]models.Command, error) {
-	var commands []models.Command
-	query := `
-		SELECT * FROM commands 
-		WHERE device_id = ? AND status IN ('queued', 'in_progress')
-		ORDER BY created_at ASC`
-	
-	err := db.Select(&commands, query, deviceID)
-	if err != nil {
-		return nil, err
+func buildAlertWhere(filter AlertFilter) (string, []interface{}) {
+	var whereClauses []string
+	var args []interface{}
+
+	if filter.State == "open" {
+		whereClauses = append(whereClauses, "a.state != ?")
+		args = append(args, models.AlertStateResolved)
+	} else if filter.State != "" {
+		whereClauses = append(whereClauses, "a.state = ?")
+		args = append(args, filter.State)
 	}
-	
-	// Return empty slice if no commands found
-	if commands == nil {
-		commands = []models.Command{}
+	if filter.Severity != "" {
+		whereClauses = append(whereClauses, "a.severity = ?")
+		args = append(args, filter.Severity)
+	}
+	if filter.RuleID != nil {
+		whereClauses = append(whereClauses, "a.rule_id = ?")
+		args = append(args, *filter.RuleID)
+	}
+	if filter.DeviceID != nil {
+		whereClauses = append(whereClauses, "a.device_id = ?")
+		args = append(args, *filter.DeviceID)
 	}
-	
-	return commands, nil
-}
 
-// UpdateCommandStatus updates a command's status and result
-func UpdateCommandStatus(db *sqlx.DB, commandID uuid.UUID, status models.CommandStatus, result *models.CommandResult) error {
-	query := `
-		UPDATE commands SET
-			status = ?,
-			executed_at = datetime('now'),
-			result = ?
-		WHERE id = ?`
-	
-	var resJSON any
-	if result != nil {
-		b, _ := json.Marshal(result)
-		resJSON = b
+	scopeClauses, scopeArgs := filter.DeviceScope.conditions("a.device_id")
+	whereClauses = append(whereClauses, scopeClauses...)
+	args = append(args, scopeArgs...)
+
+	if len(whereClauses) == 0 {
+		return "", args
 	}
-	_, err := db.Exec(query, commandID, status, resJSON)
-	return err
+	return " WHERE " + strings.Join(whereClauses, " AND "), args
 }
 
-// ExpireOldCommands marks old commands as expired
-func ExpireOldCommands(db *sqlx.DB, deviceID uuid.UUID, timeout time.Duration) error {
-	query := `
-		UPDATE commands SET status = 'expired'
-		WHERE device_id = ? AND status IN ('queued','in_progress')
-		  AND created_at < datetime('now', '-' || ? || ' minutes')`
-	
-	_, err := db.Exec(query, deviceID, strconv.Itoa(int(timeout.Minutes())))
-	return err
+// alertListQuery selects alerts with their rule, device and acknowledging user
+const alertListQuery = `
+	SELECT a.*, r.name AS rule_name, r.rule_type, d.hostname, u.username AS acknowledged_by_username
+	FROM alerts a
+	JOIN alert_rules r ON r.id = a.rule_id
+	JOIN devices d ON d.id = a.device_id
+	LEFT JOIN users u ON u.id = a.acknowledged_by`
+
+// ListAlerts retrieves alerts matching filter, most recently fired first
+func ListAlerts(db *sqlx.DB, filter AlertFilter, offset, limit int) ([]models.AlertListItem, error) {
+	var alerts []models.AlertListItem
+	whereClause, args := buildAlertWhere(filter)
+	query := alertListQuery + whereClause + `
+		ORDER BY a.fired_at DESC, d.hostname ASC
+		LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	if err := db.Select(&alerts, query, args...); err != nil {
+		return nil, err
+	}
+	if alerts == nil {
+		alerts = []models.AlertListItem{}
+	}
+	return alerts, nil
 }
 
-// ValidateCommandOwnership verifies that a command belongs to a specific device
-func ValidateCommandOwnership(db *sqlx.DB, commandID, deviceID uuid.UUID) (bool, error) {
+// CountAlerts returns the total number of alerts matching filter
+func CountAlerts(db *sqlx.DB, filter AlertFilter) (int, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM commands WHERE id = ? AND device_id = ?`
-	err := db.Get(&count, query, commandID, deviceID)
+	whereClause, args := buildAlertWhere(filter)
+	err := db.Get(&count, `SELECT COUNT(*) FROM alerts a`+whereClause, args...)
+	return count, err
+}
+
+// FindAlertByID retrieves an alert by its ID with its rule and device
+func FindAlertByID(db *sqlx.DB, alertID uuid.UUID) (*models.AlertListItem, error) {
+	var alert models.AlertListItem
+	if err := db.Get(&alert, alertListQuery+` WHERE a.id = ?`, alertID); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// AcknowledgeAlert moves a firing alert to acknowledged. Returns false when
+// the alert was not firing.
+func AcknowledgeAlert(db *sqlx.DB, alertID, userID uuid.UUID, note string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE alerts
+		SET state = ?, acknowledged_at = ?, acknowledged_by = ?, acknowledgement_note = ?
+		WHERE id = ? AND state = ?`,
+		models.AlertStateAcknowledged, time.Now().UTC(), userID, note, alertID, models.AlertStateFiring)
 	if err != nil {
 		return false, err
 	}
-	return count > 0, nil
+	updated, err := result.RowsAffected()
+	return updated > 0, err
 }
 
-// User queries
+// Webhook queries
 
-// FindUserByUsername retrieves a user by username
-func FindUserByUsername(db *sqlx.DB, username string) (*models.User, error) {
-	var user models.User
-	query := `SELECT * FROM users WHERE username = ?`
-	err := db.Get(&user, query, username)
-	if err != nil {
+// webhookColumns selects a webhook with the number of its pending and failed deliveries
+const webhookColumns = `w.*,
+	(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'pending') AS pending_deliveries,
+	(SELECT COUNT(*) FROM webhook_deliveries d WHERE d.webhook_id = w.id AND d.status = 'failed') AS failed_deliveries`
+
+// ListWebhooks retrieves all webhooks ordered by name with their delivery counts
+func ListWebhooks(db *sqlx.DB) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	if err := db.Select(&webhooks, `SELECT `+webhookColumns+` FROM webhooks w ORDER BY w.name ASC`); err != nil {
 		return nil, err
 	}
-	return &user, nil
+	if webhooks == nil {
+		webhooks = []models.Webhook{}
+	}
+	return webhooks, nil
 }
 
-// FindUserByID retrieves a user by ID
-func FindUserByID(db *sqlx.DB, userID uuid.UUID) (*models.User, error) {
-	var user models.User
-	query := `SELECT * FROM users WHERE id = ?`
-	err := db.Get(&user, query, userID)
-	if err != nil {
+// FindWebhookByID retrieves a webhook by its ID with its delivery counts
+func FindWebhookByID(db *sqlx.DB, webhookID uuid.UUID) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := db.Get(&webhook, `SELECT `+webhookColumns+` FROM webhooks w WHERE w.id = ?`, webhookID); err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return &webhook, nil
 }
 
-// ListUsers retrieves users with pagination
-func ListUsers(db *sqlx.DB, offset, limit int) ([]models.User, error) {
-	var users []models.User
-	query := `SELECT * FROM users ORDER BY created_at DESC LIMIT ? OFFSET ?`
-	
-	err := db.Select(&users, query, limit, offset)
-	if err != nil {
+// FindWebhookByName retrieves a webhook by its name, ignoring case
+func FindWebhookByName(db *sqlx.DB, name string) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := db.Get(&webhook, `SELECT * FROM webhooks WHERE name = ?`, name); err != nil {
 		return nil, err
 	}
-	
-	// Return empty slice if no users found
-	if users == nil {
-		users = []models.User{}
+	return &webhook, nil
+}
+
+// CreateWebhook inserts a new webhook
+func CreateWebhook(db *sqlx.DB, webhook *models.Webhook) error {
+	query := `
+		INSERT INTO webhooks (id, name, url, secret, event_types, enabled, created_at, updated_at)
+		VALUES (:id, :name, :url, :secret, :event_types, :enabled, :created_at, :updated_at)`
+
+	_, err := db.NamedExec(query, webhook)
+	return err
+}
+
+// UpdateWebhook saves all fields of an existing webhook
+func UpdateWebhook(db *sqlx.DB, webhook *models.Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET name = :name, url = :url, secret = :secret, event_types = :event_types,
+			enabled = :enabled, updated_at = :updated_at
+		WHERE id = :id`
+
+	_, err := db.NamedExec(query, webhook)
+	return err
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(db *sqlx.DB, webhookID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM webhooks WHERE id = ?`, webhookID)
+	return err
+}
+
+// ListWebhookDeliveries retrieves the deliveries of a webhook, newest first,
+// optionally filtered by status
+func ListWebhookDeliveries(db *sqlx.DB, webhookID uuid.UUID, status string, offset, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	query := `SELECT * FROM webhook_deliveries WHERE webhook_id = ? AND (? = '' OR status = ?)
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ? OFFSET ?`
+
+	if err := db.Select(&deliveries, query, webhookID, status, status, limit, offset); err != nil {
+		return nil, err
 	}
-	
-	return users, nil
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	return deliveries, nil
 }
 
-// CountUsers returns the total number of users
-func CountUsers(db *sqlx.DB) (int, error) {
+// CountWebhookDeliveries returns the number of deliveries of a webhook, optionally filtered by status
+func CountWebhookDeliveries(db *sqlx.DB, webhookID uuid.UUID, status string) (int, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM users`
-	err := db.Get(&count, query)
+	err := db.Get(&count, `SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ? AND (? = '' OR status = ?)`,
+		webhookID, status, status)
 	return count, err
 }
 
-// CountAdminUsers returns the number of admin users
-func CountAdminUsers(db *sqlx.DB) (int, error) {
-	var count int
-	query := `SELECT COUNT(*) FROM users WHERE role = 'admin'`
-	err := db.Get(&count, query)
-	return count, err
+// FindWebhookDelivery retrieves a delivery of a webhook by its ID
+func FindWebhookDelivery(db *sqlx.DB, webhookID, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := db.Get(&delivery, `SELECT * FROM webhook_deliveries WHERE id = ? AND webhook_id = ?`,
+		deliveryID, webhookID); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
 }
 
-// CreateUser inserts a new user into the database
-func CreateUser(db *sqlx.DB, user *models.User) error {
-	query := `
-		INSERT INTO users (id, username, password_hash, role, created_at, updated_at)
-		VALUES (:id, :username, :password_hash, :role, :created_at, :updated_at)`
-	
-	_, err := db.NamedExec(query, user)
+// Device status reconciliation
+
+// SetDeviceStatus moves a device to a status, recording the transition in the
+// device's status history along with a device.status_changed event, and a
+// device.offline event when it went offline. Devices already in the status,
+// or whose status changed since they were loaded, are left as they are.
+func SetDeviceStatus(db sqlx.Execer, device *models.Device, status models.DeviceStatus, reason string) error {
+	_, err := changeDeviceStatus(db, device, status, reason, "")
 	return err
 }
 
-// UpdateUser updates user fields
-func UpdateUser(db *sqlx.DB, userID uuid.UUID, update *models.UserUpdate) error {
-	setParts := []string{}
-	args := []interface{}{}
-	argCount := 1
+// changeDeviceStatus implements SetDeviceStatus. A non-empty lastSeen also
+// skips devices that checked in since they were loaded. Reports whether the
+// device was changed.
+func changeDeviceStatus(db sqlx.Execer, device *models.Device, status models.DeviceStatus, reason string, lastSeen string) (bool, error) {
+	if device.Status == status {
+		return false, nil
+	}
 
-	if update.Password != nil {
-		setParts = append(setParts, "password_hash = ?"+strconv.Itoa(argCount))
-		args = append(args, *update.Password)
-		argCount++
+	now := time.Now().UTC()
+	query := `UPDATE devices SET status = ?, status_reason = ?, status_changed_at = ? WHERE id = ? AND status = ?`
+	args := []interface{}{status, reason, now, device.ID, device.Status}
+	if lastSeen != "" {
+		query += ` AND CAST(last_seen AS TEXT) = ?`
+		args = append(args, lastSeen)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return false, nil
 	}
 
-	if update.Role != nil {
-		setParts = append(setParts, "role = ?"+strconv.Itoa(argCount))
-		args = append(args, string(*update.Role))
-		argCount++
+	if _, err := db.Exec(`
+		INSERT INTO device_status_history (device_id, previous_status, status, reason, changed_at)
+		VALUES (?, ?, ?, ?, ?)`, device.ID, device.Status, status, reason, now); err != nil {
+		return false, err
 	}
 
-	if len(setParts) == 0 {
-		return nil // No updates to make
+	deviceID := device.ID
+	if err := events.Record(db, models.EventDeviceStatus, &deviceID, map[string]interface{}{
+		"hostname":        device.Hostname,
+		"previous_status": device.Status,
+		"status":          status,
+		"reason":          reason,
+	}); err != nil {
+		return false, err
+	}
+	if status == models.DeviceStatusOffline {
+		if err := events.Record(db, models.EventDeviceOffline, &deviceID, map[string]interface{}{
+			"hostname":  device.Hostname,
+			"last_seen": device.LastSeen,
+		}); err != nil {
+			return false, err
+		}
 	}
 
-	setParts = append(setParts, "updated_at = datetime('now')")
-	
-	query := "UPDATE users SET " + strings.Join(setParts, ", ") + " WHERE id = ?" + strconv.Itoa(argCount)
-	args = append(args, userID)
+	device.Status = status
+	device.StatusReason = reason
+	device.StatusChangedAt = &now
+	return true, nil
+}
 
-	_, err := db.Exec(query, args...)
-	return err
+// RecordDeviceCheckin records a device checking in in its availability
+// history and updates its status, which is active unless its agent reports
+// errors. Pass the transaction making the check-in.
+func RecordDeviceCheckin(tx *sqlx.Tx, cfg *config.Config, device *models.Device, agentError string) error {
+	now := time.Now().UTC()
+	if err := availability.RecordCheckin(tx, device.ID, now, cfg.DeviceOfflineAfter); err != nil {
+		return err
+	}
+
+	status, reason := DetermineDeviceStatus(cfg, now, agentError, now)
+	return SetDeviceStatus(tx, device, status, reason)
 }
 
-// DeleteUser removes a user from the database
-func DeleteUser(db *sqlx.DB, userID uuid.UUID) error {
-	query := `DELETE FROM users WHERE id = ?`
-	_, err := db.Exec(query, userID)
-	return err
+// ReconcileDeviceStatuses moves every device whose status no longer matches
+// its last check-in and reported errors to the status it should be in.
+// Returns the number of devices changed.
+func ReconcileDeviceStatuses(db *sqlx.DB, cfg *config.Config) (int, error) {
+	var rows []struct {
+		ID         uuid.UUID           `db:"id"`
+		Hostname   string              `db:"hostname"`
+		Status     models.DeviceStatus `db:"status"`
+		LastSeen   string              `db:"last_seen"`
+		AgentError string              `db:"agent_error"`
+	}
+	if err := db.Select(&rows, `
+		SELECT id, hostname, status, CAST(last_seen AS TEXT) AS last_seen, agent_error
+		FROM devices`); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	changed := 0
+	for _, row := range rows {
+		lastSeen, err := ParseDBTime(row.LastSeen)
+		if err != nil {
+			return 0, err
+		}
+		status, reason := DetermineDeviceStatus(cfg, lastSeen, row.AgentError, now)
+		if status == row.Status {
+			continue
+		}
+
+		device := &models.Device{ID: row.ID, Hostname: row.Hostname, Status: row.Status, LastSeen: lastSeen}
+		ok, err := changeDeviceStatus(tx, device, status, reason, row.LastSeen)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			changed++
+		}
+	}
+
+	return changed, tx.Commit()
 }
 
-func DeleteDevice(db *sqlx.DB, deviceID uuid.UUID) error {
-	query := `DELETE FROM devices WHERE id = ?`
-	_, err := db.Exec(query, deviceID)
-	return err
-}
\ No newline at end of file
+// ListDeviceStatusHistory returns the status changes of a device, newest first
+func ListDeviceStatusHistory(db *sqlx.DB, deviceID uuid.UUID, offset, limit int) ([]models.DeviceStatusChange, error) {
+	changes := []models.DeviceStatusChange{}
+	err := db.Select(&changes, `
+		SELECT * FROM device_status_history
+		WHERE device_id = ?
+		ORDER BY changed_at DESC, id DESC
+		LIMIT ? OFFSET ?`, deviceID, limit, offset)
+	return changes, err
+}
+
+// CountDeviceStatusHistory counts the status changes of a device
+func CountDeviceStatusHistory(db *sqlx.DB, deviceID uuid.UUID) (int, error) {
+	var total int
+	err := db.Get(&total, `SELECT COUNT(*) FROM device_status_history WHERE device_id = ?`, deviceID)
+	return total, err
+}
+
+// StartStatusReconciler periodically moves devices between statuses as they
+// stop and resume checking in or report errors
+func StartStatusReconciler(db *sqlx.DB, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.DeviceStatusInterval)
+	go func() {
+		for range ticker.C {
+			changed, err := ReconcileDeviceStatuses(db, cfg)
+			if err != nil {
+				log.Printf("[ERROR] Device status reconciliation failed: %v", err)
+				continue
+			}
+			if changed > 0 {
+				log.Printf("[INFO] Changed the status of %d devices", changed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,611 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/models"
+)
+
+// schema holds the tables the tests read, with time columns the driver scans
+// as times
+const schema = `
+CREATE TABLE devices (
+	id TEXT PRIMARY KEY,
+	hostname TEXT NOT NULL,
+	domain TEXT NOT NULL DEFAULT '',
+	manufacturer TEXT NOT NULL DEFAULT '',
+	model TEXT NOT NULL DEFAULT '',
+	serial_number TEXT NOT NULL DEFAULT '',
+	os_caption TEXT NOT NULL DEFAULT '',
+	os_version TEXT NOT NULL DEFAULT '',
+	os_build TEXT NOT NULL DEFAULT '',
+	first_seen DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_seen DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	device_token_hash TEXT NOT NULL DEFAULT '',
+	token_created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	status TEXT NOT NULL DEFAULT 'active',
+	status_reason TEXT NOT NULL DEFAULT '',
+	status_changed_at DATETIME,
+	agent_error TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE device_groups (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT '',
+	kind TEXT NOT NULL DEFAULT 'static',
+	criteria TEXT,
+	evaluated_at DATETIME,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE device_group_members (group_id TEXT NOT NULL, device_id TEXT NOT NULL);
+CREATE TABLE snapshots (
+	id TEXT PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	collected_at DATETIME NOT NULL,
+	snapshot_hash TEXT NOT NULL DEFAULT '',
+	software_set_hash TEXT
+);
+CREATE TABLE software_sets (
+	hash TEXT PRIMARY KEY,
+	item_count INTEGER NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE software_set_items (
+	id TEXT PRIMARY KEY,
+	set_hash TEXT NOT NULL,
+	name TEXT NOT NULL,
+	version TEXT,
+	publisher TEXT,
+	install_date DATETIME,
+	size_kb INTEGER,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	raw_name TEXT NOT NULL DEFAULT '',
+	raw_publisher TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE commands (
+	id TEXT PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	command_type TEXT NOT NULL DEFAULT 'refresh_now',
+	payload BLOB,
+	status TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	executed_at DATETIME,
+	result BLOB,
+	timeout_seconds INTEGER NOT NULL DEFAULT 300,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 3,
+	not_before DATETIME,
+	expires_at DATETIME,
+	lease_expires_at DATETIME,
+	batch_id TEXT
+);
+CREATE TABLE command_status_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	command_id TEXT NOT NULL,
+	previous_status TEXT,
+	status TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	attempt INTEGER NOT NULL DEFAULT 0,
+	changed_at DATETIME NOT NULL
+);
+CREATE TABLE events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT NOT NULL,
+	device_id TEXT,
+	data TEXT NOT NULL DEFAULT '{}',
+	created_at DATETIME NOT NULL
+);
+`
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addSnapshot stores a snapshot of a device with the software given
+func addSnapshot(t *testing.T, db *sqlx.DB, deviceID uuid.UUID, software []models.Software) uuid.UUID {
+	t.Helper()
+
+	snapshotID := uuid.New()
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	tx.MustExec(`INSERT INTO snapshots (id, device_id, collected_at) VALUES (?, ?, ?)`, snapshotID, deviceID, time.Now().UTC())
+	if err := CreateSoftwareItems(tx, snapshotID, software, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return snapshotID
+}
+
+func TestCreateSoftwareItems(t *testing.T) {
+	db := openTestDB(t)
+	deviceID := uuid.New()
+
+	software := testInventory().Software
+	first := addSnapshot(t, db, deviceID, software)
+	// The same list in another order is the same set
+	second := addSnapshot(t, db, deviceID, []models.Software{software[1], software[0]})
+
+	changed := testInventory().Software
+	changed[1].Version = "2.45.1"
+	third := addSnapshot(t, db, deviceID, changed)
+
+	setHashes := make(map[uuid.UUID]string)
+	for _, snapshotID := range []uuid.UUID{first, second, third} {
+		var setHash string
+		if err := db.Get(&setHash, `SELECT software_set_hash FROM snapshots WHERE id = ?`, snapshotID); err != nil {
+			t.Fatal(err)
+		}
+		setHashes[snapshotID] = setHash
+	}
+	if setHashes[first] == "" || setHashes[second] != setHashes[first] {
+		t.Errorf("set hashes of the same list = %q and %q, want one set", setHashes[first], setHashes[second])
+	}
+	if setHashes[third] == setHashes[first] {
+		t.Errorf("set hash of a changed list = %q, want a new set", setHashes[third])
+	}
+
+	// Only the first snapshot of each list stores its items
+	var counts []struct {
+		Hash      string `db:"hash"`
+		ItemCount int    `db:"item_count"`
+		Items     int    `db:"items"`
+	}
+	if err := db.Select(&counts, `
+		SELECT ss.hash, ss.item_count, COUNT(si.id) AS items
+		FROM software_sets ss
+		LEFT JOIN software_set_items si ON si.set_hash = ss.hash
+		GROUP BY ss.hash`); err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 {
+		t.Fatalf("software sets = %+v, want 2", counts)
+	}
+	for _, count := range counts {
+		if count.ItemCount != 2 || count.Items != 2 {
+			t.Errorf("set %s has item_count %d and %d items, want 2 and 2", count.Hash, count.ItemCount, count.Items)
+		}
+	}
+}
+
+func TestGetSoftwareBySnapshot(t *testing.T) {
+	db := openTestDB(t)
+	deviceID := uuid.New()
+
+	software := testInventory().Software
+	addSnapshot(t, db, deviceID, []models.Software{software[1], software[0]})
+	snapshotID := addSnapshot(t, db, deviceID, testInventory().Software)
+	empty := addSnapshot(t, db, deviceID, nil)
+
+	// Items of a shared set are returned as the items of the snapshot asked for
+	got, err := GetSoftwareBySnapshot(db, snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testInventory().Software
+	if len(got) != len(want) {
+		t.Fatalf("GetSoftwareBySnapshot() = %+v, want %d items", got, len(want))
+	}
+	for i, w := range want {
+		item := got[i]
+		if item.ID == uuid.Nil || item.SnapshotID != snapshotID || item.CreatedAt.IsZero() {
+			t.Errorf("item %d has id %s, snapshot_id %s and created_at %v", i, item.ID, item.SnapshotID, item.CreatedAt)
+		}
+		if item.Name != w.Name || item.Version != w.Version || item.Publisher != w.Publisher || item.SizeKB != nil ||
+			(item.InstallDate == nil) != (w.InstallDate == nil) ||
+			(item.InstallDate != nil && !item.InstallDate.Equal(*w.InstallDate)) {
+			t.Errorf("item %d = %+v, want %+v", i, item, w)
+		}
+	}
+
+	// A snapshot without software has an empty list
+	got, err = GetSoftwareBySnapshot(db, empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("GetSoftwareBySnapshot() without software = %#v, want an empty slice", got)
+	}
+}
+
+// addCommand queues a command of a device created at now, with a timeout of a
+// minute
+func addCommand(t *testing.T, db *sqlx.DB, deviceID uuid.UUID, now time.Time) uuid.UUID {
+	t.Helper()
+
+	commandID := uuid.New()
+	db.MustExec(`INSERT INTO commands (id, device_id, status, created_at, timeout_seconds) VALUES (?, ?, ?, ?, 60)`,
+		commandID, deviceID, models.CommandStatusQueued, now)
+	return commandID
+}
+
+func findCommand(t *testing.T, db *sqlx.DB, commandID uuid.UUID) *models.Command {
+	t.Helper()
+
+	command, err := FindCommand(db, commandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return command
+}
+
+// transition is a row of the status history of a command
+type transition struct {
+	from    models.CommandStatus
+	to      models.CommandStatus
+	attempt int
+}
+
+func checkHistory(t *testing.T, db *sqlx.DB, commandID uuid.UUID, want ...transition) {
+	t.Helper()
+
+	changes, err := ListCommandStatusHistory(db, commandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []transition
+	for _, change := range changes {
+		row := transition{to: change.Status, attempt: change.Attempt}
+		if change.PreviousStatus != nil {
+			row.from = *change.PreviousStatus
+		}
+		got = append(got, row)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("history of %s = %+v, want %+v", commandID, got, want)
+	}
+}
+
+func countEvents(t *testing.T, db *sqlx.DB, eventType models.EventType) int {
+	t.Helper()
+
+	var count int
+	if err := db.Get(&count, `SELECT COUNT(*) FROM events WHERE type = ?`, eventType); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestLeaseCommands(t *testing.T) {
+	db := openTestDB(t)
+	cfg := &config.Config{CommandLeaseGrace: time.Minute}
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	deviceID := uuid.New()
+
+	due := addCommand(t, db, deviceID, now)
+	held := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET not_before = ? WHERE id = ?`, now.Add(time.Hour), held)
+	expired := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET expires_at = ? WHERE id = ?`, now, expired)
+	other := addCommand(t, db, uuid.New(), now)
+
+	leased, err := LeaseCommands(db, cfg, deviceID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 1 || leased[0].ID != due {
+		t.Fatalf("LeaseCommands() = %+v, want only the due command", leased)
+	}
+
+	// The lease covers the timeout of the command and the grace period
+	leaseExpiresAt := now.Add(2 * time.Minute)
+	for _, command := range []*models.Command{&leased[0], findCommand(t, db, due)} {
+		if command.Status != models.CommandStatusInProgress || command.Attempts != 1 ||
+			command.LeaseExpiresAt == nil || !command.LeaseExpiresAt.Equal(leaseExpiresAt) {
+			t.Errorf("leased command has status %s, %d attempts and lease until %v, want in_progress, 1 and %v",
+				command.Status, command.Attempts, command.LeaseExpiresAt, leaseExpiresAt)
+		}
+	}
+	checkHistory(t, db, due, transition{models.CommandStatusQueued, models.CommandStatusInProgress, 1})
+
+	for _, commandID := range []uuid.UUID{held, expired, other} {
+		if command := findCommand(t, db, commandID); command.Status != models.CommandStatusQueued || command.Attempts != 0 {
+			t.Errorf("command %s has status %s and %d attempts, want queued and 0", commandID, command.Status, command.Attempts)
+		}
+		checkHistory(t, db, commandID)
+	}
+
+	// A command is leased once its not_before has passed, and a leased command
+	// is not leased again
+	leased, err = LeaseCommands(db, cfg, deviceID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 1 || leased[0].ID != held {
+		t.Errorf("LeaseCommands() after not_before = %+v, want only the held command", leased)
+	}
+}
+
+func TestReleaseExpiredLeases(t *testing.T) {
+	db := openTestDB(t)
+	cfg := &config.Config{CommandLeaseGrace: time.Minute}
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	deviceID := uuid.New()
+
+	commandID := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET max_attempts = 2 WHERE id = ?`, commandID)
+
+	if _, err := LeaseCommands(db, cfg, deviceID, now); err != nil {
+		t.Fatal(err)
+	}
+	leaseExpiresAt := now.Add(2 * time.Minute)
+
+	// A lease is kept until it expires, and leases of other devices are left alone
+	for _, release := range []struct {
+		deviceID uuid.UUID
+		now      time.Time
+	}{
+		{deviceID, leaseExpiresAt.Add(-time.Second)},
+		{uuid.New(), leaseExpiresAt},
+	} {
+		released, err := ReleaseExpiredLeases(db, &release.deviceID, release.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(released) != 0 {
+			t.Errorf("ReleaseExpiredLeases(%s, %v) = %+v, want none", release.deviceID, release.now, released)
+		}
+	}
+
+	// An expired lease returns the command to the queue while attempts are left
+	released, err := ReleaseExpiredLeases(db, nil, leaseExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0].Status != models.CommandStatusQueued {
+		t.Fatalf("ReleaseExpiredLeases() = %+v, want the command queued", released)
+	}
+	if command := findCommand(t, db, commandID); command.Status != models.CommandStatusQueued || command.LeaseExpiresAt != nil {
+		t.Errorf("released command has status %s and lease until %v, want queued without a lease", command.Status, command.LeaseExpiresAt)
+	}
+
+	// With its last attempt used up the command fails
+	now = leaseExpiresAt
+	if _, err := LeaseCommands(db, cfg, deviceID, now); err != nil {
+		t.Fatal(err)
+	}
+	released, err = ReleaseExpiredLeases(db, &deviceID, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0].Status != models.CommandStatusFailed {
+		t.Fatalf("ReleaseExpiredLeases() = %+v, want the command failed", released)
+	}
+
+	command := findCommand(t, db, commandID)
+	var result models.CommandResult
+	if err := json.Unmarshal(command.Result, &result); err != nil {
+		t.Fatal(err)
+	}
+	if command.Status != models.CommandStatusFailed || command.Attempts != 2 || command.LeaseExpiresAt != nil ||
+		result.Success || result.Error != "not acknowledged after 2 attempts" {
+		t.Errorf("failed command = %+v with result %+v", command, result)
+	}
+	if count := countEvents(t, db, models.EventCommandFailed); count != 1 {
+		t.Errorf("recorded %d failed events, want 1", count)
+	}
+
+	checkHistory(t, db, commandID,
+		transition{models.CommandStatusQueued, models.CommandStatusInProgress, 1},
+		transition{models.CommandStatusInProgress, models.CommandStatusQueued, 1},
+		transition{models.CommandStatusQueued, models.CommandStatusInProgress, 2},
+		transition{models.CommandStatusInProgress, models.CommandStatusFailed, 2},
+	)
+}
+
+func TestExpireCommands(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	deviceID := uuid.New()
+
+	expired := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET expires_at = ? WHERE id = ?`, now, expired)
+	pending := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET expires_at = ? WHERE id = ?`, now.Add(time.Second), pending)
+	unlimited := addCommand(t, db, deviceID, now)
+	// Commands delivered before their TTL passed are left to their lease
+	leased := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET status = ?, attempts = 1, expires_at = ?, lease_expires_at = ? WHERE id = ?`,
+		models.CommandStatusInProgress, now.Add(-time.Minute), now.Add(time.Minute), leased)
+
+	count, err := ExpireCommands(db, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("ExpireCommands() = %d, want 1", count)
+	}
+
+	want := map[uuid.UUID]models.CommandStatus{
+		expired:   models.CommandStatusExpired,
+		pending:   models.CommandStatusQueued,
+		unlimited: models.CommandStatusQueued,
+		leased:    models.CommandStatusInProgress,
+	}
+	for commandID, status := range want {
+		if command := findCommand(t, db, commandID); command.Status != status {
+			t.Errorf("command %s has status %s, want %s", commandID, command.Status, status)
+		}
+	}
+	checkHistory(t, db, expired, transition{models.CommandStatusQueued, models.CommandStatusExpired, 0})
+	checkHistory(t, db, pending)
+	checkHistory(t, db, leased)
+	if count := countEvents(t, db, models.EventCommandExpired); count != 1 {
+		t.Errorf("recorded %d expired events, want 1", count)
+	}
+}
+
+func TestAcknowledgeCommand(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+	cfg := &config.Config{CommandLeaseGrace: time.Minute}
+	now := time.Now().UTC().Add(-time.Hour)
+	device := &models.Device{ID: uuid.New()}
+
+	// Both commands are acknowledged after their lease expired: one still in
+	// progress, the other already returned to the queue
+	lapsed := addCommand(t, db, device.ID, now)
+	requeued := addCommand(t, db, device.ID, now)
+	if _, err := LeaseCommands(db, cfg, device.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`UPDATE commands SET status = ?, lease_expires_at = NULL WHERE id = ?`, models.CommandStatusQueued, requeued)
+
+	results := map[uuid.UUID]models.CommandResult{
+		lapsed:   {Success: true, Message: "inventory sent"},
+		requeued: {Success: false, Error: "collector timed out"},
+	}
+	for commandID, result := range results {
+		result := result
+		command, err := h.acknowledgeCommand(device, commandID, &result)
+		if err != nil {
+			t.Fatalf("late acknowledgement of %s: %v", commandID, err)
+		}
+
+		status := models.CommandStatusCompleted
+		if !result.Success {
+			status = models.CommandStatusFailed
+		}
+		stored := findCommand(t, db, commandID)
+		var storedResult models.CommandResult
+		if err := json.Unmarshal(stored.Result, &storedResult); err != nil {
+			t.Fatal(err)
+		}
+		if command.Status != status || stored.Status != status || stored.ExecutedAt == nil ||
+			stored.LeaseExpiresAt != nil || storedResult != result {
+			t.Errorf("acknowledged command = %+v with result %+v, want %s with %+v", stored, storedResult, status, result)
+		}
+	}
+	checkHistory(t, db, lapsed,
+		transition{models.CommandStatusQueued, models.CommandStatusInProgress, 1},
+		transition{models.CommandStatusInProgress, models.CommandStatusCompleted, 1},
+	)
+
+	// Once final the command keeps its result
+	command, err := h.acknowledgeCommand(device, lapsed, &models.CommandResult{Success: false, Error: "retried"})
+	if err != errCommandFinal || command == nil || command.Status != models.CommandStatusCompleted {
+		t.Errorf("acknowledgement of a completed command = %+v, %v, want %v", command, err, errCommandFinal)
+	}
+	if count := countEvents(t, db, models.EventCommandCompleted); count != 1 {
+		t.Errorf("recorded %d completed events, want 1", count)
+	}
+
+	// Commands of other devices are not found
+	other := &models.Device{ID: uuid.New()}
+	if _, err := h.acknowledgeCommand(other, requeued, &models.CommandResult{Success: true}); err != sql.ErrNoRows {
+		t.Errorf("acknowledgement by another device = %v, want %v", err, sql.ErrNoRows)
+	}
+	if _, err := h.acknowledgeCommand(device, uuid.New(), &models.CommandResult{Success: true}); err != sql.ErrNoRows {
+		t.Errorf("acknowledgement of an unknown command = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestDeliverCommands(t *testing.T) {
+	db := openTestDB(t)
+	cfg := &config.Config{CommandLeaseGrace: time.Minute}
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	deviceID := uuid.New()
+
+	redelivered := addCommand(t, db, deviceID, now)
+	if _, err := LeaseCommands(db, cfg, deviceID, now); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	expired := addCommand(t, db, deviceID, now)
+	db.MustExec(`UPDATE commands SET expires_at = ? WHERE id = ?`, now, expired)
+	due := addCommand(t, db, deviceID, now)
+
+	delivered, err := DeliverCommands(db, cfg, deviceID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 2 || delivered[0].ID != redelivered || delivered[1].ID != due {
+		t.Fatalf("DeliverCommands() = %+v, want the redelivered and the due command", delivered)
+	}
+	if delivered[0].Attempts != 2 || delivered[1].Attempts != 1 {
+		t.Errorf("delivered attempts = %d and %d, want 2 and 1", delivered[0].Attempts, delivered[1].Attempts)
+	}
+
+	checkHistory(t, db, redelivered,
+		transition{models.CommandStatusQueued, models.CommandStatusInProgress, 1},
+		transition{models.CommandStatusInProgress, models.CommandStatusQueued, 1},
+		transition{models.CommandStatusQueued, models.CommandStatusInProgress, 2},
+	)
+	checkHistory(t, db, expired, transition{models.CommandStatusQueued, models.CommandStatusExpired, 0})
+	checkHistory(t, db, due, transition{models.CommandStatusQueued, models.CommandStatusInProgress, 1})
+}
+
+func TestCountCommandBatchStatuses(t *testing.T) {
+	db := openTestDB(t)
+
+	first := uuid.New()
+	second := uuid.New()
+	empty := uuid.New()
+	other := uuid.New()
+
+	statuses := map[uuid.UUID][]models.CommandStatus{
+		first: {
+			models.CommandStatusQueued, models.CommandStatusQueued, models.CommandStatusInProgress,
+			models.CommandStatusCompleted, models.CommandStatusFailed, models.CommandStatusExpired,
+		},
+		second: {models.CommandStatusCompleted, models.CommandStatusCompleted},
+		// Batches not asked for are not counted
+		other: {models.CommandStatusFailed},
+	}
+	for batchID, batchStatuses := range statuses {
+		for _, status := range batchStatuses {
+			db.MustExec(`INSERT INTO commands (id, device_id, batch_id, status) VALUES (?, ?, ?, ?)`,
+				uuid.New(), uuid.New(), batchID, status)
+		}
+	}
+	// Commands sent outside of a batch are not counted either
+	db.MustExec(`INSERT INTO commands (id, device_id, status) VALUES (?, ?, ?)`,
+		uuid.New(), uuid.New(), models.CommandStatusQueued)
+
+	counts, err := CountCommandBatchStatuses(db, []uuid.UUID{first, second, empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uuid.UUID]models.CommandBatchCounts{
+		first:  {Total: 6, Queued: 2, InProgress: 1, Completed: 1, Failed: 1, Expired: 1},
+		second: {Total: 2, Completed: 2},
+	}
+	if len(counts) != len(want) {
+		t.Errorf("CountCommandBatchStatuses() = %+v, want %+v", counts, want)
+	}
+	for batchID, w := range want {
+		if counts[batchID] != w {
+			t.Errorf("counts of batch %s = %+v, want %+v", batchID, counts[batchID], w)
+		}
+	}
+	// A batch without commands has zero counts
+	if counts[empty] != (models.CommandBatchCounts{}) {
+		t.Errorf("counts of empty batch = %+v, want zero", counts[empty])
+	}
+}
@@ -1,17 +1,21 @@
 package routes
 
 import (
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/tracr/api/internal/config"
 	"github.com/tracr/api/internal/middleware"
 	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/normalize"
 )
 
 // Handler holds the database and config dependencies
 type Handler struct {
 	DB     *sqlx.DB
 	Config *config.Config
+
+	NormalizationBackfill *normalize.BackfillJob
 }
 
 // Setup configures all agent routes
@@ -19,6 +23,8 @@ func Setup(app *fiber.App, db *sqlx.DB, cfg *config.Config) {
 	handler := &Handler{
 		DB:     db,
 		Config: cfg,
+
+		NormalizationBackfill: &normalize.BackfillJob{},
 	}
 
 	// Public endpoints (no authentication)
@@ -36,8 +42,10 @@ func Setup(app *fiber.App, db *sqlx.DB, cfg *config.Config) {
 	agentAuthed.Use(middleware.DeviceAuth(db))
 	agentAuthed.Post("/:device_id/inventory", handler.SubmitInventory)
 	agentAuthed.Post("/:device_id/heartbeat", handler.Heartbeat)
+	agentAuthed.Post("/:device_id/performance", handler.SubmitPerformanceSamples)
 	agentAuthed.Get("/:device_id/commands", handler.PollCommands)
 	agentAuthed.Post("/:device_id/commands/:command_id/ack", handler.AckCommand)
+	agentAuthed.Get("/:device_id/channel", handler.RequireWebSocketUpgrade, websocket.New(handler.AgentChannel))
 
 	// Authentication routes
 	authGroup := app.Group("/v1/auth")
@@ -56,17 +64,120 @@ func Setup(app *fiber.App, db *sqlx.DB, cfg *config.Config) {
 	deviceGroup := app.Group("/v1/devices")
 	deviceGroup.Use(middleware.JWTAuth(cfg))
 	deviceGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListDevices)
+	deviceGroup.Get("/export", middleware.RequireRole(models.UserRoleViewer), handler.ExportDevices)
+	deviceGroup.Get("/availability", middleware.RequireRole(models.UserRoleViewer), handler.GetFleetAvailability)
+	deviceGroup.Post("/bulk", middleware.RequireRole(models.UserRoleAdmin), handler.BulkDeviceAction)
 	deviceGroup.Get("/:device_id", middleware.RequireRole(models.UserRoleViewer), handler.GetDevice)
 	deviceGroup.Get("/:device_id/snapshots", middleware.RequireRole(models.UserRoleViewer), handler.ListSnapshots)
+	deviceGroup.Get("/:device_id/snapshots/diff", middleware.RequireRole(models.UserRoleViewer), handler.GetSnapshotDiff)
 	deviceGroup.Get("/:device_id/snapshots/:snapshot_id", middleware.RequireRole(models.UserRoleViewer), handler.GetSnapshot)
-	deviceGroup.Post("/:device_id/commands", middleware.RequireRole(models.UserRoleAdmin), handler.CreateCommand)
+	deviceGroup.Get("/:device_id/metrics", middleware.RequireRole(models.UserRoleViewer), handler.GetDeviceMetrics)
+	deviceGroup.Get("/:device_id/metrics/summaries", middleware.RequireRole(models.UserRoleViewer), handler.ListMetricSummaries)
+	deviceGroup.Get("/:device_id/software-events", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceSoftwareEvents)
+	deviceGroup.Put("/:device_id/tags", middleware.RequireRole(models.UserRoleAdmin), handler.SetDeviceTags)
+	deviceGroup.Get("/:device_id/group-history", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceGroupMembershipHistory)
+	deviceGroup.Get("/:device_id/status-history", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceStatusHistory)
+	deviceGroup.Get("/:device_id/availability", middleware.RequireRole(models.UserRoleViewer), handler.GetDeviceAvailability)
+	// The role needed to send a command depends on its type and is checked by the handler
+	deviceGroup.Post("/:device_id/commands", middleware.RequireRole(models.UserRoleViewer), handler.CreateCommand)
 	deviceGroup.Get("/:device_id/commands", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceCommands)
+	deviceGroup.Get("/:device_id/commands/:command_id", middleware.RequireRole(models.UserRoleViewer), handler.GetDeviceCommand)
+	deviceGroup.Get("/:device_id/agent-config", middleware.RequireRole(models.UserRoleViewer), handler.GetDeviceAgentConfig)
+	deviceGroup.Put("/:device_id/agent-config", middleware.RequireRole(models.UserRoleAdmin), handler.UpdateDeviceAgentConfig)
 	deviceGroup.Delete("/:device_id", middleware.RequireRole(models.UserRoleAdmin), handler.DeleteDevice)
 
+	// Command type routes
+	commandTypeGroup := app.Group("/v1/command-types")
+	commandTypeGroup.Use(middleware.JWTAuth(cfg))
+	commandTypeGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListCommandTypes)
+
+	// Command batch routes, sending a command to many devices at once
+	commandBatchGroup := app.Group("/v1/command-batches")
+	commandBatchGroup.Use(middleware.JWTAuth(cfg))
+	commandBatchGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListCommandBatches)
+	commandBatchGroup.Post("/", middleware.RequireRole(models.UserRoleViewer), handler.CreateCommandBatch)
+	commandBatchGroup.Get("/:batch_id", middleware.RequireRole(models.UserRoleViewer), handler.GetCommandBatch)
+
 	// Software catalog routes
 	softwareGroup := app.Group("/v1/software")
 	softwareGroup.Use(middleware.JWTAuth(cfg))
 	softwareGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListSoftwareCatalog)
+	softwareGroup.Get("/devices", middleware.RequireRole(models.UserRoleViewer), handler.ListSoftwareDevices)
+	softwareGroup.Get("/outdated", middleware.RequireRole(models.UserRoleViewer), handler.ListOutdatedSoftware)
+	softwareGroup.Get("/events", middleware.RequireRole(models.UserRoleViewer), handler.ListSoftwareEvents)
+	softwareGroup.Get("/normalization-rules", middleware.RequireRole(models.UserRoleAdmin), handler.ListNormalizationRules)
+	softwareGroup.Post("/normalization-rules", middleware.RequireRole(models.UserRoleAdmin), handler.CreateNormalizationRule)
+	softwareGroup.Post("/normalization-rules/preview", middleware.RequireRole(models.UserRoleAdmin), handler.PreviewNormalization)
+	softwareGroup.Get("/normalization-rules/backfill", middleware.RequireRole(models.UserRoleAdmin), handler.GetNormalizationBackfill)
+	softwareGroup.Post("/normalization-rules/backfill", middleware.RequireRole(models.UserRoleAdmin), handler.StartNormalizationBackfill)
+	softwareGroup.Put("/normalization-rules/:rule_id", middleware.RequireRole(models.UserRoleAdmin), handler.UpdateNormalizationRule)
+	softwareGroup.Delete("/normalization-rules/:rule_id", middleware.RequireRole(models.UserRoleAdmin), handler.DeleteNormalizationRule)
+
+	// Device group and tag routes
+	groupGroup := app.Group("/v1/groups")
+	groupGroup.Use(middleware.JWTAuth(cfg))
+	groupGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceGroups)
+	groupGroup.Post("/", middleware.RequireRole(models.UserRoleAdmin), handler.CreateDeviceGroup)
+	groupGroup.Get("/tags", middleware.RequireRole(models.UserRoleViewer), handler.ListTags)
+	groupGroup.Post("/tags/:tag/devices", middleware.RequireRole(models.UserRoleAdmin), handler.AddDeviceTag)
+	groupGroup.Delete("/tags/:tag/devices", middleware.RequireRole(models.UserRoleAdmin), handler.RemoveDeviceTag)
+	groupGroup.Get("/:group_id", middleware.RequireRole(models.UserRoleViewer), handler.GetDeviceGroup)
+	groupGroup.Put("/:group_id", middleware.RequireRole(models.UserRoleAdmin), handler.UpdateDeviceGroup)
+	groupGroup.Delete("/:group_id", middleware.RequireRole(models.UserRoleAdmin), handler.DeleteDeviceGroup)
+	groupGroup.Get("/:group_id/devices", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceGroupDevices)
+	groupGroup.Post("/:group_id/devices", middleware.RequireRole(models.UserRoleAdmin), handler.AddDeviceGroupMembers)
+	groupGroup.Delete("/:group_id/devices", middleware.RequireRole(models.UserRoleAdmin), handler.RemoveDeviceGroupMembers)
+	groupGroup.Post("/:group_id/evaluate", middleware.RequireRole(models.UserRoleAdmin), handler.EvaluateDeviceGroup)
+	groupGroup.Get("/:group_id/history", middleware.RequireRole(models.UserRoleViewer), handler.ListDeviceGroupHistory)
+
+	// Compliance policy routes
+	policyGroup := app.Group("/v1/policies")
+	policyGroup.Use(middleware.JWTAuth(cfg))
+	policyGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListPolicies)
+	policyGroup.Post("/", middleware.RequireRole(models.UserRoleAdmin), handler.CreatePolicy)
+	policyGroup.Get("/compliance", middleware.RequireRole(models.UserRoleViewer), handler.GetFleetCompliance)
+	policyGroup.Post("/evaluate", middleware.RequireRole(models.UserRoleAdmin), handler.EvaluateAllPolicies)
+	policyGroup.Get("/devices/:device_id", middleware.RequireRole(models.UserRoleViewer), handler.GetDeviceCompliance)
+	policyGroup.Get("/:policy_id", middleware.RequireRole(models.UserRoleViewer), handler.GetPolicy)
+	policyGroup.Put("/:policy_id", middleware.RequireRole(models.UserRoleAdmin), handler.UpdatePolicy)
+	policyGroup.Delete("/:policy_id", middleware.RequireRole(models.UserRoleAdmin), handler.DeletePolicy)
+	policyGroup.Get("/:policy_id/results", middleware.RequireRole(models.UserRoleViewer), handler.ListPolicyResults)
+
+	// Alert routes
+	alertGroup := app.Group("/v1/alerts")
+	alertGroup.Use(middleware.JWTAuth(cfg))
+	alertGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.ListAlerts)
+	alertGroup.Post("/evaluate", middleware.RequireRole(models.UserRoleAdmin), handler.EvaluateAllAlertRules)
+	alertGroup.Get("/rules", middleware.RequireRole(models.UserRoleViewer), handler.ListAlertRules)
+	alertGroup.Post("/rules", middleware.RequireRole(models.UserRoleAdmin), handler.CreateAlertRule)
+	alertGroup.Get("/rules/:rule_id", middleware.RequireRole(models.UserRoleViewer), handler.GetAlertRule)
+	alertGroup.Put("/rules/:rule_id", middleware.RequireRole(models.UserRoleAdmin), handler.UpdateAlertRule)
+	alertGroup.Delete("/rules/:rule_id", middleware.RequireRole(models.UserRoleAdmin), handler.DeleteAlertRule)
+	alertGroup.Get("/:alert_id", middleware.RequireRole(models.UserRoleViewer), handler.GetAlert)
+	alertGroup.Post("/:alert_id/acknowledge", middleware.RequireRole(models.UserRoleAdmin), handler.AcknowledgeAlert)
+
+	// Webhook routes
+	webhookGroup := app.Group("/v1/webhooks")
+	webhookGroup.Use(middleware.JWTAuth(cfg))
+	webhookGroup.Get("/", middleware.RequireRole(models.UserRoleAdmin), handler.ListWebhooks)
+	webhookGroup.Post("/", middleware.RequireRole(models.UserRoleAdmin), handler.CreateWebhook)
+	webhookGroup.Get("/event-types", middleware.RequireRole(models.UserRoleAdmin), handler.ListWebhookEventTypes)
+	webhookGroup.Get("/:webhook_id", middleware.RequireRole(models.UserRoleAdmin), handler.GetWebhook)
+	webhookGroup.Put("/:webhook_id", middleware.RequireRole(models.UserRoleAdmin), handler.UpdateWebhook)
+	webhookGroup.Delete("/:webhook_id", middleware.RequireRole(models.UserRoleAdmin), handler.DeleteWebhook)
+	webhookGroup.Post("/:webhook_id/test", middleware.RequireRole(models.UserRoleAdmin), handler.TestWebhook)
+	webhookGroup.Get("/:webhook_id/deliveries", middleware.RequireRole(models.UserRoleAdmin), handler.ListWebhookDeliveries)
+	webhookGroup.Post("/:webhook_id/deliveries/:delivery_id/redeliver", middleware.RequireRole(models.UserRoleAdmin), handler.RedeliverWebhookDelivery)
+
+	// Event stream routes
+	eventGroup := app.Group("/v1/events")
+	eventGroup.Use(middleware.JWTAuth(cfg))
+	eventGroup.Get("/stream", middleware.RequireRole(models.UserRoleViewer), handler.StreamEvents)
+
+	// Search routes
+	searchGroup := app.Group("/v1/search")
+	searchGroup.Use(middleware.JWTAuth(cfg))
+	searchGroup.Get("/", middleware.RequireRole(models.UserRoleViewer), handler.Search)
 
 	// Audit log routes
 	auditGroup := app.Group("/v1/audit-logs")
@@ -1,21 +1,30 @@
 package routes
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/commands"
 	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/events"
 	"github.com/tracr/api/internal/middleware"
 	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/versions"
 )
 
 // Token generation and hashing utilities
@@ -38,7 +47,7 @@ func HashToken(token string) string {
 // GenerateJWTToken generates a JWT token for a user
 func GenerateJWTToken(user *models.User, cfg *config.Config) (string, time.Time, error) {
 	expiresAt := time.Now().Add(cfg.JWTExpiry)
-	
+
 	claims := middleware.JWTClaimsCustom{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   user.ID.String(),
@@ -61,25 +70,421 @@ func GenerateJWTToken(user *models.User, cfg *config.Config) (string, time.Time,
 
 // Snapshot hashing utilities
 
-// CalculateSnapshotHash computes SHA-256 hash of inventory data for deduplication
+// stableInventory is the subset of an inventory submission that identifies
+// a distinct snapshot. Volatile readings such as performance counters, free
+// space, boot time and collection timestamps are deliberately excluded.
+type stableInventory struct {
+	Hostname     string           `json:"hostname"`
+	Domain       string           `json:"domain"`
+	OS           models.OS        `json:"os"`
+	Hardware     models.Hardware  `json:"hardware"`
+	AgentVersion string           `json:"agent_version"`
+	Volumes      []stableVolume   `json:"volumes"`
+	Software     []stableSoftware `json:"software"`
+}
+
+type stableVolume struct {
+	Name       string `json:"name"`
+	FileSystem string `json:"filesystem"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+type stableSoftware struct {
+	Name        string     `json:"name"`
+	Version     string     `json:"version"`
+	Publisher   string     `json:"publisher"`
+	InstallDate *time.Time `json:"install_date"`
+}
+
+// CalculateSnapshotHash computes SHA-256 hash of the stable inventory data for deduplication.
+// Volumes and software are sorted so that collection order does not affect the hash.
 func CalculateSnapshotHash(inventory *models.InventorySubmission) (string, error) {
-	jsonData, err := json.Marshal(inventory)
+	stable := stableInventory{
+		Hostname:     inventory.Identity.Hostname,
+		Domain:       inventory.Identity.Domain,
+		OS:           inventory.OS,
+		Hardware:     inventory.Hardware,
+		AgentVersion: inventory.AgentVersion,
+		Volumes:      make([]stableVolume, 0, len(inventory.Volumes)),
+		Software:     make([]stableSoftware, 0, len(inventory.Software)),
+	}
+	stable.OS.InstallDate = stable.OS.InstallDate.UTC()
+
+	for _, volume := range inventory.Volumes {
+		stable.Volumes = append(stable.Volumes, stableVolume{
+			Name:       volume.Name,
+			FileSystem: volume.FileSystem,
+			TotalBytes: volume.TotalBytes,
+		})
+	}
+	sort.Slice(stable.Volumes, func(i, j int) bool {
+		return stable.Volumes[i].Name < stable.Volumes[j].Name
+	})
+
+	for _, item := range inventory.Software {
+		entry := stableSoftware{
+			Name:      item.Name,
+			Version:   item.Version,
+			Publisher: item.Publisher,
+		}
+		if item.InstallDate != nil {
+			installDate := item.InstallDate.UTC()
+			entry.InstallDate = &installDate
+		}
+		stable.Software = append(stable.Software, entry)
+	}
+	sort.Slice(stable.Software, func(i, j int) bool {
+		a, b := stable.Software[i], stable.Software[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Publisher < b.Publisher
+	})
+
+	jsonData, err := json.Marshal(stable)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal inventory: %w", err)
 	}
-	
+
 	hash := sha256.Sum256(jsonData)
 	return hex.EncodeToString(hash[:]), nil
 }
 
+// softwareSetEntry is a single entry of a content-addressed software set
+type softwareSetEntry struct {
+	stableSoftware
+	SizeKB *int64 `json:"size_kb"`
+}
+
+// CalculateSoftwareSetHash computes SHA-256 hash of a software list for content-addressed storage.
+// Entries are sorted first so that identical lists always produce the same hash.
+func CalculateSoftwareSetHash(software []models.Software) (string, error) {
+	entries := make([]softwareSetEntry, 0, len(software))
+	for _, item := range software {
+		entry := softwareSetEntry{
+			stableSoftware: stableSoftware{
+				Name:      item.Name,
+				Version:   item.Version,
+				Publisher: item.Publisher,
+			},
+			SizeKB: item.SizeKB,
+		}
+		if item.InstallDate != nil {
+			installDate := item.InstallDate.UTC()
+			entry.InstallDate = &installDate
+		}
+		entries = append(entries, entry)
+	}
+
+	// Marshal each entry and sort by the encoded form for a total, stable ordering
+	encoded := make([]string, len(entries))
+	for i, entry := range entries {
+		jsonData, err := json.Marshal(entry)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal software entry: %w", err)
+		}
+		encoded[i] = string(jsonData)
+	}
+	sort.Strings(encoded)
+
+	hash := sha256.Sum256([]byte("[" + strings.Join(encoded, ",") + "]"))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// BuildSnapshotCheckin extracts the volatile readings of an inventory submission
+func BuildSnapshotCheckin(snapshotID, deviceID uuid.UUID, inventory *models.InventorySubmission) (*models.SnapshotCheckin, error) {
+	freeBytes := make(map[string]int64, len(inventory.Volumes))
+	for _, volume := range inventory.Volumes {
+		freeBytes[volume.Name] = volume.FreeBytes
+	}
+
+	freeBytesJSON, err := json.Marshal(freeBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal volume free bytes: %w", err)
+	}
+
+	cpu := inventory.Performance.CPUPercent
+	memoryUsed := inventory.Performance.MemoryUsedBytes
+	memoryTotal := inventory.Performance.MemoryTotalBytes
+	bootTime := inventory.Identity.BootTime
+
+	return &models.SnapshotCheckin{
+		ID:                  uuid.New(),
+		SnapshotID:          snapshotID,
+		DeviceID:            deviceID,
+		CollectedAt:         inventory.CollectedAt.UTC(),
+		CPUPercent:          &cpu,
+		MemoryUsedBytes:     &memoryUsed,
+		MemoryTotalBytes:    &memoryTotal,
+		BootTime:            &bootTime,
+		LastInteractiveUser: inventory.Identity.LastInteractiveUser,
+		VolumeFreeBytes:     json.RawMessage(freeBytesJSON),
+	}, nil
+}
+
+// Version utilities
+
+// VersionConstraint restricts software versions with a comparison such as "<120.0"
+type VersionConstraint struct {
+	Op      string
+	Version string
+}
+
+// versionOperators lists the supported comparison operators, longest first
+var versionOperators = []string{"<=", ">=", "!=", "<", ">", "="}
+
+// ParseVersionConstraint parses an expression such as "<120.0", ">=118" or
+// "118.0.1" (an exact match) into a version constraint
+func ParseVersionConstraint(expr string) (VersionConstraint, error) {
+	expr = strings.TrimSpace(expr)
+	for _, op := range versionOperators {
+		if strings.HasPrefix(expr, op) {
+			version := strings.TrimSpace(strings.TrimPrefix(expr, op))
+			if version == "" {
+				return VersionConstraint{}, fmt.Errorf("missing version after %q", op)
+			}
+			return VersionConstraint{Op: op, Version: version}, nil
+		}
+	}
+	if expr == "" {
+		return VersionConstraint{}, fmt.Errorf("empty version constraint")
+	}
+	return VersionConstraint{Op: "=", Version: expr}, nil
+}
+
+// Matches reports whether version satisfies the constraint
+func (vc VersionConstraint) Matches(version string) bool {
+	cmp := versions.Compare(version, vc.Version)
+	switch vc.Op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Normalization utilities
+
+// applyNormalizationRuleRequest copies the provided fields of a request onto a rule
+func applyNormalizationRuleRequest(rule *models.NormalizationRule, req *models.NormalizationRuleRequest) {
+	if req.Field != nil {
+		rule.Field = *req.Field
+	}
+	if req.MatchType != nil {
+		rule.MatchType = *req.MatchType
+	}
+	if req.Pattern != nil {
+		rule.Pattern = *req.Pattern
+	}
+	if req.Replacement != nil {
+		rule.Replacement = *req.Replacement
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Description != nil {
+		rule.Description = *req.Description
+	}
+}
+
+// applyPolicyRequest copies the provided fields of a request onto a policy
+func applyPolicyRequest(p *models.Policy, req *models.PolicyRequest) {
+	if req.Name != nil {
+		p.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+	}
+	if req.RuleType != nil {
+		p.RuleType = *req.RuleType
+	}
+	if req.Params != nil {
+		p.Params = *req.Params
+	}
+	if req.Severity != nil {
+		p.Severity = *req.Severity
+	}
+	if req.Enabled != nil {
+		p.Enabled = *req.Enabled
+	}
+}
+
+// applyAlertRuleRequest copies the provided fields of a request onto an alert rule
+func applyAlertRuleRequest(rule *models.AlertRule, req *models.AlertRuleRequest) {
+	if req.Name != nil {
+		rule.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		rule.Description = *req.Description
+	}
+	if req.RuleType != nil {
+		rule.RuleType = *req.RuleType
+	}
+	if req.Params != nil {
+		rule.Params = *req.Params
+	}
+	if req.Severity != nil {
+		rule.Severity = *req.Severity
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+}
+
+// applyWebhookRequest copies the provided fields of a webhook request onto a webhook
+func applyWebhookRequest(webhook *models.Webhook, req *models.WebhookRequest) {
+	if req.Name != nil {
+		webhook.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.URL != nil {
+		webhook.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+	if req.EventTypes != nil {
+		webhook.EventTypes = models.EventTypeList(*req.EventTypes)
+	}
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+}
+
+// CompliancePercent returns the share of passing results, or nil when nothing was evaluated
+func CompliancePercent(passing, failing int) *float64 {
+	if passing+failing == 0 {
+		return nil
+	}
+	percent := math.Round(float64(passing)*10000/float64(passing+failing)) / 100
+	return &percent
+}
+
+// SortNormalizationRules orders rules the way they are applied at ingest:
+// by priority, keeping the given order for equal priorities
+func SortNormalizationRules(rules []models.NormalizationRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+}
+
+// Tag utilities
+
+// maxTagLength is the longest accepted device tag
+const maxTagLength = 64
+
+// NormalizeTags trims tags and drops duplicates, ignoring case. Tags must be
+// non-empty and may not contain commas so they can be given in query strings.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("tags cannot be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tags cannot contain commas")
+		}
+
+		key := strings.ToLower(tag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized, nil
+}
+
+// Time utilities
+
+// ParseDBTime parses a timestamp read back as text, as happens for aggregates
+// such as MAX(collected_at) which lose the column type. Empty values yield the zero time.
+func ParseDBTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp format: %q", value)
+}
+
 // Device status utilities
 
-// DetermineDeviceStatus determines device status based on last seen timestamp
-func DetermineDeviceStatus(lastSeen time.Time) models.DeviceStatus {
-	if time.Since(lastSeen) <= 5*time.Minute {
-		return models.DeviceStatusActive
+// BuildCommand creates a queued command for a device from a request whose
+// payload has been validated, filling in the timeout of the command type and
+// the configured TTL and attempts where the request leaves them out. The TTL
+// runs from when the command becomes available.
+func BuildCommand(cfg *config.Config, definition commands.Definition, deviceID uuid.UUID, req *models.CommandRequest, payload json.RawMessage, now time.Time) *models.Command {
+	command := &models.Command{
+		ID:             uuid.New(),
+		DeviceID:       deviceID,
+		CommandType:    req.CommandType,
+		Payload:        payload,
+		Status:         models.CommandStatusQueued,
+		CreatedAt:      now,
+		TimeoutSeconds: int(definition.DefaultTimeout / time.Second),
+		MaxAttempts:    cfg.CommandMaxAttempts,
 	}
-	return models.DeviceStatusOffline
+	if req.TimeoutSeconds != nil {
+		command.TimeoutSeconds = *req.TimeoutSeconds
+	}
+	if req.MaxAttempts != nil {
+		command.MaxAttempts = *req.MaxAttempts
+	}
+
+	availableAt := now
+	if req.NotBefore != nil && req.NotBefore.After(now) {
+		notBefore := req.NotBefore.UTC()
+		command.NotBefore = &notBefore
+		availableAt = notBefore
+	}
+
+	ttl := cfg.CommandTTL
+	if req.TTLSeconds != nil {
+		ttl = time.Duration(*req.TTLSeconds) * time.Second
+	}
+	expiresAt := availableAt.Add(ttl)
+	command.ExpiresAt = &expiresAt
+
+	return command
+}
+
+// DetermineDeviceStatus determines the status a device should be in from the
+// time of its last check-in and the errors its agent last reported, along
+// with the reason for it
+func DetermineDeviceStatus(cfg *config.Config, lastSeen time.Time, agentError string, now time.Time) (models.DeviceStatus, string) {
+	since := now.Sub(lastSeen)
+	switch {
+	case since > cfg.DeviceInactiveAfter:
+		return models.DeviceStatusInactive, fmt.Sprintf("no check-in since %s", lastSeen.UTC().Format(time.RFC3339))
+	case since > cfg.DeviceOfflineAfter:
+		return models.DeviceStatusOffline, fmt.Sprintf("no check-in since %s", lastSeen.UTC().Format(time.RFC3339))
+	case agentError != "":
+		return models.DeviceStatusError, "agent reported errors: " + agentError
+	}
+	return models.DeviceStatusActive, "checking in"
 }
 
 // Error response utilities
@@ -101,8 +506,33 @@ func ValidationErrorResponse(c *fiber.Ctx, err error) error {
 // Device status utilities
 
 // CalculateDeviceOnlineStatus determines if a device is online based on last seen timestamp
-func CalculateDeviceOnlineStatus(lastSeen time.Time) bool {
-	return time.Since(lastSeen) <= 5*time.Minute
+func CalculateDeviceOnlineStatus(lastSeen time.Time, offlineAfter time.Duration) bool {
+	return time.Since(lastSeen) <= offlineAfter
+}
+
+// BuildDeviceListItem combines a device with its current inventory state and volumes
+func BuildDeviceListItem(device models.Device, state *models.DeviceCurrentState, volumes []models.Volume, offlineAfter time.Duration) models.DeviceListItem {
+	item := models.DeviceListItem{
+		Device:   device,
+		IsOnline: CalculateDeviceOnlineStatus(device.LastSeen, offlineAfter),
+		Volumes:  volumes,
+		Tags:     []string{},
+	}
+
+	if state != nil {
+		item.LatestSnapshot = &models.SnapshotSummary{
+			ID:               state.SnapshotID,
+			CollectedAt:      state.CollectedAt,
+			CPUPercent:       state.CPUPercent,
+			MemoryUsedBytes:  state.MemoryUsedBytes,
+			MemoryTotalBytes: state.MemoryTotalBytes,
+			BootTime:         state.BootTime,
+		}
+		item.UptimeHours = CalculateUptimeHours(state.BootTime)
+		item.SoftwareCount = state.SoftwareCount
+	}
+
+	return item
 }
 
 // CalculateUptimeHours calculates uptime hours from boot time
@@ -116,7 +546,7 @@ func CalculateUptimeHours(bootTime *time.Time) int {
 // CalculateVolumeUsage calculates used bytes and used percentage for a volume
 func CalculateVolumeUsage(volume *models.Volume) {
 	volume.UsedBytes = volume.TotalBytes - volume.FreeBytes
-	
+
 	if volume.TotalBytes > 0 {
 		volume.UsedPercent = (float64(volume.UsedBytes) / float64(volume.TotalBytes)) * 100.0
 	} else {
@@ -140,12 +570,14 @@ func ExtractUserFromContext(c *fiber.Ctx) (uuid.UUID, string, models.UserRole, e
 		return uuid.Nil, "", "", fmt.Errorf("invalid user ID in context")
 	}
 
-	claims, ok := userClaims.(models.JWTClaims)
-	if !ok {
-		return uuid.Nil, "", "", fmt.Errorf("invalid user claims in context")
+	// The JWT middleware stores a pointer to the claims
+	switch claims := userClaims.(type) {
+	case *models.JWTClaims:
+		return id, claims.Username, claims.Role, nil
+	case models.JWTClaims:
+		return id, claims.Username, claims.Role, nil
 	}
-
-	return id, claims.Username, claims.Role, nil
+	return uuid.Nil, "", "", fmt.Errorf("invalid user claims in context")
 }
 
 // ExtractClientIP extracts client IP address from request
@@ -203,5 +635,60 @@ func LogAuditAction(db *sqlx.DB, c *fiber.Ctx, action string, deviceID *uuid.UUI
 	}
 
 	// Save to database
-	return CreateAuditLog(db, auditLog)
-}
\ No newline at end of file
+	if err := CreateAuditLog(db, auditLog); err != nil {
+		return err
+	}
+
+	return events.Record(db, models.EventAuditCreated, deviceID, auditLog)
+}
+
+// deviceExportColumns are the CSV columns of device exports
+var deviceExportColumns = []string{
+	"id", "hostname", "domain", "manufacturer", "model", "serial_number",
+	"os_caption", "os_version", "os_build", "status", "is_online",
+	"software_count", "tags", "first_seen", "last_seen",
+}
+
+// DevicesCSV encodes device list items as CSV with a header row
+func DevicesCSV(items []models.DeviceListItem) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(deviceExportColumns); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		record := []string{
+			item.ID.String(),
+			csvCell(item.Hostname),
+			csvCell(item.Domain),
+			csvCell(item.Manufacturer),
+			csvCell(item.Model),
+			csvCell(item.SerialNumber),
+			csvCell(item.OSCaption),
+			csvCell(item.OSVersion),
+			csvCell(item.OSBuild),
+			string(item.Status),
+			strconv.FormatBool(item.IsOnline),
+			strconv.Itoa(item.SoftwareCount),
+			csvCell(strings.Join(item.Tags, ";")),
+			item.FirstSeen.UTC().Format(time.RFC3339),
+			item.LastSeen.UTC().Format(time.RFC3339),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvCell neutralizes values reported by devices that spreadsheets would
+// evaluate as a formula, by prefixing them with a quote
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,156 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tracr/api/internal/models"
+)
+
+func testInventory() *models.InventorySubmission {
+	installDate := time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)
+	return &models.InventorySubmission{
+		Identity: models.Identity{
+			Hostname:            "WS-0142",
+			Domain:              "corp.example.com",
+			LastInteractiveUser: "CORP\\jdoe",
+			BootTime:            time.Date(2024, 3, 1, 7, 45, 0, 0, time.UTC),
+		},
+		OS:          models.OS{Caption: "Windows 11 Pro", Version: "10.0.22631", BuildNumber: "22631", InstallDate: installDate},
+		Hardware:    models.Hardware{Manufacturer: "Dell Inc.", Model: "OptiPlex 7090", SerialNumber: "8KXQ2M3"},
+		Performance: models.Performance{CPUPercent: 12.5, MemoryUsedBytes: 6 << 30, MemoryTotalBytes: 16 << 30},
+		Volumes: []models.Volume{
+			{Name: "C:", FileSystem: "NTFS", TotalBytes: 500 << 30, FreeBytes: 120 << 30},
+			{Name: "D:", FileSystem: "NTFS", TotalBytes: 1000 << 30, FreeBytes: 800 << 30},
+		},
+		Software: []models.Software{
+			{Name: "7-Zip", Version: "23.01", Publisher: "Igor Pavlov", InstallDate: &installDate},
+			{Name: "Git", Version: "2.44.0", Publisher: "The Git Development Community"},
+		},
+		CollectedAt:  time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC),
+		AgentVersion: "1.4.0",
+	}
+}
+
+func TestCalculateSnapshotHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(inventory *models.InventorySubmission)
+		changed bool
+	}{
+		{"collected at", func(inv *models.InventorySubmission) { inv.CollectedAt = inv.CollectedAt.Add(time.Hour) }, false},
+		{"performance", func(inv *models.InventorySubmission) {
+			inv.Performance = models.Performance{CPUPercent: 87, MemoryUsedBytes: 15 << 30, MemoryTotalBytes: 16 << 30}
+		}, false},
+		{"free bytes", func(inv *models.InventorySubmission) { inv.Volumes[0].FreeBytes = 80 << 30 }, false},
+		{"boot time", func(inv *models.InventorySubmission) {
+			inv.Identity.BootTime = inv.Identity.BootTime.Add(24 * time.Hour)
+		}, false},
+		{"interactive user", func(inv *models.InventorySubmission) { inv.Identity.LastInteractiveUser = "CORP\\asmith" }, false},
+		{"software order", func(inv *models.InventorySubmission) {
+			inv.Software[0], inv.Software[1] = inv.Software[1], inv.Software[0]
+		}, false},
+		{"volume order", func(inv *models.InventorySubmission) { inv.Volumes[0], inv.Volumes[1] = inv.Volumes[1], inv.Volumes[0] }, false},
+		{"install date time zone", func(inv *models.InventorySubmission) {
+			inv.OS.InstallDate = inv.OS.InstallDate.In(time.FixedZone("CET", 3600))
+		}, false},
+		{"hostname", func(inv *models.InventorySubmission) { inv.Identity.Hostname = "WS-0143" }, true},
+		{"domain", func(inv *models.InventorySubmission) { inv.Identity.Domain = "" }, true},
+		{"os build", func(inv *models.InventorySubmission) { inv.OS.BuildNumber = "22635" }, true},
+		{"hardware", func(inv *models.InventorySubmission) { inv.Hardware.SerialNumber = "8KXQ2M4" }, true},
+		{"agent version", func(inv *models.InventorySubmission) { inv.AgentVersion = "1.5.0" }, true},
+		{"software version", func(inv *models.InventorySubmission) { inv.Software[1].Version = "2.45.1" }, true},
+		{"software removed", func(inv *models.InventorySubmission) { inv.Software = inv.Software[:1] }, true},
+		{"volume size", func(inv *models.InventorySubmission) { inv.Volumes[1].TotalBytes = 2000 << 30 }, true},
+		{"volume file system", func(inv *models.InventorySubmission) { inv.Volumes[1].FileSystem = "ReFS" }, true},
+		{"volume added", func(inv *models.InventorySubmission) {
+			inv.Volumes = append(inv.Volumes, models.Volume{Name: "E:", FileSystem: "exFAT", TotalBytes: 64 << 30})
+		}, true},
+	}
+
+	base, err := CalculateSnapshotHash(testInventory())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inventory := testInventory()
+			tt.mutate(inventory)
+
+			hash, err := CalculateSnapshotHash(inventory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := hash != base; changed != tt.changed {
+				t.Errorf("hash changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestCalculateSoftwareSetHash(t *testing.T) {
+	sizeKB := int64(5120)
+	tests := []struct {
+		name    string
+		mutate  func(software []models.Software) []models.Software
+		changed bool
+	}{
+		{"order", func(sw []models.Software) []models.Software { return []models.Software{sw[1], sw[0]} }, false},
+		{"IDs", func(sw []models.Software) []models.Software {
+			sw[0].ID = uuid.New()
+			sw[0].SnapshotID = uuid.New()
+			return sw
+		}, false},
+		{"install date time zone", func(sw []models.Software) []models.Software {
+			installDate := sw[0].InstallDate.In(time.FixedZone("CET", 3600))
+			sw[0].InstallDate = &installDate
+			return sw
+		}, false},
+		{"version", func(sw []models.Software) []models.Software { sw[1].Version = "2.45.1"; return sw }, true},
+		{"publisher", func(sw []models.Software) []models.Software { sw[0].Publisher = "7-Zip Project"; return sw }, true},
+		{"size", func(sw []models.Software) []models.Software { sw[1].SizeKB = &sizeKB; return sw }, true},
+		{"removed", func(sw []models.Software) []models.Software { return sw[:1] }, true},
+		{"duplicated", func(sw []models.Software) []models.Software { return append(sw, sw[1]) }, true},
+	}
+
+	base, err := CalculateSoftwareSetHash(testInventory().Software)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := CalculateSoftwareSetHash(tt.mutate(testInventory().Software))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := hash != base; changed != tt.changed {
+				t.Errorf("hash changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"WS-0142", "WS-0142"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1", "'\t=1"},
+		{"a=1", "a=1"},
+	}
+
+	for _, tt := range tests {
+		if got := csvCell(tt.value); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
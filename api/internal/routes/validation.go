@@ -2,9 +2,17 @@ package routes
 
 import (
 	"fmt"
+	"net/url"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/tracr/api/internal/alerting"
+	"github.com/tracr/api/internal/devicefilter"
+	"github.com/tracr/api/internal/models"
+	"github.com/tracr/api/internal/normalize"
+	"github.com/tracr/api/internal/policy"
 )
 
 // Package-level validator instance
@@ -36,10 +44,19 @@ func formatValidationErrors(errors validator.ValidationErrors) error {
 		switch err.Tag() {
 		case "required":
 			message = fmt.Sprintf("%s is required", err.Field())
-		case "min":
-			message = fmt.Sprintf("%s must be at least %s characters", err.Field(), err.Param())
-		case "max":
-			message = fmt.Sprintf("%s must be at most %s characters", err.Field(), err.Param())
+		case "min", "max":
+			bound := "at least"
+			if err.Tag() == "max" {
+				bound = "at most"
+			}
+			switch err.Kind() {
+			case reflect.Slice:
+				message = fmt.Sprintf("%s must contain %s %s items", err.Field(), bound, err.Param())
+			case reflect.String:
+				message = fmt.Sprintf("%s must be %s %s characters", err.Field(), bound, err.Param())
+			default:
+				message = fmt.Sprintf("%s must be %s %s", err.Field(), bound, err.Param())
+			}
 		case "email":
 			message = fmt.Sprintf("%s must be a valid email address", err.Field())
 		case "uuid":
@@ -54,4 +71,140 @@ func formatValidationErrors(errors validator.ValidationErrors) error {
 	}
 	
 	return fmt.Errorf("validation failed: %s", strings.Join(messages, ", "))
-}
\ No newline at end of file
+}
+
+// ValidateNormalizationRule checks the field, match type and pattern of a normalization rule
+func ValidateNormalizationRule(rule *models.NormalizationRule) error {
+	switch rule.Field {
+	case models.NormalizationFieldName, models.NormalizationFieldPublisher:
+	default:
+		return fmt.Errorf("field must be one of: name, publisher")
+	}
+
+	switch rule.MatchType {
+	case models.NormalizationMatchRegex, models.NormalizationMatchAlias:
+	default:
+		return fmt.Errorf("match_type must be one of: regex, alias")
+	}
+
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return fmt.Errorf("pattern is required")
+	}
+
+	return normalize.ValidateRule(*rule)
+}
+
+// ValidateDeviceGroup checks that smart groups have valid criteria and static
+// groups have none. Criteria without a match mode default to matching all conditions.
+func ValidateDeviceGroup(group *models.DeviceGroup) error {
+	if group.Criteria != nil && group.Criteria.Match == "" {
+		group.Criteria.Match = models.GroupMatchAll
+	}
+
+	switch group.Kind {
+	case models.DeviceGroupStatic:
+		if group.Criteria != nil {
+			return fmt.Errorf("criteria can only be set on smart groups")
+		}
+		return nil
+	case models.DeviceGroupSmart:
+		if group.Criteria == nil {
+			return fmt.Errorf("criteria are required for smart groups")
+		}
+		if _, _, err := devicefilter.CriteriaSQL(*group.Criteria); err != nil {
+			return fmt.Errorf("invalid criteria: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("kind must be one of: static, smart")
+}
+
+// ValidatePolicy checks the name, severity and rule parameters of a policy
+func ValidatePolicy(p *models.Policy) error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch p.Severity {
+	case models.PolicySeverityLow, models.PolicySeverityMedium, models.PolicySeverityHigh, models.PolicySeverityCritical:
+	default:
+		return fmt.Errorf("severity must be one of: low, medium, high, critical")
+	}
+
+	return policy.Validate(p.RuleType, p.Params)
+}
+
+// ValidateAlertRule checks the name, severity and rule parameters of an alert rule
+func ValidateAlertRule(rule *models.AlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch rule.Severity {
+	case models.AlertSeverityInfo, models.AlertSeverityWarning, models.AlertSeverityCritical:
+	default:
+		return fmt.Errorf("severity must be one of: info, warning, critical")
+	}
+
+	return alerting.Validate(rule.RuleType, rule.Params)
+}
+
+// ValidateWebhook checks the name, URL and event types of a webhook
+func ValidateWebhook(webhook *models.Webhook) error {
+	if webhook.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	endpoint, err := url.Parse(webhook.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+
+	for _, eventType := range webhook.EventTypes {
+		known := false
+		for _, subscribable := range models.EventTypes {
+			if eventType == subscribable {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event type: %s", eventType)
+		}
+	}
+
+	return nil
+}
+
+// ValidateAgentConfig checks that the durations of an agent configuration
+// parse and are within the bounds the agent can work with
+func ValidateAgentConfig(config *models.AgentConfig) error {
+	durations := []struct {
+		name    string
+		value   *string
+		minimum time.Duration
+		maximum time.Duration
+	}{
+		{"collection_interval", config.CollectionInterval, time.Minute, 24 * time.Hour},
+		{"sample_interval", config.SampleInterval, time.Second, time.Hour},
+		{"sample_upload_interval", config.SampleUploadInterval, time.Minute, 24 * time.Hour},
+		{"heartbeat_interval", config.HeartbeatInterval, 10 * time.Second, time.Hour},
+		{"command_poll_interval", config.CommandPollInterval, 5 * time.Second, time.Hour},
+		{"command_wait", config.CommandWait, 0, maxCommandWait},
+	}
+
+	for _, d := range durations {
+		if d.value == nil {
+			continue
+		}
+		value, err := time.ParseDuration(*d.value)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 30s or 15m", d.name)
+		}
+		if value < d.minimum || value > d.maximum {
+			return fmt.Errorf("%s must be between %s and %s", d.name, d.minimum, d.maximum)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrUnavailable is returned when SQLite was built without the FTS5 extension
+var ErrUnavailable = errors.New("SQLite was built without FTS5, rebuild with -tags sqlite_fts5 to enable search")
+
+// The indexes are created at startup rather than by a migration because they
+// depend on FTS5 being compiled into SQLite. Triggers on the devices,
+// snapshot, current software and audit log tables keep them up to date, so
+// every ingest and audit write path maintains the index in the same
+// transaction as the change itself.
+var tables = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS search_devices USING fts5(
+		device_id UNINDEXED, hostname, serial_number, last_interactive_user,
+		domain, manufacturer, model, os_caption,
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3')`,
+
+	// Software is indexed once per distinct title currently installed
+	`CREATE TABLE IF NOT EXISTS search_software_titles (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		publisher TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE VIRTUAL TABLE IF NOT EXISTS search_software USING fts5(
+		name, publisher,
+		content = 'search_software_titles', content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3')`,
+
+	`CREATE VIRTUAL TABLE IF NOT EXISTS search_audit_logs USING fts5(
+		audit_log_id UNINDEXED, action, details,
+		tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3')`,
+}
+
+// trigger is a named trigger keeping an index in sync with its source table
+type trigger struct {
+	name string
+	sql  string
+}
+
+var triggers = []trigger{
+	{"search_devices_insert", `
+		AFTER INSERT ON devices BEGIN
+			INSERT INTO search_devices (device_id, hostname, serial_number, last_interactive_user, domain, manufacturer, model, os_caption)
+			VALUES (NEW.id, NEW.hostname, COALESCE(NEW.serial_number, ''), '', COALESCE(NEW.domain, ''),
+				COALESCE(NEW.manufacturer, ''), COALESCE(NEW.model, ''), COALESCE(NEW.os_caption, ''));
+		END`},
+	{"search_devices_update", `
+		AFTER UPDATE OF hostname, serial_number, domain, manufacturer, model, os_caption ON devices
+		WHEN NEW.hostname IS NOT OLD.hostname OR NEW.serial_number IS NOT OLD.serial_number
+			OR NEW.domain IS NOT OLD.domain OR NEW.manufacturer IS NOT OLD.manufacturer
+			OR NEW.model IS NOT OLD.model OR NEW.os_caption IS NOT OLD.os_caption
+		BEGIN
+			UPDATE search_devices
+			SET hostname = NEW.hostname, serial_number = COALESCE(NEW.serial_number, ''),
+				domain = COALESCE(NEW.domain, ''), manufacturer = COALESCE(NEW.manufacturer, ''),
+				model = COALESCE(NEW.model, ''), os_caption = COALESCE(NEW.os_caption, '')
+			WHERE device_id = NEW.id;
+		END`},
+	{"search_devices_delete", `
+		AFTER DELETE ON devices BEGIN
+			DELETE FROM search_devices WHERE device_id = OLD.id;
+		END`},
+	{"search_devices_snapshot_user", `
+		AFTER INSERT ON snapshots BEGIN
+			UPDATE search_devices SET last_interactive_user = COALESCE(NEW.last_interactive_user, '')
+			WHERE device_id = NEW.device_id AND last_interactive_user IS NOT COALESCE(NEW.last_interactive_user, '');
+		END`},
+	{"search_devices_checkin_user", `
+		AFTER INSERT ON snapshot_checkins BEGIN
+			UPDATE search_devices SET last_interactive_user = COALESCE(NEW.last_interactive_user, '')
+			WHERE device_id = NEW.device_id AND last_interactive_user IS NOT COALESCE(NEW.last_interactive_user, '');
+		END`},
+
+	{"search_software_title_insert", `
+		AFTER INSERT ON search_software_titles BEGIN
+			INSERT INTO search_software (rowid, name, publisher) VALUES (NEW.id, NEW.name, NEW.publisher);
+		END`},
+	{"search_software_title_delete", `
+		AFTER DELETE ON search_software_titles BEGIN
+			INSERT INTO search_software (search_software, rowid, name, publisher) VALUES ('delete', OLD.id, OLD.name, OLD.publisher);
+		END`},
+	{"search_software_insert", `
+		AFTER INSERT ON device_current_software BEGIN
+			INSERT OR IGNORE INTO search_software_titles (name, publisher) VALUES (NEW.name, COALESCE(NEW.publisher, ''));
+		END`},
+	{"search_software_update", `
+		AFTER UPDATE OF name ON device_current_software WHEN NEW.name IS NOT OLD.name BEGIN
+			INSERT OR IGNORE INTO search_software_titles (name, publisher) VALUES (NEW.name, COALESCE(NEW.publisher, ''));
+			DELETE FROM search_software_titles
+			WHERE name = OLD.name AND NOT EXISTS (SELECT 1 FROM device_current_software WHERE name = OLD.name);
+		END`},
+	{"search_software_delete", `
+		AFTER DELETE ON device_current_software BEGIN
+			DELETE FROM search_software_titles
+			WHERE name = OLD.name AND NOT EXISTS (SELECT 1 FROM device_current_software WHERE name = OLD.name);
+		END`},
+
+	{"search_audit_logs_insert", `
+		AFTER INSERT ON audit_logs BEGIN
+			INSERT INTO search_audit_logs (audit_log_id, action, details)
+			VALUES (NEW.id, NEW.action, COALESCE(CAST(NEW.details AS TEXT), ''));
+		END`},
+	{"search_audit_logs_delete", `
+		AFTER DELETE ON audit_logs BEGIN
+			DELETE FROM search_audit_logs WHERE audit_log_id = OLD.id;
+		END`},
+}
+
+// Setup creates the search indexes and the triggers maintaining them. The
+// indexes are rebuilt from the source tables whenever a trigger was missing,
+// which covers the first start and any period the triggers were disabled.
+// Without FTS5 the triggers are dropped, since they would make writes fail,
+// and ErrUnavailable is returned.
+func Setup(db *sqlx.DB) error {
+	var fts5 int
+	if err := db.Get(&fts5, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		return err
+	}
+	if fts5 == 0 {
+		for _, t := range triggers {
+			if _, err := db.Exec(`DROP TRIGGER IF EXISTS ` + t.name); err != nil {
+				return err
+			}
+		}
+		return ErrUnavailable
+	}
+
+	installed, err := installedTriggers(db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range tables {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+	for _, t := range triggers {
+		if _, err := tx.Exec(`CREATE TRIGGER IF NOT EXISTS ` + t.name + t.sql); err != nil {
+			return fmt.Errorf("failed to create trigger %s: %w", t.name, err)
+		}
+	}
+
+	if installed < len(triggers) {
+		if err := rebuild(tx); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Rebuilt full-text search indexes")
+	}
+
+	return tx.Commit()
+}
+
+// Available reports whether the search indexes are installed and maintained
+func Available(db sqlx.Queryer) (bool, error) {
+	installed, err := installedTriggers(db)
+	if err != nil {
+		return false, err
+	}
+	return installed == len(triggers), nil
+}
+
+func installedTriggers(db sqlx.Queryer) (int, error) {
+	names := make([]string, 0, len(triggers))
+	args := make([]interface{}, 0, len(triggers))
+	for _, t := range triggers {
+		names = append(names, "?")
+		args = append(args, t.name)
+	}
+
+	var count int
+	err := sqlx.Get(db, &count, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (`+strings.Join(names, ", ")+`)`, args...)
+	return count, err
+}
+
+// rebuild repopulates every index from its source table
+func rebuild(tx *sqlx.Tx) error {
+	statements := []string{
+		`DELETE FROM search_devices`,
+		`INSERT INTO search_devices (device_id, hostname, serial_number, last_interactive_user, domain, manufacturer, model, os_caption)
+		SELECT d.id, d.hostname, COALESCE(d.serial_number, ''),
+			COALESCE((
+				SELECT u.last_interactive_user FROM (
+					SELECT last_interactive_user, collected_at FROM snapshots WHERE device_id = d.id
+					UNION ALL
+					SELECT last_interactive_user, collected_at FROM snapshot_checkins WHERE device_id = d.id
+				) u ORDER BY u.collected_at DESC LIMIT 1
+			), ''),
+			COALESCE(d.domain, ''), COALESCE(d.manufacturer, ''), COALESCE(d.model, ''), COALESCE(d.os_caption, '')
+		FROM devices d`,
+
+		`DELETE FROM search_software_titles`,
+		`INSERT OR IGNORE INTO search_software_titles (name, publisher)
+		SELECT name, MIN(publisher) FROM device_current_software GROUP BY name`,
+		`INSERT INTO search_software (search_software) VALUES ('rebuild')`,
+
+		`DELETE FROM search_audit_logs`,
+		`INSERT INTO search_audit_logs (audit_log_id, action, details)
+		SELECT id, action, COALESCE(CAST(details AS TEXT), '') FROM audit_logs`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to rebuild search index: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/models"
+)
+
+// MaxQueryLength bounds the size of a search query
+const MaxQueryLength = 256
+
+// maxTerms bounds the number of terms in a search query
+const maxTerms = 10
+
+// Highlight markers wrapped around matched terms in snippets
+const (
+	highlightStart = "**"
+	highlightEnd   = "**"
+)
+
+// ErrEmptyQuery is returned when a query contains no searchable terms
+var ErrEmptyQuery = errors.New("query contains no searchable terms")
+
+// MatchQuery converts free text into an FTS5 query. Every whitespace
+// separated term must match, and the last word of each term matches as a
+// prefix so partial serial numbers and usernames are found while typing.
+// Terms are quoted, so FTS5 operators in user input are treated as text.
+func MatchQuery(text string) (string, error) {
+	if len(text) > MaxQueryLength {
+		return "", errors.New("query is too long")
+	}
+
+	var terms []string
+	for _, term := range strings.Fields(text) {
+		term = strings.ReplaceAll(term, `"`, "")
+		if strings.IndexFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		terms = append(terms, `"`+term+`"*`)
+	}
+
+	if len(terms) == 0 {
+		return "", ErrEmptyQuery
+	}
+	if len(terms) > maxTerms {
+		return "", errors.New("query has too many terms")
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// Search runs a full-text query against the indexes of the requested entity
+// types and returns the best ranked hits of each, up to limit per type.
+// Groups without hits are omitted and the rest keep the order of types. BM25
+// scores depend on the statistics of each index, so they only rank results
+// within a group and are not compared across types.
+func Search(db *sqlx.DB, text string, types []models.SearchEntityType, limit int) ([]models.SearchGroup, error) {
+	match, err := MatchQuery(text)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]models.SearchGroup, 0, len(types))
+	for _, entityType := range types {
+		var group models.SearchGroup
+		var err error
+		switch entityType {
+		case models.SearchEntityDevice:
+			group, err = searchDevices(db, match, limit)
+		case models.SearchEntitySoftware:
+			group, err = searchSoftware(db, match, limit)
+		case models.SearchEntityAuditLog:
+			group, err = searchAuditLogs(db, match, limit)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(group.Results) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+func countMatches(db *sqlx.DB, table, match string) (int, error) {
+	var total int
+	err := db.Get(&total, `SELECT COUNT(*) FROM `+table+` WHERE `+table+` MATCH ?`, match)
+	return total, err
+}
+
+func searchDevices(db *sqlx.DB, match string, limit int) (models.SearchGroup, error) {
+	group := models.SearchGroup{Type: models.SearchEntityDevice, Results: []models.SearchResult{}}
+
+	var rows []struct {
+		DeviceID            uuid.UUID `db:"device_id"`
+		Hostname            string    `db:"hostname"`
+		SerialNumber        string    `db:"serial_number"`
+		LastInteractiveUser string    `db:"last_interactive_user"`
+		Snippet             string    `db:"snippet"`
+		Score               float64   `db:"score"`
+	}
+	// Hostnames and serial numbers weigh most, then the interactive user
+	err := db.Select(&rows, `
+		SELECT search_devices.device_id, d.hostname, search_devices.serial_number,
+			search_devices.last_interactive_user,
+			snippet(search_devices, -1, ?, ?, '…', 8) AS snippet,
+			-bm25(search_devices, 0, 10.0, 10.0, 5.0, 2.0, 1.0, 1.0, 1.0) AS score
+		FROM search_devices
+		JOIN devices d ON d.id = search_devices.device_id
+		WHERE search_devices MATCH ?
+		ORDER BY score DESC, d.hostname ASC
+		LIMIT ?`, highlightStart, highlightEnd, match, limit)
+	if err != nil {
+		return group, err
+	}
+
+	for _, row := range rows {
+		deviceID := row.DeviceID
+		var details []string
+		for _, value := range []string{row.SerialNumber, row.LastInteractiveUser} {
+			if value != "" {
+				details = append(details, value)
+			}
+		}
+		group.Results = append(group.Results, models.SearchResult{
+			Type:     models.SearchEntityDevice,
+			ID:       row.DeviceID.String(),
+			Title:    row.Hostname,
+			Subtitle: strings.Join(details, " · "),
+			Snippet:  row.Snippet,
+			Score:    row.Score,
+			DeviceID: &deviceID,
+		})
+	}
+
+	group.Total, err = countMatches(db, "search_devices", match)
+	return group, err
+}
+
+func searchSoftware(db *sqlx.DB, match string, limit int) (models.SearchGroup, error) {
+	group := models.SearchGroup{Type: models.SearchEntitySoftware, Results: []models.SearchResult{}}
+
+	var rows []struct {
+		Name        string  `db:"name"`
+		Publisher   string  `db:"publisher"`
+		DeviceCount int     `db:"device_count"`
+		Score       float64 `db:"score"`
+	}
+	// Equally relevant titles are ordered by how widely they are installed
+	err := db.Select(&rows, `
+		SELECT t.name, t.publisher,
+			(SELECT COUNT(DISTINCT cs.device_id) FROM device_current_software cs WHERE cs.name = t.name) AS device_count,
+			-bm25(search_software, 10.0, 2.0) AS score
+		FROM search_software
+		JOIN search_software_titles t ON t.id = search_software.rowid
+		WHERE search_software MATCH ?
+		ORDER BY score DESC, device_count DESC, t.name ASC
+		LIMIT ?`, match, limit)
+	if err != nil {
+		return group, err
+	}
+
+	for _, row := range rows {
+		group.Results = append(group.Results, models.SearchResult{
+			Type:        models.SearchEntitySoftware,
+			ID:          row.Name,
+			Title:       row.Name,
+			Subtitle:    row.Publisher,
+			Score:       row.Score,
+			DeviceCount: row.DeviceCount,
+		})
+	}
+
+	group.Total, err = countMatches(db, "search_software", match)
+	return group, err
+}
+
+func searchAuditLogs(db *sqlx.DB, match string, limit int) (models.SearchGroup, error) {
+	group := models.SearchGroup{Type: models.SearchEntityAuditLog, Results: []models.SearchResult{}}
+
+	var rows []struct {
+		ID        string     `db:"id"`
+		Action    string     `db:"action"`
+		DeviceID  *uuid.UUID `db:"device_id"`
+		Username  *string    `db:"username"`
+		Timestamp string     `db:"timestamp"`
+		Snippet   string     `db:"snippet"`
+		Score     float64    `db:"score"`
+	}
+	err := db.Select(&rows, `
+		SELECT a.id, a.action, a.device_id, u.username,
+			CAST(a.timestamp AS TEXT) AS timestamp,
+			snippet(search_audit_logs, 2, ?, ?, '…', 8) AS snippet,
+			-bm25(search_audit_logs, 0, 5.0, 1.0) AS score
+		FROM search_audit_logs
+		JOIN audit_logs a ON a.id = search_audit_logs.audit_log_id
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE search_audit_logs MATCH ?
+		ORDER BY score DESC, a.timestamp DESC
+		LIMIT ?`, highlightStart, highlightEnd, match, limit)
+	if err != nil {
+		return group, err
+	}
+
+	for _, row := range rows {
+		result := models.SearchResult{
+			Type:     models.SearchEntityAuditLog,
+			ID:       row.ID,
+			Title:    row.Action,
+			Snippet:  row.Snippet,
+			Score:    row.Score,
+			DeviceID: row.DeviceID,
+		}
+		if row.Username != nil {
+			result.Subtitle = *row.Username
+		}
+		if timestamp, ok := parseTimestamp(row.Timestamp); ok {
+			result.Timestamp = &timestamp
+		}
+		group.Results = append(group.Results, result)
+	}
+
+	group.Total, err = countMatches(db, "search_audit_logs", match)
+	return group, err
+}
+
+func parseTimestamp(value string) (time.Time, bool) {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return parsed.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
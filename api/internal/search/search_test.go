@@ -0,0 +1,228 @@
+package search
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/models"
+)
+
+func TestMatchQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		err  bool
+	}{
+		{"ws-01", `"ws-01"*`, false},
+		{"chrome  google", `"chrome"* "google"*`, false},
+		{`"OR" NEAR(a b)`, `"OR"* "NEAR(a"* "b)"*`, false},
+		{`say "hi"`, `"say"* "hi"*`, false},
+		{"- * ()", "", true},
+		{"", "", true},
+		{"a b c d e f g h i j k", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := MatchQuery(tt.text)
+		if (err != nil) != tt.err {
+			t.Errorf("MatchQuery(%q) error = %v, want error %v", tt.text, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("MatchQuery(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+// schema holds the columns of the source tables that the indexes read
+const schema = `
+CREATE TABLE users (id TEXT PRIMARY KEY, username TEXT NOT NULL);
+CREATE TABLE devices (
+	id TEXT PRIMARY KEY, hostname TEXT NOT NULL, serial_number TEXT, domain TEXT,
+	manufacturer TEXT, model TEXT, os_caption TEXT
+);
+CREATE TABLE snapshots (device_id TEXT, last_interactive_user TEXT, collected_at DATETIME);
+CREATE TABLE snapshot_checkins (device_id TEXT, last_interactive_user TEXT, collected_at DATETIME);
+CREATE TABLE device_current_software (device_id TEXT, name TEXT NOT NULL, publisher TEXT);
+CREATE TABLE audit_logs (
+	id TEXT PRIMARY KEY, user_id TEXT, device_id TEXT, action TEXT NOT NULL,
+	details TEXT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// setupIndex creates the source tables and the search indexes in an
+// in-memory database, skipping the test when SQLite lacks FTS5
+func setupIndex(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	if err := Setup(db); err != nil {
+		if errors.Is(err, ErrUnavailable) {
+			t.Skip("SQLite was built without FTS5, run the tests with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSearch(t *testing.T) {
+	db := setupIndex(t)
+
+	laptop := uuid.New()
+	desktop := uuid.New()
+	db.MustExec(`INSERT INTO devices (id, hostname, serial_number, domain, manufacturer, model, os_caption)
+		VALUES (?, 'LAPTOP-7F3K', 'PF3X9K2', 'corp', 'Lenovo', 'ThinkPad', 'Windows 11 Pro'),
+		       (?, 'DESKTOP-A1', 'MXL1234', 'corp', 'HP', 'EliteDesk', 'Windows 10 Pro')`, laptop, desktop)
+	db.MustExec(`INSERT INTO snapshots (device_id, last_interactive_user, collected_at) VALUES (?, 'jdoe', CURRENT_TIMESTAMP)`, laptop)
+	db.MustExec(`INSERT INTO device_current_software (device_id, name, publisher)
+		VALUES (?, 'Google Chrome', 'Google LLC'), (?, 'Google Chrome', 'Google LLC'), (?, 'Lenovo Vantage', 'Lenovo')`,
+		laptop, desktop, laptop)
+	db.MustExec(`INSERT INTO audit_logs (id, device_id, action, details) VALUES ('a1', ?, 'device_deleted', '{"hostname":"LAPTOP-OLD"}')`, laptop)
+
+	all := []models.SearchEntityType{models.SearchEntityDevice, models.SearchEntitySoftware, models.SearchEntityAuditLog}
+
+	t.Run("prefix of serial number", func(t *testing.T) {
+		groups, err := Search(db, "pf3x", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || groups[0].Type != models.SearchEntityDevice {
+			t.Fatalf("groups = %+v, want only devices", groups)
+		}
+		if got := groups[0].Results[0].ID; got != laptop.String() {
+			t.Errorf("result = %s, want %s", got, laptop)
+		}
+	})
+
+	t.Run("interactive user", func(t *testing.T) {
+		groups, err := Search(db, "jdoe", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || groups[0].Total != 1 || groups[0].Results[0].Subtitle != "PF3X9K2 · jdoe" {
+			t.Errorf("groups = %+v, want the laptop with its serial number and user", groups)
+		}
+	})
+
+	t.Run("software counted once per title", func(t *testing.T) {
+		groups, err := Search(db, "chrome", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || groups[0].Total != 1 || groups[0].Results[0].DeviceCount != 2 {
+			t.Errorf("groups = %+v, want one software title installed on 2 devices", groups)
+		}
+	})
+
+	t.Run("groups keep the requested order", func(t *testing.T) {
+		// "lenovo" matches the laptop, a software title and nothing else, and
+		// the software hit must not move ahead of devices on score alone
+		types := []models.SearchEntityType{models.SearchEntityDevice, models.SearchEntitySoftware}
+		groups, err := Search(db, "lenovo", types, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 2 || groups[0].Type != models.SearchEntityDevice || groups[1].Type != models.SearchEntitySoftware {
+			t.Fatalf("groups = %+v, want devices then software", groups)
+		}
+
+		reversed := []models.SearchEntityType{models.SearchEntitySoftware, models.SearchEntityDevice}
+		groups, err = Search(db, "lenovo", reversed, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 2 || groups[0].Type != models.SearchEntitySoftware {
+			t.Errorf("groups = %+v, want software then devices", groups)
+		}
+	})
+
+	t.Run("results ranked within a group", func(t *testing.T) {
+		groups, err := Search(db, "windows", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || len(groups[0].Results) != 2 {
+			t.Fatalf("groups = %+v, want both devices", groups)
+		}
+		results := groups[0].Results
+		if results[0].Score < results[1].Score {
+			t.Errorf("scores = %v, %v, want descending", results[0].Score, results[1].Score)
+		}
+	})
+
+	t.Run("limit per type", func(t *testing.T) {
+		groups, err := Search(db, "corp", all, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || len(groups[0].Results) != 1 || groups[0].Total != 2 {
+			t.Errorf("groups = %+v, want 1 of 2 devices", groups)
+		}
+	})
+
+	t.Run("audit log details", func(t *testing.T) {
+		groups, err := Search(db, "laptop-old", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || groups[0].Type != models.SearchEntityAuditLog || groups[0].Results[0].Timestamp == nil {
+			t.Errorf("groups = %+v, want the audit log with its timestamp", groups)
+		}
+	})
+
+	t.Run("index follows changes", func(t *testing.T) {
+		db.MustExec(`UPDATE devices SET hostname = 'LAPTOP-RENAMED' WHERE id = ?`, laptop)
+		db.MustExec(`DELETE FROM device_current_software WHERE name = 'Lenovo Vantage'`)
+
+		groups, err := Search(db, "renamed", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || groups[0].Results[0].Title != "LAPTOP-RENAMED" {
+			t.Errorf("groups = %+v, want the renamed laptop", groups)
+		}
+
+		groups, err = Search(db, "vantage", all, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 0 {
+			t.Errorf("groups = %+v, want none after the title was removed", groups)
+		}
+	})
+}
+
+func TestSetupRebuildsMissingTriggers(t *testing.T) {
+	db := setupIndex(t)
+
+	device := uuid.New()
+	db.MustExec(`DROP TRIGGER search_devices_insert`)
+	db.MustExec(`INSERT INTO devices (id, hostname) VALUES (?, 'UNINDEXED-01')`, device)
+
+	if available, err := Available(db); err != nil || available {
+		t.Fatalf("Available() = %v, %v, want false", available, err)
+	}
+	if err := Setup(db); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := Search(db, "unindexed", []models.SearchEntityType{models.SearchEntityDevice}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Total != 1 {
+		t.Errorf("groups = %+v, want the device added while the trigger was missing", groups)
+	}
+}
@@ -0,0 +1,308 @@
+package versions
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Segment is one component of a version, either numeric or alphabetic
+type Segment struct {
+	Num   uint64
+	Str   string
+	IsNum bool
+}
+
+// Version is a parsed software version string. Epoch holds the leading epoch
+// of package versions ("1:2.3"), Core the release components ("118.0.5993"),
+// Pre the pre-release components ("beta.2").
+type Version struct {
+	Raw   string
+	Epoch uint64
+	Core  []Segment
+	Pre   []Segment
+}
+
+// prereleaseRanks orders well-known pre-release tags. Any of them starts the
+// pre-release part of a version, with or without a preceding dash (1.0b2).
+var prereleaseRanks = map[string]int{
+	"dev":      0,
+	"snapshot": 0,
+	"nightly":  0,
+	"a":        1,
+	"alpha":    1,
+	"b":        2,
+	"beta":     2,
+	"pre":      3,
+	"preview":  3,
+	"insider":  3,
+	"rc":       4,
+}
+
+// noiseWords are vendor decorations that carry no ordering information and
+// are treated as separators ("8 Update 381", "5.1 Build 2600", "8u381")
+var noiseWords = map[string]bool{
+	"build":   true,
+	"update":  true,
+	"u":       true,
+	"r":       true,
+	"rev":     true,
+	"release": true,
+	"version": true,
+	"ver":     true,
+	"v":       true,
+}
+
+// Parse splits a version string into comparable segments. It accepts dotted
+// numeric versions (118.0.5993.89), semantic versions (1.2.3-rc.1+build.5)
+// and common vendor formats such as "19.00", "v2.4 (x64)", "8u381",
+// "1.8.0_381", "2.0b3" or "5.12.0 (20354)". A numeric prefix followed by a
+// colon is an epoch ("1:2.3"), which outranks the rest of the version.
+func Parse(raw string) Version {
+	v := Version{Raw: raw}
+	s := strings.TrimSpace(raw)
+
+	if i := strings.IndexByte(s, ':'); i > 0 {
+		if epoch, err := strconv.ParseUint(s[:i], 10, 64); err == nil {
+			v.Epoch = epoch
+			s = s[i+1:]
+		}
+	}
+
+	// Build metadata never affects ordering
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+
+	s = stripParentheses(s)
+
+	// A dash followed by a letter starts a semver pre-release (1.2.3-beta.1),
+	// a dash followed by a digit is a plain separator (1.2-3)
+	if i := strings.IndexByte(s, '-'); i >= 0 && i+1 < len(s) && unicode.IsLetter(rune(s[i+1])) {
+		v.Core = appendSegments(nil, s[:i], false)
+		v.Pre = appendSegments(nil, s[i+1:], true)
+		return v
+	}
+
+	inPre := false
+	for _, token := range tokenize(s) {
+		if !inPre && !token.IsNum {
+			lower := strings.ToLower(token.Str)
+			if _, ok := prereleaseRanks[lower]; ok && len(v.Core) > 0 {
+				inPre = true
+			}
+		}
+		if inPre {
+			v.Pre = append(v.Pre, token)
+		} else if token.IsNum || !noiseWords[strings.ToLower(token.Str)] {
+			v.Core = append(v.Core, token)
+		}
+	}
+
+	return v
+}
+
+// IsPrerelease reports whether the version carries a pre-release tag
+func (v Version) IsPrerelease() bool {
+	return len(v.Pre) > 0
+}
+
+// Compare orders two versions and returns -1, 0 or 1. Missing trailing
+// numeric components count as zero, so "118.0" equals "118.0.0", while a
+// trailing letter ranks above its absence, so "1.1.1w" sorts after "1.1.1".
+// A release sorts after its pre-releases.
+func (v Version) Compare(other Version) int {
+	if v.Epoch != other.Epoch {
+		if v.Epoch < other.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareCore(v.Core, other.Core); c != 0 {
+		return c
+	}
+
+	switch {
+	case len(v.Pre) == 0 && len(other.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(other.Pre) == 0:
+		return -1
+	}
+	return comparePre(v.Pre, other.Pre)
+}
+
+// Compare parses and orders two version strings, returning -1, 0 or 1
+func Compare(a, b string) int {
+	return Parse(a).Compare(Parse(b))
+}
+
+// Less reports whether version a sorts before version b, falling back to the
+// raw strings so that equivalent spellings ("1.0" and "1.0.0") order stably
+func Less(a, b string) bool {
+	if c := Compare(a, b); c != 0 {
+		return c < 0
+	}
+	return a < b
+}
+
+// compareCore orders release components. When one version runs out of
+// components, a remaining number is compared against zero and a remaining
+// letter ranks above the missing component ("1.0.2k" after "1.0.2").
+func compareCore(a, b []Segment) int {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	for i := 0; i < n; i++ {
+		var c int
+		switch {
+		case i >= len(a):
+			c = -compareMissing(b[i])
+		case i >= len(b):
+			c = compareMissing(a[i])
+		default:
+			c = compareSegment(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareMissing orders a segment against a component the other version lacks
+func compareMissing(s Segment) int {
+	if !s.IsNum {
+		return 1
+	}
+	return compareSegment(s, Segment{IsNum: true})
+}
+
+func comparePre(a, b []Segment) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if !a[i].IsNum && !b[i].IsNum {
+			rankA, okA := prereleaseRanks[strings.ToLower(a[i].Str)]
+			rankB, okB := prereleaseRanks[strings.ToLower(b[i].Str)]
+			if okA && okB {
+				if rankA != rankB {
+					return compareInts(rankA, rankB)
+				}
+				continue
+			}
+		}
+		if c := compareSegment(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// compareSegment orders numeric segments numerically and alphabetic segments
+// case-insensitively. Numeric segments sort after alphabetic ones.
+func compareSegment(a, b Segment) int {
+	switch {
+	case a.IsNum && b.IsNum:
+		switch {
+		case a.Num < b.Num:
+			return -1
+		case a.Num > b.Num:
+			return 1
+		}
+		return 0
+	case a.IsNum:
+		return 1
+	case b.IsNum:
+		return -1
+	}
+	return strings.Compare(strings.ToLower(a.Str), strings.ToLower(b.Str))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// stripParentheses removes parenthesised decorations such as "(x64)" while
+// keeping purely numeric build numbers such as "(20354)"
+func stripParentheses(s string) string {
+	var out strings.Builder
+	for {
+		open := strings.IndexByte(s, '(')
+		if open < 0 {
+			break
+		}
+		closing := strings.IndexByte(s[open:], ')')
+		if closing < 0 {
+			s = s[:open]
+			break
+		}
+		inner := strings.TrimSpace(s[open+1 : open+closing])
+		out.WriteString(s[:open])
+		if inner != "" && strings.IndexFunc(inner, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' }) < 0 {
+			out.WriteString("." + inner)
+		}
+		s = s[open+closing+1:]
+	}
+	out.WriteString(s)
+	return out.String()
+}
+
+func appendSegments(segments []Segment, s string, keepNoise bool) []Segment {
+	for _, token := range tokenize(s) {
+		if keepNoise || token.IsNum || !noiseWords[strings.ToLower(token.Str)] {
+			segments = append(segments, token)
+		}
+	}
+	return segments
+}
+
+// tokenize splits s into runs of digits and runs of letters, dropping everything else
+func tokenize(s string) []Segment {
+	var segments []Segment
+	var current strings.Builder
+	currentIsNum := false
+
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		text := current.String()
+		if currentIsNum {
+			num, err := strconv.ParseUint(text, 10, 64)
+			if err != nil {
+				// Too long to be a number, compare as text
+				segments = append(segments, Segment{Str: text})
+			} else {
+				segments = append(segments, Segment{Num: num, Str: text, IsNum: true})
+			}
+		} else {
+			segments = append(segments, Segment{Str: text})
+		}
+		current.Reset()
+	}
+
+	for _, r := range s {
+		isDigit := r >= '0' && r <= '9'
+		isLetter := unicode.IsLetter(r)
+		if !isDigit && !isLetter {
+			flush()
+			continue
+		}
+		if current.Len() > 0 && isDigit != currentIsNum {
+			flush()
+		}
+		current.WriteRune(r)
+		currentIsNum = isDigit
+	}
+	flush()
+
+	return segments
+}
@@ -0,0 +1,126 @@
+package versions
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want int
+	}{
+		// Numeric components
+		{"equal", "118.0.5993.89", "118.0.5993.89", 0},
+		{"numeric not lexical", "1.10", "1.9", 1},
+		{"major wins", "2.0", "1.99.99", 1},
+		{"leading zeros", "19.00", "19.0", 0},
+
+		// Unequal lengths
+		{"missing zero", "118.0", "118.0.0", 0},
+		{"missing trailing component", "1.0", "1.0.1", -1},
+		{"extra trailing component", "1.0.0.1", "1.0", 1},
+
+		// Letter suffixes
+		{"suffix after release", "1.1.1w", "1.1.1", 1},
+		{"release before suffix", "1.0.2", "1.0.2k", -1},
+		{"suffixes in order", "1.1.1v", "1.1.1w", -1},
+		{"suffix before next release", "1.0.2k", "1.1.0", -1},
+		{"suffix case", "1.1.1W", "1.1.1w", 0},
+
+		// Pre-release tags
+		{"pre-release before release", "1.0.0-beta", "1.0.0", -1},
+		{"alpha before beta", "1.0.0-alpha", "1.0.0-beta", -1},
+		{"beta before rc", "1.0.0-rc.1", "1.0.0-beta.5", 1},
+		{"numbered pre-releases", "1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"longer pre-release", "1.0.0-rc.1", "1.0.0-rc", 1},
+		{"undashed pre-release", "2.0b3", "2.0", -1},
+		{"pre-release of next version", "2.0b3", "1.9", 1},
+
+		// Epochs
+		{"epoch outranks version", "1:2.0", "3.0", 1},
+		{"higher epoch", "2:1.0", "1:9.9", 1},
+		{"zero epoch", "0:1.0", "1.0", 0},
+		{"same epoch", "1:1.2", "1:1.10", -1},
+
+		// Vendor formats
+		{"update number", "8u381", "8 Update 202", 1},
+		{"build number", "5.12.0 (20354)", "5.12.0", 1},
+		{"decoration", "v2.4 (x64)", "2.4", 0},
+		{"build metadata", "1.2.3+build.5", "1.2.3", 0},
+		{"underscore", "1.8.0_381", "1.8.0_202", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compare(tt.a, tt.b); got != tt.want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := Compare(tt.b, tt.a); got != -tt.want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestLess(t *testing.T) {
+	sorted := []string{
+		"1.0.0-alpha",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0",
+		"1.0.0",
+		"1.0.2",
+		"1.0.2k",
+		"1.1.1",
+		"1.1.1v",
+		"1.1.1w",
+		"1.10",
+		"1:0.5",
+	}
+
+	shuffled := []string{
+		"1.1.1w", "1:0.5", "1.0.2", "1.0.0-rc.1", "1.10", "1.0.0", "1.0.0-beta.11",
+		"1.0.2k", "1.1.1", "1.0.0-alpha", "1.1.1v", "1.0", "1.0.0-beta.2",
+	}
+	sort.Slice(shuffled, func(i, j int) bool { return Less(shuffled[i], shuffled[j]) })
+
+	if !reflect.DeepEqual(shuffled, sorted) {
+		t.Errorf("sorted versions = %v, want %v", shuffled, sorted)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw        string
+		epoch      uint64
+		core       int
+		prerelease bool
+	}{
+		{"118.0.5993.89", 0, 4, false},
+		{"1.2.3-rc.1+build.5", 0, 3, true},
+		{"2.0b3", 0, 2, true},
+		{"1.1.1w", 0, 4, false},
+		{"8 Update 381", 0, 2, false},
+		{"3:1.2", 3, 2, false},
+		{"", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			v := Parse(tt.raw)
+			if v.Epoch != tt.epoch {
+				t.Errorf("Epoch = %d, want %d", v.Epoch, tt.epoch)
+			}
+			if len(v.Core) != tt.core {
+				t.Errorf("Core = %v, want %d segments", v.Core, tt.core)
+			}
+			if v.IsPrerelease() != tt.prerelease {
+				t.Errorf("IsPrerelease() = %v, want %v", v.IsPrerelease(), tt.prerelease)
+			}
+		})
+	}
+}
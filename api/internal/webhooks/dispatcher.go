@@ -0,0 +1,213 @@
+package webhooks
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/events"
+	"github.com/tracr/api/internal/models"
+)
+
+// cursorName identifies the dispatcher's position in the event log
+const cursorName = "webhooks"
+
+// Batch sizes of a single dispatch pass
+const (
+	eventBatch    = 100
+	deliveryBatch = 50
+)
+
+// deliveryWorkers bounds the number of endpoints delivered to at once
+const deliveryWorkers = 8
+
+// pollInterval bounds how long a recorded event or a due retry waits for dispatch
+const pollInterval = 2 * time.Second
+
+// Start runs the dispatcher in the background. It queues a delivery for every
+// new event and enabled webhook subscribed to it, then attempts the deliveries
+// that are due, to several endpoints at once. Finished deliveries are removed
+// along with old events.
+func Start(db *sqlx.DB, cfg *config.Config) {
+	// Store the starting position before requests are served, so that the
+	// events recorded before the first pass are not skipped
+	if _, err := events.Cursor(db, cursorName); err != nil {
+		log.Printf("[ERROR] Failed to load webhook event cursor: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		retention := time.NewTicker(time.Hour)
+		for {
+			select {
+			case <-events.Changed():
+			case <-ticker.C:
+			case <-retention.C:
+				cutoff := time.Now().Add(-cfg.EventRetention).UTC()
+				if _, err := db.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`,
+					models.WebhookDeliveryPending, cutoff); err != nil {
+					log.Printf("[ERROR] Webhook delivery retention failed: %v", err)
+				}
+				continue
+			}
+
+			if err := enqueue(db); err != nil {
+				log.Printf("[ERROR] Failed to queue webhook deliveries: %v", err)
+			}
+			if err := deliverDue(db, cfg); err != nil {
+				log.Printf("[ERROR] Failed to deliver webhooks: %v", err)
+			}
+		}
+	}()
+}
+
+// enqueue queues deliveries for the events recorded since the last pass and
+// advances the cursor in the same transaction
+func enqueue(db *sqlx.DB) error {
+	cursor, err := events.Cursor(db, cursorName)
+	if err != nil {
+		return err
+	}
+
+	for {
+		batch, err := events.After(db, cursor, eventBatch)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		var webhooks []models.Webhook
+		if err := db.Select(&webhooks, `SELECT * FROM webhooks WHERE enabled = 1`); err != nil {
+			return err
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, event := range batch {
+			payload, err := NewPayload(event)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			for _, webhook := range webhooks {
+				if !Subscribed(webhook, event.Type) {
+					continue
+				}
+				eventID := event.ID
+				if _, err := Queue(tx, webhook.ID, &eventID, event.Type, payload, &now); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			cursor = event.ID
+		}
+		if err := events.SaveCursor(tx, cursorName, cursor); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// dueDelivery is a pending delivery with the endpoint it is posted to
+type dueDelivery struct {
+	models.WebhookDelivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+// deliverDue attempts the pending deliveries of enabled webhooks whose next
+// attempt is due, oldest first. A pass stops after a batch in which an
+// endpoint failed, leaving the rest for the next pass.
+func deliverDue(db *sqlx.DB, cfg *config.Config) error {
+	for {
+		var due []dueDelivery
+		err := db.Select(&due, `
+			SELECT d.*, w.url, w.secret
+			FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = ? AND w.enabled = 1 AND d.next_attempt_at <= ?
+			ORDER BY d.next_attempt_at ASC
+			LIMIT ?`, models.WebhookDeliveryPending, time.Now().UTC(), deliveryBatch)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		delivered, err := deliverBatch(db, cfg, due)
+		if err != nil || !delivered || len(due) < deliveryBatch {
+			return err
+		}
+	}
+}
+
+// deliverBatch attempts a batch of deliveries with up to deliveryWorkers
+// endpoints at once. Each endpoint gets its own queue, worked by a single
+// worker so that its deliveries are posted in order and a slow endpoint
+// only holds up its own. Reports whether every delivery succeeded.
+func deliverBatch(db *sqlx.DB, cfg *config.Config, due []dueDelivery) (bool, error) {
+	var endpoints []uuid.UUID
+	queues := make(map[uuid.UUID][]dueDelivery)
+	for _, delivery := range due {
+		if _, ok := queues[delivery.WebhookID]; !ok {
+			endpoints = append(endpoints, delivery.WebhookID)
+		}
+		queues[delivery.WebhookID] = append(queues[delivery.WebhookID], delivery)
+	}
+
+	workers := deliveryWorkers
+	if len(endpoints) < workers {
+		workers = len(endpoints)
+	}
+
+	work := make(chan []dueDelivery)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	delivered := true
+	var firstErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for queue := range work {
+				ok, err := deliverQueue(db, cfg, queue)
+				mu.Lock()
+				delivered = delivered && ok
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, endpoint := range endpoints {
+		work <- queues[endpoint]
+	}
+	close(work)
+	wg.Wait()
+
+	return delivered, firstErr
+}
+
+// deliverQueue attempts the deliveries of one endpoint in order. It stops at
+// the first delivery that fails, so an unreachable endpoint costs a single
+// timeout per pass. Reports whether every delivery succeeded.
+func deliverQueue(db *sqlx.DB, cfg *config.Config, queue []dueDelivery) (bool, error) {
+	for i := range queue {
+		webhook := models.Webhook{ID: queue[i].WebhookID, URL: queue[i].URL, Secret: queue[i].Secret}
+		if err := Attempt(db, cfg, webhook, &queue[i].WebhookDelivery); err != nil {
+			return false, err
+		}
+		if queue[i].Status != models.WebhookDeliverySucceeded {
+			return false, nil
+		}
+	}
+	return true, nil
+}
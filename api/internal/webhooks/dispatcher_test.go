@@ -0,0 +1,193 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/models"
+)
+
+// schema holds the webhook tables with time columns the driver scans as times
+const schema = `
+CREATE TABLE webhooks (
+	id TEXT PRIMARY KEY, name TEXT NOT NULL, url TEXT NOT NULL, secret TEXT NOT NULL,
+	event_types TEXT NOT NULL DEFAULT '[]', enabled BOOLEAN NOT NULL DEFAULT 1
+);
+CREATE TABLE webhook_deliveries (
+	id TEXT PRIMARY KEY,
+	webhook_id TEXT NOT NULL,
+	event_id INTEGER,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME,
+	last_attempt_at DATETIME,
+	response_status INTEGER,
+	response_body TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	completed_at DATETIME
+);
+`
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addWebhook creates a webhook posting to url with count due deliveries,
+// returned oldest first
+func addWebhook(t *testing.T, db *sqlx.DB, url string, count int) []uuid.UUID {
+	t.Helper()
+
+	webhookID := uuid.New()
+	db.MustExec(`INSERT INTO webhooks (id, name, url, secret) VALUES (?, ?, ?, 'secret')`, webhookID, webhookID.String(), url)
+
+	ids := make([]uuid.UUID, 0, count)
+	for i := 0; i < count; i++ {
+		due := time.Now().UTC().Add(-time.Duration(count-i) * time.Minute)
+		delivery, err := Queue(db, webhookID, nil, models.EventType("test"), []byte(`{}`), &due)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, delivery.ID)
+	}
+	return ids
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		WebhookMaxAttempts: 3,
+		WebhookRetryBase:   time.Second,
+		WebhookTimeout:     5 * time.Second,
+	}
+}
+
+func deliveryStatuses(t *testing.T, db *sqlx.DB, ids []uuid.UUID) []models.WebhookDeliveryStatus {
+	t.Helper()
+
+	statuses := make([]models.WebhookDeliveryStatus, 0, len(ids))
+	for _, id := range ids {
+		var status models.WebhookDeliveryStatus
+		if err := db.Get(&status, `SELECT status FROM webhook_deliveries WHERE id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func TestDeliverDueInParallel(t *testing.T) {
+	db := openTestDB(t)
+
+	// The slow endpoint only answers once the fast one received all of its
+	// deliveries, which never happens when endpoints are served one by one
+	fastDone := make(chan struct{})
+	var fastCount int
+	var mu sync.Mutex
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fastCount++
+		if fastCount == 3 {
+			close(fastDone)
+		}
+	}))
+	defer fast.Close()
+
+	var slowOrder []string
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-fastDone:
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		slowOrder = append(slowOrder, r.Header.Get(HeaderDelivery))
+		mu.Unlock()
+	}))
+	defer slow.Close()
+
+	slowIDs := addWebhook(t, db, slow.URL, 3)
+	fastIDs := addWebhook(t, db, fast.URL, 3)
+
+	if err := deliverDue(db, testConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range deliveryStatuses(t, db, append(slowIDs, fastIDs...)) {
+		if status != models.WebhookDeliverySucceeded {
+			t.Fatalf("delivery status = %s, want every delivery to succeed", status)
+		}
+	}
+
+	// Deliveries to one endpoint keep their order
+	for i, id := range slowIDs {
+		if slowOrder[i] != id.String() {
+			t.Errorf("slow endpoint received %v, want %v", slowOrder, slowIDs)
+			break
+		}
+	}
+}
+
+func TestDeliverDueStopsAtFailingEndpoint(t *testing.T) {
+	db := openTestDB(t)
+
+	var failingCount int
+	var mu sync.Mutex
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		failingCount++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+
+	failingIDs := addWebhook(t, db, failing.URL, 3)
+	healthyIDs := addWebhook(t, db, healthy.URL, 2)
+
+	if err := deliverDue(db, testConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	if failingCount != 1 {
+		t.Errorf("failing endpoint received %d requests, want 1", failingCount)
+	}
+	for _, status := range deliveryStatuses(t, db, healthyIDs) {
+		if status != models.WebhookDeliverySucceeded {
+			t.Errorf("healthy delivery status = %s, want succeeded", status)
+		}
+	}
+
+	// The failed delivery backs off and the ones behind it are still due
+	var attempts []int
+	if err := db.Select(&attempts, `SELECT attempts FROM webhook_deliveries WHERE webhook_id =
+		(SELECT webhook_id FROM webhook_deliveries WHERE id = ?) ORDER BY next_attempt_at`, failingIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 3 || attempts[0] != 0 || attempts[1] != 0 || attempts[2] != 1 {
+		t.Errorf("attempts ordered by next attempt = %v, want [0 0 1]", attempts)
+	}
+}
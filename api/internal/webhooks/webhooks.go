@@ -0,0 +1,177 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/tracr/api/internal/config"
+	"github.com/tracr/api/internal/models"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Tracr-Event"
+	HeaderDelivery  = "X-Tracr-Delivery"
+	HeaderSignature = "X-Tracr-Signature"
+)
+
+// maxBackoff caps the delay between delivery attempts
+const maxBackoff = time.Hour
+
+// maxResponseBody bounds the part of a response body kept in the delivery log
+const maxResponseBody = 2048
+
+// Sign returns the signature header of a body sent at timestamp, in the form
+//
+//	t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">
+//
+// keyed with the webhook secret. Receivers recompute the HMAC over the raw
+// body and should reject old timestamps to prevent replays.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Subscribed reports whether a webhook receives events of a type
+func Subscribed(webhook models.Webhook, eventType models.EventType) bool {
+	if len(webhook.EventTypes) == 0 {
+		return true
+	}
+	for _, subscribed := range webhook.EventTypes {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay after the given number of failed attempts,
+// doubling from base up to an hour
+func Backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// NewPayload builds the body posted for an event
+func NewPayload(event models.Event) (json.RawMessage, error) {
+	eventID := event.ID
+	return json.Marshal(models.WebhookPayload{
+		EventID:    &eventID,
+		Type:       event.Type,
+		OccurredAt: event.CreatedAt,
+		DeviceID:   event.DeviceID,
+		Data:       event.Data,
+	})
+}
+
+// Queue creates a pending delivery. Deliveries without a next attempt time
+// are left to the caller to attempt, the dispatcher only picks up due ones.
+func Queue(db sqlx.Execer, webhookID uuid.UUID, eventID *int64, eventType models.EventType, payload json.RawMessage, nextAttemptAt *time.Time) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{
+		ID:            uuid.New(),
+		WebhookID:     webhookID,
+		EventID:       eventID,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: nextAttemptAt,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO webhook_deliveries (
+			id, webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.ID, delivery.WebhookID, delivery.EventID, delivery.EventType, []byte(delivery.Payload),
+		delivery.Status, delivery.NextAttemptAt, delivery.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Attempt posts a delivery to its webhook and records the outcome. A 2xx
+// response completes the delivery. Otherwise it is retried after a backoff
+// until the configured number of attempts is used up.
+func Attempt(db *sqlx.DB, cfg *config.Config, webhook models.Webhook, delivery *models.WebhookDelivery) error {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = nil
+	delivery.ResponseBody = ""
+	delivery.Error = ""
+
+	if status, body, err := post(cfg, webhook, delivery, now); err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.ResponseStatus = &status
+		delivery.ResponseBody = body
+		if status < 200 || status > 299 {
+			delivery.Error = fmt.Sprintf("endpoint responded with status %d", status)
+		}
+	}
+
+	switch {
+	case delivery.Error == "":
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.NextAttemptAt = nil
+		delivery.CompletedAt = &now
+	case delivery.Attempts >= cfg.WebhookMaxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.CompletedAt = &now
+	default:
+		next := now.Add(Backoff(cfg.WebhookRetryBase, delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, last_attempt_at = ?,
+			response_status = ?, response_body = ?, error = ?, completed_at = ?
+		WHERE id = ?`,
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt,
+		delivery.ResponseStatus, delivery.ResponseBody, delivery.Error, delivery.CompletedAt, delivery.ID)
+	return err
+}
+
+func post(cfg *config.Config, webhook models.Webhook, delivery *models.WebhookDelivery, now time.Time) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tracr-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, string(delivery.EventType))
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, now, delivery.Payload))
+
+	client := &http.Client{Timeout: cfg.WebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, string(body), nil
+}
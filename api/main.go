@@ -13,10 +13,15 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
+	"github.com/tracr/api/internal/alerting"
 	"github.com/tracr/api/internal/config"
 	"github.com/tracr/api/internal/database"
+	"github.com/tracr/api/internal/events"
+	"github.com/tracr/api/internal/metrics"
 	"github.com/tracr/api/internal/middleware"
 	"github.com/tracr/api/internal/routes"
+	"github.com/tracr/api/internal/search"
+	"github.com/tracr/api/internal/webhooks"
 )
 
 func main() {
@@ -42,6 +47,38 @@ func main() {
 
 	log.Printf("✓ Database migrations completed successfully")
 
+	// Move legacy per-snapshot software rows into content-addressed sets
+	migrated, err := routes.BackfillSoftwareSets(db)
+	if err != nil {
+		log.Fatalf("Failed to backfill software sets: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("✓ Migrated software lists of %d snapshots to software sets", migrated)
+	}
+
+	// Build current-state inventory for devices that predate it
+	backfilled, err := routes.BackfillDeviceCurrentInventory(db)
+	if err != nil {
+		log.Fatalf("Failed to backfill current inventory: %v", err)
+	}
+	if backfilled > 0 {
+		log.Printf("✓ Built current inventory for %d devices", backfilled)
+	}
+
+	// Create the full-text search indexes, which need SQLite built with
+	// -tags sqlite_fts5
+	if err := search.Setup(db); err != nil {
+		log.Fatalf("Failed to set up full-text search: %v", err)
+	}
+
+	// Start background jobs
+	metrics.StartRetention(db, cfg)
+	alerting.StartEvaluation(db, cfg)
+	routes.StartStatusReconciler(db, cfg)
+	routes.StartCommandSweeper(db, cfg)
+	events.StartRetention(db, cfg)
+	webhooks.Start(db, cfg)
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		ServerHeader: "Tracr API",